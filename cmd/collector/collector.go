@@ -0,0 +1,130 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package main
+
+import (
+	"net"
+	"os"
+	"reflect"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xvzf/insight/internal/collector"
+	"github.com/xvzf/insight/pkg/config"
+	"github.com/xvzf/insight/pkg/export"
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/kubemeta"
+	"github.com/xvzf/insight/pkg/metrics"
+	"github.com/xvzf/insight/pkg/sink"
+)
+
+// Logger
+var log *logrus.Entry
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"app":     "collector",
+		"context": "main",
+	})
+}
+
+// Configuration
+var cfg = config.DefaultCollector()
+
+// newEnrichers creates the configured event enrichers
+func newEnrichers() ([]insight.Enricher, error) {
+	if !cfg.Kubernetes.Enrich {
+		return nil, nil
+	}
+	clientset, err := kubemeta.NewClientset(cfg.Kubernetes.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	c := kubemeta.NewCache(clientset, cfg.Kubernetes.Resync)
+	go func() {
+		if err := c.Run(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	return []insight.Enricher{c}, nil
+}
+
+// openSinks returns a function opening the given event sinks
+func openSinks(specs []string) func() (sink.Sink, error) {
+	buffer := cfg.Queue.Buffer()
+	return func() (sink.Sink, error) {
+		return sink.FromSpecs(specs, buffer)
+	}
+}
+
+// reload applies the log level and sinks of the current configuration. Other settings require
+// a restart.
+func reload(s sink.Reloadable) {
+	next := config.DefaultCollector()
+	if err := config.Load(next, os.Args[1:]); err != nil {
+		log.WithError(err).Error("Invalid configuration, keeping the current one")
+		return
+	}
+	next.Apply()
+	cfg.LogLevel = next.LogLevel
+
+	if !reflect.DeepEqual(next.Sinks, cfg.Sinks) {
+		if err := s.Reload(openSinks(next.Sinks)); err != nil {
+			log.WithError(err).Error("Failed to reload the sinks")
+		} else {
+			cfg.Sinks = next.Sinks
+		}
+	}
+}
+
+func main() {
+	config.MustLoad(cfg)
+	cfg.Apply()
+	metrics.Serve(cfg.MetricsAddr)
+
+	conn, err := net.ListenPacket("udp", cfg.Listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.WithField("listen", conn.LocalAddr().String()).Info("Listening for flow records")
+
+	enrichers, err := newEnrichers()
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := sink.NewReloadable(openSinks(cfg.Sinks))
+	if err != nil {
+		log.Fatal(err)
+	}
+	e := export.NewEvents(s, enrichers...)
+	defer e.Close()
+	go config.OnReload(func() { reload(s) })
+
+	c := collector.New(conn, e, cfg.BatchSize, cfg.FlushInterval)
+
+	if err := c.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
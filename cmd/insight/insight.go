@@ -26,12 +26,27 @@
 package main
 
 import (
+	"fmt"
 	"os"
-	"time"
+	"reflect"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"github.com/xvzf/insight/internal/insight"
 	"github.com/xvzf/insight/pkg/capture"
+	"github.com/xvzf/insight/pkg/clusterip"
+	"github.com/xvzf/insight/pkg/config"
+	"github.com/xvzf/insight/pkg/conntrack/netlink"
+	"github.com/xvzf/insight/pkg/dns"
+	"github.com/xvzf/insight/pkg/export"
+	"github.com/xvzf/insight/pkg/httpmeta"
+	ecs "github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/ipfix"
+	"github.com/xvzf/insight/pkg/kubemeta"
+	"github.com/xvzf/insight/pkg/link"
+	"github.com/xvzf/insight/pkg/metrics"
+	"github.com/xvzf/insight/pkg/procfs"
+	"github.com/xvzf/insight/pkg/sink"
 )
 
 func init() {
@@ -45,15 +60,228 @@ func init() {
 // Logger
 var log *logrus.Entry
 
+// Configuration
+var cfg = config.DefaultInsight()
+
+// newExporter creates the configured flow exporter. Event sinks are returned as well, so they
+// can be reloaded.
+func newExporter() (export.Exporter, sink.Reloadable, error) {
+	switch cfg.Exporter.Type {
+	case "ipfix":
+		e, err := export.NewIPFIX(cfg.Exporter.Collector, ipfix.VersionIPFIX, cfg.Exporter.ObservationDomain, cfg.Exporter.TemplateRefresh)
+		return e, nil, err
+	case "netflow9":
+		e, err := export.NewIPFIX(cfg.Exporter.Collector, ipfix.VersionNetflow9, cfg.Exporter.ObservationDomain, cfg.Exporter.TemplateRefresh)
+		return e, nil, err
+	case "events":
+		enrichers, err := newEnrichers()
+		if err != nil {
+			return nil, nil, err
+		}
+		s, err := sink.NewReloadable(openSinks(cfg.Sinks))
+		if err != nil {
+			return nil, nil, err
+		}
+		if cfg.DNS.Enabled {
+			t := dns.NewTracker(export.NewEventWriter(s, enrichers...), cfg.DNS.Options())
+			go t.Run()
+			inspectors = append(inspectors, t)
+			enrichers = append(enrichers, t)
+		}
+		if cfg.HTTP.Enabled {
+			t := httpmeta.NewTracker(export.NewEventWriter(s, enrichers...), cfg.HTTP.Options())
+			go t.Run()
+			inspectors = append(inspectors, t)
+		}
+		return export.NewEvents(s, enrichers...), s, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown exporter %s", cfg.Exporter.Type)
+	}
+}
+
+// newEnrichers creates the configured event enrichers
+func newEnrichers() ([]ecs.Enricher, error) {
+	var enrichers []ecs.Enricher
+	var c kubemeta.Cache
+	if cfg.Kubernetes.Enrich {
+		clientset, err := kubemeta.NewClientset(cfg.Kubernetes.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		c = kubemeta.NewCache(clientset, cfg.Kubernetes.Resync)
+		go func() {
+			if err := c.Run(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+		enrichers = append(enrichers, c)
+	}
+	// Socket attribution overrides the address based metadata of local endpoints
+	if cfg.Attribution.Sockets {
+		enrichers = append(enrichers, kubemeta.NewSocketEnricher(c, socketTable()))
+	}
+	return enrichers, nil
+}
+
+// inspectors examine the payload of captured packets
+var inspectors []capture.Inspector
+
+// sockets is the socket table of the node shared by the attribution of flows and events
+var sockets procfs.Table
+
+// socketTable returns the socket table of the node
+func socketTable() procfs.Table {
+	if sockets == nil {
+		// Flows are exported at the latest the idle timeout or linger time after their last
+		// packet, their sockets may be closed by then
+		retention := cfg.FlowTable.IdleTimeout + cfg.FlowTable.Linger + cfg.Attribution.Refresh
+		sockets = procfs.NewTable(cfg.Attribution.ProcRoot, cfg.Attribution.Refresh, retention)
+	}
+	return sockets
+}
+
+// openSinks returns a function opening the given event sinks
+func openSinks(specs []string) func() (sink.Sink, error) {
+	buffer := cfg.Queue.Buffer()
+	return func() (sink.Sink, error) {
+		return sink.FromSpecs(specs, buffer)
+	}
+}
+
+// offline processes a capture file and writes the resulting events
+func offline() {
+	c, err := capture.OpenFile(cfg.Offline.ReadFile, cfg.Offline.Replay)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	if cfg.Filter != "" {
+		if err := c.Filter(cfg.Filter); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	out := sink.NewStdout()
+	if cfg.Offline.WriteFile != "-" {
+		out, err = sink.NewFile(cfg.Offline.WriteFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	e := export.NewEvents(out)
+	defer e.Close()
+
+	log.Info("Processing capture file")
+
+	if err := insight.NewOffline(c, cfg.FlowTable.Options(), e).Run(); err != nil {
+		log.Error(err)
+	}
+}
+
+// newProbe creates the probe for the configured flow source. The capture is returned as well
+// for live packet sources, so its filter can be reloaded.
+func newProbe(e export.Exporter) (insight.Probe, capture.Capturer, error) {
+	switch cfg.Source {
+	case "conntrack":
+		l, err := netlink.Listen(netlink.GroupDestroy)
+		if err != nil {
+			return nil, nil, err
+		}
+		return insight.NewConntrackProbe(l, e), nil, nil
+	case "pcap", "afpacket":
+		c, err := openCapture()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if cfg.Filter != "" {
+			if err := c.Filter(cfg.Filter); err != nil {
+				return nil, nil, err
+			}
+		}
+		prometheus.MustRegister(capture.NewStatsCollector(c))
+
+		// Create new probe exporting flows on idle and active timeouts
+		return insight.NewProbe(c, cfg.FlowTable.Options(), e, inspectors...), c, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown source %s", cfg.Source)
+	}
+}
+
+// openCapture opens the configured live capture
+func openCapture() (capture.Capturer, error) {
+	open, n := capture.Opener(capture.Open), 1
+	if cfg.Source == "afpacket" {
+		open, n = capture.AFPacketOpener(cfg.AFPacket.Options()), cfg.AFPacket.Workers
+	}
+
+	// Without link events, only the interfaces present on startup are captured on
+	l, err := link.Listen()
+	if err != nil {
+		log.WithError(err).Warn("Failed to subscribe to link events")
+	}
+	return capture.OpenInterfaces(cfg.Interfaces, n, open, l)
+}
+
+// reload applies the log level, BPF filter and sinks of the current configuration. Other
+// settings require a restart.
+func reload(c capture.Capturer, s sink.Reloadable) {
+	next := config.DefaultInsight()
+	if err := config.Load(next, os.Args[1:]); err != nil {
+		log.WithError(err).Error("Invalid configuration, keeping the current one")
+		return
+	}
+	next.Apply()
+	cfg.LogLevel = next.LogLevel
+
+	if c != nil && next.Filter != cfg.Filter {
+		if err := c.Filter(next.Filter); err != nil {
+			log.WithError(err).Error("Failed to apply the BPF filter")
+		} else {
+			log.WithField("filter", next.Filter).Info("Applied BPF filter")
+			cfg.Filter = next.Filter
+		}
+	}
+
+	if s != nil && !reflect.DeepEqual(next.Sinks, cfg.Sinks) {
+		if err := s.Reload(openSinks(next.Sinks)); err != nil {
+			log.WithError(err).Error("Failed to reload the sinks")
+		} else {
+			cfg.Sinks = next.Sinks
+		}
+	}
+}
+
 func main() {
-	ls := os.Getenv("LOGSTASH")
-	c, err := capture.Open("eth0")
+	config.MustLoad(cfg)
+	cfg.Apply()
+
+	if cfg.Offline.ReadFile != "" {
+		offline()
+		return
+	}
+
+	metrics.Serve(cfg.MetricsAddr)
+
+	e, s, err := newExporter()
 	if err != nil {
-		log.Panic("failed to startup")
+		log.Fatal(err)
+	}
+	defer e.Close()
+	if cfg.Resolver != "" {
+		e = export.NewTranslating(e, clusterip.NewClient(cfg.Resolver))
+	}
+	// Sockets are addressed to the service before it is translated
+	if cfg.Attribution.Processes {
+		e = export.NewAttributing(e, socketTable())
 	}
 
-	// Create new probe, flow container lifetime of 10s
-	p := insight.NewProbe(c, 10*time.Second, ls)
+	p, c, err := newProbe(e)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go config.OnReload(func() { reload(c, s) })
 
 	log.Info("Starting insight")
 
@@ -26,11 +26,12 @@
 package main
 
 import (
-	"os"
 	"sync"
 
 	"github.com/sirupsen/logrus"
 	"github.com/xvzf/insight/internal/kubestatestore"
+	"github.com/xvzf/insight/pkg/config"
+	"github.com/xvzf/insight/pkg/metrics"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
@@ -47,17 +48,22 @@ func init() {
 }
 
 func main() {
+	cfg := config.DefaultKubeAgent()
+	config.MustLoad(cfg)
+	cfg.Apply()
+
 	log.Info("Starting up KubeAgent")
+	metrics.Serve(cfg.MetricsAddr)
 
 	// @TODO connect out of container
 	// var kubeconfig = "/Users/xvzf/.kube/config"
 	// config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-	config, err := rest.InClusterConfig()
+	restConfig, err := rest.InClusterConfig()
 
 	if err != nil {
 		log.Panic(err)
 	}
-	clientset, err := kubernetes.NewForConfig(config)
+	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -79,7 +85,7 @@ func main() {
 	}
 
 	var wg sync.WaitGroup
-	store := kubestatestore.New(os.Getenv("CONN_STRING"))
+	store := kubestatestore.New(cfg.ConnString)
 	// Create a goroutine for the pod & service watcher
 	for _, watcher := range []watch.Interface{podWatcher, svcWatcher, endpointsWatcher} {
 		wg.Add(1)
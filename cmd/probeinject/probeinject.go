@@ -27,10 +27,12 @@ package main
 
 import (
 	"net/http"
-	"os"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/xvzf/insight/internal/probeinject"
+	"github.com/xvzf/insight/pkg/config"
+	"github.com/xvzf/insight/pkg/metrics"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -46,42 +48,61 @@ func init() {
 	})
 }
 
-var (
-	tlsCertFile = os.Getenv("TLS_CERT_FILE") // TLS Certificate path
-	tlsKeyFile  = os.Getenv("TLS_KEY_FILE")  // TLS Key path
-	probeImage  = os.Getenv("PROBE_IMAGE")   // Which image to use for injection
-	logstash    = os.Getenv("LOGSTASH")      // Target passed to the injected network probe
-)
-
 func main() {
+	cfg := config.DefaultProbeInject()
+	config.MustLoad(cfg)
+	cfg.Apply()
+	metrics.Serve(cfg.MetricsAddr)
+
 	// Connect to the k8s api
-	config, err := rest.InClusterConfig()
+	restConfig, err := rest.InClusterConfig()
 
 	if err != nil {
 		log.Panic(err)
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		log.Panic(err)
 	}
 	log.Info("Connection to Kubernetes Cluster established")
 
-	// Probeinjector
-	probeInjector := probeinject.New(clientset, "insight", corev1.Container{
+	probe := corev1.Container{
 		Name:  "insight-sidecar-probe",
-		Image: probeImage,
+		Image: cfg.Probe.Image,
 		Env: []corev1.EnvVar{
 			corev1.EnvVar{
-				Name:  "LOGSTASH",
-				Value: logstash,
+				Name:  "SINKS",
+				Value: strings.Join(cfg.Probe.Sinks, ","),
+			},
+			corev1.EnvVar{
+				Name:  "METRICS_ADDR",
+				Value: cfg.Probe.MetricsAddr,
 			},
 		},
-	})
+	}
+	if cfg.Probe.Resolver != "" {
+		// The resolver runs in the host network of every node
+		probe.Env = append(probe.Env,
+			corev1.EnvVar{
+				Name: "HOST_IP",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIP"},
+				},
+			},
+			corev1.EnvVar{
+				Name:  "RESOLVER",
+				Value: cfg.Probe.Resolver,
+			},
+		)
+	}
+
+	// Probeinjector
+	probeInjector := probeinject.New(clientset, cfg.Annotation, probe)
 	http.HandleFunc("/inject", probeInjector.HandleWebhook)
 
-	log.Infof("Start listening on %s", ":8443")
-	err = http.ListenAndServeTLS(":8443", tlsCertFile, tlsKeyFile, nil)
+	log.Infof("Start listening on %s", cfg.Listen)
+	err = http.ListenAndServeTLS(cfg.Listen, cfg.TLSCertFile, cfg.TLSKeyFile, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
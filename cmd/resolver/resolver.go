@@ -26,11 +26,10 @@
 package main
 
 import (
-	"os"
-
-	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/sirupsen/logrus"
 	"github.com/xvzf/insight/internal/resolver"
+	"github.com/xvzf/insight/pkg/config"
+	"github.com/xvzf/insight/pkg/metrics"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -46,24 +45,21 @@ func init() {
 }
 
 func main() {
-	config, err := rest.InClusterConfig()
+	cfg := config.DefaultResolver()
+	config.MustLoad(cfg)
+	cfg.Apply()
+	metrics.Serve(cfg.MetricsAddr)
+
+	restConfig, err := rest.InClusterConfig()
 	if err != nil {
 		log.Panic(err)
 	}
-	clientset, err := kubernetes.NewForConfig(config)
+	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	log.Info(os.Getenv("INSIGHT_MEMCACHED_PORT_11211_TCP"))
-
-	mc := memcache.New(os.Getenv("INSIGHT_MEMCACHED_PORT_11211_TCP"))
-
-	if err := mc.Ping(); err != nil {
-		log.Fatal(err)
-	}
-
-	r := resolver.New(clientset, mc)
+	r := resolver.New(clientset, cfg.Listen, cfg.AllowedNets(), cfg.MappingTTL, cfg.DestroyedMappingTTL)
 
 	if err := r.Run(); err != nil {
 		log.Fatal(err)
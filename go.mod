@@ -3,12 +3,14 @@ module github.com/xvzf/insight
 go 1.13
 
 require (
-	github.com/bradfitz/gomemcache v0.0.0-20190913173617-a41fca850d0b
 	github.com/cloudflare/cfssl v1.4.1
-	github.com/google/go-cmp v0.3.1
+	github.com/google/go-cmp v0.4.0
 	github.com/google/gopacket v1.1.17
 	github.com/lib/pq v1.3.0
+	github.com/prometheus/client_golang v1.5.1
 	github.com/sirupsen/logrus v1.4.2
+	golang.org/x/net v0.0.0-20191004110552-13f9640d40b9
+	gopkg.in/yaml.v2 v2.2.5
 	k8s.io/api v0.17.0
 	k8s.io/apimachinery v0.17.0
 	k8s.io/client-go v0.17.0
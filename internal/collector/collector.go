@@ -0,0 +1,172 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package collector
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xvzf/insight/pkg/export"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/communityid"
+	"github.com/xvzf/insight/pkg/ipfix"
+)
+
+// Logger
+var log *logrus.Entry
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "collector",
+	})
+}
+
+// maxPacketSize is the largest UDP datagram the collector accepts
+const maxPacketSize = 65535
+
+// Collector receives NetFlow v5/v9 and IPFIX messages from third party exporters (routers,
+// switches, CNIs) and passes the decoded flows to the exporter
+type Collector interface {
+	Run() error
+	Stop()
+}
+
+type collector struct {
+	conn          net.PacketConn     // UDP listener
+	decoder       ipfix.Decoder      // Message decoder, caches templates per exporter
+	hasher        communityid.Hasher // CommunityID hasher
+	exporter      export.Exporter    // Flow exporter
+	batchSize     int                // Maximum number of flows per export
+	flushInterval time.Duration      // Maximum time flows are buffered
+	flowChan      chan []*flow.Flow  // Decoded flows
+	exitChan      chan struct{}      // Exit channel
+	errChan       chan error         // Error channel
+}
+
+// New creates a new collector reading from conn. Flows are exported in batches of at most
+// batchSize flows or after flushInterval, whatever comes first
+func New(conn net.PacketConn, e export.Exporter, batchSize int, flushInterval time.Duration) Collector {
+	return &collector{
+		conn:          conn,
+		decoder:       ipfix.NewDecoder(),
+		hasher:        communityid.NewHasher(0),
+		exporter:      e,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		flowChan:      make(chan []*flow.Flow, 100),
+		exitChan:      make(chan struct{}),
+		errChan:       make(chan error),
+	}
+}
+
+func (c *collector) receiveRunner() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-c.exitChan:
+			default:
+				c.errChan <- err
+			}
+			return
+		}
+
+		flows, err := c.decoder.Decode(addr.String(), buf[:n])
+		if err != nil {
+			messagesReceived.WithLabelValues("invalid").Inc()
+			log.WithError(err).WithField("exporter", addr.String()).Warn("Failed to decode message")
+			continue
+		}
+		messagesReceived.WithLabelValues("decoded").Inc()
+		flowsReceived.Add(float64(len(flows)))
+		if len(flows) == 0 {
+			continue
+		}
+
+		for _, f := range flows {
+			f.CommunityID = c.hasher.Hash(f.Meta)
+		}
+
+		select {
+		case c.flowChan <- flows:
+		default:
+			flowsDropped.Add(float64(len(flows)))
+			log.Errorf("Buffer full, %d flow records lost", len(flows))
+		}
+	}
+}
+
+func (c *collector) export(flows []*flow.Flow) {
+	if len(flows) == 0 {
+		return
+	}
+	if err := c.exporter.Export(flows); err != nil {
+		log.WithError(err).Errorf("Failed to export flows, %d flow records lost", len(flows))
+		return
+	}
+	log.WithField("flows", len(flows)).Info("Exported flows")
+}
+
+func (c *collector) exportRunner() {
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	var batch []*flow.Flow
+	for {
+		select {
+		case <-c.exitChan:
+			c.export(batch)
+			return
+		case <-ticker.C:
+			c.export(batch)
+			batch = nil
+		case flows := <-c.flowChan:
+			batch = append(batch, flows...)
+			if len(batch) >= c.batchSize {
+				c.export(batch)
+				batch = nil
+			}
+		}
+	}
+}
+
+func (c *collector) Run() error {
+	if c.batchSize <= 0 || c.flushInterval <= 0 {
+		return errors.New("batch size and flush interval have to be positive")
+	}
+	go c.receiveRunner()
+	go c.exportRunner()
+	return <-c.errChan
+}
+
+func (c *collector) Stop() {
+	close(c.exitChan)
+	c.conn.Close()
+	c.errChan <- nil
+}
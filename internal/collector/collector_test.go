@@ -0,0 +1,140 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package collector
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/communityid"
+	"github.com/xvzf/insight/pkg/ipfix"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+// recordingExporter keeps the exported batches
+type recordingExporter struct {
+	sync.Mutex
+	batches [][]*flow.Flow
+}
+
+func (r *recordingExporter) Export(flows []*flow.Flow) error {
+	r.Lock()
+	defer r.Unlock()
+	r.batches = append(r.batches, flows)
+	return nil
+}
+
+func (r *recordingExporter) Close() error {
+	return nil
+}
+
+func (r *recordingExporter) exported() [][]*flow.Flow {
+	r.Lock()
+	defer r.Unlock()
+	return append([][]*flow.Flow(nil), r.batches...)
+}
+
+func TestCollector(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recordingExporter{}
+	c := New(conn, rec, 2, time.Hour)
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Run() }()
+
+	sender, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	flows := []*flow.Flow{
+		{
+			Meta:     flow.Meta{Transport: protos.TCP, Src: net.ParseIP("10.0.0.1"), Dst: net.ParseIP("10.0.0.2"), SrcPort: 50124, DstPort: 443},
+			Incoming: flow.Counters{Bytes: 1024, Packets: 4},
+			Outgoing: flow.Counters{Bytes: 4096, Packets: 3},
+			Start:    time.Unix(1577880000, 0),
+			End:      time.Unix(1577880010, 0),
+		},
+		{
+			Meta:     flow.Meta{Transport: protos.UDP, Src: net.ParseIP("10.0.0.1"), Dst: net.ParseIP("10.0.0.53"), SrcPort: 5353, DstPort: 53},
+			Outgoing: flow.Counters{Bytes: 64, Packets: 1},
+			Start:    time.Unix(1577880000, 0),
+			End:      time.Unix(1577880000, 0),
+		},
+	}
+
+	// Invalid messages are skipped
+	sender.Write([]byte{0, 10, 0, 255})
+	e := ipfix.NewEncoder(ipfix.VersionIPFIX, 1, ipfix.DefaultPEN, 1400)
+	for _, msg := range e.Encode(flows, time.Unix(1577880020, 0), true) {
+		if _, err := sender.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Exported once the batch is full
+	deadline := time.Now().Add(time.Second)
+	for len(rec.exported()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	batch := rec.exported()[0]
+	if len(batch) != 2 {
+		t.Fatalf("expected 2 flows, got %d", len(batch))
+	}
+	for i, f := range batch {
+		if f.Meta.DstPort != flows[i].Meta.DstPort || f.Outgoing.Bytes != flows[i].Outgoing.Bytes {
+			t.Errorf("unexpected flow %v", f)
+		}
+		if f.CommunityID != communityid.NewHasher(0).Hash(flows[i].Meta) {
+			t.Errorf("unexpected CommunityID %s", f.CommunityID)
+		}
+	}
+
+	c.Stop()
+	if err := <-errChan; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectorInvalidConfig(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := New(conn, &recordingExporter{}, 0, time.Second).Run(); err == nil {
+		t.Error("expected error for zero batch size")
+	}
+}
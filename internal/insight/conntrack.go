@@ -0,0 +1,142 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package insight
+
+import (
+	"time"
+
+	"github.com/xvzf/insight/pkg/conntrack/netlink"
+	"github.com/xvzf/insight/pkg/export"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/communityid"
+)
+
+// maxConntrackBatch limits the number of flows buffered between two exports
+const maxConntrackBatch = 1000
+
+type conntrackProbe struct {
+	listener netlink.Listener   // Conntrack DESTROY events
+	exporter export.Exporter    // Flow exporter
+	hasher   communityid.Hasher // CommunityID hasher
+	flowChan chan *flow.Flow    // Flows of destroyed entries
+	exitChan chan struct{}      // Exit channel
+	errChan  chan error         // Error channel
+}
+
+// NewConntrackProbe creates a probe building flow records from destroyed conntrack entries
+// instead of capturing packets. The listener has to be subscribed to netlink.GroupDestroy;
+// byte and packet counters require nf_conntrack_acct, exact start and end times
+// nf_conntrack_timestamp to be enabled.
+func NewConntrackProbe(l netlink.Listener, e export.Exporter) Probe {
+	return &conntrackProbe{
+		listener: l,
+		exporter: e,
+		hasher:   communityid.NewHasher(0),
+		flowChan: make(chan *flow.Flow, maxConntrackBatch),
+		exitChan: make(chan struct{}),
+		errChan:  make(chan error),
+	}
+}
+
+func (p *conntrackProbe) receiveRunner() {
+	warned := false
+	for {
+		events, err := p.listener.Receive()
+		if err == netlink.ErrLostEvents {
+			log.Warn(err)
+			continue
+		}
+		if err != nil {
+			select {
+			case <-p.exitChan:
+			default:
+				p.errChan <- err
+			}
+			return
+		}
+
+		for _, e := range events {
+			f := e.Entry.Flow()
+			if f.Incoming.Packets == 0 && f.Outgoing.Packets == 0 && !warned {
+				log.Warn("Conntrack entries without counters, enable net.netfilter.nf_conntrack_acct")
+				warned = true
+			}
+			f.CommunityID = p.hasher.Hash(f.Meta)
+
+			select {
+			case p.flowChan <- f:
+			case <-p.exitChan:
+				return
+			}
+		}
+	}
+}
+
+func (p *conntrackProbe) export(flows []*flow.Flow) {
+	if len(flows) == 0 {
+		return
+	}
+	if err := p.exporter.Export(flows); err != nil {
+		log.WithError(err).Errorf("Failed to export flows, %d flow records lost", len(flows))
+		return
+	}
+	log.WithField("flows", len(flows)).Info("Exported flows")
+}
+
+func (p *conntrackProbe) dumpRunner() {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	var flows []*flow.Flow
+	for {
+		select {
+		case <-p.exitChan:
+			p.export(flows)
+			return
+		case <-ticker.C:
+			p.export(flows)
+			flows = nil
+		case f := <-p.flowChan:
+			flows = append(flows, f)
+			if len(flows) >= maxConntrackBatch {
+				p.export(flows)
+				flows = nil
+			}
+		}
+	}
+}
+
+func (p *conntrackProbe) Run() error {
+	go p.receiveRunner()
+	go p.dumpRunner()
+	return <-p.errChan
+}
+
+func (p *conntrackProbe) Stop() {
+	close(p.exitChan)
+	p.listener.Close()
+	p.errChan <- nil
+}
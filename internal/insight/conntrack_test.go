@@ -0,0 +1,134 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package insight
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xvzf/insight/pkg/conntrack"
+	"github.com/xvzf/insight/pkg/conntrack/netlink"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/communityid"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+// fakeListener returns the events passed to events, Receive fails once it has been closed
+type fakeListener struct {
+	events chan []*conntrack.Event
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newFakeListener() *fakeListener {
+	return &fakeListener{events: make(chan []*conntrack.Event), closed: make(chan struct{})}
+}
+
+func (f *fakeListener) Receive() ([]*conntrack.Event, error) {
+	select {
+	case events := <-f.events:
+		if events == nil {
+			return nil, netlink.ErrLostEvents
+		}
+		return events, nil
+	case <-f.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (f *fakeListener) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+// recordingExporter keeps the exported flows
+type recordingExporter struct {
+	sync.Mutex
+	flows []*flow.Flow
+}
+
+func (r *recordingExporter) Export(flows []*flow.Flow) error {
+	r.Lock()
+	defer r.Unlock()
+	r.flows = append(r.flows, flows...)
+	return nil
+}
+
+func (r *recordingExporter) Close() error {
+	return nil
+}
+
+func (r *recordingExporter) exported() []*flow.Flow {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*flow.Flow(nil), r.flows...)
+}
+
+func TestConntrackProbe(t *testing.T) {
+	l := newFakeListener()
+	rec := &recordingExporter{}
+	p := NewConntrackProbe(l, rec)
+	errChan := make(chan error, 1)
+	go func() { errChan <- p.Run() }()
+
+	meta := flow.Meta{Transport: protos.TCP, Src: net.ParseIP("10.42.2.28"), Dst: net.ParseIP("10.42.1.16"), SrcPort: 53296, DstPort: 80}
+	reply := flow.Meta{Transport: protos.TCP, Src: meta.Dst, Dst: meta.Src, SrcPort: meta.DstPort, DstPort: meta.SrcPort}
+	l.events <- nil // Overrun, the probe keeps running
+	l.events <- []*conntrack.Event{{
+		Type: conntrack.EventDestroy,
+		Entry: conntrack.Entry{
+			FlowMeta0: meta,
+			FlowMeta1: reply,
+			Counters0: flow.Counters{Packets: 6, Bytes: 500},
+			Counters1: flow.Counters{Packets: 10, Bytes: 12000},
+			TCPState:  conntrack.TCPTimeWait,
+		},
+	}}
+
+	// Exported on the next tick
+	deadline := time.Now().Add(3 * expiryInterval)
+	for len(rec.exported()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	f := rec.exported()[0]
+	if f.CommunityID != communityid.NewHasher(0).Hash(meta) {
+		t.Errorf("unexpected CommunityID %s", f.CommunityID)
+	}
+	if f.Incoming.Bytes != 500 || f.Outgoing.Bytes != 12000 || f.State() != flow.StateClosed {
+		t.Errorf("unexpected flow %+v", f)
+	}
+
+	p.Stop()
+	if err := <-errChan; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,94 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package insight
+
+import (
+	"time"
+
+	"github.com/xvzf/insight/pkg/capture"
+	"github.com/xvzf/insight/pkg/export"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/container"
+)
+
+// Offline runs a (file based) capture through a flow container and exports the resulting flows
+type Offline interface {
+	Run() error
+}
+
+type offline struct {
+	options  container.Options // Flow container options
+	capture  capture.Capturer  // Capture object
+	exporter export.Exporter   // Flow exporter
+}
+
+// NewOffline creates a new offline runner passing flows to the exporter.
+// Flows are expired based on the capture timestamps of the processed packets.
+func NewOffline(c capture.Capturer, opts container.Options, e export.Exporter) Offline {
+	return &offline{
+		options:  opts,
+		capture:  c,
+		exporter: e,
+	}
+}
+
+// write exports the given flows
+func (o *offline) write(flows []*flow.Flow) error {
+	if len(flows) == 0 {
+		return nil
+	}
+	if err := o.exporter.Export(flows); err != nil {
+		return err
+	}
+	log.WithField("flows", len(flows)).Info("Exported flows")
+	return nil
+}
+
+// Run processes packets until the capture is exhausted
+func (o *offline) Run() error {
+	c := container.NewWithOptions(o.options)
+
+	var lastExpiry time.Time
+	for gp := range o.capture.Packets() {
+		s, err := capture.NewSample(gp)
+		if err != nil {
+			log.Debug(err)
+			continue
+		}
+		c.Add(s)
+
+		// Expire flows according to the capture time
+		if s.Timestamp.Sub(lastExpiry) >= expiryInterval {
+			if err := o.write(c.Expire(s.Timestamp)); err != nil {
+				return err
+			}
+			lastExpiry = s.Timestamp
+		}
+	}
+
+	// End of capture, flush what is left
+	return o.write(c.Dump())
+}
@@ -0,0 +1,86 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package insight
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/capture"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/container"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+func TestOffline(t *testing.T) {
+	c, err := capture.OpenFile("../../pkg/capture/testdata/flows.pcap", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	e := &recordingExporter{}
+	opts := container.Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute}
+	if err := NewOffline(c, opts, e).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The capture ends before the flows expire, all are flushed
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	client, server := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	expected := map[protos.ProtocolType]*flow.Flow{
+		protos.TCP: {
+			Meta:      flow.Meta{Transport: protos.TCP, Src: client, SrcPort: 41234, Dst: server, DstPort: 80},
+			Incoming:  flow.Counters{Packets: 3, Bytes: 120, TCPFlags: flow.TCPFlagSYN | flow.TCPFlagACK | flow.TCPFlagRST},
+			Outgoing:  flow.Counters{Packets: 1, Bytes: 40, TCPFlags: flow.TCPFlagSYN | flow.TCPFlagACK},
+			Start:     start,
+			End:       start.Add(100 * time.Millisecond),
+			EndReason: flow.EndForced,
+		},
+		protos.UDP: {
+			Meta:      flow.Meta{Transport: protos.UDP, Src: client, SrcPort: 5353, Dst: server, DstPort: 53},
+			Incoming:  flow.Counters{Packets: 1, Bytes: 32},
+			Start:     start.Add(75 * time.Millisecond),
+			End:       start.Add(75 * time.Millisecond),
+			EndReason: flow.EndForced,
+		},
+	}
+
+	if len(e.flows) != len(expected) {
+		t.Fatalf("Expected %d flows, got %d", len(expected), len(e.flows))
+	}
+	for _, f := range e.flows {
+		if f.CommunityID == "" {
+			t.Error("CommunityID not set")
+		}
+		f.CommunityID = ""
+		if diff := cmp.Diff(expected[f.Meta.Transport], f); diff != "" {
+			t.Error(diff)
+		}
+	}
+}
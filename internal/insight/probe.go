@@ -26,18 +26,15 @@
 package insight
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
-	"net/http"
-	"sync"
+	"fmt"
+	"strconv"
 	"time"
 
-	"github.com/google/gopacket"
 	"github.com/sirupsen/logrus"
 	"github.com/xvzf/insight/pkg/capture"
+	"github.com/xvzf/insight/pkg/export"
+	"github.com/xvzf/insight/pkg/flow"
 	"github.com/xvzf/insight/pkg/flow/container"
-	"github.com/xvzf/insight/pkg/insight"
 )
 
 // Logger
@@ -57,114 +54,149 @@ type Probe interface {
 }
 
 type probe struct {
-	sampleTime     time.Duration         // How often should we create a new flow container
-	logstash       string                // Logstash target
-	capture        capture.Capturer      // Capture object
-	container      container.Container   // Flow container
-	containerMutex sync.Mutex            // goroutine safe :-)
-	dumpChan       chan []*insight.Event // Dump channel
-	exitChan       chan struct{}         // Exit channel
-	errChan        chan error            // Error channel
+	exporter   export.Exporter     // Flow exporter
+	capture    capture.Capturer    // Capture object
+	inspectors []capture.Inspector // Payload inspectors
+	workers    []*worker           // Capture workers, each owning a share of the flows
+	dumpChan   chan []*flow.Flow   // Dump channel
+	exitChan   chan struct{}       // Exit channel
+	errChan    chan error          // Error channel
 }
 
-// NewProbe creates a new probe object
-func NewProbe(c capture.Capturer, st time.Duration, l string) Probe {
+// worker processes the packets of one capture socket
+type worker struct {
+	id        string              // Worker ID used for metrics
+	capture   capture.Capturer    // Capture socket
+	container container.Container // Flow container
+}
+
+// expiryInterval defines how often the flow container is checked for expired flows
+const expiryInterval = time.Second
+
+// NewProbe creates a new probe object; flows are passed to the exporter after being idle or
+// active for the configured timeouts. Fanout capturers get one worker and flow container per
+// socket, the flow limit is shared by the workers. Samples are passed to the inspectors
+// before they are added to the flow container.
+func NewProbe(c capture.Capturer, opts container.Options, e export.Exporter, inspectors ...capture.Inspector) Probe {
 	p := &probe{
 		capture:    c,
-		sampleTime: st,
-		logstash:   l,
-		container:  container.New(),
-		dumpChan:   make(chan []*insight.Event, 10),
+		exporter:   e,
+		inspectors: inspectors,
+		dumpChan:   make(chan []*flow.Flow, 10),
 		exitChan:   make(chan struct{}),
 		errChan:    make(chan error),
 	}
 
-	return p
-}
-
-func (p *probe) newContainer() {
-	p.containerMutex.Lock()
-	defer p.containerMutex.Unlock()
-	log.Info("Creating new flow container")
-	// convert to events & transmit
-	select {
-	case p.dumpChan <- insight.NewFromFlows(p.container.Dump()):
-	default:
-		p.errChan <- errors.New("Buffer full, dropping flows")
+	sources := []capture.Capturer{c}
+	if f, ok := c.(capture.Fanout); ok {
+		sources = f.Workers()
+	}
+	if opts.MaxFlows > 0 {
+		opts.Limit = container.NewLimit(opts.MaxFlows)
+	}
+	for i, source := range sources {
+		p.workers = append(p.workers, &worker{
+			id:        strconv.Itoa(i),
+			capture:   source,
+			container: container.NewWithOptions(opts),
+		})
 	}
 
-	p.container = container.New()
-}
-
-func (p *probe) addEvents(events []*insight.Event) {
+	return p
 }
 
-func (p *probe) handlePacket(gp gopacket.Packet) {
-	s, err := capture.NewSample(gp)
-	if err != nil {
-		log.Warn(err)
+func (p *probe) expireFlows() {
+	now := time.Now()
+	var flows []*flow.Flow
+	for _, w := range p.workers {
+		flows = append(flows, w.container.Expire(now)...)
+		activeFlows.WithLabelValues(w.id).Set(float64(w.container.Len()))
+	}
+	if len(flows) == 0 {
 		return
 	}
-	p.container.Add(s)
+
+	// transmit, waiting for the exporter to catch up instead of dropping flows
+	select {
+	case p.dumpChan <- flows:
+	default:
+		log.Warn("Dump buffer full, delaying flow expiry")
+		select {
+		case p.dumpChan <- flows:
+		case <-p.exitChan:
+		}
+	}
 }
 
-func (p *probe) captureRunner() {
-	for gp := range p.capture.Packets() {
+// captureRunner adds the packets of a worker to its flow container; packets are processed
+// in order so a busy capture applies back pressure instead of piling up goroutines
+func (p *probe) captureRunner(w *worker) {
+	for gp := range w.capture.Packets() {
 		select {
-		case <-p.errChan:
-			return
 		case <-p.exitChan:
 			return
 		default:
 		}
-		go p.handlePacket(gp)
+		s, err := capture.NewSample(gp)
+		if err != nil {
+			log.Debug(err)
+			continue
+		}
+		for _, i := range p.inspectors {
+			i.Inspect(s)
+		}
+		w.container.Add(s)
+	}
+	select {
+	case p.errChan <- fmt.Errorf("captureRunner %s exited", w.id):
+	case <-p.exitChan:
 	}
-	p.errChan <- errors.New("captureRunner exited")
 }
 
 func (p *probe) dumpRunner() {
 	for {
 		select {
-		case <-p.errChan:
-			return
 		case <-p.exitChan:
 			return
-		case events := <-p.dumpChan:
-			js, err := json.Marshal(events)
-			if err != nil {
-				p.errChan <- err
+		case flows := <-p.dumpChan:
+			if err := p.exporter.Export(flows); err != nil {
+				log.WithError(err).Errorf("Failed to export flows, %d flow records lost", len(flows))
+				continue
 			}
-			resp, err := http.Post(p.logstash, "application/json", bytes.NewBuffer(js))
-			if err != nil || resp.StatusCode != 200 {
-				log.Errorf("Failed to dump buffer, %d flow records lost", len(events))
-			}
-			log.WithField("container_size", len(events)).Info("Dumped container")
+			log.WithField("flows", len(flows)).Info("Exported flows")
 		}
 	}
 }
 
-func (p *probe) containerRenewRunner() {
+func (p *probe) expiryRunner() {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
-		case <-p.errChan:
-			return
 		case <-p.exitChan:
 			return
-		default:
-			time.Sleep(p.sampleTime)
+		case <-ticker.C:
 		}
-		p.newContainer()
+		p.expireFlows()
 	}
 }
 
 func (p *probe) Run() error {
-	go p.captureRunner()
-	go p.containerRenewRunner()
+	for _, w := range p.workers {
+		go p.captureRunner(w)
+	}
+	go p.expiryRunner()
 	go p.dumpRunner()
-	return <-p.errChan
+
+	select {
+	case err := <-p.errChan:
+		return err
+	case <-p.exitChan:
+		return nil
+	}
 }
 
 func (p *probe) Stop() {
 	close(p.exitChan)
-	p.errChan <- nil
 }
@@ -0,0 +1,91 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package insight
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/xvzf/insight/pkg/capture"
+	"github.com/xvzf/insight/pkg/flow/container"
+)
+
+// fakeCapturer is a capture without packets
+type fakeCapturer struct {
+	packets chan gopacket.Packet
+}
+
+func (f *fakeCapturer) Packets() chan gopacket.Packet { return f.packets }
+func (f *fakeCapturer) Filter(string) error           { return nil }
+func (f *fakeCapturer) Stats() (*capture.Stats, error) {
+	return &capture.Stats{}, nil
+}
+func (f *fakeCapturer) Close() {}
+
+// runProbe runs p, returning the error of Run on the channel
+func runProbe(p Probe) chan error {
+	errChan := make(chan error, 1)
+	go func() { errChan <- p.Run() }()
+	return errChan
+}
+
+func TestProbeCaptureExit(t *testing.T) {
+	opts := container.Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute}
+	// The error of an exited worker is never taken by another runner
+	for i := 0; i < 10; i++ {
+		c := &fakeCapturer{packets: make(chan gopacket.Packet)}
+		p := NewProbe(c, opts, &recordingExporter{})
+		errChan := runProbe(p)
+		close(c.packets)
+
+		select {
+		case err := <-errChan:
+			if err == nil {
+				t.Error("Expected an error for the exited capture")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Probe still running without capture")
+		}
+		p.Stop()
+	}
+}
+
+func TestProbeStop(t *testing.T) {
+	c := &fakeCapturer{packets: make(chan gopacket.Packet)}
+	p := NewProbe(c, container.Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute}, &recordingExporter{})
+	errChan := runProbe(p)
+
+	p.Stop()
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Probe still running after stop")
+	}
+}
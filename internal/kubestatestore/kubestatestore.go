@@ -83,14 +83,17 @@ func (k *kubeStateStore) HandleUpdate(e watch.Event) {
 	}
 
 	if pod, ok := e.Object.(*v1.Pod); ok {
+		updatesReceived.WithLabelValues("pod", string(e.Type)).Inc()
 		k.handlePodUpdate(e.Type, pod)
 	}
 
 	if svc, ok := e.Object.(*v1.Service); ok {
+		updatesReceived.WithLabelValues("service", string(e.Type)).Inc()
 		k.handleSvcUpdate(e.Type, svc)
 	}
 
 	if endpoints, ok := e.Object.(*v1.Endpoints); ok {
+		updatesReceived.WithLabelValues("endpoints", string(e.Type)).Inc()
 		k.handleEndpointsUpdate(e.Type, endpoints)
 	}
 }
@@ -108,6 +111,7 @@ func (k *kubeStateStore) handlePodUpdate(event watch.EventType, pod *v1.Pod) {
 			_, err = k.db.Exec(query, pod.UID, pod.Name, pod.Namespace, pod.Status.PodIP, d)
 		}
 		if err != nil {
+			storeErrors.WithLabelValues("pod").Inc()
 			log.WithField("kind", "pod").Error("pq error:", err)
 		} else {
 			log.WithFields(logrus.Fields{
@@ -125,6 +129,7 @@ func (k *kubeStateStore) handlePodUpdate(event watch.EventType, pod *v1.Pod) {
 			_, err = k.db.Exec(query, pod.UID, pod.Name, pod.Namespace, pod.Status.PodIP, d)
 		}
 		if err != nil {
+			storeErrors.WithLabelValues("pod").Inc()
 			log.WithField("kind", "pod").Error("pq error:", err)
 		} else {
 			log.WithFields(logrus.Fields{
@@ -136,6 +141,7 @@ func (k *kubeStateStore) handlePodUpdate(event watch.EventType, pod *v1.Pod) {
 	case "DELETED":
 		_, err = k.db.Exec("delete from pods where uid = $1", pod.UID)
 		if err != nil {
+			storeErrors.WithLabelValues("pod").Inc()
 			log.WithField("kind", "pod").Error("pq error:", err)
 		} else {
 			log.WithFields(logrus.Fields{
@@ -162,6 +168,7 @@ func (k *kubeStateStore) handleSvcUpdate(event watch.EventType, svc *v1.Service)
 			_, err = k.db.Exec(query, svc.UID, svc.Name, svc.Namespace, svc.Spec.ClusterIP, d)
 		}
 		if err != nil {
+			storeErrors.WithLabelValues("service").Inc()
 			log.WithField("kind", "service").Error("pq error:", err)
 		} else {
 			log.WithFields(logrus.Fields{
@@ -178,6 +185,7 @@ func (k *kubeStateStore) handleSvcUpdate(event watch.EventType, svc *v1.Service)
 			_, err = k.db.Exec(query, svc.UID, svc.Name, svc.Namespace, svc.Spec.ClusterIP, d)
 		}
 		if err != nil {
+			storeErrors.WithLabelValues("service").Inc()
 			log.WithField("kind", "service").Error("pq error:", err)
 		} else {
 			log.WithFields(logrus.Fields{
@@ -188,6 +196,7 @@ func (k *kubeStateStore) handleSvcUpdate(event watch.EventType, svc *v1.Service)
 	case "DELETED":
 		_, err = k.db.Exec("delete from pods where uid = $1", svc.UID)
 		if err != nil {
+			storeErrors.WithLabelValues("service").Inc()
 			log.WithField("kind", "service").Error("pq error:", err)
 		} else {
 			log.WithFields(logrus.Fields{
@@ -209,6 +218,7 @@ func (k *kubeStateStore) handleEndpointsUpdate(event watch.EventType, endpoints
 		// Not an ideal solution but it works for now. @TODO
 		_, err = k.db.Exec(query, endpoints.UID, endpoints.Name, endpoints.Namespace, d)
 		if err != nil {
+			storeErrors.WithLabelValues("endpoints").Inc()
 			log.WithField("kind", "endpoints").Error("pq error:", err)
 		} else {
 			log.WithFields(logrus.Fields{
@@ -220,6 +230,7 @@ func (k *kubeStateStore) handleEndpointsUpdate(event watch.EventType, endpoints
 		query := "update endpoints set name = $2, namespace = $3, definition = $4 where uid = $1"
 		_, err = k.db.Exec(query, endpoints.UID, endpoints.Name, endpoints.Namespace, d)
 		if err != nil {
+			storeErrors.WithLabelValues("endpoints").Inc()
 			log.WithField("kind", "endpoints").Error("pq error:", err)
 		} else {
 			log.WithFields(logrus.Fields{
@@ -230,6 +241,7 @@ func (k *kubeStateStore) handleEndpointsUpdate(event watch.EventType, endpoints
 	case "DELETED":
 		_, err = k.db.Exec("delete from endpoints where uid = $1", endpoints.UID)
 		if err != nil {
+			storeErrors.WithLabelValues("endpoints").Inc()
 			log.WithField("kind", "endpoints").Error("pq error:", err)
 		} else {
 			log.WithFields(logrus.Fields{
@@ -85,6 +85,7 @@ func (i *injector) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 	log := log.WithField("func", "HandleWebhook")
 
 	if r.Header.Get("Content-Type") != "application/json" {
+		admissions.WithLabelValues("error").Inc()
 		log.Error("invalid content type, expected application/json")
 		http.Error(w, "invalid content type, expected application/json", http.StatusBadRequest)
 		return
@@ -98,6 +99,7 @@ func (i *injector) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	if len(body) == 0 {
+		admissions.WithLabelValues("error").Inc()
 		log.Error("empty request body")
 		http.Error(w, "empty request body", http.StatusBadRequest)
 		return
@@ -106,6 +108,7 @@ func (i *injector) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 	// Decode AdmissionRequest
 	admissionReviewRequest := admissionv1.AdmissionReview{}
 	if json.Unmarshal(body, &admissionReviewRequest) != nil {
+		admissions.WithLabelValues("error").Inc()
 		log.Error("Failed to unmarshal AdmissionRequest object")
 		http.Error(w, "failed to unmarshal AdmissionRequest", http.StatusBadRequest)
 		return
@@ -114,6 +117,7 @@ func (i *injector) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 	// Genrate AdmissionReview including the patch for the sidecar
 	resp, err := i.genAdmissionResponse(admissionReviewRequest.Request)
 	if err != nil {
+		admissions.WithLabelValues("error").Inc()
 		log.Error(err)
 		http.Error(w, "patch generation failed", http.StatusInternalServerError)
 	}
@@ -151,6 +155,7 @@ func (i *injector) genAdmissionResponse(req *admissionv1.AdmissionRequest) (*adm
 
 	// Check if the namespace the pod is supposted to be deployed has probe-injection enabled
 	if !i.podEnabledInjection(&pod) {
+		admissions.WithLabelValues("skipped").Inc()
 		return &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}, nil
 	}
 
@@ -160,6 +165,9 @@ func (i *injector) genAdmissionResponse(req *admissionv1.AdmissionRequest) (*adm
 		return nil, err
 	}
 
+	admissions.WithLabelValues("patched").Inc()
+	patches.Inc()
+
 	// Build AdmissionResponse response object
 	return &admissionv1.AdmissionResponse{
 		UID:       req.UID,    //UID from the request needs to be passed
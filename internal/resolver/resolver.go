@@ -26,18 +26,15 @@
 package resolver
 
 import (
-	"bufio"
-	"encoding/json"
 	"errors"
 	"net"
-	"os/exec"
+	"net/http"
+	"time"
 
-	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/sirupsen/logrus"
 	"github.com/xvzf/insight/pkg/clusterip"
 	"github.com/xvzf/insight/pkg/conntrack"
-	"github.com/xvzf/insight/pkg/conntrack/parser"
-	"github.com/xvzf/insight/pkg/flow/communityid"
+	"github.com/xvzf/insight/pkg/conntrack/netlink"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -51,45 +48,49 @@ func init() {
 	})
 }
 
-// Resolver keeps track of the kubernetes cluster state and updates
-// Tables in postgres
+// expiryInterval is the interval expired ClusterIP mappings are removed in
+const expiryInterval = 10 * time.Second
+
+// Resolver keeps track of the connections to kubernetes services on a node and serves the
+// backends they have been translated to
 type Resolver interface {
 	Run() error
 	Stop()
 }
 
-// ClusterIPmap describes a mapping entry for a clusterIP -> podIP based on the communityID
-type ClusterIPmap struct {
-	CommunityID string `json:"community_id"` // New CommunityID
-	ReplaceIP   net.IP `json:"replace_ip"`   // Actual target IP
-	ReplacePort uint16 `json:"replace_port"` // Actual target port
-}
-
 type resolver struct {
 	watcher   clusterip.Watcher
+	table     clusterip.Table
 	clientset *kubernetes.Clientset
-	memcache  *memcache.Client
+	listen    string
+	allowed   []*net.IPNet
 	exitChan  chan struct{}
 	errChan   chan error
-	hasher    communityid.Hasher
 }
 
-// New creates a new Resolver
-func New(clientset *kubernetes.Clientset, mc *memcache.Client) Resolver {
+// New creates a new Resolver serving the translation API on listen to clients in the allowed
+// networks (all if empty). Mappings expire after ttl, or destroyedTTL after the connection has
+// been closed.
+func New(clientset *kubernetes.Clientset, listen string, allowed []*net.IPNet, ttl, destroyedTTL time.Duration) Resolver {
 	return &resolver{
 		watcher:   clusterip.NewWatcher(),
+		table:     clusterip.NewTable(ttl, destroyedTTL),
 		clientset: clientset,
-		memcache:  mc,
+		listen:    listen,
+		allowed:   allowed,
 		exitChan:  make(chan struct{}),
 		errChan:   make(chan error),
-		hasher:    communityid.NewHasher(0),
 	}
 }
 
 func (r *resolver) Run() error {
+	r.updateNodeIPs()
+
 	// ClusterIP service watcher
 	go r.clusterIPrunner()
 	go r.conntrackRunner()
+	go r.expiryRunner()
+	go r.apiRunner()
 
 	err := <-r.errChan
 	// Trigger exit on the other running goroutines
@@ -102,92 +103,106 @@ func (r *resolver) Stop() {
 }
 
 func (r *resolver) handleConntrackEvent(e *conntrack.Event) {
-	log := log.WithField("func", "handleConntrackEvent")
-
-	if !r.watcher.IsServiceIP(e.Entry.FlowMeta0.Dst) {
-		// Conntrack entry is not a clusterIP, exit here
+	svc := r.watcher.Lookup(e.Entry.FlowMeta0)
+	if svc == nil {
+		// Conntrack entry is not addressed to a service, exit here
 		return
 	}
 
-	// Calculate the replacement object
-	cID := r.hasher.Hash(e.Entry.FlowMeta0)
-	cm := ClusterIPmap{
-		CommunityID: r.hasher.Hash(e.Entry.FlowMeta1),
-		ReplaceIP:   e.Entry.FlowMeta1.Src,
-		ReplacePort: e.Entry.FlowMeta1.SrcPort,
-	}
-
-	js, _ := json.Marshal(cm)
-
+	r.table.HandleEvent(e)
 	switch e.Type {
 	case conntrack.EventNew:
-		{
-			err := r.memcache.Set(&memcache.Item{
-				Key:        cID,
-				Value:      js,
-				Expiration: 3600, // Default expiration time (in secondds) to be sure it gets delete
-			})
-			if err != nil {
-				log.Error(err)
-			}
-			log.WithField("type", "NEW").Debug(string(js))
-		}
+		mappingsWritten.WithLabelValues("new").Inc()
 	case conntrack.EventDestroy:
-		{
-			err := r.memcache.Set(&memcache.Item{
-				Key:        cID,
-				Value:      js,
-				Expiration: 30, // Give logstash some time to process incoming flows (10s timeouts)
-			})
-			if err != nil {
-				log.Error(err)
-			}
-			log.WithField("type", "DESTROY").Debug(string(js))
-		}
+		mappingsWritten.WithLabelValues("destroy").Inc()
 	}
+	log.WithFields(logrus.Fields{
+		"ip":      e.Entry.FlowMeta0.Dst,
+		"port":    e.Entry.FlowMeta0.DstPort,
+		"service": svc.Namespace + "/" + svc.Name,
+		"type":    svc.Type,
+	}).Debug("Updated service mapping")
 }
 
-// conntrackRunner keeps track of conntrack connections and updates the database
-func (r *resolver) conntrackRunner() {
-	// @TODO move this to a netlink-native implementation
-	cmd := exec.Command("conntrack", "-E")
-	stdout, err := cmd.StdoutPipe()
+// apiRunner serves the translation API
+func (r *resolver) apiRunner() {
+	srv := &http.Server{Addr: r.listen, Handler: clusterip.RestrictClients(clusterip.NewHandler(r.table), r.allowed)}
+	go func() {
+		<-r.exitChan
+		srv.Close()
+	}()
 
-	if err != nil {
+	log.WithField("listen", r.listen).Info("Serving the translation API")
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		r.errChan <- err
+	}
+}
+
+// expiryRunner removes expired mappings
+func (r *resolver) expiryRunner() {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.exitChan:
+			return
+		case <-ticker.C:
+			r.table.Expire()
+			mappings.Set(float64(r.table.Len()))
+			r.updateNodeIPs()
+		}
+	}
+}
+
+// updateNodeIPs passes the addresses of the node to the watcher so NodePort connections are
+// recognised. The resolver runs in the host network namespace, the local interfaces are the ones
+// of the node.
+func (r *resolver) updateNodeIPs() {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.WithError(err).Warn("Could not list node addresses")
 		return
 	}
-	defer stdout.Close()
 
-	// Create reader
-	rd := bufio.NewReader(stdout)
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	r.watcher.SetNodeIPs(ips)
+}
 
-	// Start execution
-	if err := cmd.Start(); err != nil {
+// conntrackRunner keeps track of conntrack connections and updates the database
+func (r *resolver) conntrackRunner() {
+	l, err := netlink.Listen(netlink.GroupNew | netlink.GroupDestroy)
+	if err != nil {
 		r.errChan <- err
 		return
 	}
+	defer l.Close()
 
 	for {
 		// Check for exit condition
 		select {
 		case <-r.exitChan:
-			break
+			return
 		default:
 		}
 
-		// Read a line from the conntrack output
-		l, err := rd.ReadString('\n')
+		events, err := l.Receive()
+		if err == netlink.ErrLostEvents {
+			log.Warn(err)
+			continue
+		}
 		if err != nil {
 			r.errChan <- err
-			break
+			return
 		}
 
-		// Try to parse the readline
-		e, err := parser.ParseConntrackEvent(l)
-		// If it could be parsed, pass it on to the conntrack event handler
-		if err == nil {
-			go r.handleConntrackEvent(e)
+		for _, e := range events {
+			r.handleConntrackEvent(e)
 		}
 	}
 }
@@ -0,0 +1,76 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package capture
+
+import (
+	"os"
+	"runtime"
+)
+
+// Fanout is implemented by capturers distributing the packets of an interface across multiple
+// sockets. All packets of a flow (both directions) are delivered to the same worker, every
+// worker can therefore maintain its own share of the flow table.
+type Fanout interface {
+	Capturer
+	Workers() []Capturer
+}
+
+// AFPacketOptions configures an AF_PACKET capture
+type AFPacketOptions struct {
+	BlockSize int    // Size of a ring buffer block in bytes, has to be a multiple of the page size
+	NumBlocks int    // Number of blocks in the ring buffer of every worker
+	SnapLen   int    // Maximum number of bytes captured per packet
+	Workers   int    // Number of sockets joining the fanout group
+	FanoutID  uint16 // ID of the fanout group, has to be unique per network namespace
+}
+
+// Defaults of the AF_PACKET capture
+const (
+	DefaultBlockSize = 1 << 20
+	DefaultNumBlocks = 16
+	// DefaultSnapLen is large enough for the ethernet, VLAN, IP and TCP headers including options
+	DefaultSnapLen = 128
+)
+
+// withDefaults fills unset options
+func (o AFPacketOptions) withDefaults() AFPacketOptions {
+	if o.BlockSize <= 0 {
+		o.BlockSize = DefaultBlockSize
+	}
+	if o.NumBlocks <= 0 {
+		o.NumBlocks = DefaultNumBlocks
+	}
+	if o.SnapLen <= 0 {
+		o.SnapLen = DefaultSnapLen
+	}
+	if o.Workers <= 0 {
+		o.Workers = runtime.NumCPU()
+	}
+	if o.FanoutID == 0 {
+		o.FanoutID = uint16(os.Getpid())
+	}
+	return o
+}
@@ -0,0 +1,288 @@
+//go:build linux
+// +build linux
+
+/*
+ *  MIT License
+ *
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *
+ */
+
+package capture
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/bpf"
+)
+
+// pollTimeout bounds how long a read blocks, so workers notice a shutdown
+const pollTimeout = 100 * time.Millisecond
+
+// Backoff of failing reads, e.g. while the interface is down
+const (
+	minReadBackoff = 10 * time.Millisecond
+	maxReadBackoff = time.Second
+)
+
+// afpacketHandle is a single TPACKET_V3 socket of a fanout group
+type afpacketHandle struct {
+	tpacket *afpacket.TPacket
+	device  string
+	index   int // Interface index, to notice the interface has been removed
+	snapLen int
+	done    chan struct{}  // Closed on shutdown
+	wg      sync.WaitGroup // Running readers, the ring must not be unmapped while they are reading
+	once    sync.Once
+}
+
+// afpacketGroup contains all sockets of a fanout group
+type afpacketGroup struct {
+	workers []*afpacketHandle
+}
+
+// OpenAFPacket creates a packet capture based on memory mapped TPACKET_V3 ring buffers.
+// Packets are distributed across opts.Workers sockets by a flow hash (PACKET_FANOUT_HASH) and
+// truncated to opts.SnapLen bytes.
+func OpenAFPacket(device string, opts AFPacketOptions) (Fanout, error) {
+	opts = opts.withDefaults()
+	g := &afpacketGroup{}
+	ifi, err := net.InterfaceByName(device)
+	if err != nil {
+		log.Error("Could not open device ", device)
+		return nil, err
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		tp, err := afpacket.NewTPacket(
+			afpacket.OptInterface(device),
+			afpacket.TPacketVersion3,
+			afpacket.OptBlockSize(opts.BlockSize),
+			afpacket.OptNumBlocks(opts.NumBlocks),
+			afpacket.OptPollTimeout(pollTimeout),
+		)
+		if err != nil {
+			log.Error("Could not open device ", device)
+			g.Close()
+			return nil, err
+		}
+		h := &afpacketHandle{
+			tpacket: tp,
+			device:  device,
+			index:   ifi.Index,
+			snapLen: opts.SnapLen,
+			done:    make(chan struct{}),
+		}
+		g.workers = append(g.workers, h)
+
+		if err := tp.SetFanout(afpacket.FanoutHashWithDefrag, opts.FanoutID); err != nil {
+			log.Error("Could not join fanout group ", opts.FanoutID)
+			g.Close()
+			return nil, err
+		}
+	}
+
+	// An empty filter still truncates packets to the snapshot length
+	if err := g.Filter(""); err != nil {
+		g.Close()
+		return nil, err
+	}
+	log.WithField("workers", opts.Workers).Info("Opened device ", device)
+
+	return g, nil
+}
+
+// Workers returns the sockets of the fanout group
+func (g *afpacketGroup) Workers() []Capturer {
+	workers := make([]Capturer, len(g.workers))
+	for i, w := range g.workers {
+		workers[i] = w
+	}
+	return workers
+}
+
+// Closes the capture
+func (g *afpacketGroup) Close() {
+	for _, w := range g.workers {
+		w.Close()
+	}
+}
+
+// Set a BPF filter on all sockets
+func (g *afpacketGroup) Filter(filter string) error {
+	for _, w := range g.workers {
+		if err := w.Filter(filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns the summed up kernel packet counters of all sockets
+func (g *afpacketGroup) Stats() (*Stats, error) {
+	total := &Stats{}
+	for _, w := range g.workers {
+		st, err := w.Stats()
+		if err != nil {
+			return nil, err
+		}
+		total.add(st)
+	}
+	return total, nil
+}
+
+// Packets returns a channel producing the packets of all sockets
+func (g *afpacketGroup) Packets() chan gopacket.Packet {
+	chans := make([]chan gopacket.Packet, len(g.workers))
+	for i, w := range g.workers {
+		chans[i] = w.Packets()
+	}
+	return merge(chans)
+}
+
+// Closes the socket after the reader stopped
+func (h *afpacketHandle) Close() {
+	h.once.Do(func() {
+		close(h.done)
+		h.wg.Wait()
+		h.tpacket.Close()
+	})
+}
+
+// Set a BPF filter, the filter is compiled by libpcap
+func (h *afpacketHandle) Filter(filter string) error {
+	instructions, err := pcap.CompileBPFFilter(layers.LinkTypeEthernet, h.snapLen, filter)
+	if err != nil {
+		log.Error("Could not compile BPF filter ", filter)
+		return err
+	}
+
+	raw := make([]bpf.RawInstruction, len(instructions))
+	for i, ins := range instructions {
+		raw[i] = bpf.RawInstruction{Op: ins.Code, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+
+	res := h.tpacket.SetBPF(raw)
+	if res != nil {
+		log.Error("Could not set BPF filter ", filter)
+	} else {
+		log.Debug("BPF filter set ", filter)
+	}
+
+	return res
+}
+
+// Stats returns the kernel packet counters of the socket
+func (h *afpacketHandle) Stats() (*Stats, error) {
+	_, st, err := h.tpacket.SocketStats()
+	if err != nil {
+		return nil, err
+	}
+	return &Stats{
+		Received: uint64(st.Packets()),
+		Dropped:  uint64(st.Drops()),
+	}, nil
+}
+
+// Packets returns a channel producing every packet of the socket; the channel is closed on shutdown
+func (h *afpacketHandle) Packets() chan gopacket.Packet {
+	out := make(chan gopacket.Packet, 1000)
+	h.wg.Add(1)
+	go h.readRunner(out)
+	return out
+}
+
+// removed reports whether the interface of the socket does not exist anymore
+func (h *afpacketHandle) removed() bool {
+	ifi, err := net.InterfaceByName(h.device)
+	return err != nil || ifi.Index != h.index
+}
+
+// readRunner reads packets from the ring buffer until the capture is closed or the socket fails
+// permanently. Failing reads are retried with backoff, the socket keeps reporting an error
+// after the interface went down until packets arrive again.
+func (h *afpacketHandle) readRunner(out chan gopacket.Packet) {
+	defer h.wg.Done()
+	defer close(out)
+
+	failures := 0
+	backoff := time.Duration(0)
+	for {
+		select {
+		case <-h.done:
+			return
+		default:
+		}
+
+		// The data is copied out of the ring, packets are passed on and outlive the next read
+		data, ci, err := h.tpacket.ReadPacketData()
+		if err == afpacket.ErrTimeout {
+			continue
+		}
+		if err != nil {
+			if errno, ok := err.(syscall.Errno); ok && (errno == syscall.EBADF || errno == syscall.EINVAL) || h.removed() {
+				log.WithError(err).Warn("Stopped reading from ", h.device)
+				return
+			}
+
+			failures++
+			switch {
+			case backoff == 0:
+				backoff = minReadBackoff
+			case backoff < maxReadBackoff:
+				backoff *= 2
+				if backoff > maxReadBackoff {
+					backoff = maxReadBackoff
+				}
+			}
+			// Warn once and then about every minute
+			if failures%60 == 1 {
+				log.WithError(err).WithField("failures", failures).Warn("Failed to read from ", h.device)
+			}
+			select {
+			case <-h.done:
+				return
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		if failures > 0 {
+			log.WithField("failures", failures).Info("Reading from ", h.device, " recovered")
+			failures, backoff = 0, 0
+		}
+
+		p := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		p.Metadata().CaptureInfo = ci
+
+		select {
+		case out <- p:
+		case <-h.done:
+			return
+		}
+	}
+}
@@ -26,6 +26,8 @@
 package capture
 
 import (
+	"sync"
+
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/pcap"
 
@@ -46,9 +48,24 @@ var log *logrus.Entry
 type Capturer interface {
 	Packets() chan gopacket.Packet
 	Filter(string) error
+	Stats() (*Stats, error)
 	Close()
 }
 
+// Stats contains the packet counters of a capture
+type Stats struct {
+	Received  uint64 // Packets received by the filter
+	Dropped   uint64 // Packets dropped because of a full buffer
+	IfDropped uint64 // Packets dropped by the network interface or its driver
+}
+
+// add sums up the counters of several captures
+func (s *Stats) add(o *Stats) {
+	s.Received += o.Received
+	s.Dropped += o.Dropped
+	s.IfDropped += o.IfDropped
+}
+
 // pcapHandle contains the handle in order to allow filter changes and graceful shutdown
 type pcapHandle struct {
 	handle *pcap.Handle
@@ -89,6 +106,24 @@ func (p *pcapHandle) Filter(filter string) error {
 	return res
 }
 
+// Stats returns the kernel packet counters of the capture
+func (p *pcapHandle) Stats() (*Stats, error) {
+	return pcapStats(p.handle)
+}
+
+// pcapStats converts the statistics of a pcap handle
+func pcapStats(h *pcap.Handle) (*Stats, error) {
+	st, err := h.Stats()
+	if err != nil {
+		return nil, err
+	}
+	return &Stats{
+		Received:  uint64(st.PacketsReceived),
+		Dropped:   uint64(st.PacketsDropped),
+		IfDropped: uint64(st.PacketsIfDropped),
+	}, nil
+}
+
 // Packets returns a channel producing every packet
 func (p *pcapHandle) Packets() chan gopacket.Packet {
 	log.Info("Starting packet stream")
@@ -98,3 +133,23 @@ func (p *pcapHandle) Packets() chan gopacket.Packet {
 	source.NoCopy = true
 	return source.Packets()
 }
+
+// merge combines packet channels, the returned channel is closed after all inputs have been closed
+func merge(chans []chan gopacket.Packet) chan gopacket.Packet {
+	out := make(chan gopacket.Packet, 1000)
+	var wg sync.WaitGroup
+	for _, c := range chans {
+		wg.Add(1)
+		go func(in chan gopacket.Packet) {
+			defer wg.Done()
+			for p := range in {
+				out <- p
+			}
+		}(c)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
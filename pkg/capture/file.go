@@ -0,0 +1,133 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package capture
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+// fileHandle reads packets from a capture file and optionally replays them with their original timing
+type fileHandle struct {
+	handle *pcap.Handle
+	replay bool          // Emit packets according to their capture timestamps
+	done   chan struct{} // Closed on shutdown
+	once   sync.Once
+}
+
+// OpenFile creates a packet capture reading from a pcap or pcapng file.
+// If replay is set, packets are emitted at the pace they were originally captured.
+func OpenFile(path string, replay bool) (Capturer, error) {
+	handle, err := pcap.OpenOffline(path)
+
+	if err != nil {
+		log.Error("Could not open capture file ", path)
+		return nil, err
+	}
+	log.Info("Opened capture file ", path)
+
+	return &fileHandle{
+		handle: handle,
+		replay: replay,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Closes the capture
+func (f *fileHandle) Close() {
+	f.once.Do(func() {
+		close(f.done)
+		f.handle.Close()
+	})
+}
+
+// Set a pcap filter
+func (f *fileHandle) Filter(filter string) error {
+	res := f.handle.SetBPFFilter(filter)
+
+	if res != nil {
+		log.Error("Could not set BPF filter ", filter)
+	} else {
+		log.Info("BPF filter set ", filter)
+	}
+
+	return res
+}
+
+// Stats are not available for capture files
+func (f *fileHandle) Stats() (*Stats, error) {
+	return nil, errors.New("statistics are not available for capture files")
+}
+
+// Packets returns a channel producing every packet of the capture file; the channel is closed at EOF
+func (f *fileHandle) Packets() chan gopacket.Packet {
+	log.Info("Starting packet stream from file")
+	source := gopacket.NewPacketSource(f.handle, f.handle.LinkType())
+	// Every packet is read into a new buffer which can be decoded in place
+	source.Lazy = true
+	source.NoCopy = true
+
+	if !f.replay {
+		return source.Packets()
+	}
+
+	out := make(chan gopacket.Packet, 1000)
+	go f.replayRunner(source.Packets(), out)
+	return out
+}
+
+// replayRunner delays every packet so the gaps between packets match the capture timestamps
+func (f *fileHandle) replayRunner(in, out chan gopacket.Packet) {
+	defer close(out)
+
+	var first time.Time // Capture timestamp of the first packet
+	var start time.Time // Wall clock time the first packet was emitted
+
+	for p := range in {
+		ts := p.Metadata().Timestamp
+		if start.IsZero() {
+			first, start = ts, time.Now()
+		}
+
+		if d := time.Until(start.Add(ts.Sub(first))); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-f.done:
+				return
+			}
+		}
+
+		select {
+		case out <- p:
+		case <-f.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,138 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package capture
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+// testFile contains a TCP connection which is reset and a UDP packet between 10.0.0.1 and
+// 10.0.0.2, a packet every 25ms
+const testFile = "testdata/flows.pcap"
+
+var testFileStart = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// readAll reads the packets of a capture until its channel is closed
+func readAll(t *testing.T, c Capturer) []gopacket.Packet {
+	var packets []gopacket.Packet
+	timeout := time.After(5 * time.Second)
+	ch := c.Packets()
+	for {
+		select {
+		case p, ok := <-ch:
+			if !ok {
+				return packets
+			}
+			packets = append(packets, p)
+		case <-timeout:
+			t.Fatal("Timed out reading packets")
+		}
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	c, err := OpenFile(testFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	packets := readAll(t, c)
+	if len(packets) != 5 {
+		t.Fatalf("Expected 5 packets, got %d", len(packets))
+	}
+	for i, p := range packets {
+		if ts := p.Metadata().Timestamp; !ts.Equal(testFileStart.Add(time.Duration(i) * 25 * time.Millisecond)) {
+			t.Errorf("%d: unexpected timestamp %s", i, ts)
+		}
+	}
+
+	s, err := NewSample(packets[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := flow.Meta{Transport: protos.TCP, Src: net.IP{10, 0, 0, 1}, SrcPort: 41234, Dst: net.IP{10, 0, 0, 2}, DstPort: 80}
+	if diff := cmp.Diff(expected, s.FlowMeta()); diff != "" {
+		t.Error(diff)
+	}
+	if !s.TCPFlags.Has(flow.TCPFlagSYN) {
+		t.Errorf("Expected SYN, got %v", s.TCPFlags.Names())
+	}
+
+	if _, err := c.Stats(); err == nil {
+		t.Error("Expected no statistics for capture files")
+	}
+}
+
+func TestOpenFileMissing(t *testing.T) {
+	if _, err := OpenFile("testdata/missing.pcap", false); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestOpenFileReplay(t *testing.T) {
+	c, err := OpenFile(testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	start := time.Now()
+	if packets := readAll(t, c); len(packets) != 5 {
+		t.Fatalf("Expected 5 packets, got %d", len(packets))
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("Expected the packets to be replayed over 100ms, took %s", d)
+	}
+}
+
+func TestOpenFileReplayClose(t *testing.T) {
+	c, err := OpenFile(testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := c.Packets()
+	<-ch
+	c.Close()
+
+	// The replay stops while waiting for the next packet
+	select {
+	case <-time.After(50 * time.Millisecond):
+		t.Error("Replay did not stop after the capture was closed")
+	case _, ok := <-ch:
+		for ok {
+			_, ok = <-ch
+		}
+	}
+}
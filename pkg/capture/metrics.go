@@ -0,0 +1,83 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package capture
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/xvzf/insight/pkg/metrics"
+)
+
+var sampleErrors = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "capture",
+	Name:      "sample_errors_total",
+	Help:      "Packets which could not be parsed into a sample.",
+})
+
+var activeInterfaces = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "capture",
+	Name:      "interfaces",
+	Help:      "Network interfaces currently captured on.",
+})
+
+var (
+	packetsReceivedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "capture", "packets_received_total"),
+		"Packets received by the capture filter.", nil, nil,
+	)
+	packetsDroppedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "capture", "packets_dropped_total"),
+		"Packets dropped by the kernel (buffer) or the network interface (interface).", []string{"reason"}, nil,
+	)
+)
+
+// statsCollector exposes the packet counters of a capturer
+type statsCollector struct {
+	c Capturer
+}
+
+// NewStatsCollector creates a prometheus collector exposing the packet counters of c
+func NewStatsCollector(c Capturer) prometheus.Collector {
+	return &statsCollector{c: c}
+}
+
+func (s *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- packetsReceivedDesc
+	ch <- packetsDroppedDesc
+}
+
+func (s *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	st, err := s.c.Stats()
+	if err != nil {
+		log.WithError(err).Debug("Failed to collect capture statistics")
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(packetsReceivedDesc, prometheus.CounterValue, float64(st.Received))
+	ch <- prometheus.MustNewConstMetric(packetsDroppedDesc, prometheus.CounterValue, float64(st.Dropped), "buffer")
+	ch <- prometheus.MustNewConstMetric(packetsDroppedDesc, prometheus.CounterValue, float64(st.IfDropped), "interface")
+}
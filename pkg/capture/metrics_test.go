@@ -0,0 +1,68 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package capture
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// statsCapturer returns fixed packet counters
+type statsCapturer struct {
+	fakeCapturer
+	stats *Stats
+	err   error
+}
+
+func (s *statsCapturer) Stats() (*Stats, error) { return s.stats, s.err }
+
+func TestStatsCollector(t *testing.T) {
+	total := &Stats{}
+	for _, st := range []*Stats{{Received: 10, Dropped: 2, IfDropped: 1}, {Received: 5, Dropped: 1, IfDropped: 3}} {
+		total.add(st)
+	}
+
+	expected := `
+# HELP insight_capture_packets_dropped_total Packets dropped by the kernel (buffer) or the network interface (interface).
+# TYPE insight_capture_packets_dropped_total counter
+insight_capture_packets_dropped_total{reason="buffer"} 3
+insight_capture_packets_dropped_total{reason="interface"} 4
+# HELP insight_capture_packets_received_total Packets received by the capture filter.
+# TYPE insight_capture_packets_received_total counter
+insight_capture_packets_received_total 15
+`
+	if err := testutil.CollectAndCompare(NewStatsCollector(&statsCapturer{stats: total}), strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	// Capturers without statistics do not expose any counters
+	if n := testutil.CollectAndCount(NewStatsCollector(&statsCapturer{err: errors.New("not available")})); n != 0 {
+		t.Errorf("Expected no metrics, got %d", n)
+	}
+}
@@ -0,0 +1,364 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package capture
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/link"
+)
+
+// Opener opens the capture of a single network interface
+type Opener func(device string) (Capturer, error)
+
+// AFPacketOpener returns an opener for AF_PACKET captures. Fanout groups are bound to a single
+// interface, every interface therefore gets its own fanout group ID.
+func AFPacketOpener(opts AFPacketOptions) Opener {
+	opts = opts.withDefaults()
+	var mu sync.Mutex
+	return func(device string) (Capturer, error) {
+		mu.Lock()
+		o := opts
+		opts.FanoutID++
+		mu.Unlock()
+		return OpenAFPacket(device, o)
+	}
+}
+
+// interfaceCapture is the capture of one interface of a multi-interface capture
+type interfaceCapture struct {
+	iface   flow.Interface
+	capture Capturer
+	done    chan struct{} // Closed when the interface is removed
+}
+
+// multiHandle captures on all interfaces matching a list of patterns; interfaces appearing and
+// disappearing at runtime are picked up via link events
+type multiHandle struct {
+	patterns []string
+	open     Opener
+	listener link.Listener // Link events, nil if interfaces are only discovered on startup
+	workers  []*multiWorker
+
+	mu       sync.Mutex
+	filter   string                    // BPF filter applied to all interfaces
+	captures map[int]*interfaceCapture // Active captures by interface index
+	removed  Stats                     // Counters of removed interfaces
+
+	forwarders sync.WaitGroup
+	done       chan struct{}
+	once       sync.Once
+}
+
+// multiWorker receives the packets of its share of every interface capture
+type multiWorker struct {
+	m   *multiHandle
+	out chan gopacket.Packet
+}
+
+// OpenInterfaces creates a packet capture on all interfaces matching the patterns (shell globs,
+// e.g. cali*). Packets are tagged with their observation interface. Fanout captures opened by
+// open are spread across the given number of workers; the i-th socket of every interface feeds
+// the i-th worker. If l is set, interfaces are added and removed according to the link events.
+func OpenInterfaces(patterns []string, workers int, open Opener, l link.Listener) (Fanout, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	m := &multiHandle{
+		patterns: patterns,
+		open:     open,
+		listener: l,
+		captures: make(map[int]*interfaceCapture),
+		done:     make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		m.workers = append(m.workers, &multiWorker{m: m, out: make(chan gopacket.Packet, 1000)})
+	}
+
+	m.sync()
+
+	m.mu.Lock()
+	n := len(m.captures)
+	m.mu.Unlock()
+	if n == 0 && l == nil {
+		return nil, errors.New("no interface to capture on")
+	}
+
+	if l != nil {
+		go m.linkRunner()
+	}
+	return m, nil
+}
+
+// sync captures on all currently matching interfaces and stops capturing on vanished ones
+func (m *multiHandle) sync() {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.WithError(err).Error("Failed to list interfaces")
+		return
+	}
+
+	present := make(map[int]bool)
+	for _, i := range ifaces {
+		if link.Match(m.patterns, i.Name) {
+			present[i.Index] = true
+			m.add(flow.Interface{Name: i.Name, Index: i.Index})
+		}
+	}
+
+	m.mu.Lock()
+	var vanished []int
+	for index := range m.captures {
+		if !present[index] {
+			vanished = append(vanished, index)
+		}
+	}
+	m.mu.Unlock()
+	for _, index := range vanished {
+		m.remove(index)
+	}
+}
+
+// add starts capturing on an interface
+func (m *multiHandle) add(iface flow.Interface) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-m.done:
+		return
+	default:
+	}
+	if ic, ok := m.captures[iface.Index]; ok {
+		if ic.iface.Name == iface.Name {
+			return
+		}
+		// Interface has been renamed, reopen to tag packets with the new name
+		m.removeLocked(iface.Index)
+	}
+
+	c, err := m.open(iface.Name)
+	if err != nil {
+		log.WithError(err).Warn("Failed to capture on interface ", iface.Name)
+		return
+	}
+	if m.filter != "" {
+		if err := c.Filter(m.filter); err != nil {
+			log.WithError(err).Warn("Failed to set BPF filter on interface ", iface.Name)
+			c.Close()
+			return
+		}
+	}
+
+	ic := &interfaceCapture{iface: iface, capture: c, done: make(chan struct{})}
+	m.captures[iface.Index] = ic
+
+	sources := []Capturer{c}
+	if f, ok := c.(Fanout); ok {
+		sources = f.Workers()
+	}
+	for i, source := range sources {
+		m.forwarders.Add(1)
+		go m.forward(ic, source.Packets(), m.workers[i%len(m.workers)])
+	}
+
+	activeInterfaces.Inc()
+	log.WithField("index", iface.Index).Info("Capturing on interface ", iface.Name)
+}
+
+// remove stops capturing on an interface
+func (m *multiHandle) remove(index int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeLocked(index)
+}
+
+func (m *multiHandle) removeLocked(index int) {
+	ic, ok := m.captures[index]
+	if !ok {
+		return
+	}
+	delete(m.captures, index)
+
+	// Keep the counters monotonic
+	if st, err := ic.capture.Stats(); err == nil {
+		m.removed.add(st)
+	}
+	close(ic.done)
+	ic.capture.Close()
+
+	activeInterfaces.Dec()
+	log.WithField("index", index).Info("Stopped capturing on interface ", ic.iface.Name)
+}
+
+// forward tags the packets of an interface and passes them to a worker
+func (m *multiHandle) forward(ic *interfaceCapture, in chan gopacket.Packet, w *multiWorker) {
+	defer m.forwarders.Done()
+
+	for {
+		select {
+		case p, ok := <-in:
+			if !ok {
+				return
+			}
+			md := p.Metadata()
+			md.InterfaceIndex = ic.iface.Index
+			md.AncillaryData = append(md.AncillaryData, ic.iface)
+
+			select {
+			case w.out <- p:
+			case <-ic.done:
+				return
+			}
+		case <-ic.done:
+			return
+		}
+	}
+}
+
+// linkRunner applies link events until the capture is closed
+func (m *multiHandle) linkRunner() {
+	for {
+		events, err := m.listener.Receive()
+		select {
+		case <-m.done:
+			return
+		default:
+		}
+
+		if err == link.ErrLostEvents {
+			log.Warn("Link events lost, rescanning interfaces")
+			m.sync()
+			continue
+		}
+		if err != nil {
+			log.WithError(err).Error("Failed to receive link events, interfaces are no longer tracked")
+			return
+		}
+
+		for _, e := range events {
+			switch {
+			case e.Type == link.EventDelete:
+				m.remove(e.Index)
+			case link.Match(m.patterns, e.Name):
+				m.add(flow.Interface{Name: e.Name, Index: e.Index})
+			default:
+				// Renamed to a name which is not captured anymore
+				m.remove(e.Index)
+			}
+		}
+	}
+}
+
+// Workers returns the capture workers
+func (m *multiHandle) Workers() []Capturer {
+	workers := make([]Capturer, len(m.workers))
+	for i, w := range m.workers {
+		workers[i] = w
+	}
+	return workers
+}
+
+// Closes the capture on all interfaces
+func (m *multiHandle) Close() {
+	m.once.Do(func() {
+		m.mu.Lock()
+		close(m.done)
+		for index := range m.captures {
+			m.removeLocked(index)
+		}
+		m.mu.Unlock()
+
+		if m.listener != nil {
+			m.listener.Close()
+		}
+
+		m.forwarders.Wait()
+		for _, w := range m.workers {
+			close(w.out)
+		}
+	})
+}
+
+// Set a BPF filter on all current and future interfaces
+func (m *multiHandle) Filter(filter string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ic := range m.captures {
+		if err := ic.capture.Filter(filter); err != nil {
+			return err
+		}
+	}
+	m.filter = filter
+	return nil
+}
+
+// Stats returns the summed up packet counters of all interfaces
+func (m *multiHandle) Stats() (*Stats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	total := m.removed
+	for _, ic := range m.captures {
+		st, err := ic.capture.Stats()
+		if err != nil {
+			return nil, err
+		}
+		total.add(st)
+	}
+	return &total, nil
+}
+
+// Packets returns a channel producing the packets of all interfaces
+func (m *multiHandle) Packets() chan gopacket.Packet {
+	chans := make([]chan gopacket.Packet, len(m.workers))
+	for i, w := range m.workers {
+		chans[i] = w.out
+	}
+	return merge(chans)
+}
+
+// Packets returns the packets assigned to the worker
+func (w *multiWorker) Packets() chan gopacket.Packet {
+	return w.out
+}
+
+func (w *multiWorker) Filter(filter string) error {
+	return w.m.Filter(filter)
+}
+
+func (w *multiWorker) Stats() (*Stats, error) {
+	return w.m.Stats()
+}
+
+func (w *multiWorker) Close() {
+	w.m.Close()
+}
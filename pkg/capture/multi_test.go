@@ -0,0 +1,158 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package capture
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/link"
+)
+
+// fakeCapturer produces the packets sent on its channel
+type fakeCapturer struct {
+	packets chan gopacket.Packet
+	closed  chan struct{}
+}
+
+func newFakeCapturer() *fakeCapturer {
+	return &fakeCapturer{packets: make(chan gopacket.Packet), closed: make(chan struct{})}
+}
+
+func (f *fakeCapturer) Packets() chan gopacket.Packet { return f.packets }
+func (f *fakeCapturer) Filter(string) error           { return nil }
+func (f *fakeCapturer) Stats() (*Stats, error) {
+	return &Stats{Received: 1, Dropped: 1, IfDropped: 1}, nil
+}
+func (f *fakeCapturer) Close() { close(f.closed) }
+
+// fakeListener returns link events sent on its channel
+type fakeListener struct {
+	events chan *link.Event
+}
+
+func (f *fakeListener) Receive() ([]*link.Event, error) {
+	e, ok := <-f.events
+	if !ok {
+		return nil, io.EOF
+	}
+	return []*link.Event{e}, nil
+}
+
+func (f *fakeListener) Close() error { return nil }
+
+// testPacket creates an UDP packet
+func testPacket(t *testing.T) gopacket.Packet {
+	buf := gopacket.NewSerializeBuffer()
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	udp := &layers.UDP{SrcPort: 53, DstPort: 5353}
+	udp.SetNetworkLayerForChecksum(ip)
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, udp); err != nil {
+		t.Fatal(err)
+	}
+	return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+}
+
+func TestOpenInterfaces(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("No loopback interface")
+	}
+
+	captures := make(map[string]*fakeCapturer)
+	opened := make(chan string, 10)
+	open := func(device string) (Capturer, error) {
+		c := newFakeCapturer()
+		captures[device] = c
+		opened <- device
+		return c, nil
+	}
+	l := &fakeListener{events: make(chan *link.Event)}
+
+	c, err := OpenInterfaces([]string{"lo", "cali*"}, 1, open, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if d := <-opened; d != "lo" {
+		t.Fatalf("Expected capture on lo, got %s", d)
+	}
+
+	// Interface appearing at runtime
+	l.events <- &link.Event{Type: link.EventNew, Index: 4242, Name: "cali1234"}
+	l.events <- &link.Event{Type: link.EventNew, Index: 4243, Name: "eth1"}
+	if d := <-opened; d != "cali1234" {
+		t.Fatalf("Expected capture on cali1234, got %s", d)
+	}
+
+	out := c.Workers()[0].Packets()
+	for name, index := range map[string]int{"lo": lo.Index, "cali1234": 4242} {
+		captures[name].packets <- testPacket(t)
+		s, err := NewSample(<-out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Interface != (flow.Interface{Name: name, Index: index}) {
+			t.Errorf("Expected interface %s/%d, got %v", name, index, s.Interface)
+		}
+	}
+
+	st, err := c.Stats()
+	if err != nil || *st != (Stats{Received: 2, Dropped: 2, IfDropped: 2}) {
+		t.Errorf("Expected summed up stats, got %v (%v)", st, err)
+	}
+
+	// Interface disappearing
+	l.events <- &link.Event{Type: link.EventDelete, Index: 4242, Name: "cali1234"}
+	select {
+	case <-captures["cali1234"].closed:
+	case <-time.After(time.Second):
+		t.Error("Capture of a removed interface has not been closed")
+	}
+
+	st, err = c.Stats()
+	if err != nil || *st != (Stats{Received: 2, Dropped: 2, IfDropped: 2}) {
+		t.Errorf("Counters of removed interfaces have to be kept, got %v (%v)", st, err)
+	}
+	if len(opened) != 0 {
+		t.Errorf("Unexpected capture on %s", <-opened)
+	}
+}
+
+func TestOpenInterfacesNoMatch(t *testing.T) {
+	open := func(device string) (Capturer, error) {
+		return newFakeCapturer(), nil
+	}
+	if _, err := OpenInterfaces([]string{"doesnotexist*"}, 1, open, nil); err == nil {
+		t.Error("Expected error without any interface to capture on")
+	}
+}
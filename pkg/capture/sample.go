@@ -29,6 +29,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
@@ -46,6 +47,20 @@ type Sample struct {
 	Src       net.IP              // Source IP
 	Dst       net.IP              // Destination IP
 	Bytes     uint16              // Packet size
+	TCPFlags  flow.TCPFlags       // TCP control bits (in case of protocol = TCP)
+	Seq       uint32              // TCP sequence number (in case of protocol = TCP)
+	Ack       uint32              // TCP acknowledgment number (in case of protocol = TCP)
+	Window    uint16              // TCP receive window, not scaled (in case of protocol = TCP)
+	SegLen    uint16              // TCP segment length, independent of the captured payload (in case of protocol = TCP)
+	Timestamp time.Time           // Capture timestamp
+	Interface flow.Interface      // Observation interface
+	Payload   []byte              // TCP/UDP payload, only valid until the sample has been added to a flow container
+}
+
+// Inspector examines the payload of samples before they are added to the flow table. It is
+// called concurrently by the capture workers.
+type Inspector interface {
+	Inspect(s *Sample)
 }
 
 // FlowMeta extracts flow metadata from a packet
@@ -72,30 +87,72 @@ func (s Sample) String() string {
 	)
 }
 
-// getTransportMeta tries to extract TCP/UDP Metadata (srcport, dstport) from a given packet
-func getMeta(p gopacket.Packet) (uint16, uint16, uint16, uint16, protos.ProtocolType) {
+// tcpFlags extracts the control bits of a TCP header
+func tcpFlags(tcp *layers.TCP) flow.TCPFlags {
+	var f flow.TCPFlags
+	for _, b := range []struct {
+		set  bool
+		flag flow.TCPFlags
+	}{
+		{tcp.FIN, flow.TCPFlagFIN},
+		{tcp.SYN, flow.TCPFlagSYN},
+		{tcp.RST, flow.TCPFlagRST},
+		{tcp.PSH, flow.TCPFlagPSH},
+		{tcp.ACK, flow.TCPFlagACK},
+		{tcp.URG, flow.TCPFlagURG},
+		{tcp.ECE, flow.TCPFlagECE},
+		{tcp.CWR, flow.TCPFlagCWR},
+	} {
+		if b.set {
+			f |= b.flag
+		}
+	}
+	return f
+}
+
+// getMeta tries to extract TCP/UDP Metadata (srcport, dstport, flags) or ICMP type & code from a given packet.
+func getMeta(p gopacket.Packet, s *Sample) {
 
 	if tcpLayer := p.Layer(layers.LayerTypeTCP); tcpLayer != nil {
 		tcp, _ := tcpLayer.(*layers.TCP)
-		return uint16(tcp.SrcPort), uint16(tcp.DstPort), 0, 0, protos.TCP
+		s.SrcPort, s.DstPort, s.TCPFlags, s.Transport = uint16(tcp.SrcPort), uint16(tcp.DstPort), tcpFlags(tcp), protos.TCP
+		s.Seq, s.Ack, s.Window, s.Payload = tcp.Seq, tcp.Ack, tcp.Window, tcp.Payload
+		// The payload is cut off by the snaplen, the bytes missing from the capture belong to it
+		s.SegLen = uint16(len(tcp.Payload))
+		if md := p.Metadata(); md.Length > md.CaptureLength {
+			s.SegLen += uint16(md.Length - md.CaptureLength)
+		}
+		return
 	}
 
 	if udpLayer := p.Layer(layers.LayerTypeUDP); udpLayer != nil {
 		udp, _ := udpLayer.(*layers.UDP)
-		return uint16(udp.SrcPort), uint16(udp.DstPort), 0, 0, protos.UDP
+		s.SrcPort, s.DstPort, s.Transport = uint16(udp.SrcPort), uint16(udp.DstPort), protos.UDP
+		s.Payload = udp.Payload
+		return
 	}
 
 	if icmp4Layer := p.Layer(layers.LayerTypeICMPv4); icmp4Layer != nil {
 		icmp, _ := icmp4Layer.(*layers.ICMPv4)
-		return 0, 0, uint16(icmp.TypeCode.Type()), uint16(icmp.TypeCode.Code()), protos.ICMP4
+		s.IcmpType, s.IcmpCode, s.Transport = uint16(icmp.TypeCode.Type()), uint16(icmp.TypeCode.Code()), protos.ICMP4
+		return
 	}
 
 	if icmp6Layer := p.Layer(layers.LayerTypeICMPv6); icmp6Layer != nil {
 		icmp, _ := icmp6Layer.(*layers.ICMPv6)
-		return 0, 0, uint16(icmp.TypeCode.Type()), uint16(icmp.TypeCode.Code()), protos.ICMP6
+		s.IcmpType, s.IcmpCode, s.Transport = uint16(icmp.TypeCode.Type()), uint16(icmp.TypeCode.Code()), protos.ICMP6
 	}
+}
 
-	return 0, 0, 0, 0, 0
+// observationInterface returns the interface a packet has been captured on. Multi-interface
+// captures attach it as ancillary data, otherwise only the index is known (if at all).
+func observationInterface(md *gopacket.PacketMetadata) flow.Interface {
+	for _, a := range md.AncillaryData {
+		if i, ok := a.(flow.Interface); ok {
+			return i
+		}
+	}
+	return flow.Interface{Index: md.InterfaceIndex}
 }
 
 // NewSample parses an IP packet and generates a Sample
@@ -104,35 +161,32 @@ func NewSample(p gopacket.Packet) (*Sample, error) {
 	// Parse IPv4 packet
 	if v4layer := p.Layer(layers.LayerTypeIPv4); v4layer != nil {
 		v4, _ := v4layer.(*layers.IPv4)
-		srcPort, dstPort, icmpType, icmpCode, proto := getMeta(p)
-		return &Sample{
+		s := &Sample{
 			Src:       v4.SrcIP,
 			Dst:       v4.DstIP,
-			IcmpType:  icmpType,
-			IcmpCode:  icmpCode,
-			Transport: proto,
 			Bytes:     v4.Length,
-			SrcPort:   srcPort,
-			DstPort:   dstPort,
-		}, nil
+			Timestamp: p.Metadata().Timestamp,
+			Interface: observationInterface(p.Metadata()),
+		}
+		getMeta(p, s)
+		return s, nil
 	}
 
 	// Parse IPv6 packet
 	if v6layer := p.Layer(layers.LayerTypeIPv6); v6layer != nil {
 		v6, _ := v6layer.(*layers.IPv6)
-		srcPort, dstPort, icmpType, icmpCode, proto := getMeta(p)
-		return &Sample{
+		s := &Sample{
 			Src:       v6.SrcIP,
 			Dst:       v6.DstIP,
-			IcmpType:  icmpType,
-			IcmpCode:  icmpCode,
-			Transport: proto,
 			Bytes:     v6.Length,
-			SrcPort:   srcPort,
-			DstPort:   dstPort,
-		}, nil
+			Timestamp: p.Metadata().Timestamp,
+			Interface: observationInterface(p.Metadata()),
+		}
+		getMeta(p, s)
+		return s, nil
 	}
 
 	// No IP packet/invalid header
+	sampleErrors.Inc()
 	return nil, errors.New("not an IP packet")
 }
@@ -0,0 +1,91 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package capture
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// serialize encodes a TCP segment with payload bytes in the network layers
+func serialize(t *testing.T, network []gopacket.SerializableLayer, payload int) []byte {
+	tcp := &layers.TCP{SrcPort: 41234, DstPort: 80, Seq: 1000, ACK: true, Window: 1024}
+	buf := gopacket.NewSerializeBuffer()
+	l := append(network, tcp, gopacket.Payload(make([]byte, payload)))
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, l...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNewSampleSegmentLength(t *testing.T) {
+	src, dst := net.ParseIP("10.0.0.1").To4(), net.ParseIP("10.0.0.2").To4()
+	src6, dst6 := net.ParseIP("fd00::1"), net.ParseIP("fd00::2")
+
+	// Destination options with a PadN option filling up the header to 8 bytes
+	opts := &layers.IPv6Destination{Options: []*layers.IPv6DestinationOption{{OptionType: 1, OptionLength: 4, OptionData: make([]byte, 4)}}}
+	opts.NextHeader = layers.IPProtocolTCP
+
+	for _, c := range []struct {
+		name      string
+		first     gopacket.LayerType
+		network   []gopacket.SerializableLayer
+		truncated int // Bytes cut off by the snaplen
+	}{
+		{"ipv4", layers.LayerTypeIPv4, []gopacket.SerializableLayer{
+			&layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: src, DstIP: dst},
+		}, 0},
+		{"ipv4 truncated", layers.LayerTypeIPv4, []gopacket.SerializableLayer{
+			&layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: src, DstIP: dst},
+		}, 60},
+		{"ipv6", layers.LayerTypeIPv6, []gopacket.SerializableLayer{
+			&layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolTCP, SrcIP: src6, DstIP: dst6},
+		}, 0},
+		{"ipv6 extension header", layers.LayerTypeIPv6, []gopacket.SerializableLayer{
+			&layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolIPv6Destination, SrcIP: src6, DstIP: dst6},
+			opts,
+		}, 0},
+		{"ipv6 extension header truncated", layers.LayerTypeIPv6, []gopacket.SerializableLayer{
+			&layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolIPv6Destination, SrcIP: src6, DstIP: dst6},
+			opts,
+		}, 60},
+	} {
+		data := serialize(t, c.network, 100)
+		p := gopacket.NewPacket(data[:len(data)-c.truncated], c.first, gopacket.Default)
+		p.Metadata().CaptureInfo = gopacket.CaptureInfo{Length: len(data), CaptureLength: len(data) - c.truncated}
+
+		s, err := NewSample(p)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if s.SegLen != 100 || s.Seq != 1000 {
+			t.Errorf("%s: expected a segment of 100 bytes, got %d (seq %d)", c.name, s.SegLen, s.Seq)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package clusterip
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+// Logger
+var log *logrus.Entry
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "clusterip",
+	})
+}
+
+// TranslatePath is the path of the translation API
+const TranslatePath = "/translate"
+
+// clientTimeout limits the duration of a translation request
+const clientTimeout = 5 * time.Second
+
+// Tuple is the JSON representation of a flow.Meta
+type Tuple struct {
+	Transport uint8  `json:"transport"` // IANA protocol number
+	Src       net.IP `json:"src"`
+	Dst       net.IP `json:"dst"`
+	SrcPort   uint16 `json:"src_port,omitempty"`
+	DstPort   uint16 `json:"dst_port,omitempty"`
+	IcmpType  uint16 `json:"icmp_type,omitempty"`
+	IcmpCode  uint16 `json:"icmp_code,omitempty"`
+}
+
+// NewTuple converts a flow.Meta
+func NewTuple(m flow.Meta) Tuple {
+	return Tuple{
+		Transport: uint8(m.Transport),
+		Src:       m.Src,
+		Dst:       m.Dst,
+		SrcPort:   m.SrcPort,
+		DstPort:   m.DstPort,
+		IcmpType:  m.IcmpType,
+		IcmpCode:  m.IcmpCode,
+	}
+}
+
+// Meta converts the tuple to a flow.Meta
+func (t Tuple) Meta() flow.Meta {
+	return flow.Meta{
+		Transport: protos.ProtocolType(t.Transport),
+		Src:       t.Src,
+		Dst:       t.Dst,
+		SrcPort:   t.SrcPort,
+		DstPort:   t.DstPort,
+		IcmpType:  t.IcmpType,
+		IcmpCode:  t.IcmpCode,
+	}
+}
+
+// NewHandler serves the translation API of a Translator:
+//
+//	POST /translate with a JSON array of tuples returns an array of mappings (null if unknown)
+//	GET /translate?community_id=<id> returns the mapping of a flow (404 if unknown), only
+//	supported by Tables
+func NewHandler(t Translator) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(TranslatePath, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var tuples []Tuple
+			if err := json.NewDecoder(r.Body).Decode(&tuples); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			metas := make([]flow.Meta, len(tuples))
+			for i, tuple := range tuples {
+				metas[i] = tuple.Meta()
+			}
+			mappings, err := t.Translate(metas)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, mappings)
+		case http.MethodGet:
+			table, ok := t.(Table)
+			id := r.URL.Query().Get("community_id")
+			if !ok || id == "" {
+				http.Error(w, "community_id required", http.StatusBadRequest)
+				return
+			}
+			mp := table.Lookup(id)
+			if mp == nil {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(w, mp)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+// RestrictClients wraps h, answering requests from addresses outside of allowed with 403. All
+// clients are allowed if the list is empty.
+func RestrictClients(h http.Handler, allowed []*net.IPNet) http.Handler {
+	if len(allowed) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(host)
+		if err == nil && ip != nil {
+			for _, n := range allowed {
+				if n.Contains(ip) {
+					h.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithError(err).Warn("Failed to write response")
+	}
+}
+
+// client queries the translation API of a resolver
+type client struct {
+	url    string
+	client *http.Client
+}
+
+// NewClient creates a Translator querying the resolver at url (e.g. http://10.0.0.1:9478)
+func NewClient(url string) Translator {
+	return &client{
+		url:    strings.TrimSuffix(url, "/") + TranslatePath,
+		client: &http.Client{Timeout: clientTimeout},
+	}
+}
+
+func (c *client) Translate(metas []flow.Meta) ([]*Mapping, error) {
+	tuples := make([]Tuple, len(metas))
+	for i, m := range metas {
+		tuples[i] = NewTuple(m)
+	}
+	body, err := json.Marshal(tuples)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("translation request failed: %s", resp.Status)
+	}
+
+	var mappings []*Mapping
+	if err := json.NewDecoder(resp.Body).Decode(&mappings); err != nil {
+		return nil, err
+	}
+	if len(mappings) != len(metas) {
+		return nil, fmt.Errorf("expected %d mappings, got %d", len(metas), len(mappings))
+	}
+	return mappings, nil
+}
@@ -0,0 +1,116 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package clusterip
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/conntrack"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/communityid"
+)
+
+func TestAPI(t *testing.T) {
+	tab := NewTable(time.Hour, 30*time.Second).(*table)
+	tab.HandleEvent(&conntrack.Event{Type: conntrack.EventNew, Entry: serviceEntry})
+
+	srv := httptest.NewServer(NewHandler(tab))
+	defer srv.Close()
+
+	expected, err := tab.Translate([]flow.Meta{serviceFlow, backendFlow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewClient(srv.URL + "/").Translate([]flow.Meta{serviceFlow, backendFlow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(got, expected) {
+		t.Error(cmp.Diff(expected, got))
+	}
+
+	for id, status := range map[string]int{
+		communityid.NewHasher(0).Hash(serviceFlow): http.StatusOK,
+		communityid.NewHasher(0).Hash(backendFlow): http.StatusNotFound,
+		"": http.StatusBadRequest,
+	} {
+		resp, err := http.Get(srv.URL + TranslatePath + "?community_id=" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%q: expected status %d, got %d", id, status, resp.StatusCode)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+
+	if _, err := NewClient(srv.URL).Translate([]flow.Meta{serviceFlow}); err == nil {
+		t.Error("Expected error for mismatching number of mappings")
+	}
+
+	srv.Close()
+	if _, err := NewClient(srv.URL).Translate([]flow.Meta{serviceFlow}); err == nil {
+		t.Error("Expected error for unavailable resolver")
+	}
+}
+
+func TestRestrictClients(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, pods, _ := net.ParseCIDR("10.1.0.0/16")
+
+	for _, c := range []struct {
+		allowed []*net.IPNet
+		status  int
+	}{
+		{nil, http.StatusOK},
+		{[]*net.IPNet{pods, loopback}, http.StatusOK},
+		{[]*net.IPNet{pods}, http.StatusForbidden},
+	} {
+		srv := httptest.NewServer(RestrictClients(ok, c.allowed))
+		resp, err := http.Get(srv.URL + TranslatePath)
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%v: expected status %d, got %d", c.allowed, c.status, resp.StatusCode)
+		}
+	}
+}
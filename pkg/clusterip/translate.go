@@ -0,0 +1,201 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package clusterip
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xvzf/insight/pkg/conntrack"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/communityid"
+)
+
+// Mapping translates a flow addressed to a service to the backend selected by kube-proxy
+type Mapping struct {
+	CommunityID string `json:"community_id"` // CommunityID of the translated flow
+	ServiceIP   net.IP `json:"service_ip"`   // Service address the client connected to
+	ServicePort uint16 `json:"service_port"` // Service port the client connected to
+	ReplaceIP   net.IP `json:"replace_ip"`   // Actual target IP
+	ReplacePort uint16 `json:"replace_port"` // Actual target port
+}
+
+// Apply replaces the service address of m by the backend. The second return value is false if
+// m does not contain the service address.
+func (mp *Mapping) Apply(m flow.Meta) (flow.Meta, bool) {
+	switch {
+	case m.Dst.Equal(mp.ServiceIP) && m.DstPort == mp.ServicePort:
+		m.Dst, m.DstPort = mp.ReplaceIP, mp.ReplacePort
+	case m.Src.Equal(mp.ServiceIP) && m.SrcPort == mp.ServicePort:
+		// First packet of the flow has been sent by the server
+		m.Src, m.SrcPort = mp.ReplaceIP, mp.ReplacePort
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+// Rewrite applies the mapping to a flow, the original tuple and CommunityID are preserved
+func (mp *Mapping) Rewrite(f *flow.Flow) bool {
+	m, ok := mp.Apply(f.Meta)
+	if !ok {
+		return false
+	}
+	f.Original = &flow.Original{Meta: f.Meta, CommunityID: f.CommunityID}
+	f.Meta, f.CommunityID = m, mp.CommunityID
+	return true
+}
+
+// Translator looks up the backends of flows addressed to services
+type Translator interface {
+	// Translate returns a mapping for every flow, nil if it is not addressed to a known service
+	Translate(metas []flow.Meta) ([]*Mapping, error)
+}
+
+// Table contains the mappings of the service connections in the conntrack table of a node,
+// keyed by the CommunityID of the original flow
+type Table interface {
+	Translator
+	Lookup(communityID string) *Mapping
+	HandleEvent(e *conntrack.Event)
+	Expire()
+	Len() int
+}
+
+type tableEntry struct {
+	mapping *Mapping
+	expires time.Time
+}
+
+type table struct {
+	sync.RWMutex
+	data         map[string]*tableEntry
+	hasher       communityid.Hasher
+	ttl          time.Duration
+	destroyedTTL time.Duration
+	now          func() time.Time
+}
+
+// NewTable creates a new Table. Mappings expire after ttl, or destroyedTTL after the
+// connection has been closed.
+func NewTable(ttl, destroyedTTL time.Duration) Table {
+	return &table{
+		data:         make(map[string]*tableEntry),
+		hasher:       communityid.NewHasher(0),
+		ttl:          ttl,
+		destroyedTTL: destroyedTTL,
+		now:          time.Now,
+	}
+}
+
+// HandleEvent adds the mapping of a conntrack entry. Entries which have not been translated
+// are ignored, the caller has to check whether the original destination is a service.
+func (t *table) HandleEvent(e *conntrack.Event) {
+	backend, ok := e.Entry.Translated()
+	if !ok {
+		return
+	}
+
+	var ttl time.Duration
+	switch e.Type {
+	case conntrack.EventNew:
+		ttl = t.ttl
+	case conntrack.EventDestroy:
+		// Give the probes some time to export the last flows of the connection
+		ttl = t.destroyedTTL
+	default:
+		return
+	}
+
+	// Only the destination is replaced, the client address is kept even if masqueraded
+	orig := e.Entry.FlowMeta0
+	translated := orig
+	translated.Dst, translated.DstPort = backend.Dst, backend.DstPort
+
+	key := t.hasher.Hash(orig)
+	entry := &tableEntry{
+		mapping: &Mapping{
+			CommunityID: t.hasher.Hash(translated),
+			ServiceIP:   orig.Dst,
+			ServicePort: orig.DstPort,
+			ReplaceIP:   backend.Dst,
+			ReplacePort: backend.DstPort,
+		},
+		expires: t.now().Add(ttl),
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	t.data[key] = entry
+}
+
+// Lookup returns the mapping of the flow with the given CommunityID, nil if unknown
+func (t *table) Lookup(communityID string) *Mapping {
+	t.RLock()
+	defer t.RUnlock()
+
+	e, ok := t.data[communityID]
+	if !ok || t.now().After(e.expires) {
+		return nil
+	}
+	return e.mapping
+}
+
+func (t *table) Translate(metas []flow.Meta) ([]*Mapping, error) {
+	mappings := make([]*Mapping, len(metas))
+	for i, m := range metas {
+		mp := t.Lookup(t.hasher.Hash(m))
+		if mp == nil {
+			continue
+		}
+		// Guard against CommunityID collisions
+		if _, ok := mp.Apply(m); ok {
+			mappings[i] = mp
+		}
+	}
+	return mappings, nil
+}
+
+// Expire removes expired mappings
+func (t *table) Expire() {
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.now()
+	for k, e := range t.data {
+		if now.After(e.expires) {
+			delete(t.data, k)
+		}
+	}
+}
+
+// Len returns the number of mappings
+func (t *table) Len() int {
+	t.RLock()
+	defer t.RUnlock()
+	return len(t.data)
+}
@@ -0,0 +1,164 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package clusterip
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/conntrack"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/communityid"
+	"github.com/xvzf/insight/pkg/protos"
+	"github.com/xvzf/insight/pkg/tracking/trackingtest"
+)
+
+var (
+	// Pod connecting to a ClusterIP service
+	serviceFlow = flow.Meta{
+		Transport: protos.TCP,
+		Src:       net.ParseIP("10.1.0.5"),
+		SrcPort:   41234,
+		Dst:       net.ParseIP("10.96.0.20"),
+		DstPort:   80,
+	}
+	// Same connection as seen by the backend
+	backendFlow = flow.Meta{
+		Transport: protos.TCP,
+		Src:       net.ParseIP("10.1.0.5"),
+		SrcPort:   41234,
+		Dst:       net.ParseIP("10.1.1.7"),
+		DstPort:   8080,
+	}
+
+	serviceEntry = conntrack.Entry{
+		FlowMeta0: serviceFlow,
+		FlowMeta1: flow.Meta{
+			Transport: protos.TCP,
+			Src:       backendFlow.Dst,
+			SrcPort:   backendFlow.DstPort,
+			Dst:       serviceFlow.Src,
+			DstPort:   serviceFlow.SrcPort,
+		},
+	}
+)
+
+func TestTableTranslate(t *testing.T) {
+	tab := NewTable(time.Hour, 30*time.Second).(*table)
+	tab.HandleEvent(&conntrack.Event{Type: conntrack.EventNew, Entry: serviceEntry})
+
+	// Not translated by kube-proxy
+	untranslated := conntrack.Entry{FlowMeta0: backendFlow, FlowMeta1: flow.Meta{
+		Transport: protos.TCP,
+		Src:       backendFlow.Dst,
+		SrcPort:   backendFlow.DstPort,
+		Dst:       backendFlow.Src,
+		DstPort:   backendFlow.SrcPort,
+	}}
+	tab.HandleEvent(&conntrack.Event{Type: conntrack.EventNew, Entry: untranslated})
+	if tab.Len() != 1 {
+		t.Errorf("Expected 1 mapping, got %d", tab.Len())
+	}
+
+	// Flow captured from the server side first
+	reversed := serviceFlow
+	reversed.Src, reversed.SrcPort, reversed.Dst, reversed.DstPort = serviceFlow.Dst, serviceFlow.DstPort, serviceFlow.Src, serviceFlow.SrcPort
+
+	mappings, err := tab.Translate([]flow.Meta{serviceFlow, backendFlow, reversed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Mapping{
+		CommunityID: communityid.NewHasher(0).Hash(backendFlow),
+		ServiceIP:   serviceFlow.Dst,
+		ServicePort: serviceFlow.DstPort,
+		ReplaceIP:   backendFlow.Dst,
+		ReplacePort: backendFlow.DstPort,
+	}
+	if !cmp.Equal(mappings, []*Mapping{expected, nil, expected}) {
+		t.Error(cmp.Diff([]*Mapping{expected, nil, expected}, mappings))
+	}
+
+	if m, ok := expected.Apply(reversed); !ok || !m.Src.Equal(backendFlow.Dst) || m.SrcPort != backendFlow.DstPort {
+		t.Errorf("Reversed flow not rewritten: %+v", m)
+	}
+}
+
+func TestTableExpire(t *testing.T) {
+	clock := trackingtest.NewClock(time.Unix(1000, 0))
+	tab := NewTable(time.Hour, 30*time.Second).(*table)
+	tab.now = clock.Now
+	tab.HandleEvent(&conntrack.Event{Type: conntrack.EventNew, Entry: serviceEntry})
+	id := communityid.NewHasher(0).Hash(serviceFlow)
+
+	clock.Add(30 * time.Minute)
+	tab.HandleEvent(&conntrack.Event{Type: conntrack.EventDestroy, Entry: serviceEntry})
+
+	// Still available for the last flows of the connection
+	clock.Add(20 * time.Second)
+	tab.Expire()
+	if tab.Lookup(id) == nil {
+		t.Error("Mapping expired too early")
+	}
+
+	clock.Add(20 * time.Second)
+	if tab.Lookup(id) != nil {
+		t.Error("Expired mapping returned")
+	}
+	tab.Expire()
+	if tab.Len() != 0 {
+		t.Errorf("Expected empty table, got %d mappings", tab.Len())
+	}
+}
+
+func TestMappingRewrite(t *testing.T) {
+	tab := NewTable(time.Hour, 30*time.Second).(*table)
+	tab.HandleEvent(&conntrack.Event{Type: conntrack.EventNew, Entry: serviceEntry})
+	mappings, _ := tab.Translate([]flow.Meta{serviceFlow})
+
+	f := flow.New(serviceFlow)
+	f.CommunityID = communityid.NewHasher(0).Hash(serviceFlow)
+	original := f.CommunityID
+
+	if !mappings[0].Rewrite(f) {
+		t.Fatal("Flow not rewritten")
+	}
+	if !cmp.Equal(f.Meta, backendFlow) {
+		t.Error(cmp.Diff(backendFlow, f.Meta))
+	}
+	if !cmp.Equal(f.Original, &flow.Original{Meta: serviceFlow, CommunityID: original}) {
+		t.Errorf("Original not preserved: %+v", f.Original)
+	}
+	if f.CommunityID != mappings[0].CommunityID {
+		t.Errorf("CommunityID not replaced: %s", f.CommunityID)
+	}
+
+	if mappings[0].Rewrite(flow.New(backendFlow)) {
+		t.Error("Flow not addressed to the service rewritten")
+	}
+}
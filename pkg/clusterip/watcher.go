@@ -29,33 +29,147 @@ import (
 	"net"
 	"sync"
 
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/watch"
 )
 
-// Watcher contains a kubernetes service-ip state and provides an API
-// to pass k8s events and check whether an IP is a k8s ClusterIP
+// Address types of a service
+const (
+	TypeClusterIP    = "ClusterIP"
+	TypeNodePort     = "NodePort"
+	TypeExternalIP   = "ExternalIP"
+	TypeLoadBalancer = "LoadBalancer"
+)
+
+// Address is an address clients connect to a service on. NodePorts are reachable on every
+// node address, their IP is nil.
+type Address struct {
+	Type      string
+	IP        net.IP
+	Port      uint16
+	Transport protos.ProtocolType
+	PortName  string // Name of the service port
+}
+
+// Service identifies the service port an address belongs to
+type Service struct {
+	Namespace string
+	Name      string
+	Port      string // Name of the service port
+	Type      string // Address type
+}
+
+// Addresses returns the addresses of all ports of a service. The API version in use only
+// exposes a single cluster IP (Spec.ClusterIP), the secondary family of dual-stack services
+// is not covered.
+func Addresses(svc *corev1.Service) []Address {
+	var addrs []Address
+	for _, p := range svc.Spec.Ports {
+		transport, ok := transports[p.Protocol]
+		if !ok {
+			continue
+		}
+		port := uint16(p.Port)
+
+		if ip := parseIP(svc.Spec.ClusterIP); ip != nil {
+			addrs = append(addrs, Address{TypeClusterIP, ip, port, transport, p.Name})
+		}
+		for _, external := range svc.Spec.ExternalIPs {
+			if ip := parseIP(external); ip != nil {
+				addrs = append(addrs, Address{TypeExternalIP, ip, port, transport, p.Name})
+			}
+		}
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ip := parseIP(ingress.IP); ip != nil {
+				addrs = append(addrs, Address{TypeLoadBalancer, ip, port, transport, p.Name})
+			}
+		}
+		if p.NodePort != 0 {
+			addrs = append(addrs, Address{TypeNodePort, nil, uint16(p.NodePort), transport, p.Name})
+		}
+	}
+	return addrs
+}
+
+// IPs returns the virtual IPs of a service: the cluster IP, external IPs and load balancer
+// ingress IPs
+func IPs(svc *corev1.Service) []net.IP {
+	var ips []net.IP
+	if ip := parseIP(svc.Spec.ClusterIP); ip != nil {
+		ips = append(ips, ip)
+	}
+	for _, external := range svc.Spec.ExternalIPs {
+		if ip := parseIP(external); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ip := parseIP(ingress.IP); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// transports maps service port protocols
+var transports = map[corev1.Protocol]protos.ProtocolType{
+	corev1.ProtocolTCP:  protos.TCP,
+	corev1.ProtocolUDP:  protos.UDP,
+	corev1.ProtocolSCTP: protos.SCTP,
+}
+
+// parseIP parses a service address, empty addresses and "None" of headless services are nil
+func parseIP(s string) net.IP {
+	if s == "" || s == corev1.ClusterIPNone {
+		return nil
+	}
+	return net.ParseIP(s)
+}
+
+// Watcher contains a kubernetes service address index and provides an API
+// to pass k8s events and look up the service a connection is addressed to
 type Watcher interface {
 	HandleUpdate(e watch.Event)
 	IsServiceIP(ip net.IP) bool
+	Lookup(m flow.Meta) *Service
+	SetNodeIPs(ips []net.IP)
+}
+
+// addrKey indexes service addresses, the IP of NodePorts is empty
+type addrKey struct {
+	ip        string
+	port      uint16
+	transport protos.ProtocolType
+}
+
+// indexed contains the index entries of a service
+type indexed struct {
+	ips   []string
+	addrs []addrKey
 }
 
 type watcher struct {
-	sync.Mutex
-	data map[string]bool
+	sync.RWMutex
+	ips      map[string]int       // Number of services using a virtual IP
+	addrs    map[addrKey]*Service // Service ports by address
+	services map[string]*indexed  // Index entries by namespace/name
+	nodeIPs  map[string]bool      // Local addresses NodePorts are reachable on
 }
 
 // NewWatcher creates a new Watcher
 func NewWatcher() Watcher {
 	return &watcher{
-		data: make(map[string]bool),
+		ips:      make(map[string]int),
+		addrs:    make(map[addrKey]*Service),
+		services: make(map[string]*indexed),
+		nodeIPs:  make(map[string]bool),
 	}
 }
 
 // HandleUpdate handles API Server requests
 func (w *watcher) HandleUpdate(e watch.Event) {
-	w.Mutex.Lock()
-	defer w.Mutex.Unlock()
 	svc, ok := e.Object.(*corev1.Service)
 
 	// Not a service
@@ -63,24 +177,98 @@ func (w *watcher) HandleUpdate(e watch.Event) {
 		return
 	}
 
-	// Not of type clusterIP
-	if svc.Spec.ClusterIP == "None" {
+	w.Lock()
+	defer w.Unlock()
+
+	key := svc.Namespace + "/" + svc.Name
+	switch e.Type {
+	case watch.Added, watch.Modified:
+		// Addresses and ports can change, replace all entries of the service
+		w.remove(key)
+		w.add(key, svc)
+	case watch.Deleted:
+		w.remove(key)
+	}
+}
+
+// add indexes the addresses of a service
+func (w *watcher) add(key string, svc *corev1.Service) {
+	entry := &indexed{}
+	for _, ip := range IPs(svc) {
+		entry.ips = append(entry.ips, ip.String())
+		w.ips[ip.String()]++
+	}
+
+	for _, a := range Addresses(svc) {
+		k := addrKey{port: a.Port, transport: a.Transport}
+		if a.IP != nil {
+			k.ip = a.IP.String()
+		}
+		entry.addrs = append(entry.addrs, k)
+		w.addrs[k] = &Service{
+			Namespace: svc.Namespace,
+			Name:      svc.Name,
+			Port:      a.PortName,
+			Type:      a.Type,
+		}
+	}
+	w.services[key] = entry
+}
+
+// remove deletes the index entries of a service
+func (w *watcher) remove(key string) {
+	entry, ok := w.services[key]
+	if !ok {
 		return
 	}
+	delete(w.services, key)
 
-	switch e.Type {
-	case "ADDED":
-		w.data[svc.Spec.ClusterIP] = true
-	case "DELETED":
-		delete(w.data, svc.Spec.ClusterIP)
+	for _, ip := range entry.ips {
+		if w.ips[ip]--; w.ips[ip] <= 0 {
+			delete(w.ips, ip)
+		}
+	}
+	for _, k := range entry.addrs {
+		// The address might have been taken over by another service, e.g. an external IP
+		if s, ok := w.addrs[k]; ok && s.Namespace+"/"+s.Name == key {
+			delete(w.addrs, k)
+		}
 	}
 }
 
 // IsServiceIP checks if the processed IP belongs to a service
 func (w *watcher) IsServiceIP(ip net.IP) bool {
-	w.Mutex.Lock()
-	defer w.Mutex.Unlock()
+	w.RLock()
+	defer w.RUnlock()
+
+	return w.ips[ip.String()] > 0
+}
+
+// Lookup returns the service port the destination of m belongs to, nil if it is not addressed
+// to a service. NodePorts only match on node addresses.
+func (w *watcher) Lookup(m flow.Meta) *Service {
+	w.RLock()
+	defer w.RUnlock()
+
+	k := addrKey{ip: m.Dst.String(), port: m.DstPort, transport: m.Transport}
+	if s, ok := w.addrs[k]; ok {
+		return s
+	}
+	if w.nodeIPs[k.ip] {
+		k.ip = ""
+		return w.addrs[k]
+	}
+	return nil
+}
+
+// SetNodeIPs sets the local addresses of the node
+func (w *watcher) SetNodeIPs(ips []net.IP) {
+	nodeIPs := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		nodeIPs[ip.String()] = true
+	}
 
-	v, ok := w.data[ip.String()]
-	return (v && ok)
+	w.Lock()
+	defer w.Unlock()
+	w.nodeIPs = nodeIPs
 }
@@ -29,7 +29,10 @@ import "testing"
 
 import (
 	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
 	"net"
 )
@@ -39,25 +42,29 @@ var (
 		watch.Event{
 			Type: "ADDED",
 			Object: &corev1.Service{
-				Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.1"},
+				ObjectMeta: metav1.ObjectMeta{Name: "svc-1", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{ClusterIP: "10.0.0.1"},
 			},
 		},
 		watch.Event{
 			Type: "ADDED",
 			Object: &corev1.Service{
-				Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.2"},
+				ObjectMeta: metav1.ObjectMeta{Name: "svc-2", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{ClusterIP: "10.0.0.2"},
 			},
 		},
 		watch.Event{
 			Type: "ADDED",
 			Object: &corev1.Service{
-				Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.3"},
+				ObjectMeta: metav1.ObjectMeta{Name: "svc-3", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{ClusterIP: "10.0.0.3"},
 			},
 		},
 		watch.Event{
 			Type: "DELETED",
 			Object: &corev1.Service{
-				Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.3"},
+				ObjectMeta: metav1.ObjectMeta{Name: "svc-3", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{ClusterIP: "10.0.0.3"},
 			},
 		},
 		watch.Event{
@@ -67,21 +74,23 @@ var (
 		watch.Event{
 			Type: "ADDED",
 			Object: &corev1.Service{
-				Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.4"},
+				ObjectMeta: metav1.ObjectMeta{Name: "svc-4", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{ClusterIP: "10.0.0.4"},
 			},
 		},
 		watch.Event{
 			Type: "ADDED",
 			Object: &corev1.Service{
-				Spec: corev1.ServiceSpec{ClusterIP: "None"},
+				ObjectMeta: metav1.ObjectMeta{Name: "headless", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{ClusterIP: "None"},
 			},
 		},
 	}
 
-	mapAfterEvents map[string]bool = map[string]bool{
-		"10.0.0.1": true,
-		"10.0.0.2": true,
-		"10.0.0.4": true,
+	mapAfterEvents map[string]int = map[string]int{
+		"10.0.0.1": 1,
+		"10.0.0.2": 1,
+		"10.0.0.4": 1,
 	}
 
 	clusterIPs []net.IP = []net.IP{
@@ -106,7 +115,7 @@ func TestNewWatcher(t *testing.T) {
 		return
 	}
 
-	if rawW.data == nil {
+	if rawW.ips == nil || rawW.addrs == nil || rawW.services == nil {
 		t.Error("Datastructure not initialized")
 	}
 }
@@ -124,8 +133,8 @@ func TestHandleUpdate(t *testing.T) {
 
 	rawW, _ := w.(*watcher)
 
-	if !cmp.Equal(rawW.data, mapAfterEvents) {
-		t.Error(cmp.Diff(rawW.data, mapAfterEvents))
+	if !cmp.Equal(rawW.ips, mapAfterEvents) {
+		t.Error(cmp.Diff(rawW.ips, mapAfterEvents))
 	}
 }
 
@@ -144,3 +153,134 @@ func TestIsServiceIP(t *testing.T) {
 		}
 	}
 }
+
+// testService is a LoadBalancer service with all kinds of addresses
+var testService = &corev1.Service{
+	ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"},
+	Spec: corev1.ServiceSpec{
+		Type:        corev1.ServiceTypeLoadBalancer,
+		ClusterIP:   "10.96.0.20",
+		ExternalIPs: []string{"192.0.2.10"},
+		Ports: []corev1.ServicePort{
+			{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 30080},
+			{Name: "dns", Protocol: corev1.ProtocolUDP, Port: 53, NodePort: 30053},
+		},
+	},
+	Status: corev1.ServiceStatus{
+		LoadBalancer: corev1.LoadBalancerStatus{
+			Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.7"}, {Hostname: "lb.example.com"}},
+		},
+	},
+}
+
+func TestAddresses(t *testing.T) {
+	expected := []Address{
+		{TypeClusterIP, net.ParseIP("10.96.0.20"), 80, protos.TCP, "http"},
+		{TypeExternalIP, net.ParseIP("192.0.2.10"), 80, protos.TCP, "http"},
+		{TypeLoadBalancer, net.ParseIP("203.0.113.7"), 80, protos.TCP, "http"},
+		{TypeNodePort, nil, 30080, protos.TCP, "http"},
+		{TypeClusterIP, net.ParseIP("10.96.0.20"), 53, protos.UDP, "dns"},
+		{TypeExternalIP, net.ParseIP("192.0.2.10"), 53, protos.UDP, "dns"},
+		{TypeLoadBalancer, net.ParseIP("203.0.113.7"), 53, protos.UDP, "dns"},
+		{TypeNodePort, nil, 30053, protos.UDP, "dns"},
+	}
+	if got := Addresses(testService); !cmp.Equal(got, expected) {
+		t.Error(cmp.Diff(expected, got))
+	}
+}
+
+// dst returns a flow to the given destination
+func dst(ip string, port uint16, transport protos.ProtocolType) flow.Meta {
+	return flow.Meta{
+		Transport: transport,
+		Src:       net.ParseIP("10.1.0.5"),
+		SrcPort:   41234,
+		Dst:       net.ParseIP(ip),
+		DstPort:   port,
+	}
+}
+
+func TestLookup(t *testing.T) {
+	w := NewWatcher()
+	w.HandleUpdate(watch.Event{Type: watch.Added, Object: testService})
+	w.SetNodeIPs([]net.IP{net.ParseIP("192.168.0.10"), net.ParseIP("127.0.0.1")})
+
+	service := func(port, typ string) *Service {
+		return &Service{Namespace: "shop", Name: "web", Port: port, Type: typ}
+	}
+	for _, c := range []struct {
+		flow     flow.Meta
+		expected *Service
+	}{
+		{dst("10.96.0.20", 80, protos.TCP), service("http", TypeClusterIP)},
+		{dst("10.96.0.20", 53, protos.UDP), service("dns", TypeClusterIP)},
+		{dst("192.0.2.10", 80, protos.TCP), service("http", TypeExternalIP)},
+		{dst("203.0.113.7", 53, protos.UDP), service("dns", TypeLoadBalancer)},
+		{dst("192.168.0.10", 30080, protos.TCP), service("http", TypeNodePort)},
+		{dst("127.0.0.1", 30053, protos.UDP), service("dns", TypeNodePort)},
+		// Wrong port or transport
+		{dst("10.96.0.20", 443, protos.TCP), nil},
+		{dst("10.96.0.20", 80, protos.UDP), nil},
+		// NodePort on an address of another node
+		{dst("192.168.0.11", 30080, protos.TCP), nil},
+	} {
+		if got := w.Lookup(c.flow); !cmp.Equal(got, c.expected) {
+			t.Errorf("%s:%d: %s", c.flow.Dst, c.flow.DstPort, cmp.Diff(c.expected, got))
+		}
+	}
+}
+
+func TestHandleModified(t *testing.T) {
+	w := NewWatcher()
+	w.HandleUpdate(watch.Event{Type: watch.Added, Object: testService})
+	w.SetNodeIPs([]net.IP{net.ParseIP("192.168.0.10")})
+
+	// Load balancer released, external IP moved to another service, port changed
+	modified := testService.DeepCopy()
+	modified.Spec.Type = corev1.ServiceTypeNodePort
+	modified.Spec.ExternalIPs = nil
+	modified.Status.LoadBalancer.Ingress = nil
+	modified.Spec.Ports = modified.Spec.Ports[:1]
+	modified.Spec.Ports[0].Port = 8080
+	w.HandleUpdate(watch.Event{Type: watch.Modified, Object: modified})
+
+	other := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:   "10.96.0.21",
+			ExternalIPs: []string{"192.0.2.10"},
+			Ports:       []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80}},
+		},
+	}
+	w.HandleUpdate(watch.Event{Type: watch.Added, Object: other})
+
+	for _, c := range []struct {
+		flow flow.Meta
+		name string
+	}{
+		{dst("10.96.0.20", 8080, protos.TCP), "web"},
+		{dst("192.168.0.10", 30080, protos.TCP), "web"},
+		{dst("192.0.2.10", 80, protos.TCP), "api"},
+		{dst("10.96.0.20", 80, protos.TCP), ""},
+		{dst("10.96.0.20", 53, protos.UDP), ""},
+		{dst("203.0.113.7", 80, protos.TCP), ""},
+		{dst("192.168.0.10", 30053, protos.UDP), ""},
+	} {
+		got := w.Lookup(c.flow)
+		if (got == nil && c.name != "") || (got != nil && got.Name != c.name) {
+			t.Errorf("%s:%d: expected %q, got %+v", c.flow.Dst, c.flow.DstPort, c.name, got)
+		}
+	}
+	if w.IsServiceIP(net.ParseIP("203.0.113.7")) {
+		t.Error("Released load balancer IP still a service IP")
+	}
+
+	// Removing the service must not remove the external IP taken over by another service
+	w.HandleUpdate(watch.Event{Type: watch.Deleted, Object: modified})
+	if got := w.Lookup(dst("192.0.2.10", 80, protos.TCP)); got == nil || got.Name != "api" {
+		t.Errorf("External IP of other service removed, got %+v", got)
+	}
+	if w.Lookup(dst("192.168.0.10", 30080, protos.TCP)) != nil || w.IsServiceIP(net.ParseIP("10.96.0.20")) {
+		t.Error("Deleted service still indexed")
+	}
+}
@@ -0,0 +1,100 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package config
+
+import (
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/xvzf/insight/pkg/metrics"
+)
+
+// Collector is the configuration of the NetFlow/IPFIX collector
+type Collector struct {
+	Common        `yaml:",inline"`
+	Listen        string        `yaml:"listen"`
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	Sinks         []string      `yaml:"sinks"` // Reloadable
+	Queue         Queue         `yaml:"queue"`
+	Kubernetes    Kubernetes    `yaml:"kubernetes"`
+}
+
+// DefaultCollector returns the default configuration of the collector
+func DefaultCollector() *Collector {
+	return &Collector{
+		Common: Common{
+			MetricsAddr: metrics.DefaultAddr,
+			LogLevel:    "info",
+		},
+		Listen:        ":2055",
+		BatchSize:     1000,
+		FlushInterval: 5 * time.Second,
+		Queue:         defaultQueue(),
+		Kubernetes:    defaultKubernetes(),
+	}
+}
+
+// Flags registers the command line flags
+func (c *Collector) Flags(fs *flag.FlagSet) {
+	c.Common.flags(fs)
+	fs.StringVar(&c.Listen, "listen", c.Listen, "UDP address to receive NetFlow v5/v9 and IPFIX messages on")
+	fs.IntVar(&c.BatchSize, "batch-size", c.BatchSize, "Maximum number of flows per export")
+	fs.DurationVar(&c.FlushInterval, "flush-interval", c.FlushInterval, "Maximum time flows are buffered before being exported")
+	ListVar(fs, &c.Sinks, "", "sink", "Event sink (http://, elasticsearch://, file://, stdout, syslog://, kafka://), repeat to fan out")
+	c.Queue.flags(fs)
+	c.Kubernetes.flags(fs)
+}
+
+// Env lists the environment variables
+func (c *Collector) Env() []EnvVar {
+	return append(c.Common.env(),
+		EnvVar{"KUBECONFIG", "kubeconfig"},
+		EnvVar{"LOGSTASH", "sink"},
+		EnvVar{"SINKS", "sink"},
+	)
+}
+
+// Validate checks the configuration
+func (c *Collector) Validate() error {
+	if err := c.Common.validate(); err != nil {
+		return err
+	}
+	if c.Listen == "" {
+		return errors.New("listen address required")
+	}
+	if c.BatchSize <= 0 || c.FlushInterval <= 0 {
+		return errors.New("batch size and flush interval must be positive")
+	}
+	if len(c.Sinks) == 0 {
+		return errors.New("at least one sink required")
+	}
+	if err := c.Kubernetes.validate(); err != nil {
+		return err
+	}
+	return c.Queue.validate()
+}
@@ -0,0 +1,278 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "config",
+	})
+}
+
+// Logger
+var log *logrus.Entry
+
+// FileEnv is the environment variable pointing to the configuration file, overridden by -config
+const FileEnv = "INSIGHT_CONFIG"
+
+// Config is implemented by the configuration of every binary
+type Config interface {
+	// Flags registers the command line flags, bound to the configuration fields
+	Flags(fs *flag.FlagSet)
+	// Env lists the environment variables overriding flags, in the order they are applied
+	Env() []EnvVar
+	// Validate checks the configuration and normalizes values
+	Validate() error
+}
+
+// EnvVar maps an environment variable to the flag it overrides
+type EnvVar struct {
+	Name string
+	Flag string
+}
+
+// Load populates cfg, which has to contain the defaults. Values are applied in the order
+// YAML file (-config or $INSIGHT_CONFIG), environment variables and command line flags.
+func Load(cfg Config, args []string) error {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(FileEnv), "Load the configuration from this YAML file")
+	cfg.Flags(fs)
+
+	// The configuration file has to be known before the flags are applied
+	if p, ok := lookupFlag(fs, args, "config"); ok {
+		*path = p
+	}
+	if *path != "" {
+		if err := loadFile(*path, cfg); err != nil {
+			return err
+		}
+	}
+
+	// Environment
+	for _, env := range cfg.Env() {
+		value, ok := os.LookupEnv(env.Name)
+		if !ok {
+			continue
+		}
+		f := fs.Lookup(env.Flag)
+		if f == nil {
+			return fmt.Errorf("environment variable %s refers to unknown flag %s", env.Name, env.Flag)
+		}
+		if err := setEnv(f.Value, value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", value, env.Name, err)
+		}
+	}
+
+	// Command line flags replace list values instead of appending to the file or environment
+	fs.VisitAll(func(f *flag.Flag) {
+		if l, ok := f.Value.(*listValue); ok {
+			l.fresh = true
+		}
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	return cfg.Validate()
+}
+
+// MustLoad loads the configuration and exits on errors
+func MustLoad(cfg Config) {
+	if err := Load(cfg, os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+// loadFile decodes a YAML configuration file into cfg, unknown keys are rejected
+func loadFile(path string, cfg Config) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+		return fmt.Errorf("invalid configuration file %s: %v", path, err)
+	}
+	log.Info("Loaded configuration file ", path)
+	return nil
+}
+
+// lookupFlag finds the value of a flag in the command line arguments. The values of other flags
+// given as separate arguments are skipped, parsing stops at the first positional argument like fs.Parse.
+func lookupFlag(fs *flag.FlagSet, args []string, name string) (string, bool) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if eq := strings.IndexByte(arg, '='); eq >= 0 {
+			if arg[:eq] == name {
+				return arg[eq+1:], true
+			}
+			continue
+		}
+		if arg == name {
+			if i+1 < len(args) {
+				return args[i+1], true
+			}
+			return "", false
+		}
+		if f := fs.Lookup(arg); f != nil && !isBoolFlag(f.Value) {
+			i++
+		}
+	}
+	return "", false
+}
+
+// isBoolFlag reports whether a flag is given without a separate value argument
+func isBoolFlag(v flag.Value) bool {
+	b, ok := v.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// setEnv applies an environment variable. Lists are comma separated, empty lists are ignored so
+// an unset fallback variable does not clear the list.
+func setEnv(v flag.Value, value string) error {
+	l, ok := v.(*listValue)
+	if !ok {
+		return v.Set(value)
+	}
+	if value == "" {
+		return nil
+	}
+	l.fresh = true
+	for _, item := range strings.Split(value, ",") {
+		if err := l.append(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listValue binds a string list to a repeatable flag
+type listValue struct {
+	list  *[]string
+	sep   string // Separator splitting a single value, not split if empty
+	fresh bool   // The next value replaces the list
+}
+
+// ListVar defines a repeatable list flag. If sep is set, a single value is split as well.
+func ListVar(fs *flag.FlagSet, p *[]string, sep, name, usage string) {
+	fs.Var(&listValue{list: p, sep: sep}, name, usage)
+}
+
+func (l *listValue) String() string {
+	if l.list == nil {
+		return ""
+	}
+	return strings.Join(*l.list, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	if l.sep == "" {
+		return l.append(value)
+	}
+	for _, item := range strings.Split(value, l.sep) {
+		if err := l.append(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *listValue) append(item string) error {
+	item = strings.TrimSpace(item)
+	if item == "" {
+		return errors.New("empty list item")
+	}
+	if l.fresh {
+		*l.list, l.fresh = nil, false
+	}
+	*l.list = append(*l.list, item)
+	return nil
+}
+
+// Common contains the configuration shared by all binaries
+type Common struct {
+	MetricsAddr string `yaml:"metrics_addr"`
+	LogLevel    string `yaml:"log_level"`
+}
+
+// flags registers the common flags
+func (c *Common) flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", c.MetricsAddr, "Serve prometheus metrics on this address (disabled if empty)")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "Log level (debug, info, warning, error)")
+}
+
+// env lists the common environment variables
+func (c *Common) env() []EnvVar {
+	return []EnvVar{
+		{"METRICS_ADDR", "metrics-addr"},
+		{"LOG_LEVEL", "log-level"},
+	}
+}
+
+// validate checks the common configuration
+func (c *Common) validate() error {
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Apply applies the process wide settings, it is safe to call on reload
+func (c *Common) Apply() {
+	if level, err := logrus.ParseLevel(c.LogLevel); err == nil {
+		logrus.SetLevel(level)
+	}
+}
+
+// OnReload calls fn every time the process receives SIGHUP; it blocks forever
+func OnReload(fn func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		log.Info("Reloading configuration")
+		fn()
+	}
+}
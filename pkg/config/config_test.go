@@ -0,0 +1,243 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeFile writes a temporary configuration file
+func writeFile(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+// setEnvs sets environment variables for the duration of a test
+func setEnvs(t *testing.T, env map[string]string) func() {
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg := DefaultInsight()
+	if err := Load(cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(cfg, DefaultInsight()) {
+		t.Error(cmp.Diff(DefaultInsight(), cfg))
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path, cleanup := writeFile(t, `
+log_level: debug
+source: afpacket
+interfaces: [eth0, "cali*"]
+filter: tcp
+flow_table:
+  idle_timeout: 30s
+  max_flows: 1000
+sinks:
+  - http://logstash:8080
+exporter:
+  collector: file:2055
+`)
+	defer cleanup()
+	defer setEnvs(t, map[string]string{
+		"COLLECTOR": "env:4739",
+		"SINKS":     "stdout,file:///tmp/events",
+	})()
+
+	expected := DefaultInsight()
+	expected.LogLevel = "debug"
+	expected.Source = "afpacket"
+	expected.Interfaces = []string{"eth0", "cali*"}
+	expected.Filter = "udp"
+	expected.FlowTable.IdleTimeout = 5 * time.Second
+	expected.FlowTable.MaxFlows = 1000
+	expected.Exporter.Collector = "env:4739"
+	expected.Sinks = []string{"stdout", "file:///tmp/events"}
+
+	for _, args := range [][]string{
+		{"-config", path, "-f", "udp", "-idle-timeout", "5s"},
+		{"-f", "udp", "-config", path, "-idle-timeout", "5s"},
+		{"-f", "udp", "-tls=false", "-idle-timeout=5s", "--config=" + path},
+		{"-f", "udp", "-classify", "-idle-timeout", "5s", "-config", path},
+	} {
+		cfg := DefaultInsight()
+		if err := Load(cfg, args); err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(cfg, expected) {
+			t.Errorf("%v: %s", args, cmp.Diff(expected, cfg))
+		}
+	}
+}
+
+func TestLoadLists(t *testing.T) {
+	defer setEnvs(t, map[string]string{
+		"LOGSTASH": "http://logstash:8080",
+		"SINKS":    "",
+	})()
+
+	cfg := DefaultInsight()
+	if err := Load(cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(cfg.Sinks, []string{"http://logstash:8080"}) {
+		t.Errorf("Empty fallback variable must not clear the list, got %v", cfg.Sinks)
+	}
+
+	// Flags replace the environment, repeated flags append
+	cfg = DefaultInsight()
+	if err := Load(cfg, []string{"-sink", "stdout", "-sink", "kafka://k1,k2/flows", "-i", "eth0,veth*"}); err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(cfg.Sinks, []string{"stdout", "kafka://k1,k2/flows"}) {
+		t.Errorf("Invalid sinks %v", cfg.Sinks)
+	}
+	if !cmp.Equal(cfg.Interfaces, []string{"eth0", "veth*"}) {
+		t.Errorf("Invalid interfaces %v", cfg.Interfaces)
+	}
+}
+
+func TestLoadExporterAlias(t *testing.T) {
+	cfg := DefaultInsight()
+	if err := Load(cfg, []string{"-exporter", "logstash", "-dns"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Exporter.Type != "events" {
+		t.Errorf("Expected the events exporter, got %s", cfg.Exporter.Type)
+	}
+}
+
+func TestLoadEmptyScalarEnv(t *testing.T) {
+	defer setEnvs(t, map[string]string{"METRICS_ADDR": ""})()
+
+	cfg := DefaultInsight()
+	if err := Load(cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MetricsAddr != "" {
+		t.Errorf("Empty variable has to disable the metrics endpoint, got %s", cfg.MetricsAddr)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path, cleanup := writeFile(t, "unknown_key: 1\n")
+	defer cleanup()
+
+	for name, args := range map[string][]string{
+		"unknown key":       {"-config", path},
+		"missing file":      {"-config", path + ".missing"},
+		"unknown flag":      {"-unknown"},
+		"unknown source":    {"-source", "netmap"},
+		"missing collector": {"-exporter", "ipfix"},
+		"invalid timeout":   {"-idle-timeout", "0s"},
+		"block size":        {"-block-size", "1000"},
+		"log level":         {"-log-level", "verbose"},
+		"fanout id range":   {"-fanout-id", "70000"},
+		"socket refresh":    {"-attribute-sockets", "-socket-refresh", "0s"},
+		"dns exporter":      {"-dns", "-exporter", "ipfix", "-collector", "127.0.0.1:4739"},
+		"http exporter":     {"-http", "-exporter", "netflow9", "-collector", "127.0.0.1:2055"},
+	} {
+		if err := Load(DefaultInsight(), args); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResolver(t *testing.T) {
+	cfg := DefaultResolver()
+	if err := Load(cfg, []string{"-listen", ":9500", "-destroyed-mapping-ttl", "1m"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != ":9500" || cfg.DestroyedMappingTTL != time.Minute || cfg.MappingTTL != time.Hour {
+		t.Errorf("Unexpected configuration %+v", cfg)
+	}
+
+	if err := Load(DefaultResolver(), []string{"-mapping-ttl", "0s"}); err == nil {
+		t.Error("Expected error for zero TTL")
+	}
+
+	cfg = DefaultResolver()
+	if err := Load(cfg, []string{"-allowed-clients", "10.1.0.0/16,127.0.0.1/32"}); err != nil {
+		t.Fatal(err)
+	}
+	if nets := cfg.AllowedNets(); len(nets) != 2 || nets[1].String() != "127.0.0.1/32" {
+		t.Errorf("Unexpected allowed networks %v", nets)
+	}
+	if err := Load(DefaultResolver(), []string{"-allowed-clients", "10.1.0.0"}); err == nil {
+		t.Error("Expected error for invalid network")
+	}
+}
+
+func TestProbeInject(t *testing.T) {
+	defer setEnvs(t, map[string]string{
+		"TLS_CERT_FILE": "/tls/cert.pem",
+		"TLS_KEY_FILE":  "/tls/key.pem",
+		"PROBE_IMAGE":   "xvzf/insight:latest",
+		"SINKS":         "http://logstash:8080,kafka://kafka:9092/flows",
+	})()
+
+	cfg := DefaultProbeInject()
+	if err := Load(cfg, []string{"-listen", ":9443"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := &ProbeInject{
+		Common:      Common{MetricsAddr: ":9090", LogLevel: "info"},
+		Listen:      ":9443",
+		TLSCertFile: "/tls/cert.pem",
+		TLSKeyFile:  "/tls/key.pem",
+		Annotation:  "insight",
+		Probe: Probe{
+			Image: "xvzf/insight:latest",
+			Sinks: []string{"http://logstash:8080", "kafka://kafka:9092/flows"},
+		},
+	}
+	if !cmp.Equal(cfg, expected) {
+		t.Error(cmp.Diff(expected, cfg))
+	}
+}
@@ -0,0 +1,496 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/xvzf/insight/pkg/capture"
+	"github.com/xvzf/insight/pkg/dns"
+	"github.com/xvzf/insight/pkg/flow/container"
+	"github.com/xvzf/insight/pkg/httpmeta"
+	"github.com/xvzf/insight/pkg/metrics"
+	"github.com/xvzf/insight/pkg/procfs"
+	"github.com/xvzf/insight/pkg/queue"
+	"github.com/xvzf/insight/pkg/sink"
+)
+
+// FlowTable configures the flow container
+type FlowTable struct {
+	IdleTimeout   time.Duration `yaml:"idle_timeout"`
+	ActiveTimeout time.Duration `yaml:"active_timeout"`
+	Linger        time.Duration `yaml:"linger"`
+	MaxFlows      int           `yaml:"max_flows"`
+	InspectTLS    bool          `yaml:"inspect_tls"`
+	Classify      bool          `yaml:"classify"`
+	TCPMetrics    bool          `yaml:"tcp_metrics"`
+}
+
+// Options returns the flow container options
+func (f *FlowTable) Options() container.Options {
+	return container.Options{
+		IdleTimeout:   f.IdleTimeout,
+		ActiveTimeout: f.ActiveTimeout,
+		Linger:        f.Linger,
+		MaxFlows:      f.MaxFlows,
+		InspectTLS:    f.InspectTLS,
+		Classify:      f.Classify,
+		TCPMetrics:    f.TCPMetrics,
+	}
+}
+
+// AFPacket configures the AF_PACKET capture
+type AFPacket struct {
+	BlockSize int    `yaml:"block_size"`
+	NumBlocks int    `yaml:"num_blocks"`
+	SnapLen   int    `yaml:"snaplen"`
+	Workers   int    `yaml:"workers"`
+	FanoutID  uint16 `yaml:"fanout_id"`
+}
+
+// Options returns the capture options
+func (a *AFPacket) Options() capture.AFPacketOptions {
+	return capture.AFPacketOptions{
+		BlockSize: a.BlockSize,
+		NumBlocks: a.NumBlocks,
+		SnapLen:   a.SnapLen,
+		Workers:   a.Workers,
+		FanoutID:  a.FanoutID,
+	}
+}
+
+// Exporter configures the flow exporter
+type Exporter struct {
+	Type              string        `yaml:"type"`
+	Collector         string        `yaml:"collector"`
+	ObservationDomain uint32        `yaml:"observation_domain"`
+	TemplateRefresh   time.Duration `yaml:"template_refresh"`
+}
+
+// Queue configures the disk buffer of the event sinks
+type Queue struct {
+	Dir     string        `yaml:"dir"`
+	MaxSize int64         `yaml:"max_size"`
+	MaxAge  time.Duration `yaml:"max_age"`
+}
+
+// flags registers the queue flags
+func (q *Queue) flags(fs *flag.FlagSet) {
+	fs.StringVar(&q.Dir, "queue-dir", q.Dir, "Buffer undelivered event batches in this directory (disabled if empty)")
+	fs.Int64Var(&q.MaxSize, "queue-max-size", q.MaxSize, "Maximum size of the buffer of every sink in bytes")
+	fs.DurationVar(&q.MaxAge, "queue-max-age", q.MaxAge, "Drop buffered event batches older than this")
+}
+
+// validate checks the queue configuration
+func (q *Queue) validate() error {
+	if q.MaxSize < 0 || q.MaxAge < 0 {
+		return errors.New("queue limits must not be negative")
+	}
+	return nil
+}
+
+// Buffer returns the disk buffer configuration of the sinks, nil if disabled
+func (q *Queue) Buffer() *sink.BufferConfig {
+	if q.Dir == "" {
+		return nil
+	}
+	return &sink.BufferConfig{
+		Dir: q.Dir,
+		Options: queue.Options{
+			MaxSize: q.MaxSize,
+			MaxAge:  q.MaxAge,
+		},
+	}
+}
+
+// defaultQueue returns the default queue limits
+func defaultQueue() Queue {
+	return Queue{
+		MaxSize: 256 << 20,
+		MaxAge:  24 * time.Hour,
+	}
+}
+
+// Kubernetes configures the enrichment of events with kubernetes metadata
+type Kubernetes struct {
+	Enrich     bool          `yaml:"enrich"`
+	Kubeconfig string        `yaml:"kubeconfig"`
+	Resync     time.Duration `yaml:"resync"`
+}
+
+// flags registers the kubernetes flags
+func (k *Kubernetes) flags(fs *flag.FlagSet) {
+	fs.BoolVar(&k.Enrich, "kubernetes-enrich", k.Enrich, "Annotate events with the pods, services and nodes of their addresses")
+	fs.StringVar(&k.Kubeconfig, "kubeconfig", k.Kubeconfig, "Kubeconfig of the cluster to enrich events with (default in-cluster configuration)")
+	fs.DurationVar(&k.Resync, "kubernetes-resync", k.Resync, "Resync the kubernetes metadata cache in this interval (disabled if 0)")
+}
+
+// validate checks the kubernetes configuration
+func (k *Kubernetes) validate() error {
+	if k.Resync < 0 {
+		return errors.New("kubernetes resync interval must not be negative")
+	}
+	return nil
+}
+
+// defaultKubernetes returns the default kubernetes configuration
+func defaultKubernetes() Kubernetes {
+	return Kubernetes{
+		Resync: 10 * time.Minute,
+	}
+}
+
+// Attribution configures the attribution of local endpoints to the containers and processes
+// owning their sockets
+type Attribution struct {
+	Sockets   bool          `yaml:"sockets"`
+	Processes bool          `yaml:"processes"`
+	ProcRoot  string        `yaml:"proc_root"`
+	Refresh   time.Duration `yaml:"refresh"`
+}
+
+// Enabled checks if sockets are attributed at all
+func (a *Attribution) Enabled() bool {
+	return a.Sockets || a.Processes
+}
+
+// flags registers the attribution flags
+func (a *Attribution) flags(fs *flag.FlagSet) {
+	fs.BoolVar(&a.Sockets, "attribute-sockets", a.Sockets, "Attribute local endpoints to the containers owning their sockets (requires the host PID namespace)")
+	fs.BoolVar(&a.Processes, "attribute-processes", a.Processes, "Tag flows with the pid, command and cgroup of the local process owning their socket (requires the host PID namespace)")
+	fs.StringVar(&a.ProcRoot, "proc-root", a.ProcRoot, "Mount point of the proc filesystem of the node")
+	fs.DurationVar(&a.Refresh, "socket-refresh", a.Refresh, "Reread the socket tables on unknown local sockets at most in this interval")
+}
+
+// validate checks the attribution configuration
+func (a *Attribution) validate() error {
+	if a.Enabled() && a.Refresh <= 0 {
+		return errors.New("socket refresh interval must be positive")
+	}
+	return nil
+}
+
+// DNS configures the decoding of DNS messages
+type DNS struct {
+	Enabled    bool          `yaml:"enabled"`
+	Timeout    time.Duration `yaml:"timeout"`
+	CacheGrace time.Duration `yaml:"cache_grace"`
+	CacheSize  int           `yaml:"cache_size"`
+	MaxPending int           `yaml:"max_pending"`
+}
+
+// flags registers the DNS flags
+func (d *DNS) flags(fs *flag.FlagSet) {
+	fs.BoolVar(&d.Enabled, "dns", d.Enabled, "Write DNS queries and answers as events and annotate flows with the resolved domain (requires a snaplen covering DNS messages with afpacket)")
+	fs.DurationVar(&d.Timeout, "dns-timeout", d.Timeout, "Write DNS queries without an answer after this long")
+	fs.DurationVar(&d.CacheGrace, "dns-cache-grace", d.CacheGrace, "Keep resolved domains this long after their TTL expired")
+	fs.IntVar(&d.CacheSize, "dns-cache-size", d.CacheSize, "Maximum number of resolved domains cached (unbounded if 0)")
+	fs.IntVar(&d.MaxPending, "dns-max-pending", d.MaxPending, "Maximum number of DNS queries waiting for an answer (unbounded if 0)")
+}
+
+// validate checks the DNS configuration
+func (d *DNS) validate() error {
+	if d.Timeout <= 0 || d.CacheGrace < 0 || d.CacheSize < 0 || d.MaxPending < 0 {
+		return errors.New("DNS timeout must be positive, the cache limits must not be negative")
+	}
+	return nil
+}
+
+// Options returns the DNS tracker options
+func (d *DNS) Options() dns.Options {
+	return dns.Options{
+		Timeout:    d.Timeout,
+		Grace:      d.CacheGrace,
+		MaxEntries: d.CacheSize,
+		MaxPending: d.MaxPending,
+	}
+}
+
+// HTTP configures the decoding of plaintext HTTP/1.x requests
+type HTTP struct {
+	Enabled        bool          `yaml:"enabled"`
+	Timeout        time.Duration `yaml:"timeout"`
+	MaxConnections int           `yaml:"max_connections"`
+}
+
+// flags registers the HTTP flags
+func (h *HTTP) flags(fs *flag.FlagSet) {
+	fs.BoolVar(&h.Enabled, "http", h.Enabled, "Write plaintext HTTP/1.x requests with their responses as events (requires a snaplen covering the message headers with afpacket)")
+	fs.DurationVar(&h.Timeout, "http-timeout", h.Timeout, "Write HTTP requests without a response and forget idle connections after this long")
+	fs.IntVar(&h.MaxConnections, "http-max-connections", h.MaxConnections, "Maximum number of HTTP connections tracked (unbounded if 0)")
+}
+
+// validate checks the HTTP configuration
+func (h *HTTP) validate() error {
+	if h.Timeout <= 0 || h.MaxConnections < 0 {
+		return errors.New("HTTP timeout must be positive, the connection limit must not be negative")
+	}
+	return nil
+}
+
+// Options returns the HTTP tracker options
+func (h *HTTP) Options() httpmeta.Options {
+	return httpmeta.Options{
+		Timeout:        h.Timeout,
+		MaxConnections: h.MaxConnections,
+	}
+}
+
+// Offline configures the processing of capture files
+type Offline struct {
+	ReadFile  string `yaml:"read_file"`
+	WriteFile string `yaml:"write_file"`
+	Replay    bool   `yaml:"replay"`
+}
+
+// Insight is the configuration of the network probe
+type Insight struct {
+	Common      `yaml:",inline"`
+	Source      string      `yaml:"source"`
+	Interfaces  []string    `yaml:"interfaces"`
+	Filter      string      `yaml:"filter"` // Reloadable
+	FlowTable   FlowTable   `yaml:"flow_table"`
+	AFPacket    AFPacket    `yaml:"afpacket"`
+	Exporter    Exporter    `yaml:"exporter"`
+	Resolver    string      `yaml:"resolver"`
+	Sinks       []string    `yaml:"sinks"` // Reloadable
+	Queue       Queue       `yaml:"queue"`
+	Kubernetes  Kubernetes  `yaml:"kubernetes"`
+	Attribution Attribution `yaml:"attribution"`
+	DNS         DNS         `yaml:"dns"`
+	HTTP        HTTP        `yaml:"http"`
+	Offline     Offline     `yaml:"offline"`
+}
+
+// DefaultInsight returns the default configuration of the network probe
+func DefaultInsight() *Insight {
+	return &Insight{
+		Common: Common{
+			MetricsAddr: metrics.DefaultAddr,
+			LogLevel:    "info",
+		},
+		Source:     "pcap",
+		Interfaces: []string{"eth0"},
+		FlowTable: FlowTable{
+			IdleTimeout:   15 * time.Second,
+			ActiveTimeout: time.Minute,
+			Linger:        container.DefaultLinger,
+			MaxFlows:      1 << 20,
+			Classify:      true,
+			TCPMetrics:    true,
+		},
+		AFPacket: AFPacket{
+			BlockSize: capture.DefaultBlockSize,
+			NumBlocks: capture.DefaultNumBlocks,
+			SnapLen:   capture.DefaultSnapLen,
+			Workers:   runtime.NumCPU(),
+		},
+		Exporter: Exporter{
+			Type:            "events",
+			TemplateRefresh: time.Minute,
+		},
+		Queue:      defaultQueue(),
+		Kubernetes: defaultKubernetes(),
+		Attribution: Attribution{
+			ProcRoot: procfs.DefaultRoot,
+			Refresh:  time.Second,
+		},
+		DNS: DNS{
+			Timeout:    5 * time.Second,
+			CacheGrace: 2 * time.Minute,
+			CacheSize:  100000,
+			MaxPending: 100000,
+		},
+		HTTP: HTTP{
+			Timeout:        30 * time.Second,
+			MaxConnections: 100000,
+		},
+		Offline: Offline{
+			WriteFile: "-",
+		},
+	}
+}
+
+// Flags registers the command line flags
+func (c *Insight) Flags(fs *flag.FlagSet) {
+	c.Common.flags(fs)
+	fs.StringVar(&c.Offline.ReadFile, "r", c.Offline.ReadFile, "Read packets from a pcap/pcapng file instead of capturing live")
+	fs.StringVar(&c.Offline.WriteFile, "w", c.Offline.WriteFile, "Write events of a capture file to this file as newline-delimited JSON (- for stdout)")
+	fs.BoolVar(&c.Offline.Replay, "replay", c.Offline.Replay, "Replay the capture file at original packet timing")
+	fs.StringVar(&c.Filter, "f", c.Filter, "BPF filter expression")
+	fs.StringVar(&c.Source, "source", c.Source, "Flow source (pcap, afpacket, conntrack); conntrack builds flows from destroyed conntrack entries of the node")
+	ListVar(fs, &c.Interfaces, ",", "i", "Capture on these network interfaces, comma separated shell globs (e.g. eth0,cali*); interfaces appearing at runtime are picked up")
+	fs.IntVar(&c.AFPacket.Workers, "workers", c.AFPacket.Workers, "Number of AF_PACKET fanout workers, each owning a share of the flows")
+	fs.IntVar(&c.AFPacket.BlockSize, "block-size", c.AFPacket.BlockSize, "Size of an AF_PACKET ring buffer block in bytes (multiple of the page size)")
+	fs.IntVar(&c.AFPacket.NumBlocks, "num-blocks", c.AFPacket.NumBlocks, "Number of AF_PACKET ring buffer blocks per worker")
+	fs.IntVar(&c.AFPacket.SnapLen, "snaplen", c.AFPacket.SnapLen, "Capture at most this many bytes per packet with AF_PACKET (headers only by default)")
+	fs.Var(uint16Value{&c.AFPacket.FanoutID}, "fanout-id", "AF_PACKET fanout group ID, unique per network namespace (default the process ID)")
+	fs.DurationVar(&c.FlowTable.IdleTimeout, "idle-timeout", c.FlowTable.IdleTimeout, "Export flows after they have been idle for this long")
+	fs.DurationVar(&c.FlowTable.ActiveTimeout, "active-timeout", c.FlowTable.ActiveTimeout, "Export long-lived flows after they have been active for this long")
+	fs.DurationVar(&c.FlowTable.Linger, "linger", c.FlowTable.Linger, "Keep terminated TCP flows this long after their last packet to account trailing segments")
+	fs.IntVar(&c.FlowTable.MaxFlows, "max-flows", c.FlowTable.MaxFlows, "Evict the least recently seen flows when the flow table grows beyond this size (unbounded if 0)")
+	fs.BoolVar(&c.FlowTable.InspectTLS, "tls", c.FlowTable.InspectTLS, "Annotate TCP flows with the server name, version, cipher and JA3/JA4 fingerprints of their TLS handshake (requires a snaplen covering the hello messages with afpacket)")
+	fs.BoolVar(&c.FlowTable.Classify, "classify", c.FlowTable.Classify, "Identify the application protocol of flows by their first payload bytes, falling back to well-known ports")
+	fs.BoolVar(&c.FlowTable.TCPMetrics, "tcp-metrics", c.FlowTable.TCPMetrics, "Measure the handshake RTT and count retransmissions, out-of-order segments, zero windows and duplicate ACKs of TCP flows")
+	fs.StringVar(&c.Resolver, "resolver", c.Resolver, "Rewrite flows addressed to kubernetes services to their backends using the resolver API at this URL (e.g. http://10.0.0.1:9478)")
+	fs.StringVar(&c.Exporter.Type, "exporter", c.Exporter.Type, "Flow exporter (events, ipfix, netflow9; logstash is an alias of events)")
+	fs.StringVar(&c.Exporter.Collector, "collector", c.Exporter.Collector, "IPFIX/NetFlow v9 collector address (host:port)")
+	fs.Var(uint32Value{&c.Exporter.ObservationDomain}, "observation-domain", "IPFIX observation domain / NetFlow v9 source ID")
+	fs.DurationVar(&c.Exporter.TemplateRefresh, "template-refresh", c.Exporter.TemplateRefresh, "Resend IPFIX/NetFlow v9 templates in this interval")
+	ListVar(fs, &c.Sinks, "", "sink", "Event sink (http://, elasticsearch://, file://, stdout, syslog://, kafka://), repeat to fan out")
+	c.Queue.flags(fs)
+	c.Kubernetes.flags(fs)
+	c.Attribution.flags(fs)
+	c.DNS.flags(fs)
+	c.HTTP.flags(fs)
+}
+
+// Env lists the environment variables
+func (c *Insight) Env() []EnvVar {
+	return append(c.Common.env(),
+		EnvVar{"COLLECTOR", "collector"},
+		EnvVar{"RESOLVER", "resolver"},
+		EnvVar{"KUBECONFIG", "kubeconfig"},
+		EnvVar{"LOGSTASH", "sink"},
+		EnvVar{"SINKS", "sink"},
+	)
+}
+
+// Validate checks the configuration
+func (c *Insight) Validate() error {
+	if err := c.Common.validate(); err != nil {
+		return err
+	}
+
+	switch c.Source {
+	case "pcap", "afpacket", "conntrack":
+	default:
+		return fmt.Errorf("unknown source %s", c.Source)
+	}
+	if len(c.Interfaces) == 0 && c.Offline.ReadFile == "" && c.Source != "conntrack" {
+		return errors.New("no interface to capture on")
+	}
+	if c.AFPacket.BlockSize <= 0 || c.AFPacket.BlockSize%os.Getpagesize() != 0 {
+		return fmt.Errorf("AF_PACKET block size must be a multiple of the page size %d", os.Getpagesize())
+	}
+	if c.AFPacket.NumBlocks <= 0 || c.AFPacket.Workers <= 0 || c.AFPacket.SnapLen <= 0 {
+		return errors.New("AF_PACKET blocks, workers and snaplen must be positive")
+	}
+
+	if c.FlowTable.IdleTimeout <= 0 || c.FlowTable.ActiveTimeout <= 0 {
+		return errors.New("flow timeouts must be positive")
+	}
+	if c.FlowTable.MaxFlows < 0 {
+		return errors.New("max flows must not be negative")
+	}
+	if c.FlowTable.Linger < 0 {
+		return errors.New("linger time must not be negative")
+	}
+
+	switch c.Exporter.Type {
+	case "logstash":
+		// Former name of the events exporter, the events are written to the configured sinks
+		c.Exporter.Type = "events"
+	case "events":
+	case "ipfix", "netflow9":
+		if c.Exporter.Collector == "" {
+			return fmt.Errorf("exporter %s requires a collector address", c.Exporter.Type)
+		}
+		if c.Exporter.TemplateRefresh <= 0 {
+			return errors.New("template refresh interval must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown exporter %s", c.Exporter.Type)
+	}
+
+	if err := c.Kubernetes.validate(); err != nil {
+		return err
+	}
+	if err := c.Attribution.validate(); err != nil {
+		return err
+	}
+	if err := c.DNS.validate(); err != nil {
+		return err
+	}
+	if c.DNS.Enabled && c.Exporter.Type != "events" {
+		return errors.New("DNS events require the events exporter")
+	}
+	if err := c.HTTP.validate(); err != nil {
+		return err
+	}
+	if c.HTTP.Enabled && c.Exporter.Type != "events" {
+		return errors.New("HTTP events require the events exporter")
+	}
+	return c.Queue.validate()
+}
+
+// uint16Value binds an uint16 to a flag
+type uint16Value struct {
+	p *uint16
+}
+
+func (v uint16Value) String() string {
+	if v.p == nil {
+		return "0"
+	}
+	return fmt.Sprint(*v.p)
+}
+
+func (v uint16Value) Set(s string) error {
+	n, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return err
+	}
+	*v.p = uint16(n)
+	return nil
+}
+
+// uint32Value binds an uint32 to a flag
+type uint32Value struct {
+	p *uint32
+}
+
+func (v uint32Value) String() string {
+	if v.p == nil {
+		return "0"
+	}
+	return fmt.Sprint(*v.p)
+}
+
+func (v uint32Value) Set(s string) error {
+	n, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return err
+	}
+	*v.p = uint32(n)
+	return nil
+}
@@ -0,0 +1,73 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package config
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/xvzf/insight/pkg/metrics"
+)
+
+// KubeAgent is the configuration of the kubernetes state agent
+type KubeAgent struct {
+	Common     `yaml:",inline"`
+	ConnString string `yaml:"conn_string"`
+}
+
+// DefaultKubeAgent returns the default configuration of the kubernetes state agent
+func DefaultKubeAgent() *KubeAgent {
+	return &KubeAgent{
+		Common: Common{
+			MetricsAddr: metrics.DefaultAddr,
+			LogLevel:    "info",
+		},
+	}
+}
+
+// Flags registers the command line flags
+func (c *KubeAgent) Flags(fs *flag.FlagSet) {
+	c.Common.flags(fs)
+	fs.StringVar(&c.ConnString, "conn-string", c.ConnString, "PostgreSQL connection string of the kubernetes state store")
+}
+
+// Env lists the environment variables
+func (c *KubeAgent) Env() []EnvVar {
+	return append(c.Common.env(),
+		EnvVar{"CONN_STRING", "conn-string"},
+	)
+}
+
+// Validate checks the configuration
+func (c *KubeAgent) Validate() error {
+	if err := c.Common.validate(); err != nil {
+		return err
+	}
+	if c.ConnString == "" {
+		return errors.New("connection string required")
+	}
+	return nil
+}
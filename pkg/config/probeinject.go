@@ -0,0 +1,106 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package config
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/xvzf/insight/pkg/metrics"
+)
+
+// Probe configures the injected network probe
+type Probe struct {
+	Image       string   `yaml:"image"`
+	Sinks       []string `yaml:"sinks"`
+	MetricsAddr string   `yaml:"metrics_addr"`
+	Resolver    string   `yaml:"resolver"` // May refer to $(HOST_IP), the node the pod is running on
+}
+
+// ProbeInject is the configuration of the probe injection webhook
+type ProbeInject struct {
+	Common      `yaml:",inline"`
+	Listen      string `yaml:"listen"`
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+	Annotation  string `yaml:"annotation"`
+	Probe       Probe  `yaml:"probe"`
+}
+
+// DefaultProbeInject returns the default configuration of the probe injection webhook
+func DefaultProbeInject() *ProbeInject {
+	return &ProbeInject{
+		Common: Common{
+			MetricsAddr: metrics.DefaultAddr,
+			LogLevel:    "info",
+		},
+		Listen:     ":8443",
+		Annotation: "insight",
+	}
+}
+
+// Flags registers the command line flags
+func (c *ProbeInject) Flags(fs *flag.FlagSet) {
+	c.Common.flags(fs)
+	fs.StringVar(&c.Listen, "listen", c.Listen, "HTTPS address the webhook listens on")
+	fs.StringVar(&c.TLSCertFile, "tls-cert-file", c.TLSCertFile, "TLS certificate of the webhook")
+	fs.StringVar(&c.TLSKeyFile, "tls-key-file", c.TLSKeyFile, "TLS key of the webhook")
+	fs.StringVar(&c.Annotation, "annotation", c.Annotation, "Inject the probe into pods with this annotation")
+	fs.StringVar(&c.Probe.Image, "probe-image", c.Probe.Image, "Image of the injected network probe")
+	ListVar(fs, &c.Probe.Sinks, "", "probe-sink", "Event sink of the injected network probe, repeat to fan out")
+	fs.StringVar(&c.Probe.Resolver, "probe-resolver", c.Probe.Resolver, "Resolver API of the injected network probe, $(HOST_IP) is replaced by the node address (e.g. http://$(HOST_IP):9478)")
+	fs.StringVar(&c.Probe.MetricsAddr, "probe-metrics-addr", c.Probe.MetricsAddr, "Metrics address of the injected network probe (disabled if empty, the port is shared with the pod)")
+}
+
+// Env lists the environment variables
+func (c *ProbeInject) Env() []EnvVar {
+	return append(c.Common.env(),
+		EnvVar{"TLS_CERT_FILE", "tls-cert-file"},
+		EnvVar{"TLS_KEY_FILE", "tls-key-file"},
+		EnvVar{"PROBE_IMAGE", "probe-image"},
+		EnvVar{"LOGSTASH", "probe-sink"},
+		EnvVar{"SINKS", "probe-sink"},
+		EnvVar{"PROBE_METRICS_ADDR", "probe-metrics-addr"},
+		EnvVar{"PROBE_RESOLVER", "probe-resolver"},
+	)
+}
+
+// Validate checks the configuration
+func (c *ProbeInject) Validate() error {
+	if err := c.Common.validate(); err != nil {
+		return err
+	}
+	if c.TLSCertFile == "" || c.TLSKeyFile == "" {
+		return errors.New("TLS certificate and key required")
+	}
+	if c.Probe.Image == "" {
+		return errors.New("probe image required")
+	}
+	if c.Annotation == "" {
+		return errors.New("annotation required")
+	}
+	return nil
+}
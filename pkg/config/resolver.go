@@ -0,0 +1,103 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/xvzf/insight/pkg/metrics"
+)
+
+// Resolver is the configuration of the ClusterIP resolver
+type Resolver struct {
+	Common              `yaml:",inline"`
+	Listen              string        `yaml:"listen"`
+	AllowedClients      []string      `yaml:"allowed_clients"`
+	MappingTTL          time.Duration `yaml:"mapping_ttl"`
+	DestroyedMappingTTL time.Duration `yaml:"destroyed_mapping_ttl"`
+}
+
+// DefaultResolver returns the default configuration of the resolver
+func DefaultResolver() *Resolver {
+	return &Resolver{
+		Common: Common{
+			MetricsAddr: metrics.DefaultAddr,
+			LogLevel:    "info",
+		},
+		Listen:     ":9478",
+		MappingTTL: time.Hour,
+		// Give the event pipeline some time to process the last flows of a connection
+		DestroyedMappingTTL: 30 * time.Second,
+	}
+}
+
+// Flags registers the command line flags
+func (c *Resolver) Flags(fs *flag.FlagSet) {
+	c.Common.flags(fs)
+	fs.StringVar(&c.Listen, "listen", c.Listen, "Serve the ClusterIP translation API on this address")
+	ListVar(fs, &c.AllowedClients, ",", "allowed-clients", "Only answer translation requests from these networks (CIDR, repeatable or comma separated; all if empty)")
+	fs.DurationVar(&c.MappingTTL, "mapping-ttl", c.MappingTTL, "Expire ClusterIP mappings of active connections after this time")
+	fs.DurationVar(&c.DestroyedMappingTTL, "destroyed-mapping-ttl", c.DestroyedMappingTTL, "Expire ClusterIP mappings this long after the connection has been closed")
+}
+
+// Env lists the environment variables
+func (c *Resolver) Env() []EnvVar {
+	return append(c.Common.env(), EnvVar{"ALLOWED_CLIENTS", "allowed-clients"})
+}
+
+// Validate checks the configuration
+func (c *Resolver) Validate() error {
+	if err := c.Common.validate(); err != nil {
+		return err
+	}
+	if c.Listen == "" {
+		return errors.New("listen address required")
+	}
+	if c.MappingTTL <= 0 || c.DestroyedMappingTTL <= 0 {
+		return errors.New("mapping TTLs must be positive")
+	}
+	for _, cidr := range c.AllowedClients {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid allowed client network: %v", err)
+		}
+	}
+	return nil
+}
+
+// AllowedNets returns the parsed allowed client networks, the configuration has to be valid
+func (c *Resolver) AllowedNets() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range c.AllowedClients {
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
@@ -26,7 +26,10 @@
 package conntrack
 
 import (
+	"time"
+
 	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
 )
 
 // Event types for a Conntrack update
@@ -36,10 +39,21 @@ const (
 	EventDestroy
 )
 
-// Entry contains the two flow-pairs (TCP or UDP of a line in the conntrack output)
+// Entry contains the two flow-pairs (original and reply direction) of a conntrack entry
 type Entry struct {
-	FlowMeta0 flow.Meta
-	FlowMeta1 flow.Meta
+	FlowMeta0 flow.Meta // Original direction
+	FlowMeta1 flow.Meta // Reply direction
+
+	// Only available with the netlink event source
+	ID        uint32        // Conntrack ID, unique for the lifetime of the entry
+	Zone      uint16        // Conntrack zone
+	Mark      uint32        // Connection mark
+	Status    uint32        // Status bits (IPS_*)
+	TCPState  uint8         // State of a TCP connection (TCP_CONNTRACK_*), 0 if unknown
+	Counters0 flow.Counters // Original direction counters, requires nf_conntrack_acct
+	Counters1 flow.Counters // Reply direction counters, requires nf_conntrack_acct
+	Start     time.Time     // Creation time, requires nf_conntrack_timestamp
+	Stop      time.Time     // Destruction time, requires nf_conntrack_timestamp
 }
 
 // Event contains a conntrack event
@@ -47,3 +61,77 @@ type Event struct {
 	Type  uint8
 	Entry Entry
 }
+
+// TCP connection states of the conntrack state machine (TCP_CONNTRACK_*)
+const (
+	TCPNone uint8 = iota
+	TCPSynSent
+	TCPSynRecv
+	TCPEstablished
+	TCPFinWait
+	TCPCloseWait
+	TCPLastAck
+	TCPTimeWait
+	TCPClose
+	TCPSynSent2
+)
+
+// ConnectionState maps the conntrack TCP state to the connection state of a flow. Conntrack
+// ends in TIME_WAIT after FINs in both directions and in CLOSE after a RST.
+func (e *Entry) ConnectionState() flow.ConnectionState {
+	switch e.TCPState {
+	case TCPSynSent, TCPSynSent2:
+		return flow.StateAttempted
+	case TCPSynRecv, TCPEstablished, TCPFinWait, TCPCloseWait, TCPLastAck:
+		return flow.StateEstablished
+	case TCPTimeWait:
+		return flow.StateClosed
+	case TCPClose:
+		return flow.StateReset
+	default:
+		return flow.StateNone
+	}
+}
+
+// Translated returns the tuple of the original direction after NAT, derived from the reply tuple.
+// The second return value is false if the entry has not been translated.
+func (e *Entry) Translated() (flow.Meta, bool) {
+	m := e.FlowMeta1
+	m.Src, m.Dst = m.Dst, m.Src
+	m.SrcPort, m.DstPort = m.DstPort, m.SrcPort
+	if m.Transport == protos.ICMP4 || m.Transport == protos.ICMP6 {
+		// Reply tuples carry the reply type, NAT does not change type and code
+		m.IcmpType, m.IcmpCode = e.FlowMeta0.IcmpType, e.FlowMeta0.IcmpCode
+	}
+
+	o := e.FlowMeta0
+	translated := !m.Src.Equal(o.Src) || !m.Dst.Equal(o.Dst) || m.SrcPort != o.SrcPort || m.DstPort != o.DstPort
+	return m, translated
+}
+
+// Flow converts the entry to a flow record. The original direction is the client, its counters
+// are the incoming counters of the flow. Start and End are only set if conntrack timestamps
+// are enabled, otherwise the current time is used. The connection state of TCP flows is taken
+// from the conntrack state, TCP flags are not available.
+func (e *Entry) Flow() *flow.Flow {
+	f := &flow.Flow{
+		Meta:      e.FlowMeta0,
+		Incoming:  flow.Counters{Bytes: e.Counters0.Bytes, Packets: e.Counters0.Packets},
+		Outgoing:  flow.Counters{Bytes: e.Counters1.Bytes, Packets: e.Counters1.Packets},
+		Start:     e.Start,
+		End:       e.Stop,
+		EndReason: flow.EndOfFlow,
+		ConnState: e.ConnectionState(),
+	}
+
+	if f.End.IsZero() {
+		f.End = time.Now()
+	}
+	if f.Start.IsZero() {
+		f.Start = f.End
+	}
+	if m, ok := e.Translated(); ok {
+		f.Translated = &m
+	}
+	return f
+}
@@ -0,0 +1,103 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package conntrack
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+func TestEntryFlow(t *testing.T) {
+	start := time.Unix(1577880000, 0)
+
+	// Pod -> ClusterIP, DNAT to another pod
+	e := &Entry{
+		FlowMeta0: flow.Meta{Transport: protos.TCP, Src: net.ParseIP("10.42.2.28"), Dst: net.ParseIP("10.43.183.150"), SrcPort: 53296, DstPort: 80},
+		FlowMeta1: flow.Meta{Transport: protos.TCP, Src: net.ParseIP("10.42.1.16"), Dst: net.ParseIP("10.42.2.28"), SrcPort: 8080, DstPort: 53296},
+		Counters0: flow.Counters{Bytes: 500, Packets: 6},
+		Counters1: flow.Counters{Bytes: 12000, Packets: 10},
+		Start:     start,
+		Stop:      start.Add(2 * time.Second),
+		TCPState:  TCPTimeWait,
+	}
+
+	expected := &flow.Flow{
+		Meta:      e.FlowMeta0,
+		Incoming:  e.Counters0,
+		Outgoing:  e.Counters1,
+		Start:     start,
+		End:       start.Add(2 * time.Second),
+		EndReason: flow.EndOfFlow,
+		ConnState: flow.StateClosed,
+		Translated: &flow.Meta{
+			Transport: protos.TCP, Src: net.ParseIP("10.42.2.28"), Dst: net.ParseIP("10.42.1.16"), SrcPort: 53296, DstPort: 8080,
+		},
+	}
+	if f := e.Flow(); !cmp.Equal(f, expected) {
+		t.Error(cmp.Diff(expected, f))
+	}
+}
+
+func TestEntryFlowWithoutNAT(t *testing.T) {
+	// ICMP echo without timestamps
+	e := &Entry{
+		FlowMeta0: flow.Meta{Transport: protos.ICMP4, Src: net.ParseIP("10.42.2.28"), Dst: net.ParseIP("10.42.1.16"), IcmpType: 8},
+		FlowMeta1: flow.Meta{Transport: protos.ICMP4, Src: net.ParseIP("10.42.1.16"), Dst: net.ParseIP("10.42.2.28")},
+	}
+
+	f := e.Flow()
+	if f.Translated != nil {
+		t.Errorf("unexpected translation %v", f.Translated)
+	}
+	if f.End.IsZero() || !f.Start.Equal(f.End) {
+		t.Errorf("unexpected timestamps %s - %s", f.Start, f.End)
+	}
+}
+
+func TestEntryConnectionState(t *testing.T) {
+	for state, expected := range map[uint8]flow.ConnectionState{
+		TCPNone:        flow.StateNone,
+		TCPSynSent:     flow.StateAttempted,
+		TCPSynRecv:     flow.StateEstablished,
+		TCPEstablished: flow.StateEstablished,
+		TCPCloseWait:   flow.StateEstablished,
+		TCPTimeWait:    flow.StateClosed,
+		TCPClose:       flow.StateReset,
+	} {
+		e := &Entry{
+			FlowMeta0: flow.Meta{Transport: protos.TCP, Src: net.ParseIP("10.42.2.28"), Dst: net.ParseIP("10.42.1.16"), SrcPort: 53296, DstPort: 80},
+			TCPState:  state,
+		}
+		if s := e.Flow().State(); s != expected {
+			t.Errorf("conntrack state %d: expected %q, got %q", state, expected, s)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package netlink
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xvzf/insight/pkg/conntrack"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/nl"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "conntrack",
+		"int":     "netlink",
+	})
+}
+
+// Logger
+var log *logrus.Entry
+
+// ErrLostEvents is returned when conntrack events were dropped by the kernel, flows may be
+// missing their update or destroy event
+var ErrLostEvents = nl.ErrLostEvents
+
+// Group is a conntrack multicast group
+type Group uint32
+
+// Conntrack multicast groups (NF_NETLINK_CONNTRACK_*)
+const (
+	GroupNew     Group = 1
+	GroupUpdate  Group = 2
+	GroupDestroy Group = 4
+	GroupAll           = GroupNew | GroupUpdate | GroupDestroy
+)
+
+// Listener decodes conntrack events received on a netlink socket
+type Listener interface {
+	Receive() ([]*conntrack.Event, error)
+	Close() error
+}
+
+// Netlink message flags (linux/netlink.h)
+const (
+	nlmFCreate = 0x400
+	nlmFExcl   = 0x200
+)
+
+// Netfilter netlink (linux/netfilter/nfnetlink.h, nfnetlink_conntrack.h)
+const (
+	nfgenmsgLength       = 4
+	nfnlSubsysCTNetlink  = 1
+	ipctnlMsgCtNew       = 0
+	ipctnlMsgCtDelete    = 2
+	nlaFNested           = 0x8000
+	nlaFNetByteOrder     = 0x4000
+	nlaTypeMask          = ^uint16(nlaFNested | nlaFNetByteOrder)
+	nlaHeaderLength      = 4
+	ctaTupleOrig         = 1
+	ctaTupleReply        = 2
+	ctaStatus            = 3
+	ctaProtoInfo         = 4
+	ctaMark              = 8
+	ctaCountersOrig      = 9
+	ctaCountersReply     = 10
+	ctaID                = 12
+	ctaZone              = 18
+	ctaTimestamp         = 20
+	ctaTupleIP           = 1
+	ctaTupleProto        = 2
+	ctaTupleZone         = 3
+	ctaIPv4Src           = 1
+	ctaIPv4Dst           = 2
+	ctaIPv6Src           = 3
+	ctaIPv6Dst           = 4
+	ctaProtoNum          = 1
+	ctaProtoSrcPort      = 2
+	ctaProtoDstPort      = 3
+	ctaProtoICMPType     = 5
+	ctaProtoICMPCode     = 6
+	ctaProtoICMPv6Type   = 8
+	ctaProtoICMPv6Code   = 9
+	ctaCountersPackets   = 1
+	ctaCountersBytes     = 2
+	ctaCounters32Packets = 3
+	ctaCounters32Bytes   = 4
+	ctaProtoInfoTCP      = 1
+	ctaProtoInfoTCPState = 1
+	ctaTimestampStart    = 1
+	ctaTimestampStop     = 2
+)
+
+type listener struct {
+	sock nl.Socket
+}
+
+// NewListener returns a listener for the conntrack events received on a netfilter socket,
+// counting the received events and overruns
+func NewListener(sock nl.Socket) Listener {
+	return &listener{sock: sock}
+}
+
+// Receive blocks until the next datagram has been received or the listener is closed and
+// returns the contained events. ErrLostEvents signals an overrun, the listener stays usable.
+func (l *listener) Receive() ([]*conntrack.Event, error) {
+	var events []*conntrack.Event
+	b, err := l.sock.Receive()
+	if err == nil {
+		events, err = Decode(b)
+	}
+
+	if err == ErrLostEvents {
+		eventsLost.Inc()
+	}
+	for _, e := range events {
+		eventsReceived.WithLabelValues(eventTypeLabels[e.Type]).Inc()
+	}
+	return events, err
+}
+
+func (l *listener) Close() error {
+	return l.sock.Close()
+}
+
+// Decode decodes all conntrack events contained in a netlink datagram. Messages which are not
+// conntrack events are skipped.
+func Decode(b []byte) ([]*conntrack.Event, error) {
+	var events []*conntrack.Event
+
+	msgs, err := nl.Messages(b)
+	for _, m := range msgs {
+		if m.Type>>8 != nfnlSubsysCTNetlink {
+			continue
+		}
+
+		var eType uint8
+		switch m.Type & 0xff {
+		case ipctnlMsgCtNew:
+			eType = conntrack.EventUpdate
+			if m.Flags&(nlmFCreate|nlmFExcl) != 0 {
+				eType = conntrack.EventNew
+			}
+		case ipctnlMsgCtDelete:
+			eType = conntrack.EventDestroy
+		default:
+			continue
+		}
+
+		if len(m.Payload) < nfgenmsgLength {
+			return events, errors.New("conntrack message too short")
+		}
+		e := &conntrack.Event{Type: eType}
+		if err := decodeEntry(m.Payload[nfgenmsgLength:], &e.Entry); err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+
+	return events, err
+}
+
+// attribute is a netlink attribute
+type attribute struct {
+	typ   uint16
+	value []byte
+}
+
+// attributes splits b into netlink attributes
+func attributes(b []byte) ([]attribute, error) {
+	var attrs []attribute
+	for len(b) >= nlaHeaderLength {
+		length := int(nl.NativeEndian.Uint16(b[0:]))
+		if length < nlaHeaderLength || length > len(b) {
+			return nil, fmt.Errorf("invalid netlink attribute length %d", length)
+		}
+		attrs = append(attrs, attribute{
+			typ:   nl.NativeEndian.Uint16(b[2:]) & nlaTypeMask,
+			value: b[nlaHeaderLength:length],
+		})
+		if nl.Align(length) >= len(b) {
+			break
+		}
+		b = b[nl.Align(length):]
+	}
+	return attrs, nil
+}
+
+// be returns the big endian integer contained in an attribute; conntrack attributes are
+// encoded in network byte order
+func (a attribute) be() (uint64, error) {
+	switch len(a.value) {
+	case 1:
+		return uint64(a.value[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(a.value)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(a.value)), nil
+	case 8:
+		return binary.BigEndian.Uint64(a.value), nil
+	default:
+		return 0, fmt.Errorf("invalid integer attribute %d with length %d", a.typ, len(a.value))
+	}
+}
+
+func decodeEntry(b []byte, entry *conntrack.Entry) error {
+	attrs, err := attributes(b)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range attrs {
+		var err error
+		switch a.typ {
+		case ctaTupleOrig:
+			err = decodeTuple(a.value, &entry.FlowMeta0, &entry.Zone)
+		case ctaTupleReply:
+			err = decodeTuple(a.value, &entry.FlowMeta1, &entry.Zone)
+		case ctaCountersOrig:
+			err = decodeCounters(a.value, &entry.Counters0.Packets, &entry.Counters0.Bytes)
+		case ctaCountersReply:
+			err = decodeCounters(a.value, &entry.Counters1.Packets, &entry.Counters1.Bytes)
+		case ctaTimestamp:
+			err = decodeTimestamp(a.value, entry)
+		case ctaProtoInfo:
+			err = decodeProtoInfo(a.value, entry)
+		case ctaStatus, ctaMark, ctaID, ctaZone:
+			var v uint64
+			if v, err = a.be(); err != nil {
+				break
+			}
+			switch a.typ {
+			case ctaStatus:
+				entry.Status = uint32(v)
+			case ctaMark:
+				entry.Mark = uint32(v)
+			case ctaID:
+				entry.ID = uint32(v)
+			case ctaZone:
+				entry.Zone = uint16(v)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if entry.FlowMeta0.Src == nil || entry.FlowMeta1.Src == nil {
+		return errors.New("conntrack message without tuples")
+	}
+	return nil
+}
+
+// decodeTuple decodes the original or reply tuple
+func decodeTuple(b []byte, m *flow.Meta, zone *uint16) error {
+	attrs, err := attributes(b)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range attrs {
+		switch a.typ {
+		case ctaTupleIP:
+			ips, err := attributes(a.value)
+			if err != nil {
+				return err
+			}
+			for _, ip := range ips {
+				switch ip.typ {
+				case ctaIPv4Src, ctaIPv6Src:
+					m.Src = net.IP(append([]byte(nil), ip.value...))
+				case ctaIPv4Dst, ctaIPv6Dst:
+					m.Dst = net.IP(append([]byte(nil), ip.value...))
+				}
+			}
+		case ctaTupleProto:
+			protoAttrs, err := attributes(a.value)
+			if err != nil {
+				return err
+			}
+			for _, p := range protoAttrs {
+				var field *uint16
+				switch p.typ {
+				case ctaProtoNum:
+					v, err := p.be()
+					if err != nil {
+						return err
+					}
+					m.Transport = protos.ProtocolType(v)
+					continue
+				case ctaProtoSrcPort:
+					field = &m.SrcPort
+				case ctaProtoDstPort:
+					field = &m.DstPort
+				case ctaProtoICMPType, ctaProtoICMPv6Type:
+					field = &m.IcmpType
+				case ctaProtoICMPCode, ctaProtoICMPv6Code:
+					field = &m.IcmpCode
+				default:
+					continue
+				}
+				v, err := p.be()
+				if err != nil {
+					return err
+				}
+				*field = uint16(v)
+			}
+		case ctaTupleZone:
+			// Directional zones take precedence over the entry zone
+			v, err := a.be()
+			if err != nil {
+				return err
+			}
+			*zone = uint16(v)
+		}
+	}
+
+	if len(m.Src) != len(m.Dst) || (len(m.Src) != net.IPv4len && len(m.Src) != net.IPv6len) {
+		return errors.New("conntrack tuple without valid addresses")
+	}
+	return nil
+}
+
+func decodeCounters(b []byte, packets, bytes *uint64) error {
+	attrs, err := attributes(b)
+	if err != nil {
+		return err
+	}
+	for _, a := range attrs {
+		// Padding (CTA_COUNTERS_PAD) is empty
+		var field *uint64
+		switch a.typ {
+		case ctaCountersPackets, ctaCounters32Packets:
+			field = packets
+		case ctaCountersBytes, ctaCounters32Bytes:
+			field = bytes
+		default:
+			continue
+		}
+		v, err := a.be()
+		if err != nil {
+			return err
+		}
+		*field = v
+	}
+	return nil
+}
+
+// decodeProtoInfo decodes the state of TCP connections, other protocols are ignored
+func decodeProtoInfo(b []byte, entry *conntrack.Entry) error {
+	attrs, err := attributes(b)
+	if err != nil {
+		return err
+	}
+	for _, a := range attrs {
+		if a.typ != ctaProtoInfoTCP {
+			continue
+		}
+		tcp, err := attributes(a.value)
+		if err != nil {
+			return err
+		}
+		for _, t := range tcp {
+			if t.typ == ctaProtoInfoTCPState && len(t.value) == 1 {
+				entry.TCPState = t.value[0]
+			}
+		}
+	}
+	return nil
+}
+
+func decodeTimestamp(b []byte, entry *conntrack.Entry) error {
+	attrs, err := attributes(b)
+	if err != nil {
+		return err
+	}
+	for _, a := range attrs {
+		// Padding (CTA_TIMESTAMP_PAD) is empty
+		var field *time.Time
+		switch a.typ {
+		case ctaTimestampStart:
+			field = &entry.Start
+		case ctaTimestampStop:
+			field = &entry.Stop
+		default:
+			continue
+		}
+		v, err := a.be()
+		if err != nil {
+			return err
+		}
+		*field = time.Unix(0, int64(v))
+	}
+	return nil
+}
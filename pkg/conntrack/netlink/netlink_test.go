@@ -0,0 +1,311 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package netlink
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/xvzf/insight/pkg/conntrack"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/nl"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+// fakeSocket returns prepared datagrams
+type fakeSocket struct {
+	datagrams [][]byte
+	errs      []error
+	closed    bool
+}
+
+func (f *fakeSocket) Receive() ([]byte, error) {
+	if len(f.datagrams) == 0 {
+		return nil, io.EOF
+	}
+	b, err := f.datagrams[0], f.errs[0]
+	f.datagrams, f.errs = f.datagrams[1:], f.errs[1:]
+	return b, err
+}
+
+func (f *fakeSocket) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (f *fakeSocket) add(b []byte, err error) {
+	f.datagrams = append(f.datagrams, b)
+	f.errs = append(f.errs, err)
+}
+
+// nested encodes a netlink attribute containing the given attributes
+func nested(typ uint16, attrs ...[]byte) []byte {
+	var value []byte
+	for _, a := range attrs {
+		value = append(value, a...)
+	}
+	return attr(typ|nlaFNested, value)
+}
+
+// attr encodes a netlink attribute
+func attr(typ uint16, value []byte) []byte {
+	b := make([]byte, nl.Align(nlaHeaderLength+len(value)))
+	nl.NativeEndian.PutUint16(b[0:], uint16(nlaHeaderLength+len(value)))
+	nl.NativeEndian.PutUint16(b[2:], typ)
+	copy(b[nlaHeaderLength:], value)
+	return b
+}
+
+func be16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func be64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func ip(s string) []byte {
+	if v4 := net.ParseIP(s).To4(); v4 != nil {
+		return v4
+	}
+	return net.ParseIP(s)
+}
+
+// tuple encodes a conntrack tuple with ports (TCP, UDP, SCTP)
+func tuple(typ uint16, src, dst string, proto protos.ProtocolType, sport, dport uint16) []byte {
+	srcType, dstType := uint16(ctaIPv4Src), uint16(ctaIPv4Dst)
+	if len(ip(src)) == net.IPv6len {
+		srcType, dstType = ctaIPv6Src, ctaIPv6Dst
+	}
+	return nested(typ,
+		nested(ctaTupleIP, attr(srcType, ip(src)), attr(dstType, ip(dst))),
+		nested(ctaTupleProto, attr(ctaProtoNum, []byte{byte(proto)}), attr(ctaProtoSrcPort, be16(sport)), attr(ctaProtoDstPort, be16(dport))),
+	)
+}
+
+// icmpTuple encodes an ICMP conntrack tuple
+func icmpTuple(typ uint16, src, dst string, icmpType, icmpCode uint8) []byte {
+	return nested(typ,
+		nested(ctaTupleIP, attr(ctaIPv4Src, ip(src)), attr(ctaIPv4Dst, ip(dst))),
+		nested(ctaTupleProto, attr(ctaProtoNum, []byte{byte(protos.ICMP4)}), attr(ctaProtoICMPType, []byte{icmpType}), attr(ctaProtoICMPCode, []byte{icmpCode}), attr(4, be16(1234))),
+	)
+}
+
+// message encodes a netlink message
+func message(msgType, flags uint16, attrs ...[]byte) []byte {
+	payload := []byte{syscallAFInet, 0, 0, 0}
+	for _, a := range attrs {
+		payload = append(payload, a...)
+	}
+	b := make([]byte, nl.HeaderLength+len(payload))
+	nl.NativeEndian.PutUint32(b[0:], uint32(len(b)))
+	nl.NativeEndian.PutUint16(b[4:], msgType)
+	nl.NativeEndian.PutUint16(b[6:], flags)
+	copy(b[nl.HeaderLength:], payload)
+	return b
+}
+
+const (
+	syscallAFInet = 2
+	ctNew         = nfnlSubsysCTNetlink<<8 | ipctnlMsgCtNew
+	ctDelete      = nfnlSubsysCTNetlink<<8 | ipctnlMsgCtDelete
+
+	// Empty padding emitted on architectures requiring 64 bit alignment
+	ctaCountersPad  = 5
+	ctaTimestampPad = 3
+)
+
+func TestListener(t *testing.T) {
+	start := time.Unix(1577880000, 0)
+	sock := &fakeSocket{}
+
+	// NEW TCP connection to a service, DNAT to a pod
+	sock.add(message(ctNew, nlmFCreate|nlmFExcl,
+		tuple(ctaTupleOrig, "10.42.2.28", "10.43.183.150", protos.TCP, 53296, 8080),
+		tuple(ctaTupleReply, "10.42.1.16", "10.42.2.28", protos.TCP, 8080, 53296),
+		attr(ctaStatus, be32(0x8)),
+		attr(ctaMark, be32(0x4000)),
+		attr(ctaZone, be16(3)),
+		attr(ctaID, be32(42)),
+		nested(ctaProtoInfo, nested(ctaProtoInfoTCP, attr(ctaProtoInfoTCPState, []byte{conntrack.TCPSynSent}), attr(2, []byte{7}))),
+	), nil)
+
+	// Two messages in one datagram: UPDATE of an SCTP association and DESTROY of an ICMP entry
+	// including counters and timestamps
+	sock.add(append(
+		message(ctNew, 0,
+			tuple(ctaTupleOrig, "fd00::1", "fd00::2", protos.SCTP, 40000, 38412),
+			tuple(ctaTupleReply, "fd00::2", "fd00::1", protos.SCTP, 38412, 40000),
+		),
+		message(ctDelete, 0,
+			icmpTuple(ctaTupleOrig, "10.42.2.28", "8.8.8.8", 8, 0),
+			icmpTuple(ctaTupleReply, "8.8.8.8", "10.42.2.28", 0, 0),
+			nested(ctaCountersOrig, attr(ctaCountersPackets, be64(3)), attr(ctaCountersBytes, be64(252)), attr(ctaCountersPad, nil)),
+			nested(ctaCountersReply, attr(ctaCounters32Packets, be32(2)), attr(ctaCounters32Bytes, be32(168))),
+			nested(ctaTimestamp, attr(ctaTimestampPad, nil), attr(ctaTimestampStart, be64(uint64(start.UnixNano()))), attr(ctaTimestampStop, be64(uint64(start.Add(3*time.Second).UnixNano())))),
+		)...,
+	), nil)
+
+	// Overrun
+	sock.add(nil, ErrLostEvents)
+
+	l := NewListener(sock)
+	newEvents := testutil.ToFloat64(eventsReceived.WithLabelValues("new"))
+	destroyEvents := testutil.ToFloat64(eventsReceived.WithLabelValues("destroy"))
+	overruns := testutil.ToFloat64(eventsLost)
+
+	events, err := l.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*conntrack.Event{{
+		Type: conntrack.EventNew,
+		Entry: conntrack.Entry{
+			FlowMeta0: flow.Meta{Transport: protos.TCP, Src: ip("10.42.2.28"), Dst: ip("10.43.183.150"), SrcPort: 53296, DstPort: 8080},
+			FlowMeta1: flow.Meta{Transport: protos.TCP, Src: ip("10.42.1.16"), Dst: ip("10.42.2.28"), SrcPort: 8080, DstPort: 53296},
+			ID:        42,
+			Zone:      3,
+			Mark:      0x4000,
+			Status:    0x8,
+			TCPState:  conntrack.TCPSynSent,
+		},
+	}}
+	if !cmp.Equal(events, expected) {
+		t.Error(cmp.Diff(expected, events))
+	}
+
+	events, err = l.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []*conntrack.Event{{
+		Type: conntrack.EventUpdate,
+		Entry: conntrack.Entry{
+			FlowMeta0: flow.Meta{Transport: protos.SCTP, Src: ip("fd00::1"), Dst: ip("fd00::2"), SrcPort: 40000, DstPort: 38412},
+			FlowMeta1: flow.Meta{Transport: protos.SCTP, Src: ip("fd00::2"), Dst: ip("fd00::1"), SrcPort: 38412, DstPort: 40000},
+		},
+	}, {
+		Type: conntrack.EventDestroy,
+		Entry: conntrack.Entry{
+			FlowMeta0: flow.Meta{Transport: protos.ICMP4, Src: ip("10.42.2.28"), Dst: ip("8.8.8.8"), IcmpType: 8},
+			FlowMeta1: flow.Meta{Transport: protos.ICMP4, Src: ip("8.8.8.8"), Dst: ip("10.42.2.28")},
+			Counters0: flow.Counters{Packets: 3, Bytes: 252},
+			Counters1: flow.Counters{Packets: 2, Bytes: 168},
+			Start:     start,
+			Stop:      start.Add(3 * time.Second),
+		},
+	}}
+	if !cmp.Equal(events, expected) {
+		t.Error(cmp.Diff(expected, events))
+	}
+
+	if _, err := l.Receive(); err != ErrLostEvents {
+		t.Errorf("expected ErrLostEvents, got %v", err)
+	}
+
+	for name, c := range map[string][2]float64{
+		"new":      {newEvents, testutil.ToFloat64(eventsReceived.WithLabelValues("new"))},
+		"destroy":  {destroyEvents, testutil.ToFloat64(eventsReceived.WithLabelValues("destroy"))},
+		"overruns": {overruns, testutil.ToFloat64(eventsLost)},
+	} {
+		if c[1]-c[0] != 1 {
+			t.Errorf("Expected one %s counted, got %v", name, c[1]-c[0])
+		}
+	}
+
+	l.Close()
+	if !sock.closed {
+		t.Error("socket not closed")
+	}
+}
+
+func TestDecodeSkipsOtherMessages(t *testing.T) {
+	// Netlink ACK followed by a message of another subsystem and DONE
+	ack := make([]byte, nl.HeaderLength+4)
+	nl.NativeEndian.PutUint32(ack[0:], uint32(len(ack)))
+	nl.NativeEndian.PutUint16(ack[4:], nl.MsgError)
+
+	b := append(ack, message(2<<8, 0)...)
+	b = append(b, message(nl.MsgDone, 0)...)
+	b = append(b, message(ctNew, 0, tuple(ctaTupleOrig, "10.0.0.1", "10.0.0.2", protos.UDP, 1, 2))...)
+
+	events, err := Decode(b)
+	if err != nil || len(events) != 0 {
+		t.Errorf("expected no events, got %v (%v)", events, err)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	valid := message(ctNew, 0,
+		tuple(ctaTupleOrig, "10.0.0.1", "10.0.0.2", protos.UDP, 1, 2),
+		tuple(ctaTupleReply, "10.0.0.2", "10.0.0.1", protos.UDP, 2, 1),
+	)
+
+	// Netlink error
+	nlerr := make([]byte, nl.HeaderLength+4)
+	nl.NativeEndian.PutUint32(nlerr[0:], uint32(len(nlerr)))
+	nl.NativeEndian.PutUint16(nlerr[4:], nl.MsgError)
+	nl.NativeEndian.PutUint32(nlerr[nl.HeaderLength:], uint32(0xffffffff))
+
+	// Attribute length exceeding the message
+	corrupted := append([]byte(nil), valid...)
+	nl.NativeEndian.PutUint16(corrupted[nl.HeaderLength+nfgenmsgLength:], 0xfff)
+
+	for name, b := range map[string][]byte{
+		"truncated message":   valid[:len(valid)-8],
+		"netlink error":       nlerr,
+		"corrupted attribute": corrupted,
+		"missing tuples":      message(ctNew, 0, attr(ctaMark, be32(1))),
+		"invalid integer":     message(ctNew, 0, attr(ctaMark, []byte{1, 2, 3})),
+		"address mismatch": message(ctNew, 0,
+			tuple(ctaTupleOrig, "10.0.0.1", "fd00::2", protos.UDP, 1, 2),
+			tuple(ctaTupleReply, "fd00::2", "10.0.0.1", protos.UDP, 2, 1),
+		),
+	} {
+		if _, err := Decode(b); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	if _, err := Decode(valid); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package netlink
+
+import "github.com/xvzf/insight/pkg/nl"
+
+// netlinkNetfilter is the netlink protocol of netfilter (linux/netlink.h)
+const netlinkNetfilter = 12
+
+// receiveBufferSize is requested for the socket, bursts of short-lived connections easily
+// overrun the default buffer
+const receiveBufferSize = 8 << 20
+
+// Subscribe opens a netlink socket receiving the conntrack events of the given groups. This
+// requires CAP_NET_ADMIN.
+func Subscribe(groups Group) (nl.Socket, error) {
+	return nl.Open(netlinkNetfilter, uint32(groups), receiveBufferSize)
+}
+
+// Listen subscribes to the given conntrack groups and returns a listener for the events
+func Listen(groups Group) (Listener, error) {
+	sock, err := Subscribe(groups)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(sock), nil
+}
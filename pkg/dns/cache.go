@@ -0,0 +1,121 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package dns
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// cacheEntry is a name resolved to an address
+type cacheEntry struct {
+	name    string
+	expires time.Time
+}
+
+// cache maps the addresses resolved by a client to the names it asked for
+type cache struct {
+	sync.RWMutex
+	clients    map[string]map[string]*cacheEntry
+	size       int
+	maxEntries int
+	grace      time.Duration
+}
+
+// newCache creates a new cache holding at most maxEntries names. Entries are kept for grace
+// after their TTL expired, as flows are only exported after the connection has been idle.
+func newCache(maxEntries int, grace time.Duration) *cache {
+	return &cache{
+		clients:    make(map[string]map[string]*cacheEntry),
+		maxEntries: maxEntries,
+		grace:      grace,
+	}
+}
+
+// add caches the addresses resolved for name by client at ts
+func (c *cache) add(client net.IP, name string, addresses []address, ts time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	key := client.String()
+	entries, ok := c.clients[key]
+	if !ok {
+		entries = make(map[string]*cacheEntry)
+		c.clients[key] = entries
+	}
+
+	for _, a := range addresses {
+		expires := ts.Add(time.Duration(a.ttl)*time.Second + c.grace)
+		ip := a.ip.String()
+		if e, ok := entries[ip]; ok {
+			// Refresh the name the address has been resolved for most recently
+			e.name = name
+			if expires.After(e.expires) {
+				e.expires = expires
+			}
+			continue
+		}
+		if c.maxEntries > 0 && c.size >= c.maxEntries {
+			cacheDropped.Inc()
+			continue
+		}
+		entries[ip] = &cacheEntry{name: name, expires: expires}
+		c.size++
+	}
+	cacheEntries.Set(float64(c.size))
+}
+
+// lookup returns the name client resolved to ip, empty if unknown or expired
+func (c *cache) lookup(client, ip net.IP, now time.Time) string {
+	c.RLock()
+	defer c.RUnlock()
+
+	e, ok := c.clients[client.String()][ip.String()]
+	if !ok || now.After(e.expires) {
+		return ""
+	}
+	return e.name
+}
+
+// expire removes the expired entries
+func (c *cache) expire(now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	for client, entries := range c.clients {
+		for ip, e := range entries {
+			if now.After(e.expires) {
+				delete(entries, ip)
+				c.size--
+			}
+		}
+		if len(entries) == 0 {
+			delete(c.clients, client)
+		}
+	}
+	cacheEntries.Set(float64(c.size))
+}
@@ -0,0 +1,198 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+// errTruncated is returned for messages not contained in a single packet
+var errTruncated = errors.New("truncated DNS message")
+
+// responseCodes maps response codes to their names in ECS
+var responseCodes = map[layers.DNSResponseCode]string{
+	layers.DNSResponseCodeNoErr:    "NOERROR",
+	layers.DNSResponseCodeFormErr:  "FORMERR",
+	layers.DNSResponseCodeServFail: "SERVFAIL",
+	layers.DNSResponseCodeNXDomain: "NXDOMAIN",
+	layers.DNSResponseCodeNotImp:   "NOTIMP",
+	layers.DNSResponseCodeRefused:  "REFUSED",
+	layers.DNSResponseCodeYXDomain: "YXDOMAIN",
+	layers.DNSResponseCodeYXRRSet:  "YXRRSET",
+	layers.DNSResponseCodeNXRRSet:  "NXRRSET",
+	layers.DNSResponseCodeNotAuth:  "NOTAUTH",
+	layers.DNSResponseCodeNotZone:  "NOTZONE",
+}
+
+// opCodes maps operation codes to their names in ECS
+var opCodes = map[layers.DNSOpCode]string{
+	layers.DNSOpCodeQuery:  "QUERY",
+	layers.DNSOpCodeIQuery: "IQUERY",
+	layers.DNSOpCodeStatus: "STATUS",
+	layers.DNSOpCodeNotify: "NOTIFY",
+	layers.DNSOpCodeUpdate: "UPDATE",
+}
+
+// address is an address resolved by an A or AAAA record
+type address struct {
+	ip  net.IP
+	ttl uint32
+}
+
+// message is a decoded DNS message. The fields do not reference the packet data.
+type message struct {
+	id        uint16
+	response  bool
+	opCode    string
+	flags     []string
+	rcode     string
+	question  *insight.DNSQuestion
+	answers   []insight.DNSAnswer
+	addresses []address
+}
+
+// decode parses a DNS message from the payload of a packet. Messages over TCP are prefixed
+// with their length, messages spanning several segments are not reassembled.
+func decode(payload []byte, transport protos.ProtocolType) (*message, error) {
+	if transport == protos.TCP {
+		if len(payload) < 2 {
+			return nil, errTruncated
+		}
+		n := int(binary.BigEndian.Uint16(payload))
+		if len(payload) < n+2 {
+			return nil, errTruncated
+		}
+		payload = payload[2 : n+2]
+	}
+
+	var d layers.DNS
+	if err := d.DecodeFromBytes(payload, gopacket.NilDecodeFeedback); err != nil {
+		return nil, err
+	}
+
+	m := &message{
+		id:       d.ID,
+		response: d.QR,
+		opCode:   opCodes[d.OpCode],
+		flags:    headerFlags(&d),
+	}
+	if m.opCode == "" {
+		m.opCode = strconv.Itoa(int(d.OpCode))
+	}
+	if d.QR {
+		m.rcode = responseCodes[d.ResponseCode]
+		if m.rcode == "" {
+			m.rcode = strconv.Itoa(int(d.ResponseCode))
+		}
+	}
+	if len(d.Questions) > 0 {
+		q := d.Questions[0]
+		m.question = &insight.DNSQuestion{
+			Name:  string(q.Name),
+			Type:  q.Type.String(),
+			Class: q.Class.String(),
+		}
+	}
+
+	for _, rr := range d.Answers {
+		m.answers = append(m.answers, insight.DNSAnswer{
+			Name:  string(rr.Name),
+			Type:  rr.Type.String(),
+			Class: rr.Class.String(),
+			TTL:   rr.TTL,
+			Data:  recordData(&rr),
+		})
+		if (rr.Type == layers.DNSTypeA || rr.Type == layers.DNSTypeAAAA) && rr.IP != nil {
+			m.addresses = append(m.addresses, address{
+				ip:  append(net.IP(nil), rr.IP...),
+				ttl: rr.TTL,
+			})
+		}
+	}
+	return m, nil
+}
+
+// headerFlags returns the names of the flags set in the header of a message
+func headerFlags(d *layers.DNS) []string {
+	var flags []string
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{d.AA, "AA"},
+		{d.TC, "TC"},
+		{d.RD, "RD"},
+		{d.RA, "RA"},
+		{d.Z&0x2 != 0, "AD"},
+		{d.Z&0x1 != 0, "CD"},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	return flags
+}
+
+// recordData formats the data of a resource record
+func recordData(rr *layers.DNSResourceRecord) string {
+	switch rr.Type {
+	case layers.DNSTypeA, layers.DNSTypeAAAA:
+		if rr.IP == nil {
+			return ""
+		}
+		return rr.IP.String()
+	case layers.DNSTypeCNAME:
+		return string(rr.CNAME)
+	case layers.DNSTypeNS:
+		return string(rr.NS)
+	case layers.DNSTypePTR:
+		return string(rr.PTR)
+	case layers.DNSTypeMX:
+		return fmt.Sprintf("%d %s", rr.MX.Preference, rr.MX.Name)
+	case layers.DNSTypeSRV:
+		return fmt.Sprintf("%d %d %d %s", rr.SRV.Priority, rr.SRV.Weight, rr.SRV.Port, rr.SRV.Name)
+	case layers.DNSTypeSOA:
+		return fmt.Sprintf("%s %s %d", rr.SOA.MName, rr.SOA.RName, rr.SOA.Serial)
+	case layers.DNSTypeTXT:
+		txts := make([]string, len(rr.TXTs))
+		for i, txt := range rr.TXTs {
+			txts[i] = string(txt)
+		}
+		return strings.Join(txts, " ")
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,262 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package dns
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xvzf/insight/pkg/capture"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/communityid"
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/protos"
+	"github.com/xvzf/insight/pkg/tracking"
+)
+
+// Logger
+var log *logrus.Entry
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "dns",
+	})
+}
+
+// Port is the port DNS messages are decoded on
+const Port = 53
+
+// Options configure the tracking of DNS transactions
+type Options struct {
+	Timeout    time.Duration // Queries without an answer are written after this long
+	Grace      time.Duration // Resolved names are kept this long after their TTL expired
+	MaxEntries int           // Maximum number of resolved names cached (unbounded if 0)
+	MaxPending int           // Maximum number of queries waiting for an answer (unbounded if 0)
+}
+
+// Tracker decodes DNS messages of the inspected samples, writes queries with their answers as
+// ECS dns events and annotates flows to resolved addresses with the name the client asked for
+type Tracker interface {
+	capture.Inspector
+	insight.Enricher
+	Run() error
+	Stop()
+}
+
+// transactionKey identifies a query of a client
+type transactionKey struct {
+	transport  protos.ProtocolType
+	client     string
+	clientPort uint16
+	server     string
+	serverPort uint16
+	id         uint16
+}
+
+// transaction is a query and its answer
+type transaction struct {
+	meta     flow.Meta // Client to server
+	query    *message
+	queried  time.Time
+	answer   *message
+	answered time.Time
+}
+
+type tracker struct {
+	tracking.Base
+	sync.Mutex
+	options Options
+	pending map[transactionKey]*transaction
+	done    []*transaction
+	cache   *cache
+	hasher  communityid.Hasher
+}
+
+// NewTracker creates a new Tracker writing DNS events to w
+func NewTracker(w tracking.Writer, opts Options) Tracker {
+	t := &tracker{
+		options: opts,
+		pending: make(map[transactionKey]*transaction),
+		cache:   newCache(opts.MaxEntries, opts.Grace),
+		hasher:  communityid.NewHasher(0),
+	}
+	t.Base = tracking.NewBase("DNS", w, t.collect)
+	return t
+}
+
+// Inspect decodes DNS messages from and to port 53
+func (t *tracker) Inspect(s *capture.Sample) {
+	if len(s.Payload) == 0 || (s.SrcPort != Port && s.DstPort != Port) {
+		return
+	}
+	if s.Transport != protos.UDP && s.Transport != protos.TCP {
+		return
+	}
+
+	m, err := decode(s.Payload, s.Transport)
+	if err != nil {
+		messages.WithLabelValues("malformed").Inc()
+		log.WithError(err).Debug("Failed to decode DNS message")
+		return
+	}
+
+	ts := s.Timestamp
+	if ts.IsZero() {
+		ts = t.Now()
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if !m.response {
+		messages.WithLabelValues("query").Inc()
+		key := transactionKey{s.Transport, s.Src.String(), s.SrcPort, s.Dst.String(), s.DstPort, m.id}
+		if _, ok := t.pending[key]; !ok && t.options.MaxPending > 0 && len(t.pending) >= t.options.MaxPending {
+			// The answer is still written, without the time it took
+			pendingDropped.Inc()
+			return
+		}
+		t.pending[key] = &transaction{
+			meta:    s.FlowMeta(),
+			query:   m,
+			queried: ts,
+		}
+		return
+	}
+
+	messages.WithLabelValues("answer").Inc()
+	key := transactionKey{s.Transport, s.Dst.String(), s.DstPort, s.Src.String(), s.SrcPort, m.id}
+	tr, ok := t.pending[key]
+	if ok {
+		delete(t.pending, key)
+	} else {
+		// Query not seen, e.g. captured before the probe started
+		tr = &transaction{
+			meta: flow.Meta{
+				Transport: s.Transport,
+				Src:       s.Dst,
+				SrcPort:   s.DstPort,
+				Dst:       s.Src,
+				DstPort:   s.SrcPort,
+			},
+			queried: ts,
+		}
+	}
+	tr.answer, tr.answered = m, ts
+	t.done = append(t.done, tr)
+
+	if m.question != nil && len(m.addresses) > 0 {
+		t.cache.add(tr.meta.Src, m.question.Name, m.addresses, ts)
+	}
+}
+
+// Enrich sets the domain of flow destinations to the name the source resolved it from
+func (t *tracker) Enrich(events []*insight.Event) {
+	now := t.Now()
+	for _, e := range events {
+		if e.DNS != nil || e.Source == nil || e.Destination == nil || e.Destination.Domain != "" {
+			continue
+		}
+		if name := t.cache.lookup(e.Source.IP, e.Destination.IP, now); name != "" {
+			e.Destination.Domain = name
+			cacheLookups.WithLabelValues("hit").Inc()
+		} else {
+			cacheLookups.WithLabelValues("miss").Inc()
+		}
+	}
+}
+
+// collect returns the completed transactions and the queries which timed out, expired names
+// are removed from the cache
+func (t *tracker) collect(now time.Time) []*insight.Event {
+	t.cache.expire(now)
+
+	t.Lock()
+	done := t.done
+	t.done = nil
+	for key, tr := range t.pending {
+		if now.Sub(tr.queried) >= t.options.Timeout {
+			delete(t.pending, key)
+			done = append(done, tr)
+		}
+	}
+	t.Unlock()
+
+	events := make([]*insight.Event, len(done))
+	for i, tr := range done {
+		events[i] = t.event(tr)
+		if tr.answer == nil {
+			transactions.WithLabelValues("unanswered").Inc()
+		} else {
+			transactions.WithLabelValues("answered").Inc()
+		}
+	}
+	return events
+}
+
+// event converts a transaction to an ECS event
+func (t *tracker) event(tr *transaction) *insight.Event {
+	// The answer repeats the question and header of the query
+	m := tr.query
+	d := &insight.DNS{Type: "query"}
+	end := tr.queried
+	if tr.answer != nil {
+		m = tr.answer
+		d.Type = "answer"
+		d.ResponseCode = m.rcode
+		d.Answers = m.answers
+		for _, a := range m.addresses {
+			d.ResolvedIP = append(d.ResolvedIP, a.ip)
+		}
+		end = tr.answered
+	}
+	d.ID = strconv.Itoa(int(m.id))
+	d.OpCode = m.opCode
+	d.HeaderFlags = m.flags
+	d.Question = m.question
+
+	e := insight.NewEvent("dns", "dns_"+d.Type, tr.queried, end)
+	e.Source = &insight.EndpointDescription{
+		Address: tr.meta.Src.String(),
+		IP:      tr.meta.Src,
+		Port:    tr.meta.SrcPort,
+	}
+	e.Destination = &insight.EndpointDescription{
+		Address: tr.meta.Dst.String(),
+		IP:      tr.meta.Dst,
+		Port:    tr.meta.DstPort,
+	}
+	e.Network = &insight.NetworkDescription{
+		Type:        insight.IPVersion(tr.meta.Src),
+		Transport:   tr.meta.Transport.String(),
+		Protocol:    "dns",
+		CommunityID: t.hasher.Hash(tr.meta),
+	}
+	e.DNS = d
+	return e
+}
@@ -0,0 +1,287 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/xvzf/insight/pkg/capture"
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/protos"
+	"github.com/xvzf/insight/pkg/tracking/trackingtest"
+)
+
+var (
+	testClient = net.ParseIP("10.1.0.5")
+	testServer = net.ParseIP("10.96.0.10")
+	testStart  = time.Unix(1000, 0)
+)
+
+// serialize encodes a DNS message
+func serialize(t *testing.T, d *layers.DNS) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	if err := d.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testQuery is an A query for api.example.com
+func testQuery() *layers.DNS {
+	return &layers.DNS{
+		ID:     4711,
+		OpCode: layers.DNSOpCodeQuery,
+		RD:     true,
+		Questions: []layers.DNSQuestion{
+			{Name: []byte("api.example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN},
+		},
+	}
+}
+
+// testAnswer answers testQuery through a CNAME
+func testAnswer() *layers.DNS {
+	d := testQuery()
+	d.QR, d.RA = true, true
+	d.Answers = []layers.DNSResourceRecord{
+		{Name: []byte("api.example.com"), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: 300, CNAME: []byte("lb.example.net")},
+		{Name: []byte("lb.example.net"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 30, IP: net.ParseIP("192.0.2.1").To4()},
+		{Name: []byte("lb.example.net"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 30, IP: net.ParseIP("192.0.2.2").To4()},
+	}
+	return d
+}
+
+// sample creates a UDP sample from client to server, or the other way around for answers
+func sample(payload []byte, answer bool, ts time.Time) *capture.Sample {
+	s := &capture.Sample{
+		Transport: protos.UDP,
+		Src:       testClient,
+		SrcPort:   41234,
+		Dst:       testServer,
+		DstPort:   Port,
+		Timestamp: ts,
+		Payload:   payload,
+	}
+	if answer {
+		s.Src, s.Dst, s.SrcPort, s.DstPort = s.Dst, s.Src, s.DstPort, s.SrcPort
+	}
+	return s
+}
+
+// testOptions are the options of the trackers under test
+var testOptions = Options{Timeout: 5 * time.Second, Grace: time.Minute}
+
+func TestDecode(t *testing.T) {
+	m, err := decode(serialize(t, testAnswer()), protos.UDP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &message{
+		id:       4711,
+		response: true,
+		opCode:   "QUERY",
+		flags:    []string{"RD", "RA"},
+		rcode:    "NOERROR",
+		question: &insight.DNSQuestion{Name: "api.example.com", Type: "A", Class: "IN"},
+		answers: []insight.DNSAnswer{
+			{Name: "api.example.com", Type: "CNAME", Class: "IN", TTL: 300, Data: "lb.example.net"},
+			{Name: "lb.example.net", Type: "A", Class: "IN", TTL: 30, Data: "192.0.2.1"},
+			{Name: "lb.example.net", Type: "A", Class: "IN", TTL: 30, Data: "192.0.2.2"},
+		},
+		addresses: []address{
+			{net.ParseIP("192.0.2.1").To4(), 30},
+			{net.ParseIP("192.0.2.2").To4(), 30},
+		},
+	}
+	if !cmp.Equal(m, expected, cmp.AllowUnexported(message{}, address{})) {
+		t.Error(cmp.Diff(expected, m, cmp.AllowUnexported(message{}, address{})))
+	}
+}
+
+func TestDecodeTCP(t *testing.T) {
+	msg := serialize(t, testQuery())
+	payload := make([]byte, 2, len(msg)+2)
+	binary.BigEndian.PutUint16(payload, uint16(len(msg)))
+	payload = append(payload, msg...)
+
+	m, err := decode(payload, protos.TCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.id != 4711 || m.response || m.question.Name != "api.example.com" {
+		t.Errorf("Unexpected message %+v", m)
+	}
+
+	// Message continued in the next segment
+	if _, err := decode(payload[:len(payload)-1], protos.TCP); err != errTruncated {
+		t.Errorf("Expected truncated message, got %v", err)
+	}
+	if _, err := decode(msg[:5], protos.UDP); err == nil {
+		t.Error("Expected error for malformed message")
+	}
+}
+
+func TestTrackerTransaction(t *testing.T) {
+	clock := trackingtest.NewClock(testStart)
+	tr := NewTracker(nil, testOptions).(*tracker)
+	w := trackingtest.Attach(&tr.Base, clock)
+
+	tr.Inspect(sample(serialize(t, testQuery()), false, testStart))
+	tr.Inspect(sample(serialize(t, testAnswer()), true, testStart.Add(3*time.Millisecond)))
+	// Other traffic is ignored
+	tr.Inspect(&capture.Sample{Transport: protos.TCP, SrcPort: 41235, DstPort: 443, Payload: []byte{0x16, 0x03, 0x01}})
+	tr.Flush()
+
+	if len(w.Events) != 1 {
+		t.Fatalf("Expected one event, got %d", len(w.Events))
+	}
+	e := w.Events[0]
+	if e.Event.Dataset != "dns" || e.Event.Duration != 3*time.Millisecond || !e.Event.Start.Equal(testStart) {
+		t.Errorf("Unexpected event description %+v", e.Event)
+	}
+	if !e.Source.IP.Equal(testClient) || e.Source.Port != 41234 || !e.Destination.IP.Equal(testServer) || e.Destination.Port != Port {
+		t.Errorf("Unexpected endpoints %+v -> %+v", e.Source, e.Destination)
+	}
+	if e.Network.Protocol != "dns" || e.Network.Transport != "udp" || e.Network.CommunityID == "" {
+		t.Errorf("Unexpected network %+v", e.Network)
+	}
+	if e.DNS.Type != "answer" || e.DNS.ID != "4711" || e.DNS.ResponseCode != "NOERROR" || len(e.DNS.Answers) != 3 {
+		t.Errorf("Unexpected DNS fields %+v", e.DNS)
+	}
+	expectedIPs := []net.IP{net.ParseIP("192.0.2.1").To4(), net.ParseIP("192.0.2.2").To4()}
+	if !cmp.Equal(e.DNS.ResolvedIP, expectedIPs) {
+		t.Error(cmp.Diff(expectedIPs, e.DNS.ResolvedIP))
+	}
+}
+
+func TestTrackerUnanswered(t *testing.T) {
+	clock := trackingtest.NewClock(testStart)
+	tr := NewTracker(nil, testOptions).(*tracker)
+	w := trackingtest.Attach(&tr.Base, clock)
+
+	tr.Inspect(sample(serialize(t, testQuery()), false, testStart))
+	clock.Add(4 * time.Second)
+	tr.Flush()
+	if len(w.Events) != 0 {
+		t.Fatalf("Query written before the timeout: %+v", w.Events)
+	}
+
+	clock.Add(time.Second)
+	tr.Flush()
+	if len(w.Events) != 1 || w.Events[0].DNS.Type != "query" || w.Events[0].DNS.ResponseCode != "" {
+		t.Fatalf("Expected unanswered query, got %+v", w.Events)
+	}
+	if len(tr.pending) != 0 {
+		t.Error("Query still pending")
+	}
+}
+
+func TestTrackerMaxPending(t *testing.T) {
+	clock := trackingtest.NewClock(testStart)
+	tr := NewTracker(nil, testOptions).(*tracker)
+	w := trackingtest.Attach(&tr.Base, clock)
+	tr.options.MaxPending = 1
+
+	tr.Inspect(sample(serialize(t, testQuery()), false, testStart))
+	// Retransmissions of a pending query are still tracked
+	tr.Inspect(sample(serialize(t, testQuery()), false, testStart.Add(time.Second)))
+	other := sample(serialize(t, testQuery()), false, testStart)
+	other.SrcPort++
+	tr.Inspect(other)
+	if len(tr.pending) != 1 {
+		t.Fatalf("Expected one pending query, got %d", len(tr.pending))
+	}
+
+	// The answer to the dropped query is written without its query time
+	answer := sample(serialize(t, testAnswer()), true, testStart.Add(2*time.Second))
+	answer.DstPort++
+	tr.Inspect(answer)
+	tr.Flush()
+	if len(w.Events) != 1 || w.Events[0].DNS.Type != "answer" || w.Events[0].Event.Duration != 0 {
+		t.Fatalf("Expected the answer to be written, got %+v", w.Events)
+	}
+}
+
+func TestTrackerEnrich(t *testing.T) {
+	clock := trackingtest.NewClock(testStart)
+	tr := NewTracker(nil, testOptions).(*tracker)
+	trackingtest.Attach(&tr.Base, clock)
+	tr.Inspect(sample(serialize(t, testAnswer()), true, testStart))
+
+	flow := func(src, dst string) *insight.Event {
+		return &insight.Event{
+			Source:      &insight.EndpointDescription{IP: net.ParseIP(src)},
+			Destination: &insight.EndpointDescription{IP: net.ParseIP(dst)},
+		}
+	}
+	events := []*insight.Event{
+		flow("10.1.0.5", "192.0.2.2"),
+		// Other client
+		flow("10.1.0.6", "192.0.2.2"),
+		flow("10.1.0.5", "192.0.2.3"),
+	}
+	tr.Enrich(events)
+	for i, expected := range []string{"api.example.com", "", ""} {
+		if got := events[i].Destination.Domain; got != expected {
+			t.Errorf("%d: expected domain %q, got %q", i, expected, got)
+		}
+	}
+
+	// Names are kept for the grace period after the TTL
+	clock.Set(testStart.Add(30*time.Second + time.Minute))
+	tr.Flush()
+	if got := tr.cache.lookup(testClient, net.ParseIP("192.0.2.1"), clock.Now()); got != "api.example.com" {
+		t.Errorf("Name expired within the grace period, got %q", got)
+	}
+	clock.Add(time.Second)
+	tr.Flush()
+	if got := tr.cache.lookup(testClient, net.ParseIP("192.0.2.1"), clock.Now()); got != "" {
+		t.Errorf("Expected expired name, got %q", got)
+	}
+	if tr.cache.size != 0 || len(tr.cache.clients) != 0 {
+		t.Errorf("Expired entries not removed: %d", tr.cache.size)
+	}
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	c := newCache(1, 0)
+	c.add(testClient, "a.example.com", []address{{net.ParseIP("192.0.2.1"), 60}}, testStart)
+	c.add(testClient, "b.example.com", []address{{net.ParseIP("192.0.2.2"), 60}}, testStart)
+	// Known addresses are updated when the cache is full
+	c.add(testClient, "c.example.com", []address{{net.ParseIP("192.0.2.1"), 60}}, testStart)
+
+	if got := c.lookup(testClient, net.ParseIP("192.0.2.1"), testStart); got != "c.example.com" {
+		t.Errorf("Expected updated name, got %q", got)
+	}
+	if got := c.lookup(testClient, net.ParseIP("192.0.2.2"), testStart); got != "" {
+		t.Errorf("Expected name to be dropped, got %q", got)
+	}
+}
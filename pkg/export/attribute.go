@@ -0,0 +1,67 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package export
+
+import (
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/procfs"
+)
+
+// attributing tags flows with the local process owning their socket
+type attributing struct {
+	Exporter
+	table procfs.Table
+}
+
+// NewAttributing wraps e, tagging flows with the process owning a local socket of the flow as
+// looked up in t. Connections closed before the flow is exported are attributed as long as t
+// retains their sockets.
+func NewAttributing(e Exporter, t procfs.Table) Exporter {
+	return &attributing{Exporter: e, table: t}
+}
+
+func (a *attributing) Export(flows []*flow.Flow) error {
+	attributed := 0
+	for _, f := range flows {
+		o, source := a.table.LookupFlow(f.Meta)
+		if o == nil {
+			continue
+		}
+		f.Process = &flow.Process{
+			PID:         o.PID,
+			Comm:        o.Comm,
+			Executable:  o.Executable,
+			Cgroup:      o.Path,
+			ContainerID: o.ContainerID,
+			Source:      source,
+		}
+		attributed++
+	}
+	flowsAttributed.WithLabelValues("attributed").Add(float64(attributed))
+	flowsAttributed.WithLabelValues("unknown").Add(float64(len(flows) - attributed))
+
+	return a.Exporter.Export(flows)
+}
@@ -0,0 +1,85 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package export
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/procfs"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+// fakeTable knows the owner of the client socket of serviceFlow
+type fakeTable struct{}
+
+func (fakeTable) Lookup(transport protos.ProtocolType, ip net.IP, port uint16) *procfs.Owner {
+	return nil
+}
+
+func (fakeTable) LookupFlow(m flow.Meta) (*procfs.Owner, bool) {
+	if m.SrcPort != serviceFlow.SrcPort || !m.Src.Equal(serviceFlow.Src) {
+		return nil, false
+	}
+	return &procfs.Owner{
+		PID:        4242,
+		Comm:       "curl",
+		Executable: "/usr/bin/curl",
+		Cgroup: procfs.Cgroup{
+			Path:        "/kubepods/besteffort/podc0ffee/3f4a5b6c",
+			ContainerID: "3f4a5b6c",
+			PodUID:      "c0ffee",
+		},
+	}, true
+}
+
+func TestAttributing(t *testing.T) {
+	r := &recordingExporter{}
+	e := NewAttributing(r, fakeTable{})
+
+	if err := e.Export([]*flow.Flow{{Meta: serviceFlow}, {Meta: directFlow}}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*flow.Flow{{
+		Meta: serviceFlow,
+		Process: &flow.Process{
+			PID:         4242,
+			Comm:        "curl",
+			Executable:  "/usr/bin/curl",
+			Cgroup:      "/kubepods/besteffort/podc0ffee/3f4a5b6c",
+			ContainerID: "3f4a5b6c",
+			Source:      true,
+		},
+	}, {
+		Meta: directFlow,
+	}}
+	if diff := cmp.Diff(expected, r.flows); diff != "" {
+		t.Error(diff)
+	}
+}
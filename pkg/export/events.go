@@ -0,0 +1,70 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package export
+
+import (
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/sink"
+)
+
+// EventWriter writes events which do not originate from flows, e.g. DNS transactions
+type EventWriter interface {
+	Write(events []*insight.Event) error
+}
+
+// events converts flows to ECS events and writes them to a sink
+type events struct {
+	sink      sink.Sink
+	enrichers []insight.Enricher
+}
+
+// NewEvents creates an Exporter writing flows as ECS events to the given sink. The events are
+// passed through the enrichers in order.
+func NewEvents(s sink.Sink, enrichers ...insight.Enricher) Exporter {
+	return instrument("events", &events{sink: s, enrichers: enrichers})
+}
+
+// NewEventWriter creates an EventWriter passing events through the enrichers in order before
+// writing them to the given sink. The sink is not closed by the writer.
+func NewEventWriter(s sink.Sink, enrichers ...insight.Enricher) EventWriter {
+	return &events{sink: s, enrichers: enrichers}
+}
+
+func (e *events) Export(flows []*flow.Flow) error {
+	return e.Write(insight.NewFromFlows(flows))
+}
+
+func (e *events) Write(events []*insight.Event) error {
+	for _, enricher := range e.enrichers {
+		enricher.Enrich(events)
+	}
+	return e.sink.Write(events)
+}
+
+func (e *events) Close() error {
+	return e.sink.Close()
+}
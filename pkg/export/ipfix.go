@@ -0,0 +1,89 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package export
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/ipfix"
+)
+
+// maxMessageSize keeps NetFlow v9/IPFIX messages below the usual path MTU
+const maxMessageSize = 1400
+
+// ipfixExporter sends flows to a NetFlow v9 or IPFIX collector via UDP
+type ipfixExporter struct {
+	sync.Mutex
+	conn         net.Conn
+	encoder      ipfix.Encoder
+	refresh      time.Duration // Template refresh interval
+	lastTemplate time.Time     // Last time the templates have been sent
+}
+
+// NewIPFIX creates an Exporter sending NetFlow v9 or IPFIX messages to the collector at addr (host:port).
+// Templates are sent with the first message and then repeated every refresh interval, as UDP
+// collectors rely on them being resent periodically.
+func NewIPFIX(addr string, version ipfix.Version, domain uint32, refresh time.Duration) (Exporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		log.Error("Could not connect to collector ", addr)
+		return nil, err
+	}
+	log.Infof("Exporting %s to %s", version, addr)
+
+	return instrument(version.String(), &ipfixExporter{
+		conn:    conn,
+		encoder: ipfix.NewEncoder(version, domain, ipfix.DefaultPEN, maxMessageSize),
+		refresh: refresh,
+	}), nil
+}
+
+func (e *ipfixExporter) Export(flows []*flow.Flow) error {
+	e.Lock()
+	defer e.Unlock()
+
+	now := time.Now()
+	withTemplates := now.Sub(e.lastTemplate) >= e.refresh
+	if withTemplates {
+		e.lastTemplate = now
+	}
+
+	for _, msg := range e.encoder.Encode(flows, now, withTemplates) {
+		if _, err := e.conn.Write(msg); err != nil {
+			// Make sure the templates are part of the next transmission
+			e.lastTemplate = time.Time{}
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *ipfixExporter) Close() error {
+	return e.conn.Close()
+}
@@ -0,0 +1,82 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package export
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/metrics"
+)
+
+var (
+	batchesExported = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "export",
+		Name:      "batches_total",
+		Help:      "Flow batches passed to the exporter by result.",
+	}, []string{"exporter", "result"})
+	flowsExported = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "export",
+		Name:      "flows_total",
+		Help:      "Flow records passed to the exporter by result.",
+	}, []string{"exporter", "result"})
+	flowsTranslated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "export",
+		Name:      "translated_flows_total",
+		Help:      "Flows passed to the service address translation by result (rewritten, unchanged, failure).",
+	}, []string{"result"})
+	flowsAttributed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "export",
+		Name:      "attributed_flows_total",
+		Help:      "Flows passed to the process attribution by result (attributed, unknown).",
+	}, []string{"result"})
+)
+
+// instrumented counts the exported batches and flows of an exporter
+type instrumented struct {
+	Exporter
+	name string
+}
+
+// instrument wraps e, counting its batches and flows under the given exporter name
+func instrument(name string, e Exporter) Exporter {
+	return &instrumented{Exporter: e, name: name}
+}
+
+func (i *instrumented) Export(flows []*flow.Flow) error {
+	result := "success"
+	err := i.Exporter.Export(flows)
+	if err != nil {
+		result = "failure"
+	}
+	batchesExported.WithLabelValues(i.name, result).Inc()
+	flowsExported.WithLabelValues(i.name, result).Add(float64(len(flows)))
+	return err
+}
@@ -0,0 +1,66 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package export
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/xvzf/insight/pkg/flow"
+)
+
+// failingExporter fails every export
+type failingExporter struct {
+	recordingExporter
+}
+
+func (f *failingExporter) Export(flows []*flow.Flow) error {
+	return errors.New("collector unreachable")
+}
+
+func TestInstrument(t *testing.T) {
+	flows := []*flow.Flow{{Meta: serviceFlow}, {Meta: directFlow}}
+	for _, test := range []struct {
+		exporter Exporter
+		result   string
+	}{
+		{&recordingExporter{}, "success"},
+		{&failingExporter{}, "failure"},
+	} {
+		batches := testutil.ToFloat64(batchesExported.WithLabelValues("test", test.result))
+		exported := testutil.ToFloat64(flowsExported.WithLabelValues("test", test.result))
+
+		instrument("test", test.exporter).Export(flows)
+
+		if d := testutil.ToFloat64(batchesExported.WithLabelValues("test", test.result)) - batches; d != 1 {
+			t.Errorf("%s: expected one batch counted, got %v", test.result, d)
+		}
+		if d := testutil.ToFloat64(flowsExported.WithLabelValues("test", test.result)) - exported; d != 2 {
+			t.Errorf("%s: expected two flows counted, got %v", test.result, d)
+		}
+	}
+}
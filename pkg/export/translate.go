@@ -0,0 +1,209 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package export
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/xvzf/insight/pkg/clusterip"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/communityid"
+)
+
+// Lookups are answered from a cache, the translator is only queried for unknown flows. A slow
+// query does not hold back the export, it completes in the background and fills the cache.
+const (
+	translateTimeout = 200 * time.Millisecond
+	translateBackoff = 10 * time.Second // No queries after a failure
+	hitTTL           = time.Minute
+	missTTL          = 10 * time.Second // Short, the connection might not be known yet
+	maxCached        = 1 << 16
+)
+
+var (
+	errTranslateTimeout     = errors.New("translation timed out")
+	errTranslateUnavailable = errors.New("translation unavailable")
+)
+
+// cachedMapping is the mapping of a flow, nil if it is not addressed to a service
+type cachedMapping struct {
+	mapping *clusterip.Mapping
+	expires time.Time
+}
+
+// translation is the result of a translator query
+type translation struct {
+	mappings []*clusterip.Mapping
+	err      error
+}
+
+// translating rewrites flows addressed to kubernetes services to the selected backend
+type translating struct {
+	Exporter
+	translator clusterip.Translator
+	hasher     communityid.Hasher
+	timeout    time.Duration
+	now        func() time.Time
+
+	mu       sync.Mutex
+	cache    map[string]cachedMapping // CommunityID of the original flow -> mapping
+	inflight bool                     // A query is running
+	backoff  time.Time                // No queries before
+}
+
+// NewTranslating wraps e, rewriting flows addressed to kubernetes services to the backend and
+// CommunityID looked up by t. Flows are exported unmodified if the lookup fails or takes too long.
+func NewTranslating(e Exporter, t clusterip.Translator) Exporter {
+	return &translating{
+		Exporter:   e,
+		translator: t,
+		hasher:     communityid.NewHasher(0),
+		timeout:    translateTimeout,
+		now:        time.Now,
+		cache:      make(map[string]cachedMapping),
+	}
+}
+
+func (t *translating) Export(flows []*flow.Flow) error {
+	now := t.now()
+	keys := make([]string, len(flows))
+	mappings := make([]*clusterip.Mapping, len(flows))
+	var missing []int
+
+	t.mu.Lock()
+	for i, f := range flows {
+		keys[i] = f.CommunityID
+		if keys[i] == "" {
+			keys[i] = t.hasher.Hash(f.Meta)
+		}
+		if c, ok := t.cache[keys[i]]; ok && now.Before(c.expires) {
+			mappings[i] = c.mapping
+			continue
+		}
+		missing = append(missing, i)
+	}
+	t.mu.Unlock()
+
+	failed := 0
+	if len(missing) > 0 {
+		missingKeys := make([]string, len(missing))
+		metas := make([]flow.Meta, len(missing))
+		for j, i := range missing {
+			missingKeys[j], metas[j] = keys[i], flows[i].Meta
+		}
+		looked, err := t.lookup(missingKeys, metas)
+		switch err {
+		case nil:
+			for j, i := range missing {
+				mappings[i] = looked[j]
+			}
+		case errTranslateUnavailable:
+			failed = len(missing)
+		default:
+			failed = len(missing)
+			log.WithError(err).Warn("Failed to translate service addresses")
+		}
+	}
+
+	rewritten := 0
+	for i, mp := range mappings {
+		if mp == nil {
+			continue
+		}
+		f := flows[i]
+		if f.CommunityID == "" {
+			f.CommunityID = keys[i]
+		}
+		if mp.Rewrite(f) {
+			rewritten++
+		}
+	}
+	flowsTranslated.WithLabelValues("failure").Add(float64(failed))
+	flowsTranslated.WithLabelValues("rewritten").Add(float64(rewritten))
+	flowsTranslated.WithLabelValues("unchanged").Add(float64(len(flows) - rewritten - failed))
+
+	return t.Exporter.Export(flows)
+}
+
+// lookup queries the translator, waiting at most for the timeout. Only one query runs at a time
+// and none during the backoff after a failure.
+func (t *translating) lookup(keys []string, metas []flow.Meta) ([]*clusterip.Mapping, error) {
+	t.mu.Lock()
+	if t.inflight || t.now().Before(t.backoff) {
+		t.mu.Unlock()
+		return nil, errTranslateUnavailable
+	}
+	t.inflight = true
+	t.mu.Unlock()
+
+	done := make(chan translation, 1)
+	go func() {
+		mappings, err := t.translator.Translate(metas)
+		t.mu.Lock()
+		t.inflight = false
+		if err != nil {
+			t.backoff = t.now().Add(translateBackoff)
+		} else {
+			t.store(keys, mappings)
+		}
+		t.mu.Unlock()
+		done <- translation{mappings, err}
+	}()
+
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.mappings, res.err
+	case <-timer.C:
+		return nil, errTranslateTimeout
+	}
+}
+
+// store caches the mappings of a query, t.mu has to be held
+func (t *translating) store(keys []string, mappings []*clusterip.Mapping) {
+	now := t.now()
+	if len(t.cache)+len(keys) > maxCached {
+		for key, c := range t.cache {
+			if !now.Before(c.expires) {
+				delete(t.cache, key)
+			}
+		}
+		if len(t.cache)+len(keys) > maxCached {
+			t.cache = make(map[string]cachedMapping)
+		}
+	}
+
+	for i, key := range keys {
+		ttl := hitTTL
+		if mappings[i] == nil {
+			ttl = missTTL
+		}
+		t.cache[key] = cachedMapping{mapping: mappings[i], expires: now.Add(ttl)}
+	}
+}
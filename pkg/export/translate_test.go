@@ -0,0 +1,241 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package export
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/clusterip"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/communityid"
+	"github.com/xvzf/insight/pkg/protos"
+	"github.com/xvzf/insight/pkg/tracking/trackingtest"
+)
+
+var (
+	// Pod connecting to a ClusterIP service
+	serviceFlow = flow.Meta{
+		Transport: protos.TCP,
+		Src:       net.ParseIP("10.1.0.5"),
+		SrcPort:   41234,
+		Dst:       net.ParseIP("10.96.0.20"),
+		DstPort:   80,
+	}
+	// Same connection as seen by the backend
+	backendFlow = flow.Meta{
+		Transport: protos.TCP,
+		Src:       net.ParseIP("10.1.0.5"),
+		SrcPort:   41234,
+		Dst:       net.ParseIP("10.1.1.7"),
+		DstPort:   8080,
+	}
+	// Connection which is not addressed to a service
+	directFlow = flow.Meta{
+		Transport: protos.UDP,
+		Src:       net.ParseIP("10.1.0.5"),
+		SrcPort:   5353,
+		Dst:       net.ParseIP("10.1.0.6"),
+		DstPort:   53,
+	}
+
+	serviceMapping = &clusterip.Mapping{
+		CommunityID: communityid.NewHasher(0).Hash(backendFlow),
+		ServiceIP:   serviceFlow.Dst,
+		ServicePort: serviceFlow.DstPort,
+		ReplaceIP:   backendFlow.Dst,
+		ReplacePort: backendFlow.DstPort,
+	}
+)
+
+// recordingExporter keeps the exported flows
+type recordingExporter struct {
+	flows []*flow.Flow
+}
+
+func (r *recordingExporter) Export(flows []*flow.Flow) error {
+	r.flows = append(r.flows, flows...)
+	return nil
+}
+
+func (r *recordingExporter) Close() error {
+	return nil
+}
+
+// fakeTranslator maps serviceFlow to serviceMapping; it fails while err is set and blocks while
+// block is not nil
+type fakeTranslator struct {
+	sync.Mutex
+	queries int
+	err     error
+	block   chan struct{}
+}
+
+func (f *fakeTranslator) Translate(metas []flow.Meta) ([]*clusterip.Mapping, error) {
+	f.Lock()
+	f.queries++
+	err, block := f.err, f.block
+	f.Unlock()
+	if block != nil {
+		<-block
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := make([]*clusterip.Mapping, len(metas))
+	for i, m := range metas {
+		if cmp.Equal(m, serviceFlow) {
+			mappings[i] = serviceMapping
+		}
+	}
+	return mappings, nil
+}
+
+func (f *fakeTranslator) count() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.queries
+}
+
+// testFlows returns a flow addressed to a service and one which is not
+func testFlows() []*flow.Flow {
+	return []*flow.Flow{{Meta: serviceFlow}, {Meta: directFlow}}
+}
+
+func TestTranslating(t *testing.T) {
+	tr := &fakeTranslator{}
+	clock := trackingtest.NewClock(time.Unix(1000, 0))
+	rec := &recordingExporter{}
+	e := NewTranslating(rec, tr).(*translating)
+	e.now = clock.Now
+
+	if err := e.Export(testFlows()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []*flow.Flow{
+		{
+			Meta:        backendFlow,
+			CommunityID: serviceMapping.CommunityID,
+			Original:    &flow.Original{Meta: serviceFlow, CommunityID: communityid.NewHasher(0).Hash(serviceFlow)},
+		},
+		{Meta: directFlow},
+	}
+	if !cmp.Equal(rec.flows, expected) {
+		t.Error(cmp.Diff(expected, rec.flows))
+	}
+
+	// Answered from the cache
+	rec.flows = nil
+	e.Export(testFlows())
+	if tr.count() != 1 {
+		t.Errorf("expected 1 query, got %d", tr.count())
+	}
+	if !cmp.Equal(rec.flows, expected) {
+		t.Error(cmp.Diff(expected, rec.flows))
+	}
+
+	// Unknown flows are queried again sooner than known ones
+	clock.Add(missTTL)
+	e.Export(testFlows())
+	if tr.count() != 2 {
+		t.Errorf("expected 2 queries, got %d", tr.count())
+	}
+}
+
+func TestTranslatingFailure(t *testing.T) {
+	tr := &fakeTranslator{err: errors.New("connection refused")}
+	clock := trackingtest.NewClock(time.Unix(1000, 0))
+	rec := &recordingExporter{}
+	e := NewTranslating(rec, tr).(*translating)
+	e.now = clock.Now
+
+	// Exported unmodified
+	if err := e.Export(testFlows()); err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(rec.flows, testFlows()) {
+		t.Error(cmp.Diff(testFlows(), rec.flows))
+	}
+
+	// No queries during the backoff
+	tr.err = nil
+	e.Export(testFlows())
+	if tr.count() != 1 {
+		t.Errorf("expected 1 query, got %d", tr.count())
+	}
+	clock.Add(translateBackoff)
+	e.Export(testFlows())
+	if tr.count() != 2 || rec.flows[4].Original == nil {
+		t.Errorf("expected translation after the backoff, got %d queries", tr.count())
+	}
+}
+
+func TestTranslatingTimeout(t *testing.T) {
+	tr := &fakeTranslator{block: make(chan struct{})}
+	rec := &recordingExporter{}
+	e := NewTranslating(rec, tr).(*translating)
+	e.timeout = 10 * time.Millisecond
+
+	// The slow query does not hold back the export
+	if err := e.Export(testFlows()); err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(rec.flows, testFlows()) {
+		t.Error(cmp.Diff(testFlows(), rec.flows))
+	}
+
+	// At most one query runs at a time
+	e.Export(testFlows())
+	if tr.count() != 1 {
+		t.Errorf("expected 1 query, got %d", tr.count())
+	}
+
+	// The late result fills the cache
+	close(tr.block)
+	deadline := time.Now().Add(time.Second)
+	for {
+		e.mu.Lock()
+		n := len(e.cache)
+		e.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	rec.flows = nil
+	e.Export(testFlows())
+	if tr.count() != 1 || len(rec.flows) != 2 || rec.flows[0].Original == nil {
+		t.Errorf("expected cached translation, got %d queries", tr.count())
+	}
+}
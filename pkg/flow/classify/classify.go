@@ -0,0 +1,177 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package classify
+
+import (
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+// Protocol names in ECS network.protocol
+const (
+	HTTP       = "http"
+	HTTP2      = "http2"
+	GRPC       = "grpc"
+	TLS        = "tls"
+	DNS        = "dns"
+	SSH        = "ssh"
+	PostgreSQL = "postgresql"
+	MySQL      = "mysql"
+	Redis      = "redis"
+	Kafka      = "kafka"
+	MQTT       = "mqtt"
+	Memcached  = "memcached"
+)
+
+// maxSegments is the number of payload segments inspected per direction
+const maxSegments = 4
+
+// Classifier identifies the application protocol of a flow from the first payload segments
+// of each direction, independent of the port numbers
+type Classifier struct {
+	transport protos.ProtocolType
+	segments  [2]int
+	protocol  string
+	final     bool
+}
+
+// New creates a new Classifier for a flow of the given transport
+func New(transport protos.ProtocolType) *Classifier {
+	return &Classifier{transport: transport}
+}
+
+// Add inspects a payload segment of one direction of the flow, reverse selects the opposite
+// direction. It returns the protocol identified so far and true once the classification is
+// final and the classifier can be released.
+func (c *Classifier) Add(reverse bool, payload []byte) (string, bool) {
+	if c.final || len(payload) == 0 {
+		return c.protocol, c.final
+	}
+	i := 0
+	if reverse {
+		i = 1
+	}
+	if c.segments[i] >= maxSegments {
+		return c.protocol, c.final
+	}
+	c.segments[i]++
+
+	if c.protocol == HTTP2 {
+		// gRPC is identified by the content type of the HEADERS frames
+		if isGRPC(payload) {
+			c.protocol, c.final = GRPC, true
+		}
+	} else if p, final := c.match(payload); p != "" {
+		c.protocol, c.final = p, final
+	}
+
+	if c.segments[0] >= maxSegments && c.segments[1] >= maxSegments {
+		c.final = true
+	}
+	if c.final {
+		classified.WithLabelValues(result(c.protocol)).Inc()
+	}
+	return c.protocol, c.final
+}
+
+// match identifies the protocol of a payload. The result is not final if a more specific
+// protocol may be identified by later segments.
+func (c *Classifier) match(payload []byte) (string, bool) {
+	if c.transport == protos.UDP {
+		if isDNS(payload) {
+			return DNS, true
+		}
+		return "", false
+	}
+
+	switch {
+	case isTLS(payload):
+		return TLS, true
+	case isHTTP2(payload):
+		if isGRPC(payload) {
+			return GRPC, true
+		}
+		return HTTP2, false
+	case isHTTP(payload):
+		return HTTP, true
+	case isSSH(payload):
+		return SSH, true
+	case isDNSOverTCP(payload):
+		return DNS, true
+	case isPostgreSQL(payload):
+		return PostgreSQL, true
+	case isMySQL(payload):
+		return MySQL, true
+	case isMQTT(payload):
+		return MQTT, true
+	case isKafka(payload):
+		return Kafka, true
+	case isRedis(payload):
+		return Redis, true
+	case isMemcached(payload):
+		return Memcached, true
+	}
+	return "", false
+}
+
+func result(protocol string) string {
+	if protocol == "" {
+		return "unknown"
+	}
+	return protocol
+}
+
+// wellKnownPorts maps the registered ports of the identified protocols
+var wellKnownPorts = map[protos.ProtocolType]map[uint16]string{
+	protos.TCP: {
+		22:    SSH,
+		53:    DNS,
+		80:    HTTP,
+		443:   TLS,
+		1883:  MQTT,
+		3306:  MySQL,
+		5432:  PostgreSQL,
+		6379:  Redis,
+		8080:  HTTP,
+		8883:  TLS,
+		9092:  Kafka,
+		11211: Memcached,
+		50051: GRPC,
+	},
+	protos.UDP: {
+		53:    DNS,
+		11211: Memcached,
+	},
+}
+
+// ByPort returns the protocol registered for the first of the ports known, empty if none is
+func ByPort(transport protos.ProtocolType, ports ...uint16) string {
+	for _, port := range ports {
+		if p, ok := wellKnownPorts[transport][port]; ok {
+			return p
+		}
+	}
+	return ""
+}
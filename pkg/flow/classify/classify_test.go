@@ -0,0 +1,141 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package classify
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/xvzf/insight/pkg/protos"
+	"golang.org/x/net/http2/hpack"
+)
+
+// dnsQuery is an A query for example.com
+func dnsQuery(t *testing.T) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	d := &layers.DNS{
+		ID: 1,
+		RD: true,
+		Questions: []layers.DNSQuestion{
+			{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN},
+		},
+	}
+	if err := d.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// lengthPrefixed prefixes data with its length in four bytes, including the prefix if
+// inclusive is set
+func lengthPrefixed(inclusive bool, data ...byte) []byte {
+	n := len(data)
+	if inclusive {
+		n += 4
+	}
+	b := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(b, uint32(n))
+	return append(b, data...)
+}
+
+func TestMatch(t *testing.T) {
+	query := dnsQuery(t)
+	tcpQuery := append([]byte{0, byte(len(query))}, query...)
+
+	for name, tc := range map[string]struct {
+		transport protos.ProtocolType
+		payload   []byte
+		expected  string
+	}{
+		"tls":             {protos.TCP, []byte{0x16, 3, 1, 0, 0xf1, 1, 0, 0, 0xed, 3, 3}, TLS},
+		"http request":    {protos.TCP, []byte("GET /healthz HTTP/1.1\r\nHost: a\r\n\r\n"), HTTP},
+		"http response":   {protos.TCP, []byte("HTTP/1.1 200 OK\r\n"), HTTP},
+		"http2":           {protos.TCP, append([]byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"), 0, 0, 0, 4, 0, 0, 0, 0, 0), HTTP2},
+		"ssh":             {protos.TCP, []byte("SSH-2.0-OpenSSH_8.2p1\r\n"), SSH},
+		"dns":             {protos.UDP, query, DNS},
+		"dns over tcp":    {protos.TCP, tcpQuery, DNS},
+		"postgresql ssl":  {protos.TCP, lengthPrefixed(true, 0x04, 0xd2, 0x16, 0x2f), PostgreSQL},
+		"postgresql":      {protos.TCP, lengthPrefixed(true, append([]byte{0, 3, 0, 0}, "user\x00app\x00\x00"...)...), PostgreSQL},
+		"mysql":           {protos.TCP, append([]byte{14, 0, 0, 0, 10}, "8.0.21\x00\x08\x00\x00\x00\x01\x02"...), MySQL},
+		"mqtt":            {protos.TCP, append([]byte{0x10, 16, 0, 4}, "MQTT\x04\x02\x00\x3c\x00\x04test"...), MQTT},
+		"mqtt 3.1":        {protos.TCP, append([]byte{0x10, 18, 0, 6}, "MQIsdp\x03\x02\x00\x3c\x00\x04test"...), MQTT},
+		"kafka":           {protos.TCP, lengthPrefixed(false, append([]byte{0, 18, 0, 3, 0, 0, 0, 1, 0, 7}, "rdkafka\x00"...)...), Kafka},
+		"redis":           {protos.TCP, []byte("*1\r\n$4\r\nPING\r\n"), Redis},
+		"memcached":       {protos.TCP, []byte("get session:42\r\n"), Memcached},
+		"memcached bin":   {protos.TCP, append([]byte{0x80, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 3}, append(make([]byte, 12), "foo"...)...), Memcached},
+		"text":            {protos.TCP, []byte("hello world\r\n"), ""},
+		"binary":          {protos.TCP, []byte{0xde, 0xad, 0xbe, 0xef, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, ""},
+		"truncated mqtt":  {protos.TCP, []byte{0x10, 0x80}, ""},
+		"udp not dns":     {protos.UDP, []byte("GET / HTTP/1.1\r\n"), ""},
+		"tcp query alone": {protos.TCP, query, ""},
+	} {
+		if p, _ := New(tc.transport).match(tc.payload); p != tc.expected {
+			t.Errorf("%s: expected %q, got %q", name, tc.expected, p)
+		}
+	}
+}
+
+func TestClassifierGRPC(t *testing.T) {
+	c := New(protos.TCP)
+	if p, done := c.Add(false, []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")); p != HTTP2 || done {
+		t.Fatalf("Expected HTTP/2 pending gRPC detection, got %q", p)
+	}
+	// Server SETTINGS frame
+	if p, done := c.Add(true, []byte{0, 0, 0, 4, 0, 0, 0, 0, 0}); p != HTTP2 || done {
+		t.Fatalf("Expected HTTP/2 pending gRPC detection, got %q", p)
+	}
+	// HEADERS frame with a Huffman coded content type
+	headers := append([]byte{0, 0, 20, 1, 4, 0, 0, 0, 1, 0x5f, 0x8b}, hpack.AppendHuffmanString(nil, "application/grpc")...)
+	if p, done := c.Add(false, headers); p != GRPC || !done {
+		t.Errorf("Expected gRPC, got %q", p)
+	}
+}
+
+func TestClassifierGivesUp(t *testing.T) {
+	c := New(protos.TCP)
+	for i := 0; i < maxSegments; i++ {
+		if _, done := c.Add(false, []byte("hello")); done {
+			t.Fatal("Expected the classifier to wait for the other direction")
+		}
+	}
+	for i := 0; i < maxSegments; i++ {
+		c.Add(true, []byte("world"))
+	}
+	if p, done := c.Add(true, []byte("SSH-2.0-x\r\n")); p != "" || !done {
+		t.Errorf("Expected an unknown final classification, got %q", p)
+	}
+}
+
+func TestByPort(t *testing.T) {
+	if p := ByPort(protos.TCP, 40000, 5432); p != PostgreSQL {
+		t.Errorf("Expected postgresql, got %q", p)
+	}
+	if p := ByPort(protos.UDP, 5432); p != "" {
+		t.Errorf("Expected no protocol, got %q", p)
+	}
+}
@@ -0,0 +1,186 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package classify
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/http2/hpack"
+)
+
+var (
+	http2Preface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	grpcType     = []byte("application/grpc")
+	// grpcTypeHuffman is the Huffman coded content type, HPACK usually codes header values.
+	// The last byte contains padding, so only the full bytes are matched.
+	grpcTypeHuffman = func() []byte {
+		b := hpack.AppendHuffmanString(nil, string(grpcType))
+		return b[:len(b)-1]
+	}()
+
+	httpMethods = [][]byte{
+		[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("DELETE "), []byte("HEAD "),
+		[]byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "), []byte("TRACE "),
+	}
+	httpResponse = []byte("HTTP/1.")
+
+	memcachedCommands = [][]byte{
+		[]byte("get "), []byte("gets "), []byte("set "), []byte("add "), []byte("replace "),
+		[]byte("append "), []byte("prepend "), []byte("cas "), []byte("delete "), []byte("incr "),
+		[]byte("decr "), []byte("touch "), []byte("gat "), []byte("gats "), []byte("mg "),
+		[]byte("ms "), []byte("md "), []byte("ma "), []byte("stats\r\n"), []byte("version\r\n"),
+	}
+)
+
+// isTLS matches a handshake record, i.e. a ClientHello or ServerHello
+func isTLS(p []byte) bool {
+	return len(p) >= 6 && p[0] == 0x16 && p[1] == 3 && p[2] <= 4 && (p[5] == 1 || p[5] == 2)
+}
+
+// isHTTP2 matches the connection preface of a client with prior knowledge of HTTP/2
+func isHTTP2(p []byte) bool {
+	return bytes.HasPrefix(p, http2Preface)
+}
+
+func isGRPC(p []byte) bool {
+	return bytes.Contains(p, grpcType) || bytes.Contains(p, grpcTypeHuffman)
+}
+
+// isHTTP matches an HTTP/1.x request or status line
+func isHTTP(p []byte) bool {
+	if bytes.HasPrefix(p, httpResponse) {
+		return true
+	}
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(p, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSSH matches the protocol version exchange of client and server
+func isSSH(p []byte) bool {
+	return bytes.HasPrefix(p, []byte("SSH-"))
+}
+
+// isDNS matches a DNS message with at least one question
+func isDNS(p []byte) bool {
+	var d layers.DNS
+	if len(p) < 12 || d.DecodeFromBytes(p, gopacket.NilDecodeFeedback) != nil {
+		return false
+	}
+	return len(d.Questions) > 0 && len(d.Questions[0].Name) > 0
+}
+
+// isDNSOverTCP matches a DNS message prefixed with its length
+func isDNSOverTCP(p []byte) bool {
+	return len(p) > 2 && int(binary.BigEndian.Uint16(p)) == len(p)-2 && isDNS(p[2:])
+}
+
+// isPostgreSQL matches the startup, SSL and GSSAPI encryption requests of a client
+func isPostgreSQL(p []byte) bool {
+	if len(p) < 8 || int(binary.BigEndian.Uint32(p)) != len(p) {
+		return false
+	}
+	switch binary.BigEndian.Uint32(p[4:]) {
+	case 80877103, 80877104: // SSLRequest, GSSENCRequest
+		return len(p) == 8
+	case 196608: // Protocol 3.0
+		return true
+	}
+	return false
+}
+
+// isMySQL matches the initial handshake packet of a server with protocol version 10
+func isMySQL(p []byte) bool {
+	if len(p) < 6 {
+		return false
+	}
+	n := int(p[0]) | int(p[1])<<8 | int(p[2])<<16
+	return n == len(p)-4 && p[3] == 0 && p[4] == 10 && bytes.IndexByte(p[5:], 0) > 0
+}
+
+// isMQTT matches a CONNECT packet of MQTT 3.1, 3.1.1 or 5
+func isMQTT(p []byte) bool {
+	if len(p) < 2 || p[0] != 0x10 {
+		return false
+	}
+	// Skip the variable length remaining length
+	i := 1
+	for i < len(p) && i < 5 && p[i]&0x80 != 0 {
+		i++
+	}
+	if i >= len(p) {
+		return false
+	}
+	p = p[i+1:]
+	return bytes.HasPrefix(p, []byte("\x00\x04MQTT")) || bytes.HasPrefix(p, []byte("\x00\x06MQIsdp"))
+}
+
+// isKafka matches a request header with a known API key and version
+func isKafka(p []byte) bool {
+	if len(p) < 14 || int(binary.BigEndian.Uint32(p)) != len(p)-4 {
+		return false
+	}
+	key, version := int16(binary.BigEndian.Uint16(p[4:])), int16(binary.BigEndian.Uint16(p[6:]))
+	clientID := int(int16(binary.BigEndian.Uint16(p[12:])))
+	return key >= 0 && key <= 75 && version >= 0 && version <= 20 && clientID >= -1 && 14+clientID <= len(p)
+}
+
+// isRedis matches a command sent as RESP array of bulk strings, e.g. *1\r\n$4\r\nPING\r\n
+func isRedis(p []byte) bool {
+	if len(p) < 4 || p[0] != '*' {
+		return false
+	}
+	i := 1
+	for i < len(p) && p[i] >= '0' && p[i] <= '9' {
+		i++
+	}
+	return i > 1 && bytes.HasPrefix(p[i:], []byte("\r\n$"))
+}
+
+// isMemcached matches a command of the text or a request of the binary protocol
+func isMemcached(p []byte) bool {
+	if len(p) >= 24 && p[0] == 0x80 {
+		// Binary request header: magic, opcode, key length, extras length, data type 0
+		keyLen, extrasLen := int(binary.BigEndian.Uint16(p[2:])), int(p[4])
+		bodyLen := int(binary.BigEndian.Uint32(p[8:]))
+		return p[5] == 0 && keyLen+extrasLen <= bodyLen
+	}
+	if !bytes.HasSuffix(p, []byte("\r\n")) {
+		return false
+	}
+	for _, c := range memcachedCommands {
+		if bytes.HasPrefix(p, c) {
+			return true
+		}
+	}
+	return false
+}
@@ -28,39 +28,219 @@ package container
 import (
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/xvzf/insight/pkg/capture"
 	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/classify"
 	"github.com/xvzf/insight/pkg/flow/communityid"
+	"github.com/xvzf/insight/pkg/flow/tls"
+	"github.com/xvzf/insight/pkg/protos"
 )
 
 // Container takes in samples and aggregates them into flows
 type Container interface {
 	Add(s *capture.Sample) error
+	Expire(now time.Time) []*flow.Flow
 	Dump() []*flow.Flow
+	Len() int
 }
 
-type data struct {
+// Options configures a flow container
+type Options struct {
+	IdleTimeout   time.Duration // Export a flow after it has been idle for this long
+	ActiveTimeout time.Duration // Export a long-lived flow after it has been active for this long
+	Linger        time.Duration // Keep a terminated TCP flow this long after its last packet for trailing segments
+	MaxFlows      int           // Evict the least recently seen flows beyond this limit, unbounded if 0
+	Limit         *Limit        // Flow limit shared with other containers, created from MaxFlows if nil
+	Shards        int           // Number of independently locked flow table shards, rounded up to a power of two
+	InspectTLS    bool          // Extract the TLS hello messages of TCP flows, requires the packet payload
+	Classify      bool          // Identify the application protocol by the payload, falling back to the port
+	TCPMetrics    bool          // Measure the handshake RTT, retransmissions, zero windows and duplicate ACKs of TCP flows
+}
+
+// ErrTableFull is returned for samples of new flows if the flow table is full and the shard of
+// the flow has no flow to evict
+var ErrTableFull = errors.New("flow table full")
+
+// DefaultShards is the default number of flow table shards
+const DefaultShards = 64
+
+// DefaultLinger is the default time terminated TCP flows are kept, the final ACK of a FIN and
+// retransmitted FINs or RSTs follow within a few round trips
+const DefaultLinger = 2 * time.Second
+
+// Limit bounds the total number of flows of one or more containers
+type Limit struct {
+	max   int64
+	count int64
+}
+
+// NewLimit creates a limit of max flows, flow tables sharing it are bounded together
+func NewLimit(max int) *Limit {
+	return &Limit{max: int64(max)}
+}
+
+// reserve accounts for a new flow, it fails if the limit has been reached
+func (l *Limit) reserve() bool {
+	if l == nil {
+		return true
+	}
+	for {
+		n := atomic.LoadInt64(&l.count)
+		if n >= l.max {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&l.count, n, n+1) {
+			return true
+		}
+	}
+}
+
+// release accounts for a removed flow
+func (l *Limit) release() {
+	if l != nil {
+		atomic.AddInt64(&l.count, -1)
+	}
+}
+
+// entry is a flow in the flow table, linked into the LRU list of its shard
+type entry struct {
+	key        key
+	flow       *flow.Flow
+	prev, next *entry
+	tls        *tls.Handshake       // TLS handshake under inspection
+	classifier *classify.Classifier // Classification in progress
+	tcp        *tcpState            // Sequence analysis of a TCP flow
+}
+
+// shard is a part of the flow table protected by its own lock
+type shard struct {
 	sync.Mutex
-	flows map[string]*flow.Flow
+	flows   map[key]*entry
+	head    *entry       // Most recently seen flow
+	tail    *entry       // Least recently seen flow
+	limit   *Limit       // Limit of the flow table, unbounded if nil
+	removed []*flow.Flow // Flows evicted or superseded by a new connection since the last expiry
 }
 
 type container struct {
-	data   data
-	hasher communityid.Hasher
-	start  time.Time
+	shards        []shard
+	mask          uint32 // Selects the shard from a key hash
+	hasher        communityid.Hasher
+	idleTimeout   time.Duration // Export a flow after it has been idle for this long
+	activeTimeout time.Duration // Export a long-lived flow after it has been active for this long
+	linger        time.Duration // Keep a terminated TCP flow this long after its last packet
+	inspectTLS    bool
+	classify      bool
+	tcpMetrics    bool
+}
+
+// New creates a new flow container (flow cache) with NetFlow-style idle and active timeouts
+func New(idleTimeout, activeTimeout time.Duration) Container {
+	return NewWithOptions(Options{
+		IdleTimeout:   idleTimeout,
+		ActiveTimeout: activeTimeout,
+		Linger:        DefaultLinger,
+	})
 }
 
-// New creates a new flow container
-func New() Container {
-	return &container{
-		data: data{
-			flows: make(map[string]*flow.Flow),
-		},
-		hasher: communityid.NewHasher(0),
-		start:  time.Now(),
+// NewWithOptions creates a new flow container. The flow table is sharded by the flow tuple so
+// concurrent writers and the expiry only contend on a fraction of the flows.
+func NewWithOptions(opts Options) Container {
+	if opts.Shards <= 0 {
+		opts.Shards = DefaultShards
 	}
+	n := 1
+	for n < opts.Shards {
+		n <<= 1
+	}
+
+	// The limit is enforced on the whole table, a shard does not fill up while others are empty
+	if opts.Limit == nil && opts.MaxFlows > 0 {
+		opts.Limit = NewLimit(opts.MaxFlows)
+	}
+
+	c := &container{
+		shards:        make([]shard, n),
+		mask:          uint32(n - 1),
+		hasher:        communityid.NewHasher(0),
+		idleTimeout:   opts.IdleTimeout,
+		activeTimeout: opts.ActiveTimeout,
+		linger:        opts.Linger,
+		inspectTLS:    opts.InspectTLS,
+		classify:      opts.Classify,
+		tcpMetrics:    opts.TCPMetrics,
+	}
+	for i := range c.shards {
+		c.shards[i].flows = make(map[key]*entry)
+		c.shards[i].limit = opts.Limit
+	}
+	return c
+}
+
+// moveToFront marks e as the most recently seen flow
+func (s *shard) moveToFront(e *entry) {
+	if s.head == e {
+		return
+	}
+	s.unlink(e)
+	e.next = s.head
+	if s.head != nil {
+		s.head.prev = e
+	}
+	s.head = e
+	if s.tail == nil {
+		s.tail = e
+	}
+}
+
+// unlink removes e from the LRU list
+func (s *shard) unlink(e *entry) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else if s.head == e {
+		s.head = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else if s.tail == e {
+		s.tail = e.prev
+	}
+	e.prev, e.next = nil, nil
+}
+
+// insert adds e to the shard as the most recently seen flow, its slot of the limit has to be
+// reserved before
+func (s *shard) insert(e *entry) {
+	s.flows[e.key] = e
+	s.moveToFront(e)
+}
+
+// remove deletes e from the shard
+func (s *shard) remove(e *entry) {
+	s.unlink(e)
+	delete(s.flows, e.key)
+	s.limit.release()
+}
+
+// evict removes the least recently seen flow of the shard to make room for a new one, which
+// takes over its slot of the limit. Only the own shard can be locked, evict fails if it is
+// empty.
+func (s *shard) evict() bool {
+	e := s.tail
+	if e == nil {
+		return false
+	}
+	s.unlink(e)
+	delete(s.flows, e.key)
+	flowsEvicted.Inc()
+	if !e.flow.Start.IsZero() {
+		e.flow.EndReason = flow.EndLackOfResources
+		s.removed = append(s.removed, e.flow)
+	}
+	return true
 }
 
 // Adds a sample to the flowtable
@@ -71,60 +251,216 @@ func (c *container) Add(s *capture.Sample) error {
 		return errors.New("sample cannot be nil")
 	}
 
-	// Generate CommunityID for the packet
 	fm := s.FlowMeta()
-	cID := c.hasher.Hash(fm)
+	k := newKey(fm, s.Interface.Index)
+	sh := &c.shards[k.hash()&c.mask]
+
+	// Samples without a capture timestamp are accounted at the time they are added
+	ts := s.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
 
-	// Lock data container
-	c.data.Lock()
-	defer c.data.Unlock()
+	// Lock shard
+	sh.Lock()
+	defer sh.Unlock()
 
-	// Create a new flow if it is not already in the flowtable
-	if _, ok := c.data.flows[cID]; !ok {
-		c.data.flows[cID] = flow.New(fm.WithCorrectedSource())
+	// A new connection reusing the tuple of a terminated one starts a new flow
+	e, ok := sh.flows[k]
+	if ok && s.TCPFlags&(flow.TCPFlagSYN|flow.TCPFlagACK) == flow.TCPFlagSYN && e.flow.State().Terminated() {
+		sh.remove(e)
+		e.flow.EndReason = flow.EndOfFlow
+		sh.removed = append(sh.removed, e.flow)
+		ok = false
 	}
 
-	f, ok := c.data.flows[cID]
+	// Create a new flow if it is not already in the flowtable
 	if !ok {
-		return errors.New("internal error on mapping operation")
+		if !sh.limit.reserve() && !sh.evict() {
+			packetsRejected.Inc()
+			return ErrTableFull
+		}
+		e = &entry{key: k, flow: flow.New(fm.WithCorrectedSource())}
+		e.flow.Interface = s.Interface
+		if c.inspectTLS && fm.Transport == protos.TCP {
+			e.tls = tls.NewHandshake()
+		}
+		if c.tcpMetrics && fm.Transport == protos.TCP {
+			e.tcp = &tcpState{}
+		}
+		if c.classify && (fm.Transport == protos.TCP || fm.Transport == protos.UDP) {
+			e.classifier = classify.New(fm.Transport)
+		}
+		sh.insert(e)
+	} else {
+		sh.moveToFront(e)
 	}
+	f := e.flow
 
-	// Update counters
-	if f.Meta.Src.Equal(s.Src) {
-		// Incoming (Src -> Dst)
-		f.Incoming.Packets++
-		f.Incoming.Bytes += uint64(s.Bytes)
-	} else {
-		// Outgoing (Dst -> Src)
-		f.Outgoing.Packets++
-		f.Outgoing.Bytes += uint64(s.Bytes)
+	// Update first-seen/last-seen timestamps
+	if f.Start.IsZero() {
+		f.Start = ts
+	}
+	if ts.After(f.End) {
+		f.End = ts
+	}
+
+	// Update counters, incoming (Src -> Dst) or outgoing (Dst -> Src)
+	incoming := f.Meta.Src.Equal(s.Src)
+	counters, dir := &f.Incoming, 0
+	if !incoming {
+		counters, dir = &f.Outgoing, 1
+	}
+	counters.Packets++
+	counters.Bytes += uint64(s.Bytes)
+	counters.TCPFlags |= s.TCPFlags
+
+	if e.tcp != nil {
+		if rtt := e.tcp.add(s, dir, counters, ts); rtt != 0 {
+			f.RTT = rtt
+		}
+	}
+
+	if e.tls != nil {
+		done := e.tls.Add(!incoming, s.Seq, s.TCPFlags.Has(flow.TCPFlagSYN), s.Payload)
+		if t := e.tls.TLS(); t != nil {
+			f.TLS = t
+		}
+		if done {
+			e.tls = nil
+		}
+	}
+
+	if e.classifier != nil && len(s.Payload) > 0 {
+		p, done := e.classifier.Add(!incoming, s.Payload)
+		if p != "" {
+			f.Protocol, f.ProtoSource = p, flow.ProtoSourcePayload
+		}
+		if done {
+			e.classifier = nil
+		}
 	}
 
 	return nil
 }
 
-// Dump dumps all flows in the container
-func (c *container) Dump() []*flow.Flow {
+// Expire returns all flows which terminated (TCP FIN/RST) at least the linger time ago, reached
+// their idle or active timeout at the given time or have been evicted from a full flow table.
+// Terminated and idle flows are removed from the flowtable; active flows are kept with reset
+// counters so the next record of a long-lived flow continues where the exported one stopped.
+func (c *container) Expire(now time.Time) []*flow.Flow {
+	var buf []*flow.Flow
+
+	for i := range c.shards {
+		sh := &c.shards[i]
+
+		// Lock shard
+		sh.Lock()
+
+		buf = append(buf, sh.removed...)
+		sh.removed = nil
 
-	// Lock data container
-	c.data.Lock()
-	defer c.data.Unlock()
+		for _, e := range sh.flows {
+			f := e.flow
+			switch {
+			case f.State().Terminated():
+				if now.Sub(f.End) < c.linger {
+					// Trailing segments, e.g. the last ACK, are still accounted to the flow
+					continue
+				}
+				sh.remove(e)
+				// No packets since the last active timeout export
+				if f.Start.IsZero() {
+					continue
+				}
+				f.EndReason = flow.EndOfFlow
+			case now.Sub(f.End) >= c.idleTimeout:
+				sh.remove(e)
+				// Flow did not see any packets since the last active timeout export
+				if f.Start.IsZero() {
+					continue
+				}
+				f.EndReason = flow.EndIdleTimeout
+			case !f.Start.IsZero() && now.Sub(f.Start) >= c.activeTimeout:
+				// Keep the flow (and therefore its orientation) in the flowtable, the handshakes
+				// describe the whole connection. The TCP flags are kept as well, so every record
+				// reports the state of the connection rather than of its last segments.
+				e.flow = &flow.Flow{
+					Meta:      f.Meta,
+					Incoming:  flow.Counters{TCPFlags: f.Incoming.TCPFlags},
+					Outgoing:  flow.Counters{TCPFlags: f.Outgoing.TCPFlags},
+					End:       f.End,
+					Interface: f.Interface,
+					TLS:       f.TLS,
+					RTT:       f.RTT,
+				}
+				if f.ProtoSource == flow.ProtoSourcePayload {
+					e.flow.Protocol, e.flow.ProtoSource = f.Protocol, f.ProtoSource
+				}
+				f.EndReason = flow.EndActiveTimeout
+			default:
+				continue
+			}
+			buf = append(buf, f)
+		}
+
+		sh.Unlock()
+	}
+
+	c.complete(buf)
+	return buf
+}
 
+// Dump flushes all flows in the container
+func (c *container) Dump() []*flow.Flow {
 	var buf []*flow.Flow
 
-	// end timestamp
-	end := time.Now()
+	for i := range c.shards {
+		sh := &c.shards[i]
+
+		// Lock shard
+		sh.Lock()
+
+		buf = append(buf, sh.removed...)
+		sh.removed = nil
 
-	// Iterate over the hashmap and set the CommunityID attribute
-	for cID, flow := range c.data.flows {
-		// Update flow parameters
-		flow.CommunityID = cID
-		flow.Start = c.start
-		flow.End = end
+		for _, e := range sh.flows {
+			sh.remove(e)
+			if e.flow.Start.IsZero() {
+				continue
+			}
+			e.flow.EndReason = flow.EndForced
+			buf = append(buf, e.flow)
+		}
 
-		// Add to result buffer
-		buf = append(buf, flow)
+		sh.Unlock()
 	}
 
+	c.complete(buf)
 	return buf
 }
+
+// complete computes the CommunityID of exported flows and the protocol of flows not identified
+// by their payload, outside of the packet path
+func (c *container) complete(flows []*flow.Flow) {
+	for _, f := range flows {
+		f.CommunityID = c.hasher.Hash(f.Meta)
+		if c.classify && f.Protocol == "" {
+			if p := classify.ByPort(f.Meta.Transport, f.Meta.DstPort, f.Meta.SrcPort); p != "" {
+				f.Protocol, f.ProtoSource = p, flow.ProtoSourcePort
+			}
+		}
+	}
+}
+
+// Len returns the number of active flows
+func (c *container) Len() int {
+	n := 0
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.Lock()
+		n += len(sh.flows)
+		sh.Unlock()
+	}
+	return n
+}
@@ -26,6 +26,7 @@
 package container
 
 import (
+	"math/rand"
 	"net"
 	"testing"
 	"time"
@@ -141,24 +142,24 @@ func init() {
 }
 
 func TestNewContainer(t *testing.T) {
-	c := New()
+	c := New(15*time.Second, time.Minute)
 	cRaw, ok := c.(*container)
 	if !ok {
 		t.Error("Container not initalized")
 	}
 
-	if cRaw.start.Sub(time.Now()) > 0 {
-		t.Error("Start time not set correctly")
+	if cRaw.idleTimeout != 15*time.Second || cRaw.activeTimeout != time.Minute {
+		t.Error("Timeouts not set correctly")
 	}
 
-	if cRaw.data.flows == nil {
+	if len(cRaw.shards) != DefaultShards || cRaw.shards[0].flows == nil {
 		t.Error("datastructures not initalized")
 	}
 
 }
 
 func TestContainerAddInvalidSample(t *testing.T) {
-	c := New()
+	c := New(15*time.Second, time.Minute)
 
 	if err := c.Add(nil); err == nil {
 		t.Error("Invalid error, expected error got nil")
@@ -166,7 +167,7 @@ func TestContainerAddInvalidSample(t *testing.T) {
 }
 
 func TestContainerDump(t *testing.T) {
-	c := New()
+	c := New(15*time.Second, time.Minute)
 
 	for _, s := range testSamples {
 		if c.Add(s) != nil {
@@ -176,10 +177,15 @@ func TestContainerDump(t *testing.T) {
 	}
 
 	for _, f := range c.Dump() {
-		// Reset timers and CommunityID so we can compare the rest
+		if f.EndReason != flow.EndForced {
+			t.Errorf("Expected end reason %d, got %d", flow.EndForced, f.EndReason)
+		}
+
+		// Reset timers, end reason and CommunityID so we can compare the rest
 		f.Start = time.Time{}
 		f.End = time.Time{}
 		f.CommunityID = ""
+		f.EndReason = 0
 
 		found := false
 		for _, testFlow := range testFlows {
@@ -195,3 +201,575 @@ func TestContainerDump(t *testing.T) {
 		}
 	}
 }
+
+func TestContainerDumpFlushes(t *testing.T) {
+	c := New(15*time.Second, time.Minute)
+
+	for _, s := range testSamples {
+		c.Add(s)
+	}
+	if n := c.Len(); n != len(testFlows) {
+		t.Errorf("Expected %d active flows, got %d", len(testFlows), n)
+	}
+
+	if n := len(c.Dump()); n != len(testFlows) {
+		t.Errorf("Expected %d flows, got %d", len(testFlows), n)
+	}
+	if n := len(c.Dump()); n != 0 || c.Len() != 0 {
+		t.Errorf("Expected empty container after dump, got %d flows", n)
+	}
+}
+
+func TestContainerExpireIdle(t *testing.T) {
+	c := New(15*time.Second, time.Minute)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, s := range tcpTestSamples[:3] {
+		s := *s
+		s.Timestamp = start.Add(time.Duration(i) * time.Second)
+		c.Add(&s)
+	}
+
+	if flows := c.Expire(start.Add(16 * time.Second)); len(flows) != 0 {
+		t.Errorf("Flow expired too early: %v", flows)
+	}
+
+	flows := c.Expire(start.Add(17 * time.Second))
+	if len(flows) != 1 {
+		t.Fatalf("Expected 1 idle flow, got %d", len(flows))
+	}
+
+	f := flows[0]
+	if f.EndReason != flow.EndIdleTimeout {
+		t.Errorf("Expected end reason %d, got %d", flow.EndIdleTimeout, f.EndReason)
+	}
+	if !f.Start.Equal(start) || !f.End.Equal(start.Add(2*time.Second)) {
+		t.Errorf("Invalid flow timestamps %s - %s", f.Start, f.End)
+	}
+	if f.CommunityID == "" {
+		t.Error("CommunityID not set")
+	}
+
+	if flows := c.Expire(start.Add(time.Hour)); len(flows) != 0 {
+		t.Errorf("Idle flow has not been removed: %v", flows)
+	}
+}
+
+func TestContainerExpireActive(t *testing.T) {
+	c := New(15*time.Second, time.Minute)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Long-lived flow with one packet every 10 seconds
+	var exported []*flow.Flow
+	for i := 0; i <= 18; i++ {
+		s := *tcpTestSamples[i%2]
+		s.Timestamp = start.Add(time.Duration(i) * 10 * time.Second)
+		c.Add(&s)
+		exported = append(exported, c.Expire(s.Timestamp)...)
+	}
+	exported = append(exported, c.Expire(start.Add(time.Hour))...)
+
+	if len(exported) != 3 {
+		t.Fatalf("Expected 3 flow records, got %d", len(exported))
+	}
+
+	var packets uint64
+	for i, f := range exported {
+		if f.CommunityID != exported[0].CommunityID {
+			t.Error("CommunityID changed between exports of the same flow")
+		}
+		if !cmp.Equal(f.Meta, exported[0].Meta) {
+			t.Error(cmp.Diff(exported[0].Meta, f.Meta))
+		}
+		if i > 0 && !f.Start.After(exported[i-1].End) {
+			t.Error("Flow records overlap")
+		}
+		packets += f.Incoming.Packets + f.Outgoing.Packets
+	}
+
+	if exported[0].EndReason != flow.EndActiveTimeout || exported[2].EndReason != flow.EndIdleTimeout {
+		t.Error("Invalid end reasons")
+	}
+	if packets != 19 {
+		t.Errorf("Expected 19 packets accounted, got %d", packets)
+	}
+}
+
+func TestContainerExpireActiveClose(t *testing.T) {
+	c := New(time.Minute, 30*time.Second)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Client is 10.0.0.2:345, the connection is closed across an active timeout export
+	var exported []*flow.Flow
+	for _, p := range []struct {
+		client bool
+		offset time.Duration
+		flags  flow.TCPFlags
+	}{
+		{true, 0, flow.TCPFlagSYN},
+		{false, time.Millisecond, flow.TCPFlagSYN | flow.TCPFlagACK},
+		{true, 2 * time.Millisecond, flow.TCPFlagACK},
+		{true, 20 * time.Second, flow.TCPFlagFIN | flow.TCPFlagACK},
+		{false, 31 * time.Second, flow.TCPFlagFIN | flow.TCPFlagACK},
+		{true, 31*time.Second + time.Millisecond, flow.TCPFlagACK},
+	} {
+		s := *tcpTestSamples[0]
+		if p.client {
+			s = *tcpTestSamples[1]
+		}
+		s.Timestamp = start.Add(p.offset)
+		s.TCPFlags = p.flags
+		exported = append(exported, c.Expire(s.Timestamp)...)
+		c.Add(&s)
+	}
+	exported = append(exported, c.Expire(start.Add(31*time.Second+DefaultLinger+time.Millisecond))...)
+
+	if len(exported) != 2 {
+		t.Fatalf("Expected 2 flow records, got %d", len(exported))
+	}
+	for i, expected := range []struct {
+		reason  flow.EndReason
+		state   flow.ConnectionState
+		packets uint64
+	}{
+		{flow.EndActiveTimeout, flow.StateEstablished, 4},
+		{flow.EndOfFlow, flow.StateClosed, 2},
+	} {
+		f := exported[i]
+		if f.EndReason != expected.reason || f.State() != expected.state {
+			t.Errorf("%d: expected %s (reason %d), got %s (reason %d)", i, expected.state, expected.reason, f.State(), f.EndReason)
+		}
+		if packets := f.Incoming.Packets + f.Outgoing.Packets; packets != expected.packets {
+			t.Errorf("%d: expected %d packets, got %d", i, expected.packets, packets)
+		}
+	}
+	if c.Len() != 0 {
+		t.Error("Closed flow has not been removed")
+	}
+}
+
+func TestContainerExpireTerminated(t *testing.T) {
+	c := New(15*time.Second, time.Minute)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, flags := range []flow.TCPFlags{
+		flow.TCPFlagSYN,
+		flow.TCPFlagSYN | flow.TCPFlagACK,
+		flow.TCPFlagACK,
+		flow.TCPFlagFIN | flow.TCPFlagACK,
+	} {
+		// Client is 10.0.0.2:345
+		s := *tcpTestSamples[(i+1)%2]
+		s.Timestamp = start.Add(time.Duration(i) * time.Millisecond)
+		s.TCPFlags = flags
+		c.Add(&s)
+	}
+
+	if flows := c.Expire(start.Add(time.Second)); len(flows) != 0 {
+		t.Errorf("Flow terminated too early: %v", flows)
+	}
+
+	// FIN of the client
+	s := *tcpTestSamples[1]
+	s.Timestamp = start.Add(5 * time.Millisecond)
+	s.TCPFlags = flow.TCPFlagFIN | flow.TCPFlagACK
+	c.Add(&s)
+
+	// The final ACK arrives after an expiry, it must not create a new flow
+	if flows := c.Expire(start.Add(10 * time.Millisecond)); len(flows) != 0 {
+		t.Errorf("Flow exported before the linger time: %v", flows)
+	}
+	s = *tcpTestSamples[0]
+	s.Timestamp = start.Add(15 * time.Millisecond)
+	s.TCPFlags = flow.TCPFlagACK
+	c.Add(&s)
+	if n := c.Len(); n != 1 {
+		t.Errorf("Expected 1 active flow, got %d", n)
+	}
+
+	if flows := c.Expire(start.Add(time.Second)); len(flows) != 0 {
+		t.Errorf("Flow exported before the linger time: %v", flows)
+	}
+	flows := c.Expire(start.Add(15*time.Millisecond + DefaultLinger))
+	if len(flows) != 1 {
+		t.Fatalf("Expected 1 terminated flow, got %d", len(flows))
+	}
+	if flows[0].EndReason != flow.EndOfFlow || flows[0].State() != flow.StateClosed {
+		t.Errorf("Expected closed flow, got state %s (reason %d)", flows[0].State(), flows[0].EndReason)
+	}
+	if packets := flows[0].Incoming.Packets + flows[0].Outgoing.Packets; packets != 6 {
+		t.Errorf("Expected 6 packets accounted, got %d", packets)
+	}
+	if c.Len() != 0 {
+		t.Error("Terminated flow has not been removed")
+	}
+}
+
+func TestContainerTerminatedPortReuse(t *testing.T) {
+	c := New(15*time.Second, time.Minute)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, flags := range []flow.TCPFlags{
+		flow.TCPFlagSYN,
+		flow.TCPFlagRST | flow.TCPFlagACK,
+		flow.TCPFlagSYN,
+	} {
+		// Client is 10.0.0.2:345
+		s := *tcpTestSamples[(i+1)%2]
+		s.Timestamp = start.Add(time.Duration(i) * time.Millisecond)
+		s.TCPFlags = flags
+		c.Add(&s)
+	}
+
+	// The reset connection is exported with the next expiry, the new one is kept
+	flows := c.Expire(start.Add(time.Second))
+	if len(flows) != 1 || flows[0].EndReason != flow.EndOfFlow || flows[0].State() != flow.StateReset {
+		t.Fatalf("Expected the reset flow, got %v", flows)
+	}
+	if n := c.Len(); n != 1 {
+		t.Errorf("Expected the new connection to be tracked, got %d flows", n)
+	}
+}
+
+func TestNewContainerShards(t *testing.T) {
+	c := NewWithOptions(Options{Shards: 5, MaxFlows: 100}).(*container)
+
+	if len(c.shards) != 8 || c.mask != 7 {
+		t.Errorf("Expected 8 shards, got %d", len(c.shards))
+	}
+	if c.shards[0].limit == nil || c.shards[0].limit != c.shards[7].limit || c.shards[0].limit.max != 100 {
+		t.Error("Expected the limit to be shared by the shards")
+	}
+}
+
+func TestKey(t *testing.T) {
+	for _, samples := range [][]*capture.Sample{tcpTestSamples[:3], tcpTestSamples[3:], udpTestSamples[:3], icmp4TestSamples, icmp6TestSamples} {
+		k := newKey(samples[0].FlowMeta(), 0)
+		for _, s := range samples[1:] {
+			if newKey(s.FlowMeta(), 0) != k {
+				t.Errorf("Both directions of a flow have to map to the same key: %s", s)
+			}
+		}
+	}
+
+	// Same ports on both sides
+	a := flow.Meta{Transport: protos.UDP, Src: net.ParseIP("10.0.0.1"), Dst: net.ParseIP("10.0.0.2"), SrcPort: 53, DstPort: 53}
+	b := flow.Meta{Transport: protos.UDP, Src: net.ParseIP("10.0.0.2"), Dst: net.ParseIP("10.0.0.1"), SrcPort: 53, DstPort: 53}
+	if newKey(a, 0) != newKey(b, 0) {
+		t.Error("Both directions of a flow have to map to the same key")
+	}
+
+	// 4 and 16 byte representation of an IPv4 address
+	a.Src, a.Dst = a.Src.To4(), a.Dst.To4()
+	if newKey(a, 0) != newKey(b, 0) {
+		t.Error("IPv4 address representation changed the key")
+	}
+
+	if newKey(a, 1) == newKey(b, 2) {
+		t.Error("Different interfaces have to map to different keys")
+	}
+
+	a.Transport = protos.TCP
+	if newKey(a, 0) == newKey(b, 0) {
+		t.Error("Different transports have to map to different keys")
+	}
+}
+
+func TestContainerInterfaces(t *testing.T) {
+	c := New(15*time.Second, time.Minute)
+
+	// The same flow observed on the pod and the host interface
+	for _, i := range []flow.Interface{{Name: "cali1234", Index: 12}, {Name: "eth0", Index: 2}} {
+		for _, s := range tcpTestSamples[:3] {
+			s := *s
+			s.Interface = i
+			c.Add(&s)
+		}
+	}
+
+	flows := c.Dump()
+	if len(flows) != 2 {
+		t.Fatalf("Expected 2 flows, got %d", len(flows))
+	}
+	for _, f := range flows {
+		if f.Incoming.Packets+f.Outgoing.Packets != 3 {
+			t.Errorf("Expected 3 packets on %s, got %d", f.Interface.Name, f.Incoming.Packets+f.Outgoing.Packets)
+		}
+		if (f.Interface.Name == "eth0") != (f.Interface.Index == 2) {
+			t.Errorf("Invalid interface %v", f.Interface)
+		}
+	}
+	if flows[0].CommunityID != flows[1].CommunityID {
+		t.Error("CommunityID has to be independent of the interface")
+	}
+}
+
+func TestContainerEvict(t *testing.T) {
+	c := NewWithOptions(Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute, MaxFlows: 1, Shards: 1})
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, s := range []*capture.Sample{tcpTestSamples[0], udpTestSamples[0], tcpTestSamples[1]} {
+		s := *s
+		s.Timestamp = start.Add(time.Duration(i) * time.Second)
+		c.Add(&s)
+	}
+	if n := c.Len(); n != 1 {
+		t.Errorf("Expected 1 active flow, got %d", n)
+	}
+
+	flows := c.Expire(start.Add(3 * time.Second))
+	if len(flows) != 2 {
+		t.Fatalf("Expected 2 evicted flows, got %d", len(flows))
+	}
+	for i, transport := range []protos.ProtocolType{protos.TCP, protos.UDP} {
+		if flows[i].Meta.Transport != transport || flows[i].EndReason != flow.EndLackOfResources {
+			t.Errorf("Expected evicted %s flow, got %s (reason %d)", transport, flows[i].Meta.Transport, flows[i].EndReason)
+		}
+		if flows[i].CommunityID == "" {
+			t.Error("CommunityID not set")
+		}
+	}
+}
+
+// udpFlow creates a sample of the n-th UDP flow between two hosts
+func udpFlow(n int) *capture.Sample {
+	return &capture.Sample{
+		Transport: protos.UDP,
+		Src:       net.ParseIP("10.0.0.1"),
+		SrcPort:   uint16(10000 + n),
+		Dst:       net.ParseIP("10.0.0.2"),
+		DstPort:   53,
+		Bytes:     64,
+	}
+}
+
+func TestContainerSmallLimit(t *testing.T) {
+	c := NewWithOptions(Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute, MaxFlows: 10})
+
+	// The limit applies to the whole table, not to each of the shards
+	for i := 0; i < 10; i++ {
+		c.Add(udpFlow(i))
+	}
+	if n := c.Len(); n != 10 {
+		t.Errorf("Expected 10 active flows, got %d", n)
+	}
+	if flows := c.Expire(time.Now()); len(flows) != 0 {
+		t.Errorf("Expected no evicted flows, got %d", len(flows))
+	}
+}
+
+func TestContainerSharedLimit(t *testing.T) {
+	limit := NewLimit(4)
+	a := NewWithOptions(Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute, Limit: limit, Shards: 1})
+	b := NewWithOptions(Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute, Limit: limit, Shards: 1})
+
+	for i := 0; i < 2; i++ {
+		a.Add(udpFlow(i))
+		b.Add(udpFlow(i))
+	}
+	if flows := append(a.Expire(time.Now()), b.Expire(time.Now())...); len(flows) != 0 {
+		t.Fatalf("Expected no evicted flows, got %d", len(flows))
+	}
+
+	// The fifth flow evicts the least recently seen one of its own table
+	a.Add(udpFlow(2))
+	flows := a.Expire(time.Now())
+	if len(flows) != 1 || flows[0].Meta.SrcPort != 10000 || flows[0].EndReason != flow.EndLackOfResources {
+		t.Fatalf("Expected the first flow to be evicted, got %v", flows)
+	}
+
+	// Removed flows free up the limit
+	b.Dump()
+	a.Add(udpFlow(3))
+	if flows := a.Expire(time.Now()); len(flows) != 0 || a.Len() != 3 {
+		t.Errorf("Expected no evicted flows, got %d", len(flows))
+	}
+}
+
+func TestContainerLimitEmptyShard(t *testing.T) {
+	limit := NewLimit(2)
+	a := NewWithOptions(Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute, Limit: limit, Shards: 1})
+	b := NewWithOptions(Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute, Limit: limit, Shards: 1})
+
+	for i := 0; i < 2; i++ {
+		if err := a.Add(udpFlow(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing to evict in the table of the new flow, the limit is not exceeded
+	if err := b.Add(udpFlow(2)); err != ErrTableFull {
+		t.Errorf("Expected %v, got %v", ErrTableFull, err)
+	}
+	if a.Len() != 2 || b.Len() != 0 {
+		t.Errorf("Expected 2 and 0 flows, got %d and %d", a.Len(), b.Len())
+	}
+	// Known flows are still accounted
+	if err := a.Add(udpFlow(0)); err != nil {
+		t.Error(err)
+	}
+
+	a.Dump()
+	if err := b.Add(udpFlow(2)); err != nil || b.Len() != 1 {
+		t.Errorf("Expected the flow to be added after flows were removed: %v", err)
+	}
+}
+
+func TestContainerEvictLeastRecentlySeen(t *testing.T) {
+	c := NewWithOptions(Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute, MaxFlows: 2, Shards: 1})
+
+	c.Add(tcpTestSamples[0])
+	c.Add(udpTestSamples[0])
+	// Refresh the TCP flow, the UDP flow is evicted next
+	c.Add(tcpTestSamples[1])
+	c.Add(icmp4TestSamples[0])
+
+	flows := c.Expire(time.Now())
+	if len(flows) != 1 || flows[0].Meta.Transport != protos.UDP {
+		t.Fatalf("Expected the UDP flow to be evicted, got %v", flows)
+	}
+}
+
+// benchmarkSamples creates packets of n distinct flows in both directions
+func benchmarkSamples(n int) []*capture.Sample {
+	samples := make([]*capture.Sample, 0, 2*n)
+	for i := 0; i < n; i++ {
+		src := net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)).To4()
+		dst := net.IPv4(10, 255, 0, 1).To4()
+		port := uint16(1024 + i%60000)
+		samples = append(samples,
+			&capture.Sample{Transport: protos.TCP, Src: src, Dst: dst, SrcPort: port, DstPort: 443, Bytes: 1500, TCPFlags: flow.TCPFlagACK},
+			&capture.Sample{Transport: protos.TCP, Src: dst, Dst: src, SrcPort: 443, DstPort: port, Bytes: 64, TCPFlags: flow.TCPFlagACK},
+		)
+	}
+	return samples
+}
+
+// reportThroughput reports the processed packets per second
+func reportThroughput(b *testing.B, start time.Time) {
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "pkts/s")
+}
+
+func BenchmarkContainerAdd(b *testing.B) {
+	samples := benchmarkSamples(1 << 16)
+	c := New(15*time.Second, time.Minute)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		c.Add(samples[i%len(samples)])
+	}
+	reportThroughput(b, start)
+}
+
+func BenchmarkContainerAddParallel(b *testing.B) {
+	samples := benchmarkSamples(1 << 16)
+	c := New(15*time.Second, time.Minute)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Intn(len(samples))
+		for pb.Next() {
+			c.Add(samples[i%len(samples)])
+			i++
+		}
+	})
+	reportThroughput(b, start)
+}
+
+func BenchmarkContainerAddEvict(b *testing.B) {
+	samples := benchmarkSamples(1 << 18)
+	c := NewWithOptions(Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute, MaxFlows: 1 << 14})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		c.Add(samples[i%len(samples)])
+		// Drain evicted flows like the probe does
+		if i%(1<<16) == 0 {
+			c.Expire(time.Now())
+		}
+	}
+	reportThroughput(b, start)
+}
+
+func TestContainerTLS(t *testing.T) {
+	c := NewWithOptions(Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute, InspectTLS: true})
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// ClientHello for a.io with a single cipher suite, split over two segments
+	hello := append([]byte{
+		22, 3, 1, 0, 60, // Record
+		1, 0, 0, 56, // Handshake
+		3, 3}, make([]byte, 33)...) // Version, random and session ID
+	hello = append(hello,
+		0, 2, 0x13, 0x01, // Cipher suites
+		1, 0, // Compression methods
+		0, 13, 0, 0, 0, 9, 0, 7, 0, 0, 4, 'a', '.', 'i', 'o') // Server name
+
+	syn := *tcpTestSamples[1]
+	syn.TCPFlags, syn.Seq = flow.TCPFlagSYN, 99
+	first, second := *tcpTestSamples[1], *tcpTestSamples[1]
+	first.Seq, first.Payload = 100, hello[:20]
+	second.Seq, second.Payload = 120, hello[20:]
+	for i, s := range []capture.Sample{syn, first, second} {
+		s.Timestamp = start.Add(time.Duration(i) * time.Second)
+		c.Add(&s)
+	}
+	ack := *tcpTestSamples[0]
+	ack.Timestamp = start.Add(55 * time.Second)
+	c.Add(&ack)
+
+	// The handshake is kept across active timeout exports
+	exported := c.Expire(start.Add(time.Minute))
+	ack.Timestamp = start.Add(65 * time.Second)
+	c.Add(&ack)
+	exported = append(exported, c.Dump()...)
+	if len(exported) != 2 {
+		t.Fatalf("Expected 2 flow records, got %d", len(exported))
+	}
+	for _, f := range exported {
+		if f.TLS == nil || f.TLS.ServerName != "a.io" || f.TLS.JA4 == "" {
+			t.Errorf("Expected the ClientHello of a.io, got %+v", f.TLS)
+		}
+	}
+
+	// Flows are not annotated without TLS inspection
+	c = New(15*time.Second, time.Minute)
+	c.Add(&first)
+	c.Add(&second)
+	if f := c.Dump(); f[0].TLS != nil {
+		t.Errorf("Expected no TLS inspection, got %+v", f[0].TLS)
+	}
+}
+
+func TestContainerClassify(t *testing.T) {
+	c := NewWithOptions(Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute, Classify: true})
+
+	// Redis on a non-standard port
+	redis := *tcpTestSamples[1]
+	redis.Payload = []byte("*1\r\n$4\r\nPING\r\n")
+	c.Add(&redis)
+
+	// Unidentified payload to the PostgreSQL port
+	postgres := capture.Sample{Transport: protos.TCP, SrcPort: 40000, DstPort: 5432, Src: net.ParseIP("10.0.0.1"), Dst: net.ParseIP("10.0.0.3"), Bytes: 100, Payload: []byte("?")}
+	c.Add(&postgres)
+
+	flows := c.Dump()
+	if len(flows) != 2 {
+		t.Fatalf("Expected 2 flows, got %d", len(flows))
+	}
+	for _, f := range flows {
+		expected := [2]string{"redis", flow.ProtoSourcePayload}
+		if f.Meta.DstPort == 5432 {
+			expected = [2]string{"postgresql", flow.ProtoSourcePort}
+		}
+		if got := [2]string{f.Protocol, f.ProtoSource}; got != expected {
+			t.Errorf("Expected %v, got %v", expected, got)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package container
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/common"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+// key is the compact binary 5-tuple identifying a flow in the flow table. Endpoints are ordered
+// the same way as for the CommunityID, both directions of a flow therefore map to the same key.
+// Flows are accounted per observation interface, like NetFlow does for the ingress interface.
+type key struct {
+	ip0, ip1     [net.IPv6len]byte // IP addresses, IPv4 addresses are stored IPv4-mapped
+	port0, port1 uint16            // Ports, ICMP type/code port equivalents
+	transport    protos.ProtocolType
+	ifindex      int32 // Observation interface
+}
+
+// putIP stores ip in its 16 byte representation without allocating
+func putIP(dst *[net.IPv6len]byte, ip net.IP) {
+	if len(ip) == net.IPv4len {
+		copy(dst[:], net.IPv6zero[:10])
+		dst[10], dst[11] = 0xff, 0xff
+		copy(dst[12:], ip)
+		return
+	}
+	copy(dst[:], ip)
+}
+
+// newKey creates the flow table key of a flow tuple observed on the given interface
+func newKey(m flow.Meta, ifindex int) key {
+	switch m.Transport {
+	case protos.ICMP4:
+		m.SrcPort, m.DstPort, _ = common.GetICMPv4PortEquivalents(m.IcmpType, m.IcmpCode)
+	case protos.ICMP6:
+		m.SrcPort, m.DstPort, _ = common.GetICMPv6PortEquivalents(m.IcmpType, m.IcmpCode)
+	}
+
+	k := key{transport: m.Transport, ifindex: int32(ifindex)}
+	putIP(&k.ip0, m.Src)
+	putIP(&k.ip1, m.Dst)
+	k.port0, k.port1 = m.SrcPort, m.DstPort
+
+	cmp := bytes.Compare(k.ip0[:], k.ip1[:])
+	if cmp > 0 || (cmp == 0 && k.port0 > k.port1) {
+		k.ip0, k.ip1 = k.ip1, k.ip0
+		k.port0, k.port1 = k.port1, k.port0
+	}
+	return k
+}
+
+// FNV-1a parameters
+const (
+	fnvOffset = 2166136261
+	fnvPrime  = 16777619
+)
+
+// hash computes the FNV-1a hash of the key, used to select the shard
+func (k *key) hash() uint32 {
+	h := uint32(fnvOffset)
+	for _, b := range k.ip0 {
+		h = (h ^ uint32(b)) * fnvPrime
+	}
+	for _, b := range k.ip1 {
+		h = (h ^ uint32(b)) * fnvPrime
+	}
+	for _, b := range [...]byte{
+		byte(k.port0 >> 8), byte(k.port0), byte(k.port1 >> 8), byte(k.port1), byte(k.transport),
+		byte(k.ifindex >> 24), byte(k.ifindex >> 16), byte(k.ifindex >> 8), byte(k.ifindex),
+	} {
+		h = (h ^ uint32(b)) * fnvPrime
+	}
+	return h
+}
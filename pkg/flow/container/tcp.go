@@ -0,0 +1,130 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package container
+
+import (
+	"time"
+
+	"github.com/xvzf/insight/pkg/capture"
+	"github.com/xvzf/insight/pkg/flow"
+)
+
+// defaultReorderWindow separates out-of-order segments from retransmissions while the round
+// trip time of a connection is unknown
+const defaultReorderWindow = 3 * time.Millisecond
+
+// tcpDirection tracks the segments sent by one endpoint of a TCP connection
+type tcpDirection struct {
+	started  bool
+	next     uint32    // Sequence number following the highest segment sent
+	advanced time.Time // Last segment advancing the sequence
+	acked    bool
+	ack      uint32 // Last acknowledgment number
+	window   uint16 // Last receive window
+}
+
+// tcpState estimates the performance of a TCP connection from the headers of its segments
+type tcpState struct {
+	dirs    [2]tcpDirection
+	synDir  int
+	syn     time.Time // Last SYN of the client
+	synAck  time.Time // First SYN-ACK of the server
+	rtt     time.Duration
+	skipped bool // Handshake not seen or completed
+}
+
+// add accounts a segment sent in direction i to its counters. It returns the handshake round
+// trip time once the ACK completing it has been seen.
+func (t *tcpState) add(s *capture.Sample, i int, c *flow.Counters, ts time.Time) time.Duration {
+	f := s.TCPFlags
+	d := &t.dirs[i]
+
+	// Handshake
+	switch {
+	case t.rtt != 0 || t.skipped:
+	case f.Has(flow.TCPFlagSYN) && !f.Has(flow.TCPFlagACK):
+		// Measure from a retransmitted SYN, the original one has been lost
+		if t.synAck.IsZero() {
+			t.syn, t.synDir = ts, i
+		}
+	case f.Has(flow.TCPFlagSYN | flow.TCPFlagACK):
+		if !t.syn.IsZero() && i != t.synDir && t.synAck.IsZero() {
+			t.synAck = ts
+		}
+	case f.Has(flow.TCPFlagACK):
+		if !t.synAck.IsZero() && i == t.synDir {
+			t.rtt = ts.Sub(t.syn)
+		} else {
+			t.skipped = true
+		}
+	}
+
+	// SYN and FIN occupy a sequence number
+	n := uint32(s.SegLen)
+	if f.Has(flow.TCPFlagSYN) || f.Has(flow.TCPFlagFIN) {
+		n++
+	}
+	if n > 0 && !f.Has(flow.TCPFlagRST) {
+		end := s.Seq + n
+		switch {
+		case !d.started:
+			d.started, d.next, d.advanced = true, end, ts
+		case int32(s.Seq-d.next) >= 0:
+			// New data, possibly after a gap of segments arriving later
+			d.next, d.advanced = end, ts
+		case s.SegLen <= 1 && s.Seq == d.next-1 && !f.Has(flow.TCPFlagSYN) && !f.Has(flow.TCPFlagFIN):
+			// Keep-alive
+		case int32(end-d.next) > 0:
+			// Resent data followed by new data
+			c.Retransmissions++
+			d.next, d.advanced = end, ts
+		case ts.Sub(d.advanced) < t.reorderWindow():
+			c.OutOfOrder++
+		default:
+			c.Retransmissions++
+		}
+	}
+
+	if f.Has(flow.TCPFlagACK) && !f.Has(flow.TCPFlagRST) {
+		if s.Window == 0 && (!d.acked || d.window != 0) {
+			c.ZeroWindows++
+		}
+		pure := s.SegLen == 0 && !f.Has(flow.TCPFlagSYN) && !f.Has(flow.TCPFlagFIN)
+		if pure && d.acked && s.Ack == d.ack && s.Window == d.window && s.Window != 0 {
+			c.DuplicateACKs++
+		}
+		d.acked, d.ack, d.window = true, s.Ack, s.Window
+	}
+	return t.rtt
+}
+
+// reorderWindow is the time after which resent data is considered a retransmission
+func (t *tcpState) reorderWindow() time.Duration {
+	if t.rtt > 0 {
+		return t.rtt
+	}
+	return defaultReorderWindow
+}
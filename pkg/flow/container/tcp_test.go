@@ -0,0 +1,115 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package container
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/capture"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+func TestContainerTCPMetrics(t *testing.T) {
+	c := NewWithOptions(Options{IdleTimeout: 15 * time.Second, ActiveTimeout: time.Minute, TCPMetrics: true})
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	segment := func(server bool, ms int, flags flow.TCPFlags, seq, ack uint32, window, length uint16) *capture.Sample {
+		s := &capture.Sample{
+			Transport: protos.TCP, SrcPort: 40000, DstPort: 80, Src: net.ParseIP("10.0.0.1"), Dst: net.ParseIP("10.0.0.2"),
+			TCPFlags: flags, Seq: seq, Ack: ack, Window: window, SegLen: length, Bytes: 52 + length,
+			Timestamp: start.Add(time.Duration(ms) * time.Millisecond),
+		}
+		if server {
+			s.Src, s.Dst, s.SrcPort, s.DstPort = s.Dst, s.Src, s.DstPort, s.SrcPort
+		}
+		return s
+	}
+	ack, synAck := flow.TCPFlagACK, flow.TCPFlagSYN|flow.TCPFlagACK
+
+	for _, s := range []*capture.Sample{
+		// Handshake
+		segment(false, 0, flow.TCPFlagSYN, 100, 0, 1000, 0),
+		segment(true, 10, synAck, 500, 101, 1000, 0),
+		segment(false, 20, ack, 101, 501, 1000, 0),
+		// The second segment arrives after the third one, the first one is resent
+		segment(false, 30, ack, 101, 501, 1000, 10),
+		segment(false, 31, ack, 121, 501, 1000, 10),
+		segment(false, 32, ack, 111, 501, 1000, 10),
+		segment(false, 100, ack, 101, 501, 1000, 10),
+		// Keep-alive
+		segment(false, 200, ack, 130, 501, 1000, 1),
+		// The server acknowledges twice and closes its window
+		segment(true, 33, ack, 501, 111, 1000, 0),
+		segment(true, 34, ack, 501, 111, 1000, 0),
+		segment(true, 35, ack, 501, 111, 0, 0),
+		segment(true, 36, ack, 501, 111, 0, 0),
+	} {
+		c.Add(s)
+	}
+
+	flows := c.Dump()
+	if len(flows) != 1 {
+		t.Fatalf("Expected one flow, got %d", len(flows))
+	}
+	f := flows[0]
+	if f.RTT != 20*time.Millisecond {
+		t.Errorf("Expected a handshake RTT of 20ms, got %s", f.RTT)
+	}
+	expected := [2]flow.Counters{
+		{Retransmissions: 1, OutOfOrder: 1},
+		{ZeroWindows: 1, DuplicateACKs: 1},
+	}
+	got := [2]flow.Counters{f.Incoming, f.Outgoing}
+	for i := range got {
+		got[i].Bytes, got[i].Packets, got[i].TCPFlags = 0, 0, 0
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestTCPStateNoHandshake(t *testing.T) {
+	var s tcpState
+	var cnt flow.Counters
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Connection established before the capture started
+	s.add(&capture.Sample{TCPFlags: flow.TCPFlagACK, Seq: 1, Ack: 1, Window: 10, SegLen: 100}, 0, &cnt, start)
+	s.add(&capture.Sample{TCPFlags: flow.TCPFlagSYN | flow.TCPFlagACK, Seq: 0xffffffef, Ack: 1, Window: 10}, 1, &cnt, start.Add(time.Millisecond))
+	if rtt := s.add(&capture.Sample{TCPFlags: flow.TCPFlagACK, Seq: 101, Ack: 0xfffffff0, Window: 10}, 0, &cnt, start.Add(2*time.Millisecond)); rtt != 0 {
+		t.Errorf("Expected no RTT without the handshake, got %s", rtt)
+	}
+	// Sequence numbers of the server wrap around
+	s.add(&capture.Sample{TCPFlags: flow.TCPFlagACK, Seq: 0xfffffff0, Ack: 101, Window: 10, SegLen: 100}, 1, &cnt, start.Add(3*time.Millisecond))
+	s.add(&capture.Sample{TCPFlags: flow.TCPFlagACK, Seq: 0x54, Ack: 101, Window: 10, SegLen: 100}, 1, &cnt, start.Add(4*time.Millisecond))
+	if cnt != (flow.Counters{}) {
+		t.Errorf("Expected no anomalies, got %+v", cnt)
+	}
+}
@@ -35,8 +35,13 @@ import (
 
 // Counters contains flow counters
 type Counters struct {
-	Bytes   uint64
-	Packets uint64
+	Bytes           uint64
+	Packets         uint64
+	TCPFlags        TCPFlags // OR-ed TCP flags
+	Retransmissions uint64   // TCP segments resending data
+	OutOfOrder      uint64   // TCP segments arriving after a later segment
+	ZeroWindows     uint64   // TCP receive window closed
+	DuplicateACKs   uint64   // TCP ACKs repeating the previous acknowledgment
 }
 
 // Meta contains flow metadata
@@ -50,14 +55,78 @@ type Meta struct {
 	SrcPort   uint16
 }
 
+// Interface identifies the network interface a flow has been observed on
+type Interface struct {
+	Name  string
+	Index int
+}
+
+// EndReason describes why a flow record has been exported, values match the IPFIX flowEndReason
+type EndReason uint8
+
+// Flow end reasons, see https://www.iana.org/assignments/ipfix/ipfix.xhtml
+const (
+	EndIdleTimeout     EndReason = 1 // No packets seen for the idle timeout
+	EndActiveTimeout   EndReason = 2 // Flow has been active for longer than the active timeout
+	EndOfFlow          EndReason = 3 // Connection terminated (e.g. TCP FIN/RST)
+	EndForced          EndReason = 4 // Flow table has been flushed
+	EndLackOfResources EndReason = 5 // Flow has been evicted from a full flow table
+)
+
 // Flow contains flow data
 type Flow struct {
-	Meta        Meta      // Flow Src/Dst & Protocol Information
-	Incoming    Counters  // Incoming counters
-	Outgoing    Counters  // Outgoing counters
-	CommunityID string    // CommunityID
-	Start       time.Time // Start time
-	End         time.Time // Stop time
+	Meta        Meta            // Flow Src/Dst & Protocol Information
+	Incoming    Counters        // Incoming counters
+	Outgoing    Counters        // Outgoing counters
+	CommunityID string          // CommunityID
+	Start       time.Time       // First packet seen
+	End         time.Time       // Last packet seen
+	EndReason   EndReason       // Why the flow has been exported
+	Translated  *Meta           // Post-NAT tuple (client -> server), nil if unknown or not translated
+	Interface   Interface       // Observation interface
+	Original    *Original       // Flow addressed to a service before it has been rewritten to the backend
+	Process     *Process        // Local process owning a socket of the flow, nil if unknown
+	TLS         *TLS            // TLS handshake of a TCP flow, nil if no hello has been seen
+	Protocol    string          // Application protocol, empty if unknown
+	ProtoSource string          // How the application protocol has been identified (payload or port)
+	RTT         time.Duration   // TCP handshake round trip time from the SYN to the ACK completing it, 0 if not seen
+	ConnState   ConnectionState // TCP connection state reported by the source (e.g. conntrack), derived from the flags if StateNone
+}
+
+// Sources of the application protocol of a flow
+const (
+	ProtoSourcePayload = "payload" // Identified by the first payload of the flow
+	ProtoSourcePort    = "port"    // Registered for the port of the flow
+)
+
+// TLS contains the parameters of a TLS handshake. The negotiated parameters are empty if the
+// ServerHello has not been seen.
+type TLS struct {
+	Version         string   // Negotiated version, e.g. 1.3
+	VersionProtocol string   // tls or ssl
+	Cipher          string   // Negotiated cipher suite
+	ServerName      string   // Server name indication of the client
+	ALPN            []string // Application protocols offered by the client
+	NextProtocol    string   // Application protocol selected by the server, encrypted with TLS 1.3
+	JA3             string   // Fingerprint of the ClientHello
+	JA4             string   // Fingerprint of the ClientHello
+	JA3S            string   // Fingerprint of the ServerHello
+}
+
+// Process identifies the local process owning a socket of a flow
+type Process struct {
+	PID         int
+	Comm        string
+	Executable  string
+	Cgroup      string // Cgroup path
+	ContainerID string
+	Source      bool // The process owns the source socket, i.e. it opened the connection
+}
+
+// Original contains the tuple and CommunityID of a flow before rewriting
+type Original struct {
+	Meta        Meta
+	CommunityID string
 }
 
 // New creates a new Flow
@@ -69,11 +138,42 @@ func New(fm Meta) *Flow {
 	}
 }
 
+// State returns the connection state of a TCP flow reported by its source or derived from the
+// flags seen in both directions, StateNone if neither is known
+func (f *Flow) State() ConnectionState {
+	if f.Meta.Transport != protos.TCP {
+		return StateNone
+	}
+	if f.ConnState != StateNone {
+		return f.ConnState
+	}
+
+	client, server := f.Incoming.TCPFlags, f.Outgoing.TCPFlags
+	switch {
+	case client|server == 0:
+		// No flags observed, e.g. flows of other sources
+		return StateNone
+	case (client | server).Has(TCPFlagRST):
+		return StateReset
+	case client.Has(TCPFlagFIN) && server.Has(TCPFlagFIN):
+		return StateClosed
+	case server.Has(TCPFlagSYN | TCPFlagACK):
+		return StateEstablished
+	case client.Has(TCPFlagSYN):
+		return StateAttempted
+	case client.Has(TCPFlagACK) && server.Has(TCPFlagACK):
+		// Connection was established before the capture started
+		return StateEstablished
+	default:
+		return StateUnknown
+	}
+}
+
 // WithCorrectedSource is a super simple helper function for determin which one is the source IP
 // and updates the values inside accordingly
 func (m Meta) WithCorrectedSource() Meta {
 	switch m.Transport {
-	case protos.TCP, protos.UDP:
+	case protos.TCP, protos.UDP, protos.SCTP:
 		// Assume that min(SrcPort, DstPort) is the server -> destination
 		if m.DstPort > m.SrcPort {
 			// Swap around, otherwise it's fine
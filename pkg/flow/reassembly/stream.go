@@ -0,0 +1,123 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package reassembly
+
+// MaxPending is the maximum number of segments held back while waiting for a gap to be filled
+const MaxPending = 4
+
+// segment is a TCP segment received ahead of the stream
+type segment struct {
+	seq  uint32
+	data []byte
+}
+
+// Stream puts the segments of one direction of a TCP connection in order. Only the payload
+// inspectors are interested in is buffered, the stream itself does not keep delivered data.
+type Stream struct {
+	started bool
+	next    uint32 // Sequence number of the next byte of the stream
+	pending []segment
+}
+
+// Add adds a segment and passes the data continuing the stream to deliver, in stream order.
+// The stream starts after the SYN or, if the handshake has not been seen, with the first
+// segment carrying data. Retransmitted data is skipped, segments ahead of the stream are held
+// back until the gap is filled. It returns false if the segment has been dropped as too many
+// segments are held back already.
+func (s *Stream) Add(seq uint32, syn bool, payload []byte, deliver func(data []byte)) bool {
+	if syn {
+		// The SYN occupies one sequence number, TCP Fast Open data follows
+		seq++
+		s.started, s.next = true, seq
+	}
+	if len(payload) == 0 {
+		return true
+	}
+	if !s.started {
+		s.started, s.next = true, seq
+	}
+
+	if !s.insert(seq, payload, deliver) {
+		return false
+	}
+	s.drain(deliver)
+	return true
+}
+
+// Skip gives up on the missing data and continues the stream with the held back segments
+func (s *Stream) Skip(deliver func(data []byte)) {
+	if len(s.pending) == 0 {
+		return
+	}
+	next := s.pending[0].seq
+	for _, p := range s.pending[1:] {
+		if int32(p.seq-next) < 0 {
+			next = p.seq
+		}
+	}
+	s.next = next
+	s.drain(deliver)
+}
+
+// drain delivers the held back segments continuing the stream
+func (s *Stream) drain(deliver func(data []byte)) {
+	for i := 0; i < len(s.pending); {
+		p := s.pending[i]
+		if int32(p.seq-s.next) > 0 {
+			i++
+			continue
+		}
+		s.pending = append(s.pending[:i], s.pending[i+1:]...)
+		s.insert(p.seq, p.data, deliver)
+		i = 0
+	}
+}
+
+// Gap checks if segments are held back waiting for missing data
+func (s *Stream) Gap() bool {
+	return len(s.pending) > 0
+}
+
+// insert delivers the new data of a segment or holds it back
+func (s *Stream) insert(seq uint32, data []byte, deliver func(data []byte)) bool {
+	// Sequence numbers wrap around, compare their distance
+	d := int32(seq - s.next)
+	if d > 0 {
+		if len(s.pending) >= MaxPending {
+			return false
+		}
+		s.pending = append(s.pending, segment{seq: seq, data: append([]byte(nil), data...)})
+		return true
+	}
+	if int(-d) >= len(data) {
+		// Retransmission
+		return true
+	}
+	data = data[-d:]
+	s.next += uint32(len(data))
+	deliver(data)
+	return true
+}
@@ -0,0 +1,86 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package reassembly
+
+import (
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	var s Stream
+	var got []byte
+	deliver := func(data []byte) {
+		got = append(got, data...)
+	}
+
+	isn := uint32(0xfffffffa)
+	for i, seg := range []struct {
+		seq     uint32
+		syn     bool
+		payload string
+	}{
+		{isn, true, ""},
+		{isn + 1 + 10, false, "klmno"},  // Ahead, wraps around
+		{isn + 1, false, "abcde"},       // In order
+		{isn + 1, false, "abc"},         // Retransmission
+		{isn + 1 + 3, false, "defghij"}, // Overlaps, fills the gap
+		{isn + 1 + 15, false, "pq"},
+	} {
+		if !s.Add(seg.seq, seg.syn, []byte(seg.payload), deliver) {
+			t.Fatalf("Segment %d dropped", i)
+		}
+	}
+	if string(got) != "abcdefghijklmnopq" || s.Gap() {
+		t.Errorf("Unexpected stream %q", got)
+	}
+}
+
+func TestStreamSkip(t *testing.T) {
+	var s Stream
+	var got []byte
+	deliver := func(data []byte) {
+		got = append(got, data...)
+	}
+
+	// Stream starts without the handshake, the second segment is lost
+	s.Add(100, false, []byte("ab"), deliver)
+	for i := 0; i < MaxPending; i++ {
+		if !s.Add(uint32(104+2*i), false, []byte{'e' + byte(2*i), 'f' + byte(2*i)}, deliver) {
+			t.Fatalf("Segment %d dropped", i)
+		}
+	}
+	if s.Add(120, false, []byte("xy"), deliver) {
+		t.Error("Expected the segment to be dropped")
+	}
+	if !s.Gap() || string(got) != "ab" {
+		t.Fatalf("Expected a gap after %q", got)
+	}
+
+	s.Skip(deliver)
+	if s.Gap() || string(got) != "abefghijkl" {
+		t.Errorf("Unexpected stream %q after skipping the gap", got)
+	}
+}
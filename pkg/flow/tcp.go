@@ -0,0 +1,95 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package flow
+
+// TCPFlags contains the control bits of a TCP header
+type TCPFlags uint8
+
+// TCP control bits as defined in RFC 793 and RFC 3168
+const (
+	TCPFlagFIN TCPFlags = 1 << iota
+	TCPFlagSYN
+	TCPFlagRST
+	TCPFlagPSH
+	TCPFlagACK
+	TCPFlagURG
+	TCPFlagECE
+	TCPFlagCWR
+)
+
+var tcpFlagNames = []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG", "ECE", "CWR"}
+
+// Has checks if all flags in m are set
+func (f TCPFlags) Has(m TCPFlags) bool {
+	return f&m == m
+}
+
+// Names returns the names of all set flags
+func (f TCPFlags) Names() []string {
+	var names []string
+	for i, n := range tcpFlagNames {
+		if f&(1<<uint(i)) != 0 {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// ConnectionState describes the state of a TCP connection derived from the flags seen in both directions
+type ConnectionState uint8
+
+// Connection states
+const (
+	StateNone        ConnectionState = iota // Not a TCP flow
+	StateAttempted                          // SYN sent without an answer from the server
+	StateEstablished                        // Handshake completed or traffic seen in both directions
+	StateClosed                             // Connection terminated by FIN in both directions
+	StateReset                              // Connection terminated by RST
+	StateUnknown                            // TCP flow without enough information to tell
+)
+
+// String converts the connection state to a string
+func (cs ConnectionState) String() string {
+	switch cs {
+	case StateAttempted:
+		return "attempted"
+	case StateEstablished:
+		return "established"
+	case StateClosed:
+		return "closed"
+	case StateReset:
+		return "reset"
+	case StateUnknown:
+		return "unknown"
+	default:
+		return ""
+	}
+}
+
+// Terminated checks if the connection has been closed or reset
+func (cs ConnectionState) Terminated() bool {
+	return cs == StateClosed || cs == StateReset
+}
@@ -0,0 +1,72 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package flow
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+func TestTCPFlagNames(t *testing.T) {
+	names := (TCPFlagSYN | TCPFlagACK | TCPFlagCWR).Names()
+	if !cmp.Equal(names, []string{"SYN", "ACK", "CWR"}) {
+		t.Error(cmp.Diff([]string{"SYN", "ACK", "CWR"}, names))
+	}
+
+	if TCPFlags(0).Names() != nil {
+		t.Error("Expected no flag names")
+	}
+}
+
+func TestFlowState(t *testing.T) {
+	for _, toTest := range []struct {
+		transport protos.ProtocolType
+		client    TCPFlags
+		server    TCPFlags
+		state     ConnectionState
+	}{
+		{protos.UDP, 0, 0, StateNone},
+		{protos.TCP, 0, 0, StateNone},
+		{protos.TCP, TCPFlagACK, 0, StateUnknown},
+		{protos.TCP, TCPFlagSYN, 0, StateAttempted},
+		{protos.TCP, TCPFlagSYN, TCPFlagRST | TCPFlagACK, StateReset},
+		{protos.TCP, TCPFlagSYN | TCPFlagACK, TCPFlagSYN | TCPFlagACK, StateEstablished},
+		{protos.TCP, TCPFlagACK | TCPFlagPSH, TCPFlagACK, StateEstablished},
+		{protos.TCP, TCPFlagSYN | TCPFlagACK | TCPFlagFIN, TCPFlagSYN | TCPFlagACK, StateEstablished},
+		{protos.TCP, TCPFlagSYN | TCPFlagACK | TCPFlagFIN, TCPFlagSYN | TCPFlagACK | TCPFlagFIN, StateClosed},
+		{protos.TCP, TCPFlagACK | TCPFlagRST, TCPFlagACK, StateReset},
+	} {
+		f := New(Meta{Transport: toTest.transport, Src: net.ParseIP("10.0.0.1"), Dst: net.ParseIP("10.0.0.2")})
+		f.Incoming.TCPFlags, f.Outgoing.TCPFlags = toTest.client, toTest.server
+
+		if s := f.State(); s != toTest.state {
+			t.Errorf("[%s] %v/%v: expected state %q, got %q", toTest.transport, toTest.client.Names(), toTest.server.Names(), toTest.state, s)
+		}
+	}
+}
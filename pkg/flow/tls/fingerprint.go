@@ -0,0 +1,197 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package tls
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// isGREASE checks if v is a reserved GREASE value (RFC 8701), which is ignored by fingerprints
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// ja3 computes the JA3 fingerprint of a ClientHello
+func ja3(h *clientHello) string {
+	return md5Hex(ja3String(h))
+}
+
+// ja3String is the JA3 fingerprint before hashing:
+// version,ciphers,extensions,groups,point formats
+func ja3String(h *clientHello) string {
+	formats := make([]uint16, len(h.pointFormats))
+	for i, f := range h.pointFormats {
+		formats[i] = uint16(f)
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		decimalList(h.ciphers),
+		decimalList(h.extensions),
+		decimalList(h.groups),
+		decimalList(formats),
+	}, ",")
+}
+
+// ja3s computes the JA3S fingerprint of a ServerHello
+func ja3s(h *serverHello) string {
+	return md5Hex(ja3sString(h))
+}
+
+// ja3sString is the JA3S fingerprint before hashing: version,cipher,extensions
+func ja3sString(h *serverHello) string {
+	return fmt.Sprintf("%d,%d,%s", h.version, h.cipher, decimalList(h.extensions))
+}
+
+// ja4 computes the JA4 fingerprint of a ClientHello sent over TCP
+func ja4(h *clientHello) string {
+	a, b, c := ja4Parts(h)
+	return a + "_" + truncatedSHA256(b) + "_" + truncatedSHA256(c)
+}
+
+// ja4Parts returns the JA4 fingerprint with the cipher and extension parts not hashed yet,
+// see https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4.md
+func ja4Parts(h *clientHello) (string, string, string) {
+	ciphers := hexList(h.ciphers)
+	sort.Strings(ciphers)
+
+	// Server name and ALPN are part of the readable prefix, but count as extensions
+	var extensions []string
+	count := 0
+	for _, e := range h.extensions {
+		if isGREASE(e) {
+			continue
+		}
+		count++
+		if e != extServerName && e != extALPN {
+			extensions = append(extensions, fmt.Sprintf("%04x", e))
+		}
+	}
+	sort.Strings(extensions)
+
+	sni := "i"
+	if h.serverName != "" {
+		sni = "d"
+	}
+	a := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(h), sni, min99(len(ciphers)), min99(count), ja4ALPN(h.alpn))
+
+	c := strings.Join(extensions, ",")
+	if algs := hexList(h.signatureAlgs); len(algs) > 0 {
+		c += "_" + strings.Join(algs, ",")
+	}
+	return a, strings.Join(ciphers, ","), c
+}
+
+// ja4Version returns the highest supported version of the client
+func ja4Version(h *clientHello) string {
+	v := h.version
+	for _, s := range h.versions {
+		if !isGREASE(s) && s > v {
+			v = s
+		}
+	}
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	default:
+		return "00"
+	}
+}
+
+// ja4ALPN returns the first and last character of the first offered application protocol,
+// or of its hex representation if these are not alphanumeric
+func ja4ALPN(alpn []string) string {
+	if len(alpn) == 0 {
+		return "00"
+	}
+	p := alpn[0]
+	if !isAlphanumeric(p[0]) || !isAlphanumeric(p[len(p)-1]) {
+		p = hex.EncodeToString([]byte(p))
+	}
+	return string(p[0]) + string(p[len(p)-1])
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func min99(n int) int {
+	if n > 99 {
+		return 99
+	}
+	return n
+}
+
+// decimalList joins the non-GREASE values with dashes
+func decimalList(values []uint16) string {
+	var s []string
+	for _, v := range values {
+		if !isGREASE(v) {
+			s = append(s, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(s, "-")
+}
+
+// hexList formats the non-GREASE values as four digit hex numbers
+func hexList(values []uint16) []string {
+	var s []string
+	for _, v := range values {
+		if !isGREASE(v) {
+			s = append(s, fmt.Sprintf("%04x", v))
+		}
+	}
+	return s
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// truncatedSHA256 returns the first 12 hex digits of the SHA-256 hash of s, zeros if s is empty
+func truncatedSHA256(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
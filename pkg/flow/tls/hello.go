@@ -0,0 +1,265 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package tls
+
+import (
+	"errors"
+)
+
+// Record and handshake message types
+const (
+	recordHandshake    = 22
+	typeClientHello    = 1
+	typeServerHello    = 2
+	maxRecordLength    = 1<<14 + 2048
+	handshakeHeaderLen = 4
+)
+
+// Extension types
+const (
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+)
+
+var (
+	errNotTLS     = errors.New("not a TLS handshake")
+	errIncomplete = errors.New("incomplete TLS handshake message")
+	errMalformed  = errors.New("malformed TLS hello")
+)
+
+// clientHello contains the fields of a ClientHello used for fingerprinting
+type clientHello struct {
+	version       uint16 // Legacy version of the hello
+	ciphers       []uint16
+	extensions    []uint16 // In the order sent
+	groups        []uint16
+	pointFormats  []uint8
+	signatureAlgs []uint16
+	versions      []uint16 // Supported versions
+	serverName    string
+	alpn          []string
+}
+
+// serverHello contains the fields of a ServerHello
+type serverHello struct {
+	version    uint16 // Legacy version of the hello
+	cipher     uint16
+	extensions []uint16
+	selected   uint16 // Version selected through the supported versions extension
+	alpn       string
+}
+
+// handshakeMessage returns the first handshake message in a stream of TLS records. Messages
+// may span several records, errIncomplete is returned until the message is complete.
+func handshakeMessage(stream []byte) (uint8, []byte, error) {
+	var msg []byte
+	for len(stream) > 0 {
+		if len(stream) < 5 {
+			return 0, nil, errIncomplete
+		}
+		// Content type and major version of the record layer
+		if stream[0] != recordHandshake || stream[1] != 3 {
+			return 0, nil, errNotTLS
+		}
+		n := int(stream[3])<<8 | int(stream[4])
+		if n == 0 || n > maxRecordLength {
+			return 0, nil, errNotTLS
+		}
+		if len(stream) < 5+n {
+			return 0, nil, errIncomplete
+		}
+		msg = append(msg, stream[5:5+n]...)
+		stream = stream[5+n:]
+
+		if len(msg) >= handshakeHeaderLen {
+			l := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+			if len(msg) >= handshakeHeaderLen+l {
+				return msg[0], msg[handshakeHeaderLen : handshakeHeaderLen+l], nil
+			}
+		}
+	}
+	return 0, nil, errIncomplete
+}
+
+// parseClientHello parses the body of a ClientHello message
+func parseClientHello(b []byte) (*clientHello, error) {
+	r := reader{b: b}
+	h := &clientHello{version: r.u16()}
+	r.skip(32) // Random
+	r.vec8()   // Session ID
+	ciphers := reader{b: r.vec16()}
+	for !ciphers.empty() {
+		h.ciphers = append(h.ciphers, ciphers.u16())
+	}
+	r.vec8() // Compression methods
+	if r.failed {
+		return nil, errMalformed
+	}
+
+	// Extensions are optional
+	var exts reader
+	if !r.empty() {
+		exts.b = r.vec16()
+	}
+	for !exts.empty() {
+		typ, data := exts.u16(), reader{b: exts.vec16()}
+		h.extensions = append(h.extensions, typ)
+
+		switch typ {
+		case extServerName:
+			names := reader{b: data.vec16()}
+			for !names.empty() {
+				nameType, name := names.u8(), names.vec16()
+				if nameType == 0 && h.serverName == "" {
+					h.serverName = string(name)
+				}
+			}
+			data.failed = data.failed || names.failed
+		case extSupportedGroups:
+			groups := reader{b: data.vec16()}
+			for !groups.empty() {
+				h.groups = append(h.groups, groups.u16())
+			}
+		case extECPointFormats:
+			h.pointFormats = append(h.pointFormats, data.vec8()...)
+		case extSignatureAlgorithms:
+			algs := reader{b: data.vec16()}
+			for !algs.empty() {
+				h.signatureAlgs = append(h.signatureAlgs, algs.u16())
+			}
+		case extALPN:
+			protos := reader{b: data.vec16()}
+			for !protos.empty() {
+				if p := protos.vec8(); len(p) > 0 {
+					h.alpn = append(h.alpn, string(p))
+				}
+			}
+			data.failed = data.failed || protos.failed
+		case extSupportedVersions:
+			versions := reader{b: data.vec8()}
+			for !versions.empty() {
+				h.versions = append(h.versions, versions.u16())
+			}
+		}
+		if data.failed {
+			return nil, errMalformed
+		}
+	}
+	if r.failed || exts.failed {
+		return nil, errMalformed
+	}
+	return h, nil
+}
+
+// parseServerHello parses the body of a ServerHello message
+func parseServerHello(b []byte) (*serverHello, error) {
+	r := reader{b: b}
+	h := &serverHello{version: r.u16()}
+	r.skip(32) // Random
+	r.vec8()   // Session ID
+	h.cipher = r.u16()
+	r.u8() // Compression method
+	if r.failed {
+		return nil, errMalformed
+	}
+
+	// Extensions are optional
+	var exts reader
+	if !r.empty() {
+		exts.b = r.vec16()
+	}
+	for !exts.empty() {
+		typ, data := exts.u16(), reader{b: exts.vec16()}
+		h.extensions = append(h.extensions, typ)
+
+		switch typ {
+		case extSupportedVersions:
+			h.selected = data.u16()
+		case extALPN:
+			protos := reader{b: data.vec16()}
+			h.alpn = string(protos.vec8())
+			data.failed = data.failed || protos.failed
+		}
+		if data.failed {
+			return nil, errMalformed
+		}
+	}
+	if r.failed || exts.failed {
+		return nil, errMalformed
+	}
+	return h, nil
+}
+
+// reader reads big endian integers and length prefixed vectors. Reads beyond the end of the
+// data return zero values and mark the reader as failed.
+type reader struct {
+	b      []byte
+	failed bool
+}
+
+func (r *reader) empty() bool {
+	return r.failed || len(r.b) == 0
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.failed || len(r.b) < n {
+		r.failed = true
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *reader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *reader) u8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return uint16(b[0])<<8 | uint16(b[1])
+	}
+	return 0
+}
+
+func (r *reader) vec8() []byte {
+	return r.bytes(int(r.u8()))
+}
+
+func (r *reader) vec16() []byte {
+	return r.bytes(int(r.u16()))
+}
@@ -0,0 +1,59 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package tls
+
+import "github.com/xvzf/insight/pkg/flow/reassembly"
+
+// Limits of the inspection, the hello messages are expected in the first segments of a stream
+const (
+	maxBuffer   = 1<<14 + 5 // A full record
+	maxSegments = 8         // Data segments inspected per direction
+)
+
+// stream buffers the beginning of one direction of a TCP connection
+type stream struct {
+	reassembly.Stream
+	buf      []byte
+	segments int
+}
+
+// add adds a segment to the stream
+func (s *stream) add(seq uint32, syn bool, payload []byte) {
+	if len(payload) > 0 {
+		s.segments++
+	}
+	s.Add(seq, syn, payload, func(data []byte) {
+		if room := maxBuffer - len(s.buf); len(data) > room {
+			data = data[:room]
+		}
+		s.buf = append(s.buf, data...)
+	})
+}
+
+// full checks if no more data is inspected
+func (s *stream) full() bool {
+	return s.segments >= maxSegments || len(s.buf) >= maxBuffer
+}
@@ -0,0 +1,133 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package tls
+
+import (
+	"github.com/xvzf/insight/pkg/flow"
+)
+
+// Handshake extracts the ClientHello and ServerHello from the first segments of a TCP
+// connection. Segments are reassembled per direction, the messages are recognized by their
+// type, so the orientation of the flow does not need to match client and server.
+type Handshake struct {
+	streams [2]stream
+	done    [2]bool
+	client  *clientHello
+	server  *serverHello
+	notTLS  bool
+	result  *flow.TLS // Parameters of the hellos seen so far, nil until changed
+}
+
+// NewHandshake creates a new Handshake
+func NewHandshake() *Handshake {
+	return &Handshake{}
+}
+
+// Add adds a segment of one direction of the connection, reverse selects the opposite
+// direction. It returns true once the inspection finished and the handshake can be released.
+func (h *Handshake) Add(reverse bool, seq uint32, syn bool, payload []byte) bool {
+	i := 0
+	if reverse {
+		i = 1
+	}
+	if h.done[i] {
+		return h.finished()
+	}
+
+	s := &h.streams[i]
+	s.add(seq, syn, payload)
+	if len(s.buf) == 0 && !s.full() {
+		return false
+	}
+
+	typ, msg, err := handshakeMessage(s.buf)
+	if err == errIncomplete && !s.full() {
+		return false
+	}
+	h.done[i] = true
+	h.streams[i] = stream{}
+
+	switch {
+	case err == errIncomplete:
+		streams.WithLabelValues("incomplete").Inc()
+	case err != nil:
+		h.notTLS = true
+		streams.WithLabelValues("not_tls").Inc()
+	case typ == typeClientHello && h.client == nil:
+		h.client, err = parseClientHello(msg)
+		h.count("client_hello", err)
+		h.result = nil
+	case typ == typeServerHello && h.server == nil:
+		h.server, err = parseServerHello(msg)
+		h.count("server_hello", err)
+		h.result = nil
+	default:
+		streams.WithLabelValues("incomplete").Inc()
+	}
+	return h.finished()
+}
+
+func (h *Handshake) count(result string, err error) {
+	if err != nil {
+		result = "malformed"
+	}
+	streams.WithLabelValues(result).Inc()
+}
+
+// finished checks if both directions have been inspected or the connection is not TLS
+func (h *Handshake) finished() bool {
+	return h.notTLS || h.done[0] && h.done[1]
+}
+
+// TLS returns the parameters of the hellos seen so far, nil if no hello has been seen. The
+// result is not modified by later segments.
+func (h *Handshake) TLS() *flow.TLS {
+	if h.result != nil || h.client == nil && h.server == nil {
+		return h.result
+	}
+
+	t := &flow.TLS{}
+	if c := h.client; c != nil {
+		t.ServerName = c.serverName
+		t.ALPN = c.alpn
+		t.JA3 = ja3(c)
+		t.JA4 = ja4(c)
+	}
+	if s := h.server; s != nil {
+		v := s.version
+		if s.selected != 0 {
+			v = s.selected
+		}
+		if names, ok := versions[v]; ok {
+			t.VersionProtocol, t.Version = names[0], names[1]
+		}
+		t.Cipher = cipherSuiteName(s.cipher)
+		t.NextProtocol = s.alpn
+		t.JA3S = ja3s(s)
+	}
+	h.result = t
+	return t
+}
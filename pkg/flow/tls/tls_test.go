@@ -0,0 +1,263 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package tls
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/flow"
+)
+
+// vec prefixes data with its length in n bytes
+func vec(n int, data ...[]byte) []byte {
+	var b []byte
+	for _, d := range data {
+		b = append(b, d...)
+	}
+	l := len(b)
+	prefix := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		prefix[i] = byte(l)
+		l >>= 8
+	}
+	return append(prefix, b...)
+}
+
+func u16s(values ...uint16) []byte {
+	var b []byte
+	for _, v := range values {
+		b = append(b, byte(v>>8), byte(v))
+	}
+	return b
+}
+
+func ext(typ uint16, data ...[]byte) []byte {
+	return append(u16s(typ), vec(2, data...)...)
+}
+
+// records wraps a hello message into a handshake header and records of at most size bytes
+func records(typ uint8, body []byte, size int) []byte {
+	msg := append([]byte{typ}, vec(3, body)...)
+	var b []byte
+	for len(msg) > 0 {
+		n := size
+		if n > len(msg) {
+			n = len(msg)
+		}
+		b = append(b, recordHandshake, 3, 1)
+		b = append(b, vec(2, msg[:n])...)
+		msg = msg[n:]
+	}
+	return b
+}
+
+var (
+	testClientHello = records(typeClientHello, append(append(append(append(
+		u16s(0x0303),
+		make([]byte, 32)...),
+		vec(1)...),
+		vec(2, u16s(0x0a0a, 0x1301, 0xc02f))...),
+		append(vec(1, []byte{0}), vec(2,
+			ext(0x1a1a),
+			ext(extServerName, vec(2, []byte{0}, vec(2, []byte("example.com")))),
+			ext(extSupportedGroups, vec(2, u16s(0x001d, 0x0017))),
+			ext(extECPointFormats, vec(1, []byte{0})),
+			ext(extSignatureAlgorithms, vec(2, u16s(0x0403, 0x0804))),
+			ext(extALPN, vec(2, vec(1, []byte("h2")), vec(1, []byte("http/1.1")))),
+			ext(extSupportedVersions, vec(1, u16s(0x0304, 0x0303))),
+		)...)...), 100)
+
+	testServerHello = records(typeServerHello, append(append(append(append(
+		u16s(0x0303),
+		make([]byte, 32)...),
+		vec(1)...),
+		append(u16s(0xc02f), 0)...),
+		vec(2,
+			ext(0xff01, []byte{0}),
+			ext(extALPN, vec(2, vec(1, []byte("h2")))),
+		)...), 1000)
+)
+
+func TestParseClientHello(t *testing.T) {
+	typ, msg, err := handshakeMessage(testClientHello)
+	if err != nil || typ != typeClientHello {
+		t.Fatalf("Expected a ClientHello spanning several records, got type %d: %v", typ, err)
+	}
+	h, err := parseClientHello(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, expected := ja3String(h), "771,4865-49199,0-10-11-13-16-43,29-23,0"; got != expected {
+		t.Errorf("Expected JA3 %s, got %s", expected, got)
+	}
+	a, b, c := ja4Parts(h)
+	if diff := cmp.Diff([]string{"t13d0206h2", "1301,c02f", "000a,000b,000d,002b_0403,0804"}, []string{a, b, c}); diff != "" {
+		t.Error(diff)
+	}
+	if h.serverName != "example.com" || !cmp.Equal(h.alpn, []string{"h2", "http/1.1"}) {
+		t.Errorf("Unexpected server name %q or ALPN %v", h.serverName, h.alpn)
+	}
+}
+
+func TestParseServerHello(t *testing.T) {
+	typ, msg, err := handshakeMessage(testServerHello)
+	if err != nil || typ != typeServerHello {
+		t.Fatalf("Expected a ServerHello, got type %d: %v", typ, err)
+	}
+	h, err := parseServerHello(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := ja3sString(h), "771,49199,65281-16"; got != expected {
+		t.Errorf("Expected JA3S %s, got %s", expected, got)
+	}
+}
+
+func TestHandshakeMessage(t *testing.T) {
+	for name, tc := range map[string]struct {
+		stream []byte
+		err    error
+	}{
+		"http":       {[]byte("GET / HTTP/1.1\r\n"), errNotTLS},
+		"header":     {testClientHello[:3], errIncomplete},
+		"record":     {testClientHello[:50], errIncomplete},
+		"message":    {testClientHello[:105], errIncomplete},
+		"alert":      {[]byte{21, 3, 3, 0, 2, 2, 40}, errNotTLS},
+		"zero":       {[]byte{recordHandshake, 3, 3, 0, 0}, errNotTLS},
+		"sslv2":      {[]byte{0x80, 0x2e, 0x01, 0x00, 0x02}, errNotTLS},
+		"complete":   {testServerHello, nil},
+		"additional": {append(append([]byte(nil), testServerHello...), 23, 3, 3, 0, 1, 0), nil},
+	} {
+		if _, _, err := handshakeMessage(tc.stream); err != tc.err {
+			t.Errorf("%s: expected %v, got %v", name, tc.err, err)
+		}
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	h := NewHandshake()
+	isn, server := uint32(0xfffffff0), uint32(1000)
+
+	// Sequence numbers wrap around in the middle of the ClientHello, the last segment arrives
+	// first and the first one is retransmitted
+	steps := []struct {
+		reverse bool
+		seq     uint32
+		syn     bool
+		payload []byte
+	}{
+		{false, isn, true, nil},
+		{true, server, true, nil},
+		{false, isn + 1 + 80, false, testClientHello[80:]},
+		{false, isn + 1, false, testClientHello[:40]},
+		{false, isn + 1, false, testClientHello[:40]},
+		{false, isn + 1 + 40, false, testClientHello[40:80]},
+		{true, server + 1, false, testServerHello},
+	}
+	for i, s := range steps {
+		done := h.Add(s.reverse, s.seq, s.syn, s.payload)
+		if done != (i == len(steps)-1) {
+			t.Fatalf("Step %d: unexpected completion %v", i, done)
+		}
+		if i == 5 && (h.TLS() == nil || h.TLS().ServerName != "example.com") {
+			t.Fatalf("Expected the ClientHello after reassembly, got %+v", h.TLS())
+		}
+	}
+
+	expected := &flow.TLS{
+		Version:         "1.2",
+		VersionProtocol: "tls",
+		Cipher:          "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		ServerName:      "example.com",
+		ALPN:            []string{"h2", "http/1.1"},
+		NextProtocol:    "h2",
+		JA3:             md5Hex("771,4865-49199,0-10-11-13-16-43,29-23,0"),
+		JA4:             "t13d0206h2_" + truncatedSHA256("1301,c02f") + "_" + truncatedSHA256("000a,000b,000d,002b_0403,0804"),
+		JA3S:            md5Hex("771,49199,65281-16"),
+	}
+	if diff := cmp.Diff(expected, h.TLS()); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestHandshakeNotTLS(t *testing.T) {
+	h := NewHandshake()
+	if !h.Add(false, 1, false, []byte("GET / HTTP/1.1\r\n")) {
+		t.Error("Expected the inspection to finish on plain text")
+	}
+	if h.TLS() != nil {
+		t.Errorf("Expected no TLS parameters, got %+v", h.TLS())
+	}
+}
+
+func TestHandshakeGivesUp(t *testing.T) {
+	h := NewHandshake()
+	// Segments after a gap which is never filled
+	for i := 0; i < maxSegments; i++ {
+		h.Add(false, 1, false, testClientHello[:10])
+		h.Add(true, uint32(1000+100*i), false, []byte{recordHandshake})
+	}
+	if !h.finished() || h.TLS() != nil {
+		t.Errorf("Expected the inspection to give up, got %+v", h.TLS())
+	}
+}
+
+// TestClientHello inspects a ClientHello of the standard library
+func TestClientHello(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		c := tls.Client(client, &tls.Config{ServerName: "insight.example", NextProtos: []string{"h2"}})
+		c.Handshake()
+		c.Close()
+	}()
+
+	h := NewHandshake()
+	buf := make([]byte, 512)
+	seq := uint32(1)
+	for done := false; !done; {
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Add(false, seq, false, buf[:n])
+		seq += uint32(n)
+		done = h.done[0]
+	}
+
+	got := h.TLS()
+	if got == nil || got.ServerName != "insight.example" || !cmp.Equal(got.ALPN, []string{"h2"}) {
+		t.Fatalf("Unexpected TLS parameters %+v", got)
+	}
+	if !strings.HasPrefix(got.JA4, "t13d") || len(got.JA3) != 32 {
+		t.Errorf("Unexpected fingerprints %s, %s", got.JA3, got.JA4)
+	}
+}
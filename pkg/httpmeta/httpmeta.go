@@ -0,0 +1,346 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package httpmeta
+
+import (
+	"bytes"
+	"hash/fnv"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xvzf/insight/pkg/capture"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/flow/communityid"
+	"github.com/xvzf/insight/pkg/flow/reassembly"
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/protos"
+	"github.com/xvzf/insight/pkg/tracking"
+)
+
+// Logger
+var log *logrus.Entry
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "httpmeta",
+	})
+}
+
+// shards is the number of independently locked parts of the connection table
+const shards = 64
+
+// Options configure the tracking of HTTP requests
+type Options struct {
+	Timeout        time.Duration // Requests without a response and idle connections are expired after this long
+	MaxConnections int           // Maximum number of connections tracked (unbounded if 0)
+}
+
+// Tracker decodes the message headers of plaintext HTTP/1.x connections in the inspected
+// samples and writes requests with their responses as ECS http events
+type Tracker interface {
+	capture.Inspector
+	Run() error
+	Stop()
+}
+
+// connKey identifies a connection from a client to a server
+type connKey struct {
+	client     string
+	clientPort uint16
+	server     string
+	serverPort uint16
+}
+
+// exchange is a request and its response
+type exchange struct {
+	meta      flow.Meta // Client to server
+	request   *request
+	requested time.Time
+	response  *response
+	responded time.Time
+}
+
+// direction reassembles one direction of a connection and buffers the message header being
+// received
+type direction struct {
+	stream reassembly.Stream
+	header []byte
+	start  time.Time // Arrival of the first segment of the message
+}
+
+// conn is a tracked connection. Messages are expected to start at the beginning of a segment,
+// message bodies are skipped until the next segment starting a message.
+type conn struct {
+	meta    flow.Meta // Client to server
+	client  direction
+	server  direction
+	pending []*exchange // Requests waiting for a response, in order
+	seen    time.Time
+	closed  bool
+}
+
+// shard is a part of the connection table protected by its own lock
+type shard struct {
+	sync.Mutex
+	conns map[connKey]*conn
+	done  []*exchange // Completed exchanges since the last flush
+}
+
+type tracker struct {
+	tracking.Base
+	options Options
+	shards  [shards]shard
+	open    int64 // Tracked connections of all shards
+	hasher  communityid.Hasher
+}
+
+// NewTracker creates a new Tracker writing HTTP events to w
+func NewTracker(w tracking.Writer, opts Options) Tracker {
+	t := &tracker{
+		options: opts,
+		hasher:  communityid.NewHasher(0),
+	}
+	for i := range t.shards {
+		t.shards[i].conns = make(map[connKey]*conn)
+	}
+	t.Base = tracking.NewBase("HTTP", w, t.collect)
+	return t
+}
+
+// shardOf selects the shard of the connection of a sample, independent of its direction
+func (t *tracker) shardOf(s *capture.Sample) *shard {
+	return &t.shards[(endpointHash(s.Src, s.SrcPort)^endpointHash(s.Dst, s.DstPort))%shards]
+}
+
+func endpointHash(ip net.IP, port uint16) uint32 {
+	h := fnv.New32a()
+	h.Write(ip.To16())
+	h.Write([]byte{byte(port >> 8), byte(port)})
+	return h.Sum32()
+}
+
+// Inspect tracks TCP connections starting with an HTTP request and decodes their messages.
+// Segments without payload are only relevant if they close the connection.
+func (t *tracker) Inspect(s *capture.Sample) {
+	if s.Transport != protos.TCP {
+		return
+	}
+	fin := s.TCPFlags.Has(flow.TCPFlagFIN) || s.TCPFlags.Has(flow.TCPFlagRST)
+	if len(s.Payload) == 0 && !fin {
+		return
+	}
+
+	ts := s.Timestamp
+	if ts.IsZero() {
+		ts = t.Now()
+	}
+
+	sh := t.shardOf(s)
+	sh.Lock()
+	defer sh.Unlock()
+
+	c, fromClient := sh.conns[connKey{s.Src.String(), s.SrcPort, s.Dst.String(), s.DstPort}], true
+	if c == nil {
+		c, fromClient = sh.conns[connKey{s.Dst.String(), s.DstPort, s.Src.String(), s.SrcPort}], false
+	}
+	if c == nil {
+		if fin || !isRequest(s.Payload) {
+			return
+		}
+		if t.options.MaxConnections > 0 && atomic.LoadInt64(&t.open) >= int64(t.options.MaxConnections) {
+			connections.WithLabelValues("dropped").Inc()
+			return
+		}
+		connections.WithLabelValues("tracked").Inc()
+		c, fromClient = &conn{meta: s.FlowMeta()}, true
+		sh.conns[connKey{s.Src.String(), s.SrcPort, s.Dst.String(), s.DstPort}] = c
+		openConnections.Set(float64(atomic.AddInt64(&t.open, 1)))
+	}
+	c.seen = ts
+	c.closed = c.closed || fin
+
+	d, start := &c.server, isResponse
+	if fromClient {
+		d, start = &c.client, isRequest
+	}
+	deliver := func(data []byte) {
+		t.receive(sh, c, d, fromClient, start, data, ts)
+	}
+	syn := s.TCPFlags.Has(flow.TCPFlagSYN)
+	if !d.stream.Add(s.Seq, syn, s.Payload, deliver) {
+		// A segment has been lost, continue after the gap
+		d.header = nil
+		d.stream.Skip(deliver)
+		d.stream.Add(s.Seq, syn, s.Payload, deliver)
+	}
+}
+
+// receive adds data of the stream to the message header being received
+func (t *tracker) receive(sh *shard, c *conn, d *direction, fromClient bool, start func([]byte) bool, data []byte, ts time.Time) {
+	for len(data) > 0 {
+		if len(d.header) == 0 {
+			if !start(data) {
+				// Message body
+				return
+			}
+			d.start = ts
+		}
+
+		d.header = append(d.header, data...)
+		i := bytes.Index(d.header, headerEnd)
+		if i < 0 {
+			if len(d.header) > maxHeader {
+				messages.WithLabelValues("oversized").Inc()
+				d.header = nil
+			}
+			return
+		}
+
+		// Pipelined messages without body may follow the header
+		header := d.header[:i+len(headerEnd)]
+		data = append([]byte(nil), d.header[i+len(headerEnd):]...)
+		d.header = nil
+		if fromClient {
+			t.request(c, header, d.start)
+		} else {
+			t.response(sh, c, header, d.start)
+		}
+	}
+}
+
+// request starts an exchange with a request header
+func (t *tracker) request(c *conn, header []byte, ts time.Time) {
+	r, err := parseRequest(header)
+	if err != nil {
+		messages.WithLabelValues("malformed").Inc()
+		return
+	}
+	messages.WithLabelValues("request").Inc()
+	c.pending = append(c.pending, &exchange{meta: c.meta, request: r, requested: ts})
+}
+
+// response completes the oldest exchange of the connection with a response header
+func (t *tracker) response(sh *shard, c *conn, header []byte, ts time.Time) {
+	r, err := parseResponse(header)
+	if err != nil {
+		messages.WithLabelValues("malformed").Inc()
+		return
+	}
+	messages.WithLabelValues("response").Inc()
+	if r.statusCode < 200 {
+		// Interim response, the final response follows
+		return
+	}
+	if len(c.pending) == 0 {
+		messages.WithLabelValues("unmatched").Inc()
+		return
+	}
+
+	e := c.pending[0]
+	c.pending = c.pending[1:]
+	e.response, e.responded = r, ts
+	sh.done = append(sh.done, e)
+}
+
+// collect returns the completed requests and the requests which timed out. Closed and idle
+// connections are removed.
+func (t *tracker) collect(now time.Time) []*insight.Event {
+	var done []*exchange
+	for i := range t.shards {
+		sh := &t.shards[i]
+		sh.Lock()
+		done = append(done, sh.done...)
+		sh.done = nil
+		for key, c := range sh.conns {
+			for len(c.pending) > 0 && now.Sub(c.pending[0].requested) >= t.options.Timeout {
+				done = append(done, c.pending[0])
+				c.pending = c.pending[1:]
+			}
+			if len(c.pending) == 0 && (c.closed || now.Sub(c.seen) >= t.options.Timeout) {
+				delete(sh.conns, key)
+				atomic.AddInt64(&t.open, -1)
+			}
+		}
+		sh.Unlock()
+	}
+	openConnections.Set(float64(atomic.LoadInt64(&t.open)))
+
+	events := make([]*insight.Event, len(done))
+	for i, e := range done {
+		events[i] = t.event(e)
+		if e.response == nil {
+			requests.WithLabelValues("unanswered").Inc()
+		} else {
+			requests.WithLabelValues("answered").Inc()
+		}
+	}
+	return events
+}
+
+// event converts an exchange to an ECS event. The duration is the latency between the first
+// segments of the request and the response.
+func (t *tracker) event(x *exchange) *insight.Event {
+	r := x.request
+	h := &insight.HTTP{
+		Version: r.version,
+		Request: &insight.HTTPRequest{
+			Method:   r.method,
+			Referrer: r.referrer,
+		},
+	}
+	action, end := "http_request", x.requested
+	if x.response != nil {
+		h.Response = &insight.HTTPResponse{StatusCode: x.response.statusCode}
+		action, end = "http_response", x.responded
+	}
+
+	e := insight.NewEvent("http", action, x.requested, end)
+	e.Source = endpoint(x.meta.Src, x.meta.SrcPort)
+	e.Destination = endpoint(x.meta.Dst, x.meta.DstPort)
+	e.Network = &insight.NetworkDescription{
+		Type:        insight.IPVersion(x.meta.Src),
+		Transport:   x.meta.Transport.String(),
+		Protocol:    "http",
+		CommunityID: t.hasher.Hash(x.meta),
+	}
+	e.HTTP = h
+	e.URL = r.url()
+	if r.userAgent != "" {
+		e.UserAgent = &insight.UserAgent{Original: r.userAgent}
+	}
+	return e
+}
+
+func endpoint(ip net.IP, port uint16) *insight.EndpointDescription {
+	return &insight.EndpointDescription{
+		Address: ip.String(),
+		IP:      ip,
+		Port:    port,
+	}
+}
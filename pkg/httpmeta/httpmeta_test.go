@@ -0,0 +1,207 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package httpmeta
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/capture"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/protos"
+	"github.com/xvzf/insight/pkg/tracking/trackingtest"
+)
+
+var (
+	testClient = net.ParseIP("10.1.0.5")
+	testServer = net.ParseIP("10.1.2.7")
+	testStart  = time.Unix(1000, 0)
+)
+
+// sample creates a TCP segment from client to server, or the other way around
+func sample(payload string, fromServer bool, seq uint32, ts time.Time) *capture.Sample {
+	s := &capture.Sample{
+		Transport: protos.TCP,
+		Src:       testClient,
+		SrcPort:   41234,
+		Dst:       testServer,
+		DstPort:   8080,
+		TCPFlags:  flow.TCPFlagACK,
+		Seq:       seq,
+		Timestamp: ts,
+		Payload:   []byte(payload),
+	}
+	if fromServer {
+		s.Src, s.Dst, s.SrcPort, s.DstPort = s.Dst, s.Src, s.DstPort, s.SrcPort
+	}
+	return s
+}
+
+// testOptions are the options of the trackers under test
+var testOptions = Options{Timeout: 5 * time.Second}
+
+func TestParseRequest(t *testing.T) {
+	r, err := parseRequest([]byte("GET /api/v1/pods?watch=1 HTTP/1.1\r\nhost: api.example.com:8080\r\nUser-Agent: kubectl/v1.18.0\r\nReferer: http://example.com/\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &request{
+		method:    "GET",
+		target:    "/api/v1/pods?watch=1",
+		version:   "1.1",
+		host:      "api.example.com:8080",
+		userAgent: "kubectl/v1.18.0",
+		referrer:  "http://example.com/",
+	}
+	if !cmp.Equal(r, expected, cmp.AllowUnexported(request{})) {
+		t.Error(cmp.Diff(expected, r, cmp.AllowUnexported(request{})))
+	}
+
+	expectedURL := &insight.URL{Original: "/api/v1/pods?watch=1", Domain: "api.example.com", Port: 8080, Path: "/api/v1/pods", Query: "watch=1"}
+	if diff := cmp.Diff(expectedURL, r.url()); diff != "" {
+		t.Error(diff)
+	}
+
+	// Requests to a proxy carry the host in the target
+	r, err = parseRequest([]byte("GET http://[2001:db8::1]/index.html HTTP/1.0\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := r.url(); u.Domain != "2001:db8::1" || u.Path != "/index.html" || u.Port != 0 {
+		t.Errorf("Unexpected URL %+v", u)
+	}
+
+	for _, header := range []string{"GET /\r\n\r\n", "GET / SPDY/3\r\n\r\n"} {
+		if _, err := parseRequest([]byte(header)); err == nil {
+			t.Errorf("Expected an error for %q", header)
+		}
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	r, err := parseResponse([]byte("HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"))
+	if err != nil || r.statusCode != 404 || r.version != "1.1" {
+		t.Errorf("Unexpected response %+v: %v", r, err)
+	}
+	for _, header := range []string{"HTTP/1.1 OK\r\n\r\n", "HTTP/1.1 42 Bad\r\n\r\n", "ICY 200 OK\r\n\r\n"} {
+		if _, err := parseResponse([]byte(header)); err == nil {
+			t.Errorf("Expected an error for %q", header)
+		}
+	}
+}
+
+func TestTrackerExchange(t *testing.T) {
+	clock := trackingtest.NewClock(testStart)
+	tr := NewTracker(nil, testOptions).(*tracker)
+	w := trackingtest.Attach(&tr.Base, clock)
+
+	req := "POST /upload HTTP/1.1\r\nHost: files.example.com\r\nUser-Agent: curl/7.68.0\r\nContent-Length: 4\r\n\r\n"
+	resp := "HTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok"
+
+	// The request header spans two segments, the body arrives before the second one
+	tr.Inspect(sample(req[:20], false, 1000, testStart))
+	tr.Inspect(sample("data", false, 1000+uint32(len(req)), testStart.Add(time.Millisecond)))
+	tr.Inspect(sample(req[20:], false, 1020, testStart.Add(2*time.Millisecond)))
+	tr.Inspect(sample("HTTP/1.1 100 Continue\r\n\r\n", true, 5000, testStart.Add(3*time.Millisecond)))
+	tr.Inspect(sample(resp, true, 5025, testStart.Add(12*time.Millisecond)))
+	// Other traffic is ignored
+	tr.Inspect(&capture.Sample{Transport: protos.TCP, Src: testClient, SrcPort: 41235, Dst: testServer, DstPort: 443, Payload: []byte{0x16, 0x03, 0x01}})
+	tr.Flush()
+
+	if len(w.Events) != 1 {
+		t.Fatalf("Expected one event, got %d", len(w.Events))
+	}
+	e := w.Events[0]
+	if e.Event.Dataset != "http" || e.Event.Action != "http_response" || e.Event.Duration != 12*time.Millisecond || !e.Event.Start.Equal(testStart) {
+		t.Errorf("Unexpected event description %+v", e.Event)
+	}
+	if !e.Source.IP.Equal(testClient) || e.Source.Port != 41234 || !e.Destination.IP.Equal(testServer) || e.Destination.Port != 8080 {
+		t.Errorf("Unexpected endpoints %+v -> %+v", e.Source, e.Destination)
+	}
+	if e.Network.Protocol != "http" || e.Network.Transport != "tcp" || e.Network.CommunityID == "" {
+		t.Errorf("Unexpected network %+v", e.Network)
+	}
+	expected := &insight.HTTP{
+		Version:  "1.1",
+		Request:  &insight.HTTPRequest{Method: "POST"},
+		Response: &insight.HTTPResponse{StatusCode: 201},
+	}
+	if diff := cmp.Diff(expected, e.HTTP); diff != "" {
+		t.Error(diff)
+	}
+	if e.URL.Domain != "files.example.com" || e.URL.Path != "/upload" || e.UserAgent.Original != "curl/7.68.0" {
+		t.Errorf("Unexpected URL %+v or user agent %+v", e.URL, e.UserAgent)
+	}
+}
+
+func TestTrackerKeepAlive(t *testing.T) {
+	clock := trackingtest.NewClock(testStart)
+	tr := NewTracker(nil, testOptions).(*tracker)
+	w := trackingtest.Attach(&tr.Base, clock)
+
+	// Two pipelined requests in one segment, the second one is not answered
+	reqs := "GET /a HTTP/1.1\r\nHost: a\r\n\r\nGET /b HTTP/1.1\r\nHost: a\r\n\r\n"
+	tr.Inspect(sample(reqs, false, 1, testStart))
+	tr.Inspect(sample("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", true, 1, testStart.Add(time.Millisecond)))
+	tr.Flush()
+	if len(w.Events) != 1 || w.Events[0].URL.Path != "/a" {
+		t.Fatalf("Expected the answered request, got %d events", len(w.Events))
+	}
+
+	clock.Set(testStart.Add(5 * time.Second))
+	tr.Flush()
+	if len(w.Events) != 2 || w.Events[1].URL.Path != "/b" || w.Events[1].HTTP.Response != nil || w.Events[1].Event.Action != "http_request" {
+		t.Fatalf("Expected the unanswered request after the timeout, got %d events", len(w.Events))
+	}
+
+	// The connection is idle and removed
+	clock.Add(5 * time.Second)
+	tr.Flush()
+	if tr.open != 0 {
+		t.Errorf("Expected the idle connection to be removed, %d left", tr.open)
+	}
+}
+
+func TestTrackerConnectionLimit(t *testing.T) {
+	clock := trackingtest.NewClock(testStart)
+	tr := NewTracker(nil, testOptions).(*tracker)
+	trackingtest.Attach(&tr.Base, clock)
+	tr.options.MaxConnections = 1
+
+	for port := uint16(1); port <= 2; port++ {
+		s := sample("GET / HTTP/1.1\r\n\r\n", false, 1, testStart)
+		s.SrcPort = port
+		tr.Inspect(s)
+	}
+	// Responses do not start tracking
+	tr.Inspect(sample("HTTP/1.1 200 OK\r\n\r\n", true, 1, testStart))
+	if tr.open != 1 {
+		t.Errorf("Expected one tracked connection, got %d", tr.open)
+	}
+}
@@ -0,0 +1,153 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package httpmeta
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xvzf/insight/pkg/insight"
+)
+
+// maxHeader is the maximum size of a message header
+const maxHeader = 8 << 10
+
+// methods are the request methods recognized at the start of a message
+var methods = []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+
+var (
+	headerEnd    = []byte("\r\n\r\n")
+	responseLine = []byte("HTTP/1.")
+	errMalformed = errors.New("malformed HTTP message header")
+)
+
+// request is the header of a request
+type request struct {
+	method    string
+	target    string
+	version   string
+	host      string
+	userAgent string
+	referrer  string
+}
+
+// response is the header of a response
+type response struct {
+	version    string
+	statusCode int
+}
+
+// isRequest checks if data starts with a request line
+func isRequest(data []byte) bool {
+	for _, m := range methods {
+		if len(data) > len(m) && data[len(m)] == ' ' && string(data[:len(m)]) == m {
+			return true
+		}
+	}
+	return false
+}
+
+// isResponse checks if data starts with a status line
+func isResponse(data []byte) bool {
+	return bytes.HasPrefix(data, responseLine)
+}
+
+// headerLines splits a message header into its start line and header fields
+func headerLines(header []byte) (string, map[string]string) {
+	lines := strings.Split(string(bytes.TrimSuffix(header, headerEnd)), "\r\n")
+	fields := make(map[string]string)
+	for _, l := range lines[1:] {
+		i := strings.IndexByte(l, ':')
+		if i <= 0 {
+			continue
+		}
+		name := strings.ToLower(l[:i])
+		if _, ok := fields[name]; !ok {
+			fields[name] = strings.TrimSpace(l[i+1:])
+		}
+	}
+	return lines[0], fields
+}
+
+// parseRequest parses the header of a request
+func parseRequest(header []byte) (*request, error) {
+	line, fields := headerLines(header)
+	parts := strings.Split(line, " ")
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/1.") {
+		return nil, errMalformed
+	}
+	return &request{
+		method:    parts[0],
+		target:    parts[1],
+		version:   strings.TrimPrefix(parts[2], "HTTP/"),
+		host:      fields["host"],
+		userAgent: fields["user-agent"],
+		referrer:  fields["referer"],
+	}, nil
+}
+
+// parseResponse parses the header of a response
+func parseResponse(header []byte) (*response, error) {
+	line, _ := headerLines(header)
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "HTTP/1.") {
+		return nil, errMalformed
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || code < 100 || code > 999 {
+		return nil, errMalformed
+	}
+	return &response{
+		version:    strings.TrimPrefix(parts[0], "HTTP/"),
+		statusCode: code,
+	}, nil
+}
+
+// url returns the URL of a request in ECS. The host is taken from the request target in
+// absolute form, e.g. requests to a proxy, or from the Host header.
+func (r *request) url() *insight.URL {
+	u := &insight.URL{Original: r.target}
+	host := r.host
+	if parsed, err := url.ParseRequestURI(r.target); err == nil {
+		u.Path, u.Query = parsed.Path, parsed.RawQuery
+		if parsed.Host != "" {
+			host = parsed.Host
+		}
+	}
+
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		if port, err := strconv.ParseUint(p, 10, 16); err == nil {
+			u.Port = uint16(port)
+		}
+		host = h
+	}
+	u.Domain = strings.Trim(host, "[]")
+	return u
+}
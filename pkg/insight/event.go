@@ -28,6 +28,7 @@ package insight
 import (
 	"net"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/xvzf/insight/pkg/flow"
@@ -69,20 +70,187 @@ type EventDescription struct {
 
 // EndpointDescription in ECS
 type EndpointDescription struct {
-	Address string `json:"address"`
-	IP      net.IP `json:"ip"`
-	Port    uint16 `json:"port"`
-	Bytes   uint64 `json:"bytes"`
-	Packets uint64 `json:"packets"`
+	Address  string   `json:"address"`
+	IP       net.IP   `json:"ip"`
+	Port     uint16   `json:"port"`
+	Bytes    uint64   `json:"bytes,omitempty"` // Not counted for events of decoded payloads
+	Packets  uint64   `json:"packets,omitempty"`
+	TCPFlags []string `json:"tcp_flags,omitempty"` // Custom field, TCP flags sent by this endpoint
+	TCP      *TCP     `json:"tcp,omitempty"`       // Custom field, health of the TCP segments sent by this endpoint
+	NAT      *NAT     `json:"nat,omitempty"`
+	OrigIP   net.IP   `json:"orig_ip,omitempty"`   // Custom field, service address before rewriting
+	OrigPort uint16   `json:"orig_port,omitempty"` // Custom field, service port before rewriting
+	Domain   string   `json:"domain,omitempty"`
+
+	Kubernetes *Kubernetes `json:"kubernetes,omitempty"` // Custom field, kubernetes objects owning the address
+}
+
+// TCP contains the anomalies of the TCP segments sent by an endpoint
+type TCP struct {
+	Retransmissions uint64 `json:"retransmissions"`
+	OutOfOrder      uint64 `json:"out_of_order"`
+	ZeroWindows     uint64 `json:"zero_windows"`
+	DuplicateACKs   uint64 `json:"duplicate_acks"`
+}
+
+// NAT contains the translated address of an endpoint in ECS
+type NAT struct {
+	IP   net.IP `json:"ip"`
+	Port uint16 `json:"port"`
+}
+
+// Kubernetes contains the metadata of the kubernetes objects an address belongs to
+type Kubernetes struct {
+	Namespace string            `json:"namespace,omitempty"`
+	Pod       *KubernetesObject `json:"pod,omitempty"`
+	Node      *KubernetesObject `json:"node,omitempty"`
+	Service   *KubernetesObject `json:"service,omitempty"`
+	Owner     *KubernetesOwner  `json:"owner,omitempty"`    // Controller of the pod
+	Workload  *KubernetesOwner  `json:"workload,omitempty"` // Top level controller of the pod, e.g. the Deployment
+
+	Container *KubernetesContainer `json:"container,omitempty"` // Container owning the socket of a local endpoint
+}
+
+// KubernetesObject identifies a kubernetes object
+type KubernetesObject struct {
+	Name   string            `json:"name"`
+	UID    string            `json:"uid,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// KubernetesOwner identifies the controller of a kubernetes object
+type KubernetesOwner struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// KubernetesContainer identifies a container of a pod
+type KubernetesContainer struct {
+	Name string `json:"name,omitempty"`
+	ID   string `json:"id"`
 }
 
 // NetworkDescription in ECS
 type NetworkDescription struct {
-	Type        string `json:"type"`
-	Bytes       uint64 `json:"bytes"`
-	Packets     uint64 `json:"packets"`
-	Transport   string `json:"transport"`
-	CommunityID string `json:"community_id"`
+	Type            string        `json:"type"`
+	Bytes           uint64        `json:"bytes,omitempty"` // Not counted for events of decoded payloads
+	Packets         uint64        `json:"packets,omitempty"`
+	Transport       string        `json:"transport"`
+	Protocol        string        `json:"protocol,omitempty"`
+	ProtocolSource  string        `json:"protocol_source,omitempty"` // Custom field, how the protocol has been identified (payload, port)
+	CommunityID     string        `json:"community_id"`
+	ConnectionState string        `json:"connection_state,omitempty"`  // Custom field, TCP connection state
+	OrigCommunityID string        `json:"orig_community_id,omitempty"` // Custom field, CommunityID before rewriting
+	HandshakeRTT    time.Duration `json:"handshake_rtt,omitempty"`     // Custom field, TCP handshake round trip time
+}
+
+// Observer in ECS, the probe observing a flow
+type Observer struct {
+	Ingress *ObserverIngress `json:"ingress,omitempty"`
+}
+
+// ObserverIngress in ECS
+type ObserverIngress struct {
+	Interface *ObserverInterface `json:"interface"`
+}
+
+// ObserverInterface in ECS
+type ObserverInterface struct {
+	Name string `json:"name,omitempty"`
+	ID   string `json:"id,omitempty"`
+}
+
+// Process in ECS, the local process owning a socket of the flow
+type Process struct {
+	PID         int    `json:"pid"`
+	Name        string `json:"name,omitempty"`
+	Executable  string `json:"executable,omitempty"`
+	Cgroup      string `json:"cgroup,omitempty"`       // Custom field, cgroup path
+	ContainerID string `json:"container_id,omitempty"` // Custom field, container running the process
+	Endpoint    string `json:"endpoint"`               // Custom field, endpoint owning the socket (source, destination)
+}
+
+// DNS in ECS, a DNS query and its answer
+type DNS struct {
+	Type         string       `json:"type"` // query without answer or answer
+	ID           string       `json:"id"`
+	OpCode       string       `json:"op_code,omitempty"`
+	HeaderFlags  []string     `json:"header_flags,omitempty"`
+	ResponseCode string       `json:"response_code,omitempty"`
+	Question     *DNSQuestion `json:"question,omitempty"`
+	Answers      []DNSAnswer  `json:"answers,omitempty"`
+	ResolvedIP   []net.IP     `json:"resolved_ip,omitempty"`
+}
+
+// DNSQuestion in ECS
+type DNSQuestion struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+}
+
+// DNSAnswer in ECS
+type DNSAnswer struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+	TTL   uint32 `json:"ttl"`
+	Data  string `json:"data,omitempty"`
+}
+
+// TLS in ECS, the parameters of the TLS handshake of a flow
+type TLS struct {
+	Version         string     `json:"version,omitempty"`
+	VersionProtocol string     `json:"version_protocol,omitempty"`
+	Cipher          string     `json:"cipher,omitempty"`
+	NextProtocol    string     `json:"next_protocol,omitempty"`
+	Client          *TLSClient `json:"client,omitempty"`
+	Server          *TLSServer `json:"server,omitempty"`
+}
+
+// TLSClient in ECS
+type TLSClient struct {
+	ServerName string   `json:"server_name,omitempty"`
+	JA3        string   `json:"ja3"`
+	JA4        string   `json:"ja4"`            // Custom field, JA4 fingerprint
+	ALPN       []string `json:"alpn,omitempty"` // Custom field, offered application protocols
+}
+
+// TLSServer in ECS
+type TLSServer struct {
+	JA3S string `json:"ja3s"`
+}
+
+// HTTP in ECS, a request and its response
+type HTTP struct {
+	Version  string        `json:"version,omitempty"`
+	Request  *HTTPRequest  `json:"request"`
+	Response *HTTPResponse `json:"response,omitempty"`
+}
+
+// HTTPRequest in ECS
+type HTTPRequest struct {
+	Method   string `json:"method"`
+	Referrer string `json:"referrer,omitempty"`
+}
+
+// HTTPResponse in ECS
+type HTTPResponse struct {
+	StatusCode int `json:"status_code"`
+}
+
+// URL in ECS
+type URL struct {
+	Original string `json:"original"`
+	Domain   string `json:"domain,omitempty"`
+	Port     uint16 `json:"port,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Query    string `json:"query,omitempty"`
+}
+
+// UserAgent in ECS
+type UserAgent struct {
+	Original string `json:"original"`
 }
 
 // Event contains the event metadata passed to logstash
@@ -94,6 +262,18 @@ type Event struct {
 	Source      *EndpointDescription `json:"source"`
 	Destination *EndpointDescription `json:"destination"`
 	Network     *NetworkDescription  `json:"network"`
+	Observer    *Observer            `json:"observer,omitempty"`
+	Process     *Process             `json:"process,omitempty"`
+	DNS         *DNS                 `json:"dns,omitempty"`
+	TLS         *TLS                 `json:"tls,omitempty"`
+	HTTP        *HTTP                `json:"http,omitempty"`
+	URL         *URL                 `json:"url,omitempty"`
+	UserAgent   *UserAgent           `json:"user_agent,omitempty"`
+}
+
+// Enricher adds metadata to events before they are written
+type Enricher interface {
+	Enrich(events []*Event)
 }
 
 // NewFromFlows generates an event for every flow
@@ -108,12 +288,8 @@ func NewFromFlows(flows []*flow.Flow) []*Event {
 	return buf
 }
 
-// NewFromFlow generates a new event based on a flow
-func NewFromFlow(f *flow.Flow) *Event {
-	ipVersion := "ipv6"
-	if f.Meta.Src.To4() != nil {
-		ipVersion = "ipv4"
-	}
+// NewEvent creates an event of the given dataset and action with the agent and ECS metadata
+func NewEvent(dataset, action string, start, end time.Time) *Event {
 	return &Event{
 		Agent: &Agent{
 			HostName: hostname,
@@ -123,34 +299,129 @@ func NewFromFlow(f *flow.Flow) *Event {
 			Version: ECSversion,
 		},
 		Event: &EventDescription{
-			Duration: f.End.Sub(f.Start),
+			Duration: end.Sub(start),
 			Kind:     "event",
-			Action:   "network_flow",
+			Action:   action,
 			Category: "network_traffic",
-			Dataset:  "flow",
-			Start:    f.Start,
-			End:      f.End,
-		},
-		Source: &EndpointDescription{
-			Address: f.Meta.Src.String(),
-			IP:      f.Meta.Src,
-			Port:    f.Meta.SrcPort,
-			Bytes:   f.Incoming.Bytes,
-			Packets: f.Incoming.Packets,
-		},
-		Destination: &EndpointDescription{
-			Address: f.Meta.Dst.String(),
-			IP:      f.Meta.Dst,
-			Port:    f.Meta.DstPort,
-			Bytes:   f.Outgoing.Bytes,
-			Packets: f.Outgoing.Packets,
-		},
-		Network: &NetworkDescription{
-			Type:        ipVersion,
-			Bytes:       f.Incoming.Bytes + f.Outgoing.Bytes,
-			Packets:     f.Incoming.Packets + f.Outgoing.Packets,
-			Transport:   f.Meta.Transport.String(),
-			CommunityID: f.CommunityID,
+			Dataset:  dataset,
+			Start:    start,
+			End:      end,
 		},
 	}
 }
+
+// IPVersion returns the network type of an address in ECS
+func IPVersion(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// newTCP returns the TCP anomalies of a direction, nil if none have been seen
+func newTCP(c flow.Counters) *TCP {
+	if c.Retransmissions == 0 && c.OutOfOrder == 0 && c.ZeroWindows == 0 && c.DuplicateACKs == 0 {
+		return nil
+	}
+	return &TCP{
+		Retransmissions: c.Retransmissions,
+		OutOfOrder:      c.OutOfOrder,
+		ZeroWindows:     c.ZeroWindows,
+		DuplicateACKs:   c.DuplicateACKs,
+	}
+}
+
+// NewFromFlow generates a new event based on a flow
+func NewFromFlow(f *flow.Flow) *Event {
+	e := NewEvent("flow", "network_flow", f.Start, f.End)
+	e.Source = &EndpointDescription{
+		Address:  f.Meta.Src.String(),
+		IP:       f.Meta.Src,
+		Port:     f.Meta.SrcPort,
+		Bytes:    f.Incoming.Bytes,
+		Packets:  f.Incoming.Packets,
+		TCPFlags: f.Incoming.TCPFlags.Names(),
+	}
+	e.Destination = &EndpointDescription{
+		Address:  f.Meta.Dst.String(),
+		IP:       f.Meta.Dst,
+		Port:     f.Meta.DstPort,
+		Bytes:    f.Outgoing.Bytes,
+		Packets:  f.Outgoing.Packets,
+		TCPFlags: f.Outgoing.TCPFlags.Names(),
+	}
+	e.Network = &NetworkDescription{
+		Type:            IPVersion(f.Meta.Src),
+		Bytes:           f.Incoming.Bytes + f.Outgoing.Bytes,
+		Packets:         f.Incoming.Packets + f.Outgoing.Packets,
+		Transport:       f.Meta.Transport.String(),
+		Protocol:        f.Protocol,
+		ProtocolSource:  f.ProtoSource,
+		CommunityID:     f.CommunityID,
+		ConnectionState: f.State().String(),
+		HandshakeRTT:    f.RTT,
+	}
+	e.Source.TCP = newTCP(f.Incoming)
+	e.Destination.TCP = newTCP(f.Outgoing)
+
+	if i := f.Interface; i.Name != "" || i.Index != 0 {
+		e.Observer = &Observer{Ingress: &ObserverIngress{Interface: &ObserverInterface{Name: i.Name}}}
+		if i.Index != 0 {
+			e.Observer.Ingress.Interface.ID = strconv.Itoa(i.Index)
+		}
+	}
+
+	if o := f.Original; o != nil {
+		if !o.Meta.Src.Equal(f.Meta.Src) || o.Meta.SrcPort != f.Meta.SrcPort {
+			e.Source.OrigIP, e.Source.OrigPort = o.Meta.Src, o.Meta.SrcPort
+		}
+		if !o.Meta.Dst.Equal(f.Meta.Dst) || o.Meta.DstPort != f.Meta.DstPort {
+			e.Destination.OrigIP, e.Destination.OrigPort = o.Meta.Dst, o.Meta.DstPort
+		}
+		e.Network.OrigCommunityID = o.CommunityID
+	}
+
+	if t := f.Translated; t != nil {
+		if !t.Src.Equal(f.Meta.Src) || t.SrcPort != f.Meta.SrcPort {
+			e.Source.NAT = &NAT{IP: t.Src, Port: t.SrcPort}
+		}
+		if !t.Dst.Equal(f.Meta.Dst) || t.DstPort != f.Meta.DstPort {
+			e.Destination.NAT = &NAT{IP: t.Dst, Port: t.DstPort}
+		}
+	}
+
+	if p := f.Process; p != nil {
+		e.Process = &Process{
+			PID:         p.PID,
+			Name:        p.Comm,
+			Executable:  p.Executable,
+			Cgroup:      p.Cgroup,
+			ContainerID: p.ContainerID,
+			Endpoint:    "destination",
+		}
+		if p.Source {
+			e.Process.Endpoint = "source"
+		}
+	}
+
+	if t := f.TLS; t != nil {
+		e.TLS = &TLS{
+			Version:         t.Version,
+			VersionProtocol: t.VersionProtocol,
+			Cipher:          t.Cipher,
+			NextProtocol:    t.NextProtocol,
+		}
+		if t.JA3 != "" {
+			e.TLS.Client = &TLSClient{
+				ServerName: t.ServerName,
+				JA3:        t.JA3,
+				JA4:        t.JA4,
+				ALPN:       t.ALPN,
+			}
+		}
+		if t.JA3S != "" {
+			e.TLS.Server = &TLSServer{JA3S: t.JA3S}
+		}
+	}
+	return e
+}
@@ -0,0 +1,260 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package insight
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+var (
+	testStart  = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	testEnd    = testStart.Add(3 * time.Second)
+	testClient = net.ParseIP("10.1.0.5")
+	testServer = net.ParseIP("10.1.1.7")
+)
+
+// testFlow creates a TCP flow from testClient to testServer, modified by update
+func testFlow(update func(f *flow.Flow)) *flow.Flow {
+	f := &flow.Flow{
+		Meta:        flow.Meta{Transport: protos.TCP, Src: testClient, SrcPort: 41234, Dst: testServer, DstPort: 8080},
+		Incoming:    flow.Counters{Packets: 4, Bytes: 400, TCPFlags: flow.TCPFlagSYN | flow.TCPFlagACK},
+		Outgoing:    flow.Counters{Packets: 3, Bytes: 1200, TCPFlags: flow.TCPFlagSYN | flow.TCPFlagACK},
+		CommunityID: "1:LQU9qZlK+B5F3KDmev6m5PMibrg=",
+		Start:       testStart,
+		End:         testEnd,
+	}
+	if update != nil {
+		update(f)
+	}
+	return f
+}
+
+// testEvent creates the event of testFlow(nil), modified by update
+func testEvent(update func(e *Event)) *Event {
+	e := NewEvent("flow", "network_flow", testStart, testEnd)
+	e.Source = &EndpointDescription{
+		Address:  "10.1.0.5",
+		IP:       testClient,
+		Port:     41234,
+		Bytes:    400,
+		Packets:  4,
+		TCPFlags: []string{"SYN", "ACK"},
+	}
+	e.Destination = &EndpointDescription{
+		Address:  "10.1.1.7",
+		IP:       testServer,
+		Port:     8080,
+		Bytes:    1200,
+		Packets:  3,
+		TCPFlags: []string{"SYN", "ACK"},
+	}
+	e.Network = &NetworkDescription{
+		Type:            "ipv4",
+		Bytes:           1600,
+		Packets:         7,
+		Transport:       "tcp",
+		CommunityID:     "1:LQU9qZlK+B5F3KDmev6m5PMibrg=",
+		ConnectionState: "established",
+	}
+	if update != nil {
+		update(e)
+	}
+	return e
+}
+
+func TestNewFromFlow(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		flow     *flow.Flow
+		expected *Event
+	}{{
+		name:     "tcp",
+		flow:     testFlow(nil),
+		expected: testEvent(nil),
+	}, {
+		name: "udp without flags",
+		flow: testFlow(func(f *flow.Flow) {
+			f.Meta.Transport = protos.UDP
+			f.Incoming.TCPFlags, f.Outgoing.TCPFlags = 0, 0
+		}),
+		expected: testEvent(func(e *Event) {
+			e.Source.TCPFlags, e.Destination.TCPFlags = nil, nil
+			e.Network.Transport, e.Network.ConnectionState = "udp", ""
+		}),
+	}, {
+		name: "connection state reported by conntrack",
+		flow: testFlow(func(f *flow.Flow) {
+			f.Incoming.TCPFlags, f.Outgoing.TCPFlags = 0, 0
+			f.ConnState = flow.StateClosed
+		}),
+		expected: testEvent(func(e *Event) {
+			e.Source.TCPFlags, e.Destination.TCPFlags = nil, nil
+			e.Network.ConnectionState = "closed"
+		}),
+	}, {
+		name: "source nat",
+		flow: testFlow(func(f *flow.Flow) {
+			f.Translated = &flow.Meta{Transport: protos.TCP, Src: net.ParseIP("192.168.0.10"), SrcPort: 61000, Dst: testServer, DstPort: 8080}
+		}),
+		expected: testEvent(func(e *Event) {
+			e.Source.NAT = &NAT{IP: net.ParseIP("192.168.0.10"), Port: 61000}
+		}),
+	}, {
+		name: "service rewritten to a backend",
+		flow: testFlow(func(f *flow.Flow) {
+			f.Original = &flow.Original{
+				Meta:        flow.Meta{Transport: protos.TCP, Src: testClient, SrcPort: 41234, Dst: net.ParseIP("10.96.0.20"), DstPort: 80},
+				CommunityID: "1:pXs0pk3dFhVn8XHdjRYbpsUP2cQ=",
+			}
+		}),
+		expected: testEvent(func(e *Event) {
+			e.Destination.OrigIP, e.Destination.OrigPort = net.ParseIP("10.96.0.20"), 80
+			e.Network.OrigCommunityID = "1:pXs0pk3dFhVn8XHdjRYbpsUP2cQ="
+		}),
+	}, {
+		name: "observer",
+		flow: testFlow(func(f *flow.Flow) {
+			f.Interface = flow.Interface{Name: "cali1234", Index: 12}
+		}),
+		expected: testEvent(func(e *Event) {
+			e.Observer = &Observer{Ingress: &ObserverIngress{Interface: &ObserverInterface{Name: "cali1234", ID: "12"}}}
+		}),
+	}, {
+		name: "process of a container",
+		flow: testFlow(func(f *flow.Flow) {
+			f.Process = &flow.Process{
+				PID:         4242,
+				Comm:        "curl",
+				Executable:  "/usr/bin/curl",
+				Cgroup:      "/kubepods/besteffort/podc0ffee/3f4a5b6c",
+				ContainerID: "3f4a5b6c",
+				Source:      true,
+			}
+		}),
+		// Kubernetes metadata of the endpoints is added by enrichers based on the addresses and
+		// the container ID
+		expected: testEvent(func(e *Event) {
+			e.Process = &Process{
+				PID:         4242,
+				Name:        "curl",
+				Executable:  "/usr/bin/curl",
+				Cgroup:      "/kubepods/besteffort/podc0ffee/3f4a5b6c",
+				ContainerID: "3f4a5b6c",
+				Endpoint:    "source",
+			}
+		}),
+	}, {
+		name: "tls",
+		flow: testFlow(func(f *flow.Flow) {
+			f.Protocol, f.ProtoSource = "tls", flow.ProtoSourcePayload
+			f.TLS = &flow.TLS{
+				Version:         "1.3",
+				VersionProtocol: "tls",
+				Cipher:          "TLS_AES_128_GCM_SHA256",
+				ServerName:      "api.example.com",
+				ALPN:            []string{"h2", "http/1.1"},
+				NextProtocol:    "h2",
+				JA3:             "771,4865-4866,0-23-65281,29-23,0",
+				JA4:             "t13d0203h2_55b375c5d22e_06ba2ec1cba1",
+				JA3S:            "771,4865,43-51",
+			}
+		}),
+		expected: testEvent(func(e *Event) {
+			e.Network.Protocol, e.Network.ProtocolSource = "tls", "payload"
+			e.TLS = &TLS{
+				Version:         "1.3",
+				VersionProtocol: "tls",
+				Cipher:          "TLS_AES_128_GCM_SHA256",
+				NextProtocol:    "h2",
+				Client: &TLSClient{
+					ServerName: "api.example.com",
+					JA3:        "771,4865-4866,0-23-65281,29-23,0",
+					JA4:        "t13d0203h2_55b375c5d22e_06ba2ec1cba1",
+					ALPN:       []string{"h2", "http/1.1"},
+				},
+				Server: &TLSServer{JA3S: "771,4865,43-51"},
+			}
+		}),
+	}, {
+		name: "protocol by port",
+		flow: testFlow(func(f *flow.Flow) {
+			f.Protocol, f.ProtoSource = "http", flow.ProtoSourcePort
+		}),
+		expected: testEvent(func(e *Event) {
+			e.Network.Protocol, e.Network.ProtocolSource = "http", "port"
+		}),
+	}, {
+		name: "tcp metrics",
+		flow: testFlow(func(f *flow.Flow) {
+			f.RTT = 1500 * time.Microsecond
+			f.Incoming.Retransmissions, f.Incoming.OutOfOrder = 2, 1
+			f.Outgoing.ZeroWindows, f.Outgoing.DuplicateACKs = 1, 3
+		}),
+		expected: testEvent(func(e *Event) {
+			e.Network.HandshakeRTT = 1500 * time.Microsecond
+			e.Source.TCP = &TCP{Retransmissions: 2, OutOfOrder: 1}
+			e.Destination.TCP = &TCP{ZeroWindows: 1, DuplicateACKs: 3}
+		}),
+	}} {
+		if diff := cmp.Diff(test.expected, NewFromFlow(test.flow)); diff != "" {
+			t.Errorf("%s: %s", test.name, diff)
+		}
+	}
+}
+
+func TestNewFromFlows(t *testing.T) {
+	flows := []*flow.Flow{testFlow(nil), testFlow(func(f *flow.Flow) { f.Meta.SrcPort = 41235 })}
+	events := NewFromFlows(flows)
+	if len(events) != 2 || events[0].Source.Port != 41234 || events[1].Source.Port != 41235 {
+		t.Errorf("Expected an event for every flow, got %+v", events)
+	}
+}
+
+func TestEventWithoutCounters(t *testing.T) {
+	// Events of decoded payloads, e.g. DNS transactions, do not count the traffic
+	e := NewEvent("dns", "dns_answer", testStart, testEnd)
+	e.Source = &EndpointDescription{Address: "10.1.0.5", IP: net.ParseIP("10.1.0.5"), Port: 41234}
+	e.Destination = &EndpointDescription{Address: "10.96.0.10", IP: net.ParseIP("10.96.0.10"), Port: 53}
+	e.Network = &NetworkDescription{Type: "ipv4", Transport: "udp", Protocol: "dns"}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"bytes"`, `"packets"`} {
+		if strings.Contains(string(b), field) {
+			t.Errorf("Unexpected %s in %s", field, b)
+		}
+	}
+}
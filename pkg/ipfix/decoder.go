@@ -0,0 +1,414 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package ipfix
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+// Decoder parses NetFlow v5, NetFlow v9 and IPFIX messages into flows. Templates are
+// cached per exporter and observation domain (source ID).
+type Decoder interface {
+	Decode(exporter string, msg []byte) ([]*flow.Flow, error)
+}
+
+// templateKey identifies a template of an exporter
+type templateKey struct {
+	exporter string
+	domain   uint32
+	id       uint16
+}
+
+type decoder struct {
+	sync.Mutex
+	templates map[templateKey]*Template
+}
+
+// NewDecoder creates a new Decoder
+func NewDecoder() Decoder {
+	return &decoder{
+		templates: make(map[templateKey]*Template),
+	}
+}
+
+// header contains the information of a message header required to decode its records
+type header struct {
+	version    Version
+	exportTime time.Time
+	uptime     uint32 // NetFlow v9 sysUptime in ms
+	domain     uint32
+}
+
+// Decode decodes a message received from the given exporter (e.g. its IP:port)
+func (d *decoder) Decode(exporter string, msg []byte) ([]*flow.Flow, error) {
+	if len(msg) < 2 {
+		return nil, errors.New("message too short")
+	}
+
+	switch Version(binary.BigEndian.Uint16(msg)) {
+	case VersionNetflow5:
+		return decodeNetflow5(msg)
+	case VersionNetflow9:
+		if len(msg) < netflow9HeaderLength {
+			return nil, errors.New("NetFlow v9 header too short")
+		}
+		h := header{
+			version:    VersionNetflow9,
+			uptime:     binary.BigEndian.Uint32(msg[4:]),
+			exportTime: time.Unix(int64(binary.BigEndian.Uint32(msg[8:])), 0),
+			domain:     binary.BigEndian.Uint32(msg[16:]),
+		}
+		return d.decodeSets(exporter, h, msg[netflow9HeaderLength:])
+	case VersionIPFIX:
+		if len(msg) < ipfixHeaderLength {
+			return nil, errors.New("IPFIX header too short")
+		}
+		l := int(binary.BigEndian.Uint16(msg[2:]))
+		if l > len(msg) || l < ipfixHeaderLength {
+			return nil, fmt.Errorf("invalid IPFIX message length %d", l)
+		}
+		h := header{
+			version:    VersionIPFIX,
+			exportTime: time.Unix(int64(binary.BigEndian.Uint32(msg[4:])), 0),
+			domain:     binary.BigEndian.Uint32(msg[12:]),
+		}
+		return d.decodeSets(exporter, h, msg[ipfixHeaderLength:l])
+	default:
+		return nil, fmt.Errorf("unsupported version %d", binary.BigEndian.Uint16(msg))
+	}
+}
+
+// decodeNetflow5 decodes the fixed NetFlow v5 record format
+func decodeNetflow5(msg []byte) ([]*flow.Flow, error) {
+	if len(msg) < netflow5HeaderLength {
+		return nil, errors.New("NetFlow v5 header too short")
+	}
+
+	count := int(binary.BigEndian.Uint16(msg[2:]))
+	if len(msg) < netflow5HeaderLength+count*netflow5RecordLength {
+		return nil, errors.New("NetFlow v5 message truncated")
+	}
+
+	uptime := binary.BigEndian.Uint32(msg[4:])
+	exportTime := time.Unix(int64(binary.BigEndian.Uint32(msg[8:])), int64(binary.BigEndian.Uint32(msg[12:])))
+
+	var flows []*flow.Flow
+	for i := 0; i < count; i++ {
+		b := msg[netflow5HeaderLength+i*netflow5RecordLength:]
+		r := &rawRecord{
+			meta: flow.Meta{
+				Src:       net.IP(append([]byte(nil), b[0:4]...)),
+				Dst:       net.IP(append([]byte(nil), b[4:8]...)),
+				Transport: protos.ProtocolType(b[38]),
+			},
+			counters: flow.Counters{
+				Packets:  uint64(binary.BigEndian.Uint32(b[16:])),
+				Bytes:    uint64(binary.BigEndian.Uint32(b[20:])),
+				TCPFlags: flow.TCPFlags(b[37]),
+			},
+			start: sysUptimeToTime(exportTime, uptime, binary.BigEndian.Uint32(b[24:])),
+			end:   sysUptimeToTime(exportTime, uptime, binary.BigEndian.Uint32(b[28:])),
+		}
+
+		srcPort, dstPort := binary.BigEndian.Uint16(b[32:]), binary.BigEndian.Uint16(b[34:])
+		if r.meta.Transport == protos.ICMP4 {
+			// ICMP type and code are encoded in the destination port
+			r.meta.IcmpType, r.meta.IcmpCode = dstPort>>8, dstPort&0xff
+		} else {
+			r.meta.SrcPort, r.meta.DstPort = srcPort, dstPort
+		}
+
+		flows = append(flows, r.flow())
+	}
+
+	return flows, nil
+}
+
+// sysUptimeToTime converts a NetFlow sysUptime based timestamp to an absolute time. The signed
+// difference covers timestamps after the export time as well as a wrapped uptime.
+func sysUptimeToTime(exportTime time.Time, uptime, t uint32) time.Time {
+	return exportTime.Add(-time.Duration(int32(uptime-t)) * time.Millisecond)
+}
+
+// decodeSets decodes the template and data sets (flow sets) of a NetFlow v9 or IPFIX message
+func (d *decoder) decodeSets(exporter string, h header, b []byte) ([]*flow.Flow, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	var flows []*flow.Flow
+	for len(b) >= setHeaderLength {
+		id, l := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if l < setHeaderLength || l > len(b) {
+			return flows, fmt.Errorf("invalid set length %d", l)
+		}
+		body := b[setHeaderLength:l]
+		b = b[l:]
+
+		switch {
+		case id == netflow9TemplateSetID && h.version == VersionNetflow9,
+			id == ipfixTemplateSetID && h.version == VersionIPFIX:
+			if err := d.decodeTemplates(exporter, h, body); err != nil {
+				return flows, err
+			}
+		case id >= minDataSetID:
+			t, ok := d.templates[templateKey{exporter, h.domain, id}]
+			if !ok {
+				log.WithField("exporter", exporter).Debugf("Template %d unknown, skipping data set", id)
+				continue
+			}
+			fs, err := decodeData(h, t, body)
+			flows = append(flows, fs...)
+			if err != nil {
+				return flows, err
+			}
+		default:
+			// Options templates and options data are not used
+		}
+	}
+
+	return flows, nil
+}
+
+// decodeTemplates decodes the template records of a template set
+func (d *decoder) decodeTemplates(exporter string, h header, b []byte) error {
+	// Records are at least 4 bytes long, anything shorter is padding
+	for len(b) >= 4 {
+		t := &Template{
+			ID: binary.BigEndian.Uint16(b),
+		}
+		count := int(binary.BigEndian.Uint16(b[2:]))
+		b = b[4:]
+
+		key := templateKey{exporter, h.domain, t.ID}
+
+		// IPFIX template withdrawal
+		if count == 0 {
+			delete(d.templates, key)
+			continue
+		}
+
+		for i := 0; i < count; i++ {
+			if len(b) < 4 {
+				return errors.New("template record truncated")
+			}
+			f := FieldSpecifier{
+				ID:     binary.BigEndian.Uint16(b),
+				Length: binary.BigEndian.Uint16(b[2:]),
+			}
+			b = b[4:]
+
+			if h.version == VersionIPFIX && f.ID&enterpriseBit != 0 {
+				if len(b) < 4 {
+					return errors.New("template record truncated")
+				}
+				f.ID &^= enterpriseBit
+				f.Enterprise = binary.BigEndian.Uint32(b)
+				b = b[4:]
+			}
+			t.Fields = append(t.Fields, f)
+		}
+
+		d.templates[key] = t
+	}
+	return nil
+}
+
+// decodeData decodes all data records of a data set
+func decodeData(h header, t *Template, b []byte) ([]*flow.Flow, error) {
+	var flows []*flow.Flow
+
+	// Templates without fields would never terminate
+	minLength := t.minLength()
+	if minLength == 0 {
+		return nil, errors.New("empty template")
+	}
+
+	// Remaining bytes shorter than a record are padding
+	for len(b) >= minLength {
+		r := &rawRecord{}
+		consumed := 0
+
+		for _, f := range t.Fields {
+			l := int(f.Length)
+			if f.Length == variableLength {
+				if len(b) < consumed+1 {
+					return flows, errors.New("data record truncated")
+				}
+				l = int(b[consumed])
+				consumed++
+				if l == 255 {
+					if len(b) < consumed+2 {
+						return flows, errors.New("data record truncated")
+					}
+					l = int(binary.BigEndian.Uint16(b[consumed:]))
+					consumed += 2
+				}
+			}
+
+			if len(b) < consumed+l {
+				return flows, errors.New("data record truncated")
+			}
+
+			r.set(h, f, b[consumed:consumed+l])
+			consumed += l
+		}
+
+		b = b[consumed:]
+
+		if r.start.IsZero() {
+			r.start = h.exportTime
+		}
+		if r.end.IsZero() {
+			r.end = r.start
+		}
+		flows = append(flows, r.flow())
+	}
+
+	return flows, nil
+}
+
+// minLength returns the minimum length of a data record
+func (t *Template) minLength() int {
+	l := 0
+	for _, f := range t.Fields {
+		if f.Length == variableLength {
+			l++
+			continue
+		}
+		l += int(f.Length)
+	}
+	return l
+}
+
+// rawRecord contains the values of one decoded data record
+type rawRecord struct {
+	meta       flow.Meta
+	counters   flow.Counters // Forward direction
+	reverse    flow.Counters // Reverse direction (IPFIX biflow)
+	start, end time.Time
+	endReason  flow.EndReason
+	ifindex    int // Ingress interface
+}
+
+// readUint decodes an unsigned integer of up to 8 bytes (reduced size encoding)
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// set applies a field of a data record
+func (r *rawRecord) set(h header, f FieldSpecifier, b []byte) {
+	switch f.Enterprise {
+	case 0:
+	case ReversePEN:
+		switch f.ID {
+		case IEOctetDeltaCount, IEOctetTotalCount:
+			r.reverse.Bytes = readUint(b)
+		case IEPacketDeltaCount, IEPacketTotalCount:
+			r.reverse.Packets = readUint(b)
+		case IETCPControlBits:
+			r.reverse.TCPFlags = flow.TCPFlags(readUint(b))
+		}
+		return
+	default:
+		// Unknown enterprise specific element
+		return
+	}
+
+	switch f.ID {
+	case IESourceIPv4Address, IESourceIPv6Address:
+		r.meta.Src = net.IP(append([]byte(nil), b...))
+	case IEDestinationIPv4Address, IEDestinationIPv6Address:
+		r.meta.Dst = net.IP(append([]byte(nil), b...))
+	case IESourceTransportPort:
+		r.meta.SrcPort = uint16(readUint(b))
+	case IEDestinationTransportPort:
+		r.meta.DstPort = uint16(readUint(b))
+	case IEProtocolIdentifier:
+		r.meta.Transport = protos.ProtocolType(readUint(b))
+	case IEICMPTypeCodeIPv4, IEICMPTypeCodeIPv6:
+		tc := uint16(readUint(b))
+		r.meta.IcmpType, r.meta.IcmpCode = tc>>8, tc&0xff
+	case IEICMPTypeIPv4, IEICMPTypeIPv6:
+		r.meta.IcmpType = uint16(readUint(b))
+	case IEICMPCodeIPv4, IEICMPCodeIPv6:
+		r.meta.IcmpCode = uint16(readUint(b))
+	case IEOctetDeltaCount, IEOctetTotalCount:
+		r.counters.Bytes = readUint(b)
+	case IEPacketDeltaCount, IEPacketTotalCount:
+		r.counters.Packets = readUint(b)
+	case IETCPControlBits:
+		r.counters.TCPFlags = flow.TCPFlags(readUint(b))
+	case IEIngressInterface:
+		r.ifindex = int(readUint(b))
+	case IEFlowEndReason:
+		r.endReason = flow.EndReason(readUint(b))
+	case IEFirstSwitched:
+		r.start = sysUptimeToTime(h.exportTime, h.uptime, uint32(readUint(b)))
+	case IELastSwitched:
+		r.end = sysUptimeToTime(h.exportTime, h.uptime, uint32(readUint(b)))
+	case IEFlowStartSeconds:
+		r.start = time.Unix(int64(readUint(b)), 0)
+	case IEFlowEndSeconds:
+		r.end = time.Unix(int64(readUint(b)), 0)
+	case IEFlowStartMilliseconds:
+		r.start = time.Unix(0, int64(readUint(b))*int64(time.Millisecond))
+	case IEFlowEndMilliseconds:
+		r.end = time.Unix(0, int64(readUint(b))*int64(time.Millisecond))
+	}
+}
+
+// flow converts the record to a flow with corrected source (client -> server)
+func (r *rawRecord) flow() *flow.Flow {
+	// ICMP type/code are only valid for ICMP flows; some exporters encode them in the ports
+	if r.meta.Transport != protos.ICMP4 && r.meta.Transport != protos.ICMP6 {
+		r.meta.IcmpType, r.meta.IcmpCode = 0, 0
+	}
+
+	f := flow.New(r.meta.WithCorrectedSource())
+	f.Start, f.End, f.EndReason = r.start, r.end, r.endReason
+	f.Interface.Index = r.ifindex
+
+	if f.Meta.Src.Equal(r.meta.Src) {
+		f.Incoming, f.Outgoing = r.counters, r.reverse
+	} else {
+		// Exported record describes the server -> client direction
+		f.Incoming, f.Outgoing = r.reverse, r.counters
+	}
+
+	return f
+}
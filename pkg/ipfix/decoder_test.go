@@ -0,0 +1,181 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package ipfix
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+func TestDecodeIPFIX(t *testing.T) {
+	e := NewEncoder(VersionIPFIX, 42, DefaultPEN, 1400)
+	d := NewDecoder()
+
+	for _, msg := range e.Encode([]*flow.Flow{testFlowV4, testFlowV6}, testTime, true) {
+		flows, err := d.Decode("10.0.0.254:4739", msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(flows) != 2 {
+			t.Fatalf("expected 2 flows, got %d", len(flows))
+		}
+
+		for i, expected := range []*flow.Flow{testFlowV4, testFlowV6} {
+			// CommunityID is not decoded but computed by the consumer
+			expected := *expected
+			expected.CommunityID = ""
+			if !cmp.Equal(*flows[i], expected) {
+				t.Error(cmp.Diff(expected, *flows[i]))
+			}
+		}
+	}
+
+	// Templates are cached per exporter
+	msgs := e.Encode([]*flow.Flow{testFlowV4}, testTime, false)
+	if flows, err := d.Decode("10.0.0.254:4739", msgs[0]); err != nil || len(flows) != 1 {
+		t.Errorf("failed to decode with cached template: %v", err)
+	}
+	if flows, err := d.Decode("10.0.0.253:4739", msgs[0]); err != nil || len(flows) != 0 {
+		t.Errorf("expected unknown template to be skipped, got %d flows (%v)", len(flows), err)
+	}
+}
+
+func TestDecodeNetflow9(t *testing.T) {
+	e := NewEncoder(VersionNetflow9, 42, DefaultPEN, 1400)
+	d := NewDecoder()
+
+	msgs := e.Encode([]*flow.Flow{testFlowV4}, time.Now(), true)
+	flows, err := d.Decode("10.0.0.254:2055", msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One record per direction
+	if len(flows) != 2 {
+		t.Fatalf("expected 2 flows, got %d", len(flows))
+	}
+
+	if !cmp.Equal(flows[0].Meta, testFlowV4.Meta) || !cmp.Equal(flows[1].Meta, testFlowV4.Meta) {
+		t.Error("source correction failed")
+	}
+	if !cmp.Equal(flows[0].Incoming, testFlowV4.Incoming) || !cmp.Equal(flows[1].Outgoing, testFlowV4.Outgoing) {
+		t.Error("invalid counters")
+	}
+}
+
+func TestDecodeNetflow5(t *testing.T) {
+	exportTime := time.Unix(1577880000, 0)
+
+	msg := make([]byte, netflow5HeaderLength+2*netflow5RecordLength)
+	binary.BigEndian.PutUint16(msg[0:], 5)
+	binary.BigEndian.PutUint16(msg[2:], 2)
+	binary.BigEndian.PutUint32(msg[4:], 100000) // sysUptime
+	binary.BigEndian.PutUint32(msg[8:], uint32(exportTime.Unix()))
+
+	// UDP response DNS server -> client
+	r := msg[netflow5HeaderLength:]
+	copy(r[0:], net.ParseIP("8.8.8.8").To4())
+	copy(r[4:], net.ParseIP("192.168.1.52").To4())
+	binary.BigEndian.PutUint32(r[16:], 1)
+	binary.BigEndian.PutUint32(r[20:], 120)
+	binary.BigEndian.PutUint32(r[24:], 90000)
+	binary.BigEndian.PutUint32(r[28:], 95000)
+	binary.BigEndian.PutUint16(r[32:], 53)
+	binary.BigEndian.PutUint16(r[34:], 54585)
+	r[38] = byte(protos.UDP)
+
+	// ICMP echo request
+	r = msg[netflow5HeaderLength+netflow5RecordLength:]
+	copy(r[0:], net.ParseIP("192.168.1.52").To4())
+	copy(r[4:], net.ParseIP("8.8.8.8").To4())
+	binary.BigEndian.PutUint32(r[16:], 1)
+	binary.BigEndian.PutUint32(r[20:], 84)
+	binary.BigEndian.PutUint16(r[34:], 8<<8)
+	r[38] = byte(protos.ICMP4)
+
+	flows, err := NewDecoder().Decode("10.0.0.254:2055", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 2 {
+		t.Fatalf("expected 2 flows, got %d", len(flows))
+	}
+
+	udp := flows[0]
+	if !udp.Meta.Src.Equal(net.ParseIP("192.168.1.52")) || udp.Meta.DstPort != 53 {
+		t.Errorf("source correction failed: %v", udp.Meta)
+	}
+	if udp.Outgoing.Bytes != 120 || udp.Incoming.Packets != 0 {
+		t.Errorf("invalid counters %v/%v", udp.Incoming, udp.Outgoing)
+	}
+	if !udp.Start.Equal(exportTime.Add(-10*time.Second)) || !udp.End.Equal(exportTime.Add(-5*time.Second)) {
+		t.Errorf("invalid timestamps %s - %s", udp.Start, udp.End)
+	}
+
+	icmp := flows[1]
+	if icmp.Meta.IcmpType != 8 || icmp.Meta.SrcPort != 0 || icmp.Meta.DstPort != 0 {
+		t.Errorf("invalid ICMP metadata %v", icmp.Meta)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	d := NewDecoder()
+	for _, msg := range [][]byte{
+		{},
+		{0, 1, 2, 3},
+		{0, 5, 0, 1},
+		{0, 9, 0, 1, 0, 0},
+		{0, 10, 0, 255, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	} {
+		if _, err := d.Decode("10.0.0.254:2055", msg); err == nil {
+			t.Errorf("expected error decoding %v", msg)
+		}
+	}
+}
+
+func TestSysUptimeToTime(t *testing.T) {
+	exportTime := time.Unix(1577880000, 0)
+	for _, c := range []struct {
+		uptime, t uint32
+		expected  time.Time
+	}{
+		{100000, 90000, exportTime.Add(-10 * time.Second)},
+		// Stamped after the header, e.g. clock skew
+		{100000, 100500, exportTime.Add(500 * time.Millisecond)},
+		// Uptime wrapped around since the flow started
+		{1000, 0xffffffff - 999, exportTime.Add(-2 * time.Second)},
+	} {
+		if got := sysUptimeToTime(exportTime, c.uptime, c.t); !got.Equal(c.expected) {
+			t.Errorf("uptime %d, t %d: expected %s, got %s", c.uptime, c.t, c.expected, got)
+		}
+	}
+}
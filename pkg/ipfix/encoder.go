@@ -0,0 +1,366 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package ipfix
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+// Template IDs used by the encoder
+const (
+	templateIDv4 uint16 = minDataSetID
+	templateIDv6 uint16 = minDataSetID + 1
+)
+
+// communityIDLength is the length of an encoded CommunityID ("1:" + base64(sha1))
+const communityIDLength = 30
+
+// Encoder serializes flows to NetFlow v9 or IPFIX messages
+type Encoder interface {
+	Encode(flows []*flow.Flow, now time.Time, withTemplates bool) [][]byte
+}
+
+// record is a flow (direction) to be encoded with a template
+type record struct {
+	flow    *flow.Flow
+	reverse bool // Encode the Dst -> Src direction (NetFlow v9 only supports unidirectional records)
+}
+
+// field is an information element with its encoding function
+type field struct {
+	FieldSpecifier
+	put func(b []byte, r record)
+}
+
+type template struct {
+	id     uint16
+	fields []field
+	length int // Length of a data record
+}
+
+type encoder struct {
+	version    Version
+	domain     uint32    // Observation domain (IPFIX) or source ID (NetFlow v9)
+	maxSize    int       // Maximum message size
+	boot       time.Time // Reference for NetFlow v9 sysUptime
+	sequence   uint32    // Sequence number
+	v4, v6     *template
+	templates  []*template
+	enterprise uint32
+}
+
+// NewEncoder creates a new Encoder for the given protocol version. Enterprise specific elements
+// (IPFIX only) use the enterprise number pen, messages are split to not exceed maxSize bytes.
+func NewEncoder(version Version, domain, pen uint32, maxSize int) Encoder {
+	e := &encoder{
+		version:    version,
+		domain:     domain,
+		maxSize:    maxSize,
+		boot:       time.Now(),
+		enterprise: pen,
+	}
+	e.v4 = newTemplate(templateIDv4, e.fields(false))
+	e.v6 = newTemplate(templateIDv6, e.fields(true))
+	e.templates = []*template{e.v4, e.v6}
+	return e
+}
+
+func newTemplate(id uint16, fields []field) *template {
+	t := &template{id: id, fields: fields}
+	for _, f := range fields {
+		t.length += int(f.Length)
+	}
+	return t
+}
+
+// fields defines the template layout for IPv4 or IPv6 flows
+func (e *encoder) fields(v6 bool) []field {
+	addrLen, srcIE, dstIE, icmpIE := uint16(4), IESourceIPv4Address, IEDestinationIPv4Address, IEICMPTypeCodeIPv4
+	if v6 {
+		addrLen, srcIE, dstIE = 16, IESourceIPv6Address, IEDestinationIPv6Address
+		// NetFlow v9 does only know one ICMP type/code element
+		if e.version == VersionIPFIX {
+			icmpIE = IEICMPTypeCodeIPv6
+		}
+	}
+
+	fields := []field{
+		{FieldSpecifier{srcIE, addrLen, 0}, func(b []byte, r record) { putIP(b, r.src(), v6) }},
+		{FieldSpecifier{dstIE, addrLen, 0}, func(b []byte, r record) { putIP(b, r.dst(), v6) }},
+		{FieldSpecifier{IESourceTransportPort, 2, 0}, func(b []byte, r record) { binary.BigEndian.PutUint16(b, r.srcPort()) }},
+		{FieldSpecifier{IEDestinationTransportPort, 2, 0}, func(b []byte, r record) { binary.BigEndian.PutUint16(b, r.dstPort()) }},
+		{FieldSpecifier{IEProtocolIdentifier, 1, 0}, func(b []byte, r record) { b[0] = byte(r.flow.Meta.Transport) }},
+		{FieldSpecifier{icmpIE, 2, 0}, func(b []byte, r record) { binary.BigEndian.PutUint16(b, r.icmpTypeCode()) }},
+		{FieldSpecifier{IETCPControlBits, 1, 0}, func(b []byte, r record) { b[0] = byte(r.counters().TCPFlags) }},
+		{FieldSpecifier{IEOctetDeltaCount, 8, 0}, func(b []byte, r record) { binary.BigEndian.PutUint64(b, r.counters().Bytes) }},
+		{FieldSpecifier{IEPacketDeltaCount, 8, 0}, func(b []byte, r record) { binary.BigEndian.PutUint64(b, r.counters().Packets) }},
+		{FieldSpecifier{IEIngressInterface, 4, 0}, func(b []byte, r record) { binary.BigEndian.PutUint32(b, uint32(r.flow.Interface.Index)) }},
+	}
+
+	if e.version == VersionNetflow9 {
+		return append(fields,
+			field{FieldSpecifier{IEFirstSwitched, 4, 0}, func(b []byte, r record) { binary.BigEndian.PutUint32(b, e.uptime(r.flow.Start)) }},
+			field{FieldSpecifier{IELastSwitched, 4, 0}, func(b []byte, r record) { binary.BigEndian.PutUint32(b, e.uptime(r.flow.End)) }},
+		)
+	}
+
+	// IPFIX biflow, reverse direction counters
+	return append(fields,
+		field{FieldSpecifier{IETCPControlBits, 1, ReversePEN}, func(b []byte, r record) { b[0] = byte(r.flow.Outgoing.TCPFlags) }},
+		field{FieldSpecifier{IEOctetDeltaCount, 8, ReversePEN}, func(b []byte, r record) { binary.BigEndian.PutUint64(b, r.flow.Outgoing.Bytes) }},
+		field{FieldSpecifier{IEPacketDeltaCount, 8, ReversePEN}, func(b []byte, r record) { binary.BigEndian.PutUint64(b, r.flow.Outgoing.Packets) }},
+		field{FieldSpecifier{IEFlowStartMilliseconds, 8, 0}, func(b []byte, r record) { binary.BigEndian.PutUint64(b, unixMillis(r.flow.Start)) }},
+		field{FieldSpecifier{IEFlowEndMilliseconds, 8, 0}, func(b []byte, r record) { binary.BigEndian.PutUint64(b, unixMillis(r.flow.End)) }},
+		field{FieldSpecifier{IEFlowEndReason, 1, 0}, func(b []byte, r record) { b[0] = byte(r.flow.EndReason) }},
+		field{FieldSpecifier{IECommunityID, communityIDLength, e.enterprise}, func(b []byte, r record) { copy(b, r.flow.CommunityID) }},
+	)
+}
+
+func (r record) counters() flow.Counters {
+	if r.reverse {
+		return r.flow.Outgoing
+	}
+	return r.flow.Incoming
+}
+
+func (r record) src() []byte {
+	if r.reverse {
+		return r.flow.Meta.Dst
+	}
+	return r.flow.Meta.Src
+}
+
+func (r record) dst() []byte {
+	if r.reverse {
+		return r.flow.Meta.Src
+	}
+	return r.flow.Meta.Dst
+}
+
+func (r record) srcPort() uint16 {
+	if r.reverse {
+		return r.flow.Meta.DstPort
+	}
+	return r.flow.Meta.SrcPort
+}
+
+func (r record) dstPort() uint16 {
+	if r.reverse {
+		return r.flow.Meta.SrcPort
+	}
+	return r.flow.Meta.DstPort
+}
+
+func (r record) icmpTypeCode() uint16 {
+	switch r.flow.Meta.Transport {
+	case protos.ICMP4, protos.ICMP6:
+		return r.flow.Meta.IcmpType<<8 | r.flow.Meta.IcmpCode&0xff
+	}
+	return 0
+}
+
+func putIP(b []byte, ip []byte, v6 bool) {
+	if len(ip) == 16 && !v6 {
+		ip = ip[12:]
+	}
+	copy(b, ip)
+}
+
+func unixMillis(t time.Time) uint64 {
+	return uint64(t.UnixNano() / int64(time.Millisecond))
+}
+
+// uptime converts a timestamp to milliseconds since the encoder has been created (NetFlow v9 sysUptime)
+func (e *encoder) uptime(t time.Time) uint32 {
+	if t.Before(e.boot) {
+		return 0
+	}
+	return uint32(t.Sub(e.boot) / time.Millisecond)
+}
+
+// records converts flows to data records grouped by template
+func (e *encoder) records(flows []*flow.Flow) map[*template][]record {
+	recs := make(map[*template][]record)
+	for _, f := range flows {
+		t := e.v6
+		if f.Meta.Src.To4() != nil {
+			t = e.v4
+		}
+
+		if e.version == VersionIPFIX {
+			recs[t] = append(recs[t], record{flow: f})
+			continue
+		}
+
+		// NetFlow v9: one record per direction
+		if f.Incoming.Packets > 0 {
+			recs[t] = append(recs[t], record{flow: f})
+		}
+		if f.Outgoing.Packets > 0 {
+			recs[t] = append(recs[t], record{flow: f, reverse: true})
+		}
+	}
+	return recs
+}
+
+// message is a NetFlow v9/IPFIX message under construction
+type message struct {
+	buf     []byte
+	set     int // Offset of the current set header, -1 if there is no open set
+	records int // Number of records (NetFlow v9 counts template and data records)
+	data    int // Number of data records
+}
+
+func (e *encoder) headerLength() int {
+	if e.version == VersionNetflow9 {
+		return netflow9HeaderLength
+	}
+	return ipfixHeaderLength
+}
+
+func (e *encoder) newMessage() *message {
+	return &message{buf: make([]byte, e.headerLength(), e.maxSize), set: -1}
+}
+
+// openSet starts a new set with the given ID
+func (m *message) openSet(id uint16) {
+	m.set = len(m.buf)
+	m.buf = append(m.buf, byte(id>>8), byte(id), 0, 0)
+}
+
+// closeSet pads the current set to a 4 byte boundary and fills in its length
+func (m *message) closeSet() {
+	if m.set < 0 {
+		return
+	}
+	for (len(m.buf)-m.set)%4 != 0 {
+		m.buf = append(m.buf, 0)
+	}
+	binary.BigEndian.PutUint16(m.buf[m.set+2:], uint16(len(m.buf)-m.set))
+	m.set = -1
+}
+
+// appendTemplates adds a template set containing all templates
+func (e *encoder) appendTemplates(m *message) {
+	setID := uint16(ipfixTemplateSetID)
+	if e.version == VersionNetflow9 {
+		setID = netflow9TemplateSetID
+	}
+
+	m.openSet(setID)
+	for _, t := range e.templates {
+		m.buf = appendUint16(m.buf, t.id, uint16(len(t.fields)))
+		for _, f := range t.fields {
+			if f.Enterprise != 0 {
+				m.buf = appendUint16(m.buf, f.ID|enterpriseBit, f.Length)
+				m.buf = append(m.buf, byte(f.Enterprise>>24), byte(f.Enterprise>>16), byte(f.Enterprise>>8), byte(f.Enterprise))
+				continue
+			}
+			m.buf = appendUint16(m.buf, f.ID, f.Length)
+		}
+		m.records++
+	}
+	m.closeSet()
+}
+
+func appendUint16(b []byte, v ...uint16) []byte {
+	for _, i := range v {
+		b = append(b, byte(i>>8), byte(i))
+	}
+	return b
+}
+
+// finish writes the message header
+func (e *encoder) finish(m *message, now time.Time) []byte {
+	m.closeSet()
+	b := m.buf
+	switch e.version {
+	case VersionNetflow9:
+		binary.BigEndian.PutUint16(b[0:], uint16(VersionNetflow9))
+		binary.BigEndian.PutUint16(b[2:], uint16(m.records))
+		binary.BigEndian.PutUint32(b[4:], e.uptime(now))
+		binary.BigEndian.PutUint32(b[8:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(b[12:], e.sequence)
+		binary.BigEndian.PutUint32(b[16:], e.domain)
+		// Sequence number counts the exported packets
+		e.sequence++
+	default:
+		binary.BigEndian.PutUint16(b[0:], uint16(VersionIPFIX))
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+		binary.BigEndian.PutUint32(b[4:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(b[8:], e.sequence)
+		binary.BigEndian.PutUint32(b[12:], e.domain)
+		// Sequence number counts the exported data records
+		e.sequence += uint32(m.data)
+	}
+	return b
+}
+
+// Encode serializes the flows into one or more messages. Template sets are prepended to the first
+// message if withTemplates is set; the collector needs them before it can decode any data record.
+func (e *encoder) Encode(flows []*flow.Flow, now time.Time, withTemplates bool) [][]byte {
+	var msgs [][]byte
+
+	m := e.newMessage()
+	if withTemplates {
+		e.appendTemplates(m)
+	}
+
+	recs := e.records(flows)
+	for _, t := range e.templates {
+		for _, r := range recs[t] {
+			// Message full (including padding of the set), start a new one
+			if len(m.buf)+t.length+setHeaderLength+3 > e.maxSize && m.data > 0 {
+				msgs = append(msgs, e.finish(m, now))
+				m = e.newMessage()
+			}
+
+			if m.set < 0 {
+				m.openSet(t.id)
+			}
+
+			off := len(m.buf)
+			m.buf = append(m.buf, make([]byte, t.length)...)
+			for _, f := range t.fields {
+				f.put(m.buf[off:off+int(f.Length)], r)
+				off += int(f.Length)
+			}
+			m.records++
+			m.data++
+		}
+		m.closeSet()
+	}
+
+	if m.records > 0 {
+		msgs = append(msgs, e.finish(m, now))
+	}
+
+	return msgs
+}
@@ -0,0 +1,209 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package ipfix
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+var (
+	testTime = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	testFlowV4 = &flow.Flow{
+		Meta:        flow.Meta{Transport: protos.TCP, Src: net.ParseIP("10.0.0.1"), Dst: net.ParseIP("10.0.0.2"), SrcPort: 50124, DstPort: 443},
+		Incoming:    flow.Counters{Bytes: 1024, Packets: 4, TCPFlags: flow.TCPFlagSYN | flow.TCPFlagACK},
+		Outgoing:    flow.Counters{Bytes: 4096, Packets: 3, TCPFlags: flow.TCPFlagSYN | flow.TCPFlagACK},
+		CommunityID: "1:LQU9qZlK+B5F3KDmev6m5PMibrg=",
+		Start:       testTime,
+		End:         testTime.Add(time.Second),
+		EndReason:   flow.EndIdleTimeout,
+		Interface:   flow.Interface{Index: 4},
+	}
+
+	testFlowV6 = &flow.Flow{
+		Meta:        flow.Meta{Transport: protos.ICMP6, Src: net.ParseIP("2000:dead:beef::1234"), Dst: net.ParseIP("2000:dead:beef::2345"), IcmpType: 128},
+		Incoming:    flow.Counters{Bytes: 512, Packets: 1},
+		CommunityID: "1:LQU9qZlK+B5F3KDmev6m5PMibrg=",
+		Start:       testTime,
+		End:         testTime,
+		EndReason:   flow.EndIdleTimeout,
+	}
+)
+
+// sets splits a message into its sets (set ID -> set payloads)
+func sets(t *testing.T, msg []byte, headerLength int) map[uint16][][]byte {
+	res := make(map[uint16][][]byte)
+	for b := msg[headerLength:]; len(b) > 0; {
+		if len(b) < setHeaderLength {
+			t.Fatal("truncated set header")
+		}
+		id, l := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if l < setHeaderLength || l > len(b) || l%4 != 0 {
+			t.Fatalf("invalid set length %d", l)
+		}
+		res[id] = append(res[id], b[setHeaderLength:l])
+		b = b[l:]
+	}
+	return res
+}
+
+func TestEncodeIPFIX(t *testing.T) {
+	e := NewEncoder(VersionIPFIX, 42, DefaultPEN, 1400)
+
+	msgs := e.Encode([]*flow.Flow{testFlowV4, testFlowV6}, testTime, true)
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	msg := msgs[0]
+
+	if v := binary.BigEndian.Uint16(msg); v != uint16(VersionIPFIX) {
+		t.Errorf("invalid version %d", v)
+	}
+	if l := binary.BigEndian.Uint16(msg[2:]); int(l) != len(msg) {
+		t.Errorf("invalid message length %d, expected %d", l, len(msg))
+	}
+	if ts := binary.BigEndian.Uint32(msg[4:]); int64(ts) != testTime.Unix() {
+		t.Errorf("invalid export time %d", ts)
+	}
+	if seq := binary.BigEndian.Uint32(msg[8:]); seq != 0 {
+		t.Errorf("invalid sequence number %d", seq)
+	}
+	if d := binary.BigEndian.Uint32(msg[12:]); d != 42 {
+		t.Errorf("invalid observation domain %d", d)
+	}
+
+	s := sets(t, msg, ipfixHeaderLength)
+	if len(s[ipfixTemplateSetID]) != 1 {
+		t.Fatal("template set missing")
+	}
+
+	// First template: IPv4, check the enterprise specific CommunityID element
+	tmpl := s[ipfixTemplateSetID][0]
+	if id, n := binary.BigEndian.Uint16(tmpl), binary.BigEndian.Uint16(tmpl[2:]); id != templateIDv4 || int(n) != len(e.(*encoder).v4.fields) {
+		t.Errorf("invalid template header %d/%d", id, n)
+	}
+
+	v4 := s[templateIDv4]
+	if len(v4) != 1 || len(v4[0]) < e.(*encoder).v4.length {
+		t.Fatal("IPv4 data set missing")
+	}
+	rec := v4[0]
+	if !net.IP(rec[0:4]).Equal(testFlowV4.Meta.Src) || !net.IP(rec[4:8]).Equal(testFlowV4.Meta.Dst) {
+		t.Errorf("invalid addresses %s -> %s", net.IP(rec[0:4]), net.IP(rec[4:8]))
+	}
+	if p := binary.BigEndian.Uint16(rec[10:]); p != 443 {
+		t.Errorf("invalid destination port %d", p)
+	}
+	// CommunityID is the last element of the template
+	l := e.(*encoder).v4.length
+	if id := string(rec[l-communityIDLength : l]); id != testFlowV4.CommunityID {
+		t.Errorf("invalid CommunityID %q", id)
+	}
+
+	if len(s[templateIDv6]) != 1 {
+		t.Fatal("IPv6 data set missing")
+	}
+
+	// Sequence number counts data records, templates are omitted
+	msgs = e.Encode([]*flow.Flow{testFlowV4}, testTime, false)
+	if seq := binary.BigEndian.Uint32(msgs[0][8:]); seq != 2 {
+		t.Errorf("invalid sequence number %d", seq)
+	}
+	if _, ok := sets(t, msgs[0], ipfixHeaderLength)[ipfixTemplateSetID]; ok {
+		t.Error("unexpected template set")
+	}
+}
+
+func TestEncodeNetflow9(t *testing.T) {
+	e := NewEncoder(VersionNetflow9, 42, DefaultPEN, 1400)
+
+	msgs := e.Encode([]*flow.Flow{testFlowV4, testFlowV6}, testTime, true)
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	msg := msgs[0]
+
+	if v := binary.BigEndian.Uint16(msg); v != uint16(VersionNetflow9) {
+		t.Errorf("invalid version %d", v)
+	}
+	// 2 templates, 2 records for the IPv4 biflow, 1 record for the unidirectional IPv6 flow
+	if c := binary.BigEndian.Uint16(msg[2:]); c != 5 {
+		t.Errorf("invalid record count %d", c)
+	}
+
+	s := sets(t, msg, netflow9HeaderLength)
+	if len(s[netflow9TemplateSetID]) != 1 {
+		t.Fatal("template set missing")
+	}
+
+	rec := s[templateIDv4][0]
+	if l := e.(*encoder).v4.length; len(rec) < 2*l {
+		t.Fatalf("expected two IPv4 records, got %d bytes", len(rec))
+	}
+	reverse := rec[e.(*encoder).v4.length:]
+	if !net.IP(reverse[0:4]).Equal(testFlowV4.Meta.Dst) || binary.BigEndian.Uint16(reverse[8:]) != 443 {
+		t.Error("reverse record not swapped")
+	}
+
+	msgs = e.Encode([]*flow.Flow{testFlowV4}, testTime, false)
+	if seq := binary.BigEndian.Uint32(msgs[0][12:]); seq != 1 {
+		t.Errorf("invalid sequence number %d", seq)
+	}
+}
+
+func TestEncodeSplitsMessages(t *testing.T) {
+	e := NewEncoder(VersionIPFIX, 0, DefaultPEN, 512)
+
+	var flows []*flow.Flow
+	for i := 0; i < 100; i++ {
+		flows = append(flows, testFlowV4)
+	}
+
+	msgs := e.Encode(flows, testTime, true)
+	if len(msgs) < 2 {
+		t.Fatalf("expected multiple messages, got %d", len(msgs))
+	}
+
+	records := 0
+	for _, msg := range msgs {
+		if len(msg) > 512 {
+			t.Errorf("message exceeds maximum size: %d", len(msg))
+		}
+		for _, set := range sets(t, msg, ipfixHeaderLength)[templateIDv4] {
+			records += len(set) / e.(*encoder).v4.length
+		}
+	}
+
+	if records != len(flows) {
+		t.Errorf("expected %d records, got %d", len(flows), records)
+	}
+}
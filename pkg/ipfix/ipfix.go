@@ -0,0 +1,141 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package ipfix
+
+import "github.com/sirupsen/logrus"
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "ipfix",
+	})
+}
+
+// Logger
+var log *logrus.Entry
+
+// Version of the flow export protocol, shared between NetFlow v9 (RFC 3954) and IPFIX (RFC 7011)
+type Version uint16
+
+// Supported protocol versions
+const (
+	VersionNetflow5 Version = 5
+	VersionNetflow9 Version = 9
+	VersionIPFIX    Version = 10
+)
+
+// Stringr converts the version to a string
+func (v Version) String() string {
+	switch v {
+	case VersionNetflow5:
+		return "netflow5"
+	case VersionNetflow9:
+		return "netflow9"
+	case VersionIPFIX:
+		return "ipfix"
+	default:
+		return "UNDEFINED"
+	}
+}
+
+// Header sizes
+const (
+	netflow5HeaderLength = 24
+	netflow5RecordLength = 48
+	netflow9HeaderLength = 20
+	ipfixHeaderLength    = 16
+	setHeaderLength      = 4
+)
+
+// Set IDs of template sets; data sets use the template ID (>= 256)
+const (
+	netflow9TemplateSetID        = 0
+	netflow9OptionsTemplateSetID = 1
+	ipfixTemplateSetID           = 2
+	ipfixOptionsTemplateSetID    = 3
+	minDataSetID                 = 256
+)
+
+// Information elements, https://www.iana.org/assignments/ipfix/ipfix.xhtml
+// The IDs up to 127 are shared with NetFlow v9
+const (
+	IEOctetDeltaCount          uint16 = 1
+	IEPacketDeltaCount         uint16 = 2
+	IEProtocolIdentifier       uint16 = 4
+	IETCPControlBits           uint16 = 6
+	IESourceTransportPort      uint16 = 7
+	IESourceIPv4Address        uint16 = 8
+	IEIngressInterface         uint16 = 10
+	IEDestinationTransportPort uint16 = 11
+	IEDestinationIPv4Address   uint16 = 12
+	IELastSwitched             uint16 = 21 // flowEndSysUpTime
+	IEFirstSwitched            uint16 = 22 // flowStartSysUpTime
+	IESourceIPv6Address        uint16 = 27
+	IEDestinationIPv6Address   uint16 = 28
+	IEICMPTypeCodeIPv4         uint16 = 32
+	IEOctetTotalCount          uint16 = 85
+	IEPacketTotalCount         uint16 = 86
+	IEFlowEndReason            uint16 = 136
+	IEICMPTypeCodeIPv6         uint16 = 139
+	IEFlowStartSeconds         uint16 = 150
+	IEFlowEndSeconds           uint16 = 151
+	IEFlowStartMilliseconds    uint16 = 152
+	IEFlowEndMilliseconds      uint16 = 153
+	IEICMPTypeIPv4             uint16 = 176
+	IEICMPCodeIPv4             uint16 = 177
+	IEICMPTypeIPv6             uint16 = 178
+	IEICMPCodeIPv6             uint16 = 179
+)
+
+// Enterprise specific information elements
+const (
+	// ReversePEN marks reverse direction information elements of a biflow (RFC 5103)
+	ReversePEN uint32 = 29305
+	// DefaultPEN is the private enterprise number used for insight specific information elements.
+	// It defaults to the number reserved for documentation (RFC 5612) and should be overwritten
+	// with an assigned number when exporting to third party collectors.
+	DefaultPEN uint32 = 32473
+	// IECommunityID carries the CommunityID string of a flow (enterprise specific)
+	IECommunityID uint16 = 1
+)
+
+// enterpriseBit is set on the element ID of enterprise specific information elements
+const enterpriseBit = 0x8000
+
+// variableLength marks an information element with variable length encoding (IPFIX only)
+const variableLength = 0xffff
+
+// FieldSpecifier describes one information element of a template
+type FieldSpecifier struct {
+	ID         uint16
+	Length     uint16
+	Enterprise uint32 // Private enterprise number, 0 for IANA information elements
+}
+
+// Template describes the layout of data records
+type Template struct {
+	ID     uint16
+	Fields []FieldSpecifier
+}
@@ -0,0 +1,242 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package kubemeta
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/procfs"
+	"github.com/xvzf/insight/pkg/protos"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Names of the pod indexes used to attribute addresses shared by several pods
+const (
+	hostPortIndex  = "hostport"
+	containerIndex = "container"
+	uidIndex       = "uid"
+)
+
+// transports maps the transport of events to socket protocols
+var transports = map[string]protos.ProtocolType{
+	"tcp":  protos.TCP,
+	"udp":  protos.UDP,
+	"sctp": protos.SCTP,
+}
+
+// LookupHostPort returns the metadata of the pod serving port on the node address ip, either
+// in the host network or through a host port. Only ports declared in the pod spec are known.
+func (c *metaCache) LookupHostPort(ip net.IP, port uint16, transport string) *insight.Kubernetes {
+	if ip == nil {
+		return nil
+	}
+	pod := c.bestPod(hostPortIndex, hostPortKey(ip.String(), int32(port), transport))
+	if pod == nil {
+		return nil
+	}
+	lookups.WithLabelValues("hostport").Inc()
+	return c.podMetadata(pod)
+}
+
+// LookupContainer returns the metadata of the pod running the container, falling back to
+// the pod UID for containers not reported in the pod status yet
+func (c *metaCache) LookupContainer(containerID, podUID string) *insight.Kubernetes {
+	if containerID != "" {
+		if pod := c.bestPod(containerIndex, containerID); pod != nil {
+			k := c.podMetadata(pod)
+			k.Container = &insight.KubernetesContainer{
+				Name: containerName(pod, containerID),
+				ID:   containerID,
+			}
+			lookups.WithLabelValues("container").Inc()
+			return k
+		}
+	}
+	if podUID != "" {
+		if pod := c.bestPod(uidIndex, podUID); pod != nil {
+			k := c.podMetadata(pod)
+			if containerID != "" {
+				k.Container = &insight.KubernetesContainer{ID: containerID}
+			}
+			lookups.WithLabelValues("container").Inc()
+			return k
+		}
+	}
+	return nil
+}
+
+// hostPortKey is the key of the host port index
+func hostPortKey(ip string, port int32, transport string) string {
+	return net.JoinHostPort(ip, strconv.Itoa(int(port))) + "/" + strings.ToLower(transport)
+}
+
+// podHostPorts indexes pods by the node addresses and ports they serve. Pods in the host network
+// listen on their container ports, other pods are reachable on the node through host ports.
+func podHostPorts(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+
+	nodeIPs := appendIP(nil, pod.Status.HostIP)
+	if pod.Spec.HostNetwork {
+		// The pod addresses are the node addresses, including the host IP
+		nodeIPs = nil
+		for _, ip := range pod.Status.PodIPs {
+			nodeIPs = appendIP(nodeIPs, ip.IP)
+		}
+		if len(nodeIPs) == 0 {
+			nodeIPs = appendIP(nil, pod.Status.HostIP)
+		}
+	}
+
+	var keys []string
+	for _, container := range pod.Spec.Containers {
+		for _, p := range container.Ports {
+			port, ips := p.HostPort, nodeIPs
+			if pod.Spec.HostNetwork {
+				port = p.ContainerPort
+			}
+			if p.HostIP != "" {
+				ips = appendIP(nil, p.HostIP)
+			}
+			if port == 0 {
+				continue
+			}
+
+			transport := p.Protocol
+			if transport == "" {
+				transport = corev1.ProtocolTCP
+			}
+			for _, ip := range ips {
+				keys = append(keys, hostPortKey(ip, port, string(transport)))
+			}
+		}
+	}
+	return keys, nil
+}
+
+// podContainerIDs indexes pods by the IDs of their containers
+func podContainerIDs(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+
+	var ids []string
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, s := range statuses {
+			if id := containerID(s.ContainerID); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// podUID indexes pods by their UID
+func podUID(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.UID == "" {
+		return nil, nil
+	}
+	return []string{string(pod.UID)}, nil
+}
+
+// containerID strips the runtime of a container ID in the pod status, e.g.
+// containerd://0123...cdef
+func containerID(id string) string {
+	if i := strings.Index(id, "://"); i >= 0 {
+		return id[i+3:]
+	}
+	return id
+}
+
+// containerName returns the name of the container with the given ID
+func containerName(pod *corev1.Pod, id string) string {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, s := range statuses {
+			if containerID(s.ContainerID) == id {
+				return s.Name
+			}
+		}
+	}
+	return ""
+}
+
+// socketEnricher attributes local endpoints to the containers owning their sockets
+type socketEnricher struct {
+	cache Cache
+	table procfs.Table
+}
+
+// NewSocketEnricher creates an enricher annotating the local endpoints of events with the
+// container owning their socket on the node, and its pod if cache is set. This attributes
+// traffic of pods in the host network, where the address alone does not identify the workload.
+// Only the sockets of the network namespace of the probe are known, loopback addresses exist in
+// every pod and are left unattributed. Other endpoints are left unchanged, so the enricher runs
+// after the address based enrichment.
+func NewSocketEnricher(cache Cache, table procfs.Table) insight.Enricher {
+	return &socketEnricher{cache: cache, table: table}
+}
+
+// Enrich annotates the endpoints with a known local socket
+func (s *socketEnricher) Enrich(events []*insight.Event) {
+	for _, e := range events {
+		if e.Network == nil {
+			continue
+		}
+		transport, ok := transports[e.Network.Transport]
+		if !ok {
+			continue
+		}
+
+		for _, ep := range []*insight.EndpointDescription{e.Source, e.Destination} {
+			if ep == nil || ep.IP.IsLoopback() {
+				continue
+			}
+			o := s.table.Lookup(transport, ep.IP, ep.Port)
+			if o == nil || (o.ContainerID == "" && o.PodUID == "") {
+				// Unknown or a process of the host
+				continue
+			}
+
+			var k *insight.Kubernetes
+			if s.cache != nil {
+				k = s.cache.LookupContainer(o.ContainerID, o.PodUID)
+			}
+			if k == nil && o.ContainerID != "" {
+				k = &insight.Kubernetes{Container: &insight.KubernetesContainer{ID: o.ContainerID}}
+			}
+			if k != nil {
+				ep.Kubernetes = k
+			}
+		}
+	}
+}
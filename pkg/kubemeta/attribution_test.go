@@ -0,0 +1,191 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package kubemeta
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/procfs"
+	"github.com/xvzf/insight/pkg/protos"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPodHostPorts(t *testing.T) {
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Ports: []corev1.ContainerPort{
+					{ContainerPort: 8080},
+					{ContainerPort: 53, HostPort: 5353, Protocol: corev1.ProtocolUDP},
+					{ContainerPort: 9090, HostPort: 9090, HostIP: "127.0.0.1"},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{HostIP: "192.168.0.10", PodIP: "10.1.0.7"},
+	}
+	expected := []string{"192.168.0.10:5353/udp", "127.0.0.1:9090/tcp"}
+	if got, _ := podHostPorts(pod); !cmp.Equal(got, expected) {
+		t.Error(cmp.Diff(expected, got))
+	}
+
+	// Pods in the host network listen on their container ports on all node addresses
+	pod.Spec.HostNetwork = true
+	pod.Spec.Containers[0].Ports = pod.Spec.Containers[0].Ports[:1]
+	pod.Status.PodIPs = []corev1.PodIP{{IP: "192.168.0.10"}, {IP: "fd00::10"}}
+	expected = []string{"192.168.0.10:8080/tcp", "[fd00::10]:8080/tcp"}
+	if got, _ := podHostPorts(pod); !cmp.Equal(got, expected) {
+		t.Error(cmp.Diff(expected, got))
+	}
+}
+
+func TestLookupHostPort(t *testing.T) {
+	c := newTestCache(t)
+
+	expected := &insight.Kubernetes{
+		Namespace: "kube-system",
+		Pod:       &insight.KubernetesObject{Name: "kube-proxy-xyz"},
+		Node:      &insight.KubernetesObject{Name: "node-1"},
+	}
+	if got := c.LookupHostPort(net.ParseIP("192.168.0.10"), 10249, "tcp"); !cmp.Equal(got, expected) {
+		t.Error(cmp.Diff(expected, got))
+	}
+	if got := c.LookupHostPort(net.ParseIP("192.168.0.10"), 10249, "udp"); got != nil {
+		t.Errorf("Unexpected metadata for undeclared protocol: %+v", got)
+	}
+	if got := c.LookupHostPort(net.ParseIP("192.168.0.11"), 10249, "tcp"); got != nil {
+		t.Errorf("Unexpected metadata for other node: %+v", got)
+	}
+
+	// Enrichment falls back to the port for node addresses
+	events := []*insight.Event{{
+		Source:      &insight.EndpointDescription{IP: net.ParseIP("10.1.0.5"), Port: 41234},
+		Destination: &insight.EndpointDescription{IP: net.ParseIP("192.168.0.10"), Port: 10249},
+		Network:     &insight.NetworkDescription{Transport: "tcp"},
+	}}
+	c.Enrich(events)
+	if k := events[0].Destination.Kubernetes; !cmp.Equal(k, expected) {
+		t.Error(cmp.Diff(expected, k))
+	}
+}
+
+func TestLookupContainer(t *testing.T) {
+	c := newTestCache(t)
+
+	if got := c.LookupContainer(testContainerID, ""); got == nil || got.Pod.Name != "api-7d9f8c-x2kq" ||
+		!cmp.Equal(got.Container, &insight.KubernetesContainer{Name: "api", ID: testContainerID}) {
+		t.Errorf("Expected api container, got %+v", got)
+	}
+	// Container not in the pod status yet
+	if got := c.LookupContainer("0123", "pod-uid"); got == nil || got.Pod.Name != "api-7d9f8c-x2kq" ||
+		!cmp.Equal(got.Container, &insight.KubernetesContainer{ID: "0123"}) {
+		t.Errorf("Expected api pod, got %+v", got)
+	}
+	if got := c.LookupContainer("0123", "other-uid"); got != nil {
+		t.Errorf("Unexpected metadata: %+v", got)
+	}
+}
+
+// testNodeIP is the address of the node in the socket table
+var testNodeIP = net.ParseIP("192.168.0.10")
+
+// fakeTable is a socket table with fixed owners by port of the node address
+type fakeTable map[uint16]*procfs.Owner
+
+func (f fakeTable) Lookup(transport protos.ProtocolType, ip net.IP, port uint16) *procfs.Owner {
+	if transport != protos.TCP || !ip.Equal(testNodeIP) {
+		return nil
+	}
+	return f[port]
+}
+
+func (f fakeTable) LookupFlow(m flow.Meta) (*procfs.Owner, bool) {
+	return nil, false
+}
+
+func TestSocketEnricher(t *testing.T) {
+	c := newTestCache(t)
+	sidecar := "9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b"
+	table := fakeTable{
+		41234: {PID: 100, Cgroup: procfs.Cgroup{ContainerID: testContainerID, PodUID: "pod-uid"}},
+		15001: {PID: 200, Cgroup: procfs.Cgroup{ContainerID: sidecar}},
+		22:    {PID: 1},
+	}
+	e := NewSocketEnricher(c, table)
+
+	// Application talking to an unknown container in the host network
+	events := []*insight.Event{{
+		Source:      &insight.EndpointDescription{IP: testNodeIP, Port: 41234},
+		Destination: &insight.EndpointDescription{IP: testNodeIP, Port: 15001},
+		Network:     &insight.NetworkDescription{Transport: "tcp"},
+	}, {
+		// Host process
+		Source:      &insight.EndpointDescription{IP: testNodeIP, Port: 22},
+		Destination: &insight.EndpointDescription{IP: net.ParseIP("10.1.0.5"), Port: 41234},
+		Network:     &insight.NetworkDescription{Transport: "tcp"},
+	}, {
+		// Loopback sockets may belong to any network namespace
+		Source:      &insight.EndpointDescription{IP: net.ParseIP("127.0.0.1"), Port: 41234},
+		Destination: &insight.EndpointDescription{IP: net.ParseIP("127.0.0.1"), Port: 15001},
+		Network:     &insight.NetworkDescription{Transport: "tcp"},
+	}}
+	c.Enrich(events)
+	e.Enrich(events)
+
+	if k := events[0].Source.Kubernetes; k == nil || k.Pod == nil || k.Pod.Name != "api-7d9f8c-x2kq" || k.Container.Name != "api" {
+		t.Errorf("Source not attributed to the api container: %+v", k)
+	}
+	expected := &insight.Kubernetes{Container: &insight.KubernetesContainer{ID: sidecar}}
+	if k := events[0].Destination.Kubernetes; !cmp.Equal(k, expected) {
+		t.Error(cmp.Diff(expected, k))
+	}
+	if k := events[1].Source.Kubernetes; k != nil {
+		t.Errorf("Unexpected metadata for host process: %+v", k)
+	}
+	if k := events[2].Source.Kubernetes; k != nil {
+		t.Errorf("Unexpected metadata for loopback endpoint: %+v", k)
+	}
+	// Remote endpoints keep the address based metadata
+	if k := events[1].Destination.Kubernetes; k == nil || k.Container != nil || k.Pod.Name != "api-7d9f8c-x2kq" {
+		t.Errorf("Remote endpoint not enriched by address: %+v", k)
+	}
+}
+
+func TestContainerID(t *testing.T) {
+	for id, expected := range map[string]string{
+		"containerd://" + testContainerID: testContainerID,
+		"docker://0123":                   "0123",
+		"0123":                            "0123",
+		"":                                "",
+	} {
+		if got := containerID(id); got != expected {
+			t.Errorf("%s: expected %s, got %s", id, expected, got)
+		}
+	}
+}
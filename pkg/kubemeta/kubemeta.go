@@ -0,0 +1,373 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package kubemeta
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xvzf/insight/pkg/insight"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Logger
+var log *logrus.Entry
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "kubemeta",
+	})
+}
+
+// ipIndex is the name of the informer index keyed by IP address
+const ipIndex = "ip"
+
+// Cache keeps the pods, services and endpoints of the cluster indexed by IP address and
+// annotates events with the kubernetes objects their addresses belong to
+type Cache interface {
+	insight.Enricher
+	Lookup(ip net.IP) *insight.Kubernetes
+	LookupHostPort(ip net.IP, port uint16, transport string) *insight.Kubernetes
+	LookupContainer(containerID, podUID string) *insight.Kubernetes
+	Run() error
+	Stop()
+}
+
+type metaCache struct {
+	factory   informers.SharedInformerFactory
+	pods      cache.SharedIndexInformer
+	services  cache.SharedIndexInformer
+	endpoints cache.SharedIndexInformer
+	workloads *workloads
+	exitChan  chan struct{}
+}
+
+// NewClientset connects to the API server configured in kubeconfig, or the cluster the
+// process is running in if empty
+func NewClientset(kubeconfig string) (kubernetes.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// NewCache creates a new Cache, resyncing the informers in the given interval
+func NewCache(clientset kubernetes.Interface, resync time.Duration) Cache {
+	factory := informers.NewSharedInformerFactory(clientset, resync)
+	c := &metaCache{
+		factory:   factory,
+		pods:      factory.Core().V1().Pods().Informer(),
+		services:  factory.Core().V1().Services().Informer(),
+		endpoints: factory.Core().V1().Endpoints().Informer(),
+		workloads: newWorkloads(factory),
+		exitChan:  make(chan struct{}),
+	}
+
+	// Indexers have to be added before the informers are started
+	c.pods.AddIndexers(cache.Indexers{
+		ipIndex:        podIPs,
+		hostPortIndex:  podHostPorts,
+		containerIndex: podContainerIDs,
+		uidIndex:       podUID,
+	})
+	c.services.AddIndexers(cache.Indexers{ipIndex: serviceIPs})
+	c.endpoints.AddIndexers(cache.Indexers{ipIndex: endpointsIPs})
+	return c
+}
+
+// Run starts the informers and blocks until the cache is stopped
+func (c *metaCache) Run() error {
+	c.factory.Start(c.exitChan)
+
+	log.Info("Waiting for the kubernetes caches to sync")
+	for t, ok := range c.factory.WaitForCacheSync(c.exitChan) {
+		if !ok {
+			select {
+			case <-c.exitChan:
+				return nil
+			default:
+				return errors.New("failed to sync kubernetes cache of " + t.String())
+			}
+		}
+	}
+	log.Info("Kubernetes caches synced")
+
+	<-c.exitChan
+	return nil
+}
+
+// Stop stops the informers
+func (c *metaCache) Stop() {
+	close(c.exitChan)
+}
+
+// Enrich annotates the source and destination of the events. Addresses shared by pods in
+// the host network are attributed by the ports their containers declare.
+func (c *metaCache) Enrich(events []*insight.Event) {
+	for _, e := range events {
+		for _, ep := range []*insight.EndpointDescription{e.Source, e.Destination} {
+			if ep == nil {
+				continue
+			}
+			ep.Kubernetes = c.Lookup(ep.IP)
+			if ep.Kubernetes == nil && e.Network != nil {
+				ep.Kubernetes = c.LookupHostPort(ep.IP, ep.Port, e.Network.Transport)
+			}
+		}
+	}
+}
+
+// Lookup returns the metadata of the pod or service owning ip, nil if unknown
+func (c *metaCache) Lookup(ip net.IP) *insight.Kubernetes {
+	if ip == nil {
+		return nil
+	}
+	key := ip.String()
+
+	if pod := c.lookupPod(key); pod != nil {
+		lookups.WithLabelValues("pod").Inc()
+		return c.podMetadata(pod)
+	}
+
+	// Service addresses and endpoints without a pod, e.g. of headless services with
+	// manually managed endpoints
+	svc := c.lookupService(key)
+	if svc == nil {
+		svc = c.lookupEndpointsService(key)
+	}
+	if svc != nil {
+		lookups.WithLabelValues("service").Inc()
+		return &insight.Kubernetes{
+			Namespace: svc.namespace,
+			Service:   svc.KubernetesObject,
+		}
+	}
+
+	lookups.WithLabelValues("miss").Inc()
+	return nil
+}
+
+// podMetadata extracts the metadata of a pod and the objects related to it
+func (c *metaCache) podMetadata(pod *corev1.Pod) *insight.Kubernetes {
+	k := &insight.Kubernetes{
+		Namespace: pod.Namespace,
+		Pod: &insight.KubernetesObject{
+			Name:   pod.Name,
+			UID:    string(pod.UID),
+			Labels: pod.Labels,
+		},
+	}
+	// Endpoints of single-stack services only list one of the pod addresses
+	ips, _ := podIPs(pod)
+	for _, ip := range ips {
+		if svc := c.lookupEndpointsService(ip); svc != nil {
+			k.Service = svc.KubernetesObject
+			break
+		}
+	}
+	if pod.Spec.NodeName != "" {
+		k.Node = &insight.KubernetesObject{Name: pod.Spec.NodeName}
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		k.Owner = &insight.KubernetesOwner{Kind: owner.Kind, Name: owner.Name}
+	}
+	k.Workload = c.workloads.Resolve(pod)
+	return k
+}
+
+// lookupPod returns the pod using ip. Addresses of terminated pods can already be reused, so
+// running pods are preferred, followed by the most recently created one.
+func (c *metaCache) lookupPod(ip string) *corev1.Pod {
+	return c.bestPod(ipIndex, ip)
+}
+
+// bestPod returns the preferred pod of an index entry
+func (c *metaCache) bestPod(index, key string) *corev1.Pod {
+	objs, err := c.pods.GetIndexer().ByIndex(index, key)
+	if err != nil {
+		return nil
+	}
+
+	var best *corev1.Pod
+	for _, obj := range objs {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			continue
+		}
+		if best == nil || podRank(pod) > podRank(best) ||
+			(podRank(pod) == podRank(best) && best.CreationTimestamp.Before(&pod.CreationTimestamp)) {
+			best = pod
+		}
+	}
+	return best
+}
+
+// podRank orders pods sharing an address
+func podRank(pod *corev1.Pod) int {
+	switch {
+	case pod.DeletionTimestamp != nil:
+		return 0
+	case pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// service is a service object with its namespace
+type service struct {
+	*insight.KubernetesObject
+	namespace string
+}
+
+// lookupService returns the service with the virtual IP ip
+func (c *metaCache) lookupService(ip string) *service {
+	objs, err := c.services.GetIndexer().ByIndex(ipIndex, ip)
+	if err != nil || len(objs) == 0 {
+		return nil
+	}
+	svc, ok := objs[0].(*corev1.Service)
+	if !ok {
+		return nil
+	}
+	return newService(svc)
+}
+
+// lookupEndpointsService returns the service with an endpoint ip. If multiple services select
+// the address, the first one by namespace and name is returned.
+func (c *metaCache) lookupEndpointsService(ip string) *service {
+	objs, err := c.endpoints.GetIndexer().ByIndex(ipIndex, ip)
+	if err != nil || len(objs) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		// Endpoints share the name of their service
+		obj, ok, err := c.services.GetStore().GetByKey(key)
+		if err != nil || !ok {
+			continue
+		}
+		if svc, ok := obj.(*corev1.Service); ok {
+			return newService(svc)
+		}
+	}
+	return nil
+}
+
+// newService extracts the metadata of a service
+func newService(svc *corev1.Service) *service {
+	return &service{
+		KubernetesObject: &insight.KubernetesObject{
+			Name:   svc.Name,
+			UID:    string(svc.UID),
+			Labels: svc.Labels,
+		},
+		namespace: svc.Namespace,
+	}
+}
+
+// podIPs indexes pods by their addresses. Pods in the host network share the node address
+// and are not indexed.
+func podIPs(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.HostNetwork {
+		return nil, nil
+	}
+
+	var ips []string
+	for _, ip := range pod.Status.PodIPs {
+		ips = appendIP(ips, ip.IP)
+	}
+	if len(ips) == 0 {
+		ips = appendIP(ips, pod.Status.PodIP)
+	}
+	return ips, nil
+}
+
+// serviceIPs indexes services by their cluster IP, external IPs and load balancer ingress IPs
+func serviceIPs(obj interface{}) ([]string, error) {
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		return nil, nil
+	}
+
+	var ips []string
+	if svc.Spec.ClusterIP != corev1.ClusterIPNone {
+		ips = appendIP(ips, svc.Spec.ClusterIP)
+	}
+	for _, ip := range svc.Spec.ExternalIPs {
+		ips = appendIP(ips, ip)
+	}
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		ips = appendIP(ips, ingress.IP)
+	}
+	return ips, nil
+}
+
+// endpointsIPs indexes endpoints by the addresses of their ready and not ready endpoints
+func endpointsIPs(obj interface{}) ([]string, error) {
+	ep, ok := obj.(*corev1.Endpoints)
+	if !ok {
+		return nil, nil
+	}
+
+	var ips []string
+	for _, subset := range ep.Subsets {
+		for _, addr := range subset.Addresses {
+			ips = appendIP(ips, addr.IP)
+		}
+		for _, addr := range subset.NotReadyAddresses {
+			ips = appendIP(ips, addr.IP)
+		}
+	}
+	return ips, nil
+}
+
+// appendIP appends the canonical form of ip, invalid addresses are skipped
+func appendIP(ips []string, ip string) []string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ips
+	}
+	return append(ips, parsed.String())
+}
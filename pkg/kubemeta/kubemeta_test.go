@@ -0,0 +1,243 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package kubemeta
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/protos"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testContainerID = "3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e3f4a"
+
+var (
+	controller = true
+
+	testPod = &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "api-7d9f8c-x2kq",
+			Namespace:         "shop",
+			UID:               "pod-uid",
+			Labels:            map[string]string{"app": "api"},
+			CreationTimestamp: metav1.NewTime(time.Unix(100, 0)),
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "ReplicaSet", Name: "api-7d9f8c", Controller: &controller},
+			},
+		},
+		Spec: corev1.PodSpec{NodeName: "node-1"},
+		Status: corev1.PodStatus{
+			Phase:  corev1.PodRunning,
+			HostIP: "192.168.0.10",
+			PodIP:  "10.1.0.5",
+			PodIPs: []corev1.PodIP{{IP: "10.1.0.5"}, {IP: "fd00::0005"}},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "api", ContainerID: "containerd://" + testContainerID},
+			},
+		},
+	}
+	// Completed pod which used the address before
+	testCompletedPod = &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "migrate-abcde",
+			Namespace:         "shop",
+			CreationTimestamp: metav1.NewTime(time.Unix(200, 0)),
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodSucceeded,
+			PodIP: "10.1.0.5",
+		},
+	}
+	testHostPod = &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy-xyz", Namespace: "kube-system"},
+		Spec: corev1.PodSpec{
+			HostNetwork: true,
+			NodeName:    "node-1",
+			Containers: []corev1.Container{{
+				Name:  "kube-proxy",
+				Ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: 10249}},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning, HostIP: "192.168.0.10", PodIP: "192.168.0.10"},
+	}
+	testService = &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "api",
+			Namespace: "shop",
+			UID:       "svc-uid",
+			Labels:    map[string]string{"tier": "backend"},
+		},
+		Spec: corev1.ServiceSpec{ClusterIP: "10.96.0.20", ExternalIPs: []string{"192.0.2.10"}},
+	}
+	testReplicaSet = &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "api-7d9f8c",
+			Namespace: "shop",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "Deployment", Name: "api", Controller: &controller},
+			},
+		},
+	}
+	testEndpoints = &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses:         []corev1.EndpointAddress{{IP: "10.1.0.5"}},
+			NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.1.0.6"}},
+		}},
+	}
+)
+
+// newTestCache creates a cache populated with the test objects, without running informers
+func newTestCache(t *testing.T) *metaCache {
+	c := NewCache(fake.NewSimpleClientset(), 0).(*metaCache)
+	for _, obj := range []interface{}{testPod, testCompletedPod, testHostPod} {
+		if err := c.pods.GetIndexer().Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.services.GetIndexer().Add(testService); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.endpoints.GetIndexer().Add(testEndpoints); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.workloads.replicaSets.GetIndexer().Add(testReplicaSet); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestLookup(t *testing.T) {
+	c := newTestCache(t)
+
+	apiService := &insight.KubernetesObject{
+		Name:   "api",
+		UID:    "svc-uid",
+		Labels: map[string]string{"tier": "backend"},
+	}
+	apiPod := &insight.Kubernetes{
+		Namespace: "shop",
+		Pod: &insight.KubernetesObject{
+			Name:   "api-7d9f8c-x2kq",
+			UID:    "pod-uid",
+			Labels: map[string]string{"app": "api"},
+		},
+		Node:     &insight.KubernetesObject{Name: "node-1"},
+		Service:  apiService,
+		Owner:    &insight.KubernetesOwner{Kind: "ReplicaSet", Name: "api-7d9f8c"},
+		Workload: &insight.KubernetesOwner{Kind: "Deployment", Name: "api"},
+	}
+
+	for ip, expected := range map[string]*insight.Kubernetes{
+		"10.1.0.5":     apiPod,
+		"fd00::5":      apiPod,
+		"10.96.0.20":   {Namespace: "shop", Service: apiService},
+		"192.0.2.10":   {Namespace: "shop", Service: apiService},
+		"10.1.0.6":     {Namespace: "shop", Service: apiService},
+		"192.168.0.10": nil,
+		"8.8.8.8":      nil,
+	} {
+		if got := c.Lookup(net.ParseIP(ip)); !cmp.Equal(got, expected) {
+			t.Errorf("%s: %s", ip, cmp.Diff(expected, got))
+		}
+	}
+}
+
+func TestLookupUpdates(t *testing.T) {
+	c := newTestCache(t)
+
+	// Running pod got deleted, the address is only used by the completed pod now
+	if err := c.pods.GetIndexer().Delete(testPod); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Lookup(net.ParseIP("10.1.0.5")); got == nil || got.Pod.Name != "migrate-abcde" {
+		t.Errorf("Expected completed pod, got %+v", got)
+	}
+	if got := c.Lookup(net.ParseIP("fd00::5")); got != nil {
+		t.Errorf("Expected no metadata for deleted pod, got %+v", got)
+	}
+
+	// Service changed its cluster IP
+	svc := testService.DeepCopy()
+	svc.Spec.ClusterIP = "10.96.0.21"
+	if err := c.services.GetIndexer().Update(svc); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Lookup(net.ParseIP("10.96.0.20")); got != nil {
+		t.Errorf("Expected no metadata for old cluster IP, got %+v", got)
+	}
+	if got := c.Lookup(net.ParseIP("10.96.0.21")); got == nil || got.Service.Name != "api" {
+		t.Errorf("Expected service, got %+v", got)
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	c := newTestCache(t)
+
+	// Events of flows from the pod to its service and to an external address
+	events := insight.NewFromFlows([]*flow.Flow{{
+		Meta: flow.Meta{Transport: protos.TCP, Src: net.ParseIP("10.1.0.5"), SrcPort: 41234, Dst: net.ParseIP("10.96.0.20"), DstPort: 80},
+	}, {
+		Meta: flow.Meta{Transport: protos.UDP, Src: net.ParseIP("10.1.0.5"), SrcPort: 5353, Dst: net.ParseIP("8.8.8.8"), DstPort: 53},
+	}})
+	c.Enrich(events)
+
+	apiService := &insight.KubernetesObject{
+		Name:   "api",
+		UID:    "svc-uid",
+		Labels: map[string]string{"tier": "backend"},
+	}
+	apiPod := &insight.Kubernetes{
+		Namespace: "shop",
+		Pod: &insight.KubernetesObject{
+			Name:   "api-7d9f8c-x2kq",
+			UID:    "pod-uid",
+			Labels: map[string]string{"app": "api"},
+		},
+		Node:     &insight.KubernetesObject{Name: "node-1"},
+		Service:  apiService,
+		Owner:    &insight.KubernetesOwner{Kind: "ReplicaSet", Name: "api-7d9f8c"},
+		Workload: &insight.KubernetesOwner{Kind: "Deployment", Name: "api"},
+	}
+
+	for i, expected := range [][2]*insight.Kubernetes{
+		{apiPod, {Namespace: "shop", Service: apiService}},
+		{apiPod, nil},
+	} {
+		got := [2]*insight.Kubernetes{events[i].Source.Kubernetes, events[i].Destination.Kubernetes}
+		if diff := cmp.Diff(expected, got); diff != "" {
+			t.Errorf("%d: %s", i, diff)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package kubemeta
+
+import (
+	"sync"
+
+	"github.com/xvzf/insight/pkg/insight"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// workloads resolves the workload controlling a pod by walking its ownerReferences, e.g.
+// Pod -> ReplicaSet -> Deployment or Pod -> Job -> CronJob
+type workloads struct {
+	sync.RWMutex
+	replicaSets cache.SharedIndexInformer
+	jobs        cache.SharedIndexInformer
+	resolved    map[types.UID]*insight.KubernetesOwner // Workloads by UID of the intermediate owner
+	generation  uint64                                 // Incremented on every invalidation
+}
+
+// newWorkloads creates a workload resolver watching the intermediate owners of pods
+func newWorkloads(factory informers.SharedInformerFactory) *workloads {
+	w := &workloads{
+		replicaSets: factory.Apps().V1().ReplicaSets().Informer(),
+		jobs:        factory.Batch().V1().Jobs().Informer(),
+		resolved:    make(map[types.UID]*insight.KubernetesOwner),
+	}
+
+	// Owners can be adopted or orphaned, resolve them again on every change
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.invalidate,
+		UpdateFunc: func(_, obj interface{}) { w.invalidate(obj) },
+		DeleteFunc: w.invalidate,
+	}
+	w.replicaSets.AddEventHandler(handler)
+	w.jobs.AddEventHandler(handler)
+	return w
+}
+
+// invalidate removes the resolved workload of an owner
+func (w *workloads) invalidate(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	delete(w.resolved, m.GetUID())
+	w.generation++
+}
+
+// Resolve returns the workload controlling obj, nil if it has no controller
+func (w *workloads) Resolve(obj metav1.Object) *insight.KubernetesOwner {
+	ref := metav1.GetControllerOfNoCopy(obj)
+	if ref == nil {
+		return nil
+	}
+
+	var informer cache.SharedIndexInformer
+	switch ref.Kind {
+	case "ReplicaSet":
+		informer = w.replicaSets
+	case "Job":
+		informer = w.jobs
+	default:
+		// StatefulSets, DaemonSets and other controllers are workloads themselves
+		return &insight.KubernetesOwner{Kind: ref.Kind, Name: ref.Name}
+	}
+
+	w.RLock()
+	workload, ok := w.resolved[ref.UID]
+	generation := w.generation
+	w.RUnlock()
+	if ok {
+		workloadResolutions.WithLabelValues("cached").Inc()
+		return workload
+	}
+
+	// The owner is looked up by name, a recreated owner of the same name has a different UID
+	var m metav1.Object
+	if owner, ok, err := informer.GetStore().GetByKey(obj.GetNamespace() + "/" + ref.Name); err == nil && ok {
+		m, _ = meta.Accessor(owner)
+	}
+	if m == nil || m.GetUID() != ref.UID {
+		// Not synced yet, do not cache the intermediate owner
+		workloadResolutions.WithLabelValues("unknown").Inc()
+		return &insight.KubernetesOwner{Kind: ref.Kind, Name: ref.Name}
+	}
+
+	// Bare ReplicaSets and Jobs are workloads as well
+	workload = &insight.KubernetesOwner{Kind: ref.Kind, Name: ref.Name}
+	if parent := metav1.GetControllerOfNoCopy(m); parent != nil {
+		workload = &insight.KubernetesOwner{Kind: parent.Kind, Name: parent.Name}
+	}
+	workloadResolutions.WithLabelValues("resolved").Inc()
+
+	// Owners changed while resolving are resolved again on the next lookup
+	w.Lock()
+	if w.generation == generation {
+		w.resolved[ref.UID] = workload
+	}
+	w.Unlock()
+	return workload
+}
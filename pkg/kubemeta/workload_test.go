@@ -0,0 +1,137 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package kubemeta
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/insight"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// ownedBy returns object metadata controlled by the given owner
+func ownedBy(name, kind, owner string, uid types.UID) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      name,
+		Namespace: "default",
+		UID:       types.UID(name),
+		OwnerReferences: []metav1.OwnerReference{
+			{Kind: kind, Name: owner, UID: uid, Controller: &controller},
+		},
+	}
+}
+
+func TestWorkloadResolve(t *testing.T) {
+	w := newWorkloads(NewCache(fake.NewSimpleClientset(), 0).(*metaCache).factory)
+	for _, obj := range []interface{}{
+		&appsv1.ReplicaSet{ObjectMeta: ownedBy("web-5c8f", "Deployment", "web", "web")},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "bare", Namespace: "default", UID: "bare"}},
+	} {
+		w.replicaSets.GetIndexer().Add(obj)
+	}
+	w.jobs.GetIndexer().Add(&batchv1.Job{ObjectMeta: ownedBy("backup-1589", "CronJob", "backup", "backup")})
+
+	for _, c := range []struct {
+		pod      metav1.ObjectMeta
+		expected *insight.KubernetesOwner
+	}{
+		{ownedBy("web-5c8f-x2kq", "ReplicaSet", "web-5c8f", "web-5c8f"), &insight.KubernetesOwner{Kind: "Deployment", Name: "web"}},
+		{ownedBy("bare-abcd", "ReplicaSet", "bare", "bare"), &insight.KubernetesOwner{Kind: "ReplicaSet", Name: "bare"}},
+		{ownedBy("backup-1589-q7x", "Job", "backup-1589", "backup-1589"), &insight.KubernetesOwner{Kind: "CronJob", Name: "backup"}},
+		{ownedBy("db-0", "StatefulSet", "db", "db"), &insight.KubernetesOwner{Kind: "StatefulSet", Name: "db"}},
+		{ownedBy("fluentd-8sd7f", "DaemonSet", "fluentd", "fluentd"), &insight.KubernetesOwner{Kind: "DaemonSet", Name: "fluentd"}},
+		// Owner not synced yet
+		{ownedBy("api-6d4b-k2j", "ReplicaSet", "api-6d4b", "api-6d4b"), &insight.KubernetesOwner{Kind: "ReplicaSet", Name: "api-6d4b"}},
+		// Owner recreated with the same name, the cached one is the old one
+		{ownedBy("web-5c8f-old", "ReplicaSet", "web-5c8f", "web-5c8f-previous"), &insight.KubernetesOwner{Kind: "ReplicaSet", Name: "web-5c8f"}},
+		{metav1.ObjectMeta{Name: "standalone", Namespace: "default"}, nil},
+	} {
+		pod := &corev1.Pod{ObjectMeta: c.pod}
+		// Resolve twice to hit the cache
+		for i := 0; i < 2; i++ {
+			if got := w.Resolve(pod); !cmp.Equal(got, c.expected) {
+				t.Errorf("%s: %s", c.pod.Name, cmp.Diff(c.expected, got))
+			}
+		}
+	}
+
+	for _, uid := range []types.UID{"api-6d4b", "web-5c8f-previous"} {
+		if _, ok := w.resolved[uid]; ok {
+			t.Errorf("Owner %s must not be cached", uid)
+		}
+	}
+}
+
+func TestWorkloadOwnerChanges(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&appsv1.ReplicaSet{
+		ObjectMeta: ownedBy("web-5c8f", "Deployment", "web", "web"),
+	})
+	c := NewCache(clientset, 0).(*metaCache)
+	go c.Run()
+	defer c.Stop()
+
+	pod := &corev1.Pod{ObjectMeta: ownedBy("web-5c8f-x2kq", "ReplicaSet", "web-5c8f", "web-5c8f")}
+	eventually(t, &insight.KubernetesOwner{Kind: "Deployment", Name: "web"}, func() interface{} {
+		return c.workloads.Resolve(pod)
+	})
+
+	// ReplicaSet got orphaned
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "web-5c8f", Namespace: "default", UID: "web-5c8f"}}
+	if _, err := clientset.AppsV1().ReplicaSets("default").Update(rs); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, &insight.KubernetesOwner{Kind: "ReplicaSet", Name: "web-5c8f"}, func() interface{} {
+		return c.workloads.Resolve(pod)
+	})
+
+	// ReplicaSet got adopted by another Deployment
+	rs.ObjectMeta = ownedBy("web-5c8f", "Deployment", "frontend", "frontend")
+	if _, err := clientset.AppsV1().ReplicaSets("default").Update(rs); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, &insight.KubernetesOwner{Kind: "Deployment", Name: "frontend"}, func() interface{} {
+		return c.workloads.Resolve(pod)
+	})
+}
+
+// eventually waits for get to return the expected value
+func eventually(t *testing.T, expected interface{}, get func() interface{}) {
+	t.Helper()
+	var got interface{}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if got = get(); cmp.Equal(got, expected) {
+			return
+		}
+	}
+	t.Errorf("Timed out: %s", cmp.Diff(expected, got))
+}
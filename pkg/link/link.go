@@ -0,0 +1,171 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package link
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xvzf/insight/pkg/nl"
+)
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "link",
+	})
+}
+
+// Logger
+var log *logrus.Entry
+
+// ErrLostEvents is returned when link events were dropped by the kernel, the interfaces have
+// to be listed again
+var ErrLostEvents = nl.ErrLostEvents
+
+// Link event types
+const (
+	EventNew    uint8 = iota // Interface has been added or changed
+	EventDelete              // Interface has been removed
+)
+
+// Event describes a change of a network interface
+type Event struct {
+	Type  uint8
+	Index int    // Interface index
+	Name  string // Interface name
+	Up    bool   // Administrative state (IFF_UP)
+}
+
+// Listener decodes link events received on a netlink socket
+type Listener interface {
+	Receive() ([]*Event, error)
+	Close() error
+}
+
+// Rtnetlink constants (linux/rtnetlink.h, linux/if_link.h)
+const (
+	rtmNewLink      = 16
+	rtmDelLink      = 17
+	ifinfomsgLength = 16
+	nlaHeaderLength = 4
+	nlaTypeMask     = 0x3fff
+	iflaIfname      = 3
+	iffUp           = 0x1
+)
+
+type listener struct {
+	sock nl.Socket
+}
+
+// NewListener returns a listener for the link events received on an rtnetlink socket
+func NewListener(sock nl.Socket) Listener {
+	return &listener{sock: sock}
+}
+
+// Receive blocks until the next datagram has been received or the listener is closed and
+// returns the contained events. ErrLostEvents signals an overrun, the listener stays usable.
+func (l *listener) Receive() ([]*Event, error) {
+	b, err := l.sock.Receive()
+	if err != nil {
+		return nil, err
+	}
+	return Decode(b)
+}
+
+func (l *listener) Close() error {
+	return l.sock.Close()
+}
+
+// Decode decodes all link events contained in a netlink datagram. Messages which are not link
+// events are skipped.
+func Decode(b []byte) ([]*Event, error) {
+	var events []*Event
+
+	msgs, err := nl.Messages(b)
+	for _, m := range msgs {
+		var eType uint8
+		switch m.Type {
+		case rtmNewLink:
+			eType = EventNew
+		case rtmDelLink:
+			eType = EventDelete
+		default:
+			continue
+		}
+
+		if len(m.Payload) < ifinfomsgLength {
+			return events, errors.New("link message too short")
+		}
+		e := &Event{
+			Type:  eType,
+			Index: int(int32(nl.NativeEndian.Uint32(m.Payload[4:]))),
+			Up:    nl.NativeEndian.Uint32(m.Payload[8:])&iffUp != 0,
+		}
+
+		// Attributes, only the name is of interest
+		attrs := m.Payload[ifinfomsgLength:]
+		for len(attrs) >= nlaHeaderLength {
+			l := int(nl.NativeEndian.Uint16(attrs[0:]))
+			typ := nl.NativeEndian.Uint16(attrs[2:]) & nlaTypeMask
+			if l < nlaHeaderLength || l > len(attrs) {
+				return events, fmt.Errorf("invalid netlink attribute length %d", l)
+			}
+			if typ == iflaIfname {
+				e.Name = cString(attrs[nlaHeaderLength:l])
+			}
+			if nl.Align(l) > len(attrs) {
+				break
+			}
+			attrs = attrs[nl.Align(l):]
+		}
+
+		events = append(events, e)
+	}
+
+	return events, err
+}
+
+// cString converts a null terminated string
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// Match checks if the interface name matches any of the patterns (shell globs, e.g. cali*)
+func Match(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, err := filepath.Match(p, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
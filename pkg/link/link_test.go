@@ -0,0 +1,109 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package link
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/nl"
+)
+
+// message encodes a rtnetlink link message
+func message(typ uint16, index int32, flags uint32, name string) []byte {
+	var attrs []byte
+	if name != "" {
+		value := append([]byte(name), 0)
+		a := make([]byte, nl.Align(nlaHeaderLength+len(value)))
+		nl.NativeEndian.PutUint16(a[0:], uint16(nlaHeaderLength+len(value)))
+		nl.NativeEndian.PutUint16(a[2:], iflaIfname)
+		copy(a[nlaHeaderLength:], value)
+		attrs = a
+	}
+
+	b := make([]byte, nl.HeaderLength+ifinfomsgLength, nl.HeaderLength+ifinfomsgLength+len(attrs))
+	nl.NativeEndian.PutUint32(b[0:], uint32(cap(b)))
+	nl.NativeEndian.PutUint16(b[4:], typ)
+	nl.NativeEndian.PutUint32(b[nl.HeaderLength+4:], uint32(index))
+	nl.NativeEndian.PutUint32(b[nl.HeaderLength+8:], flags)
+	return append(b, attrs...)
+}
+
+func TestDecode(t *testing.T) {
+	var b []byte
+	b = append(b, message(rtmNewLink, 12, iffUp, "cali1234")...)
+	b = append(b, message(rtmDelLink, 13, 0, "veth0")...)
+	b = append(b, message(24, 1, 0, "")...) // RTM_NEWADDR, skipped
+
+	events, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*Event{
+		{Type: EventNew, Index: 12, Name: "cali1234", Up: true},
+		{Type: EventDelete, Index: 13, Name: "veth0"},
+	}
+	if !cmp.Equal(events, expected) {
+		t.Error(cmp.Diff(expected, events))
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	b := message(rtmNewLink, 12, iffUp, "eth0")
+	nl.NativeEndian.PutUint32(b[0:], uint32(len(b)+4))
+	if _, err := Decode(b); err == nil {
+		t.Error("Expected error on invalid message length")
+	}
+
+	b = message(rtmNewLink, 12, iffUp, "")
+	nl.NativeEndian.PutUint32(b[0:], nl.HeaderLength+4)
+	if _, err := Decode(b[:nl.HeaderLength+4]); err == nil {
+		t.Error("Expected error on truncated link message")
+	}
+
+	b = message(nl.MsgOverrun, 0, 0, "")
+	if _, err := Decode(b); err != ErrLostEvents {
+		t.Errorf("Expected %v, got %v", ErrLostEvents, err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	patterns := []string{"eth0", "cali*", "veth[0-9]*"}
+
+	for name, expected := range map[string]bool{
+		"eth0":       true,
+		"eth1":       false,
+		"cali12ab34": true,
+		"veth3fa":    true,
+		"vethab":     false,
+		"lo":         false,
+	} {
+		if Match(patterns, name) != expected {
+			t.Errorf("Match(%s) expected %t", name, expected)
+		}
+	}
+}
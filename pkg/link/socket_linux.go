@@ -0,0 +1,49 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package link
+
+import (
+	"syscall"
+
+	"github.com/xvzf/insight/pkg/nl"
+)
+
+// rtmgrpLink is the rtnetlink multicast group of link events (linux/rtnetlink.h)
+const rtmgrpLink = 0x1
+
+// Subscribe opens a rtnetlink socket receiving link events. No capabilities are required.
+func Subscribe() (nl.Socket, error) {
+	return nl.Open(syscall.NETLINK_ROUTE, rtmgrpLink, 0)
+}
+
+// Listen subscribes to link events and returns a listener for them
+func Listen() (Listener, error) {
+	sock, err := Subscribe()
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(sock), nil
+}
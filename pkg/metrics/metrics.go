@@ -0,0 +1,73 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "metrics",
+	})
+}
+
+// Logger
+var log *logrus.Entry
+
+// Namespace of all insight metrics
+const Namespace = "insight"
+
+// DefaultAddr is the default listen address of the metrics endpoint
+const DefaultAddr = ":9090"
+
+// Handler serves the metrics of the default registry on /metrics and a liveness probe on /healthz
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	return mux
+}
+
+// Serve exposes the metrics of the default registry on addr/metrics in the background.
+// An empty address disables the endpoint.
+func Serve(addr string) {
+	if addr == "" {
+		return
+	}
+
+	go func() {
+		log.Infof("Serving metrics on %s/metrics", addr)
+		if err := http.ListenAndServe(addr, Handler()); err != nil {
+			log.WithError(err).Error("Metrics endpoint failed")
+		}
+	}()
+}
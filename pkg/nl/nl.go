@@ -0,0 +1,123 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package nl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "netlink",
+	})
+}
+
+// Logger
+var log *logrus.Entry
+
+// NativeEndian is the byte order of netlink headers, they are encoded in host byte order
+var NativeEndian = nativeEndian()
+
+func nativeEndian() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// ErrLostEvents is returned when the kernel dropped messages because the socket buffer was full
+var ErrLostEvents = errors.New("netlink socket buffer overrun, events lost")
+
+// Socket receives raw netlink datagrams
+type Socket interface {
+	Receive() ([]byte, error)
+	Close() error
+}
+
+// Netlink message header and types (linux/netlink.h)
+const (
+	HeaderLength = 16
+	MsgError     = 2
+	MsgDone      = 3
+	MsgOverrun   = 4
+)
+
+// Message is a netlink message
+type Message struct {
+	Type    uint16
+	Flags   uint16
+	Payload []byte
+}
+
+// Align rounds up to the netlink alignment of 4 bytes
+func Align(n int) int {
+	return (n + 3) &^ 3
+}
+
+// Messages splits a netlink datagram into its messages, acknowledgements are skipped. Parsing
+// stops at NLMSG_DONE; an overrun returns ErrLostEvents and an error message its error code,
+// along with the messages before.
+func Messages(b []byte) ([]Message, error) {
+	var msgs []Message
+	for len(b) >= HeaderLength {
+		length := int(NativeEndian.Uint32(b[0:]))
+		if length < HeaderLength || length > len(b) {
+			return msgs, fmt.Errorf("invalid netlink message length %d", length)
+		}
+		m := Message{
+			Type:    NativeEndian.Uint16(b[4:]),
+			Flags:   NativeEndian.Uint16(b[6:]),
+			Payload: b[HeaderLength:length],
+		}
+		if Align(length) >= len(b) {
+			b = nil
+		} else {
+			b = b[Align(length):]
+		}
+
+		switch m.Type {
+		case MsgDone:
+			return msgs, nil
+		case MsgOverrun:
+			return msgs, ErrLostEvents
+		case MsgError:
+			if len(m.Payload) >= 4 {
+				if code := int32(NativeEndian.Uint32(m.Payload)); code != 0 {
+					return msgs, fmt.Errorf("netlink error %d", -code)
+				}
+			}
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
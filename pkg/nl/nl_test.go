@@ -0,0 +1,98 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package nl
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// message encodes a netlink message
+func message(typ, flags uint16, payload []byte) []byte {
+	b := make([]byte, Align(HeaderLength+len(payload)))
+	NativeEndian.PutUint32(b[0:], uint32(HeaderLength+len(payload)))
+	NativeEndian.PutUint16(b[4:], typ)
+	NativeEndian.PutUint16(b[6:], flags)
+	copy(b[HeaderLength:], payload)
+	return b
+}
+
+func join(msgs ...[]byte) []byte {
+	var b []byte
+	for _, m := range msgs {
+		b = append(b, m...)
+	}
+	return b
+}
+
+func TestMessages(t *testing.T) {
+	ack := make([]byte, 4)
+	nlerr := make([]byte, 4)
+	NativeEndian.PutUint32(nlerr, uint32(0xffffffff))
+
+	for _, test := range []struct {
+		name     string
+		b        []byte
+		expected []Message
+		err      error
+	}{{
+		name:     "unaligned last message",
+		b:        join(message(16, 0, []byte{1, 2, 3, 4}), message(17, 0x400, []byte{5})[:HeaderLength+1]),
+		expected: []Message{{Type: 16, Payload: []byte{1, 2, 3, 4}}, {Type: 17, Flags: 0x400, Payload: []byte{5}}},
+	}, {
+		name:     "acknowledgement skipped, done stops",
+		b:        join(message(MsgError, 0, ack), message(16, 0, nil), message(MsgDone, 0, nil), message(17, 0, nil)),
+		expected: []Message{{Type: 16, Payload: []byte{}}},
+	}, {
+		name:     "overrun",
+		b:        join(message(16, 0, nil), message(MsgOverrun, 0, nil)),
+		expected: []Message{{Type: 16, Payload: []byte{}}},
+		err:      ErrLostEvents,
+	}} {
+		msgs, err := Messages(test.b)
+		if err != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+		}
+		if diff := cmp.Diff(test.expected, msgs); diff != "" {
+			t.Errorf("%s: %s", test.name, diff)
+		}
+	}
+
+	truncated := message(16, 0, []byte{1, 2, 3, 4})
+	NativeEndian.PutUint32(truncated, uint32(len(truncated)+4))
+	short := message(16, 0, nil)
+	NativeEndian.PutUint32(short, 4)
+	for name, b := range map[string][]byte{
+		"netlink error": message(MsgError, 0, nlerr),
+		"truncated":     truncated,
+		"short length":  short,
+	} {
+		if _, err := Messages(b); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
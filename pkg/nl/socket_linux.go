@@ -0,0 +1,103 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package nl
+
+import (
+	"os"
+	"syscall"
+)
+
+// socket is a non-blocking netlink socket, waiting for datagrams in the runtime poller so
+// closing it unblocks a pending Receive
+type socket struct {
+	f    *os.File
+	conn syscall.RawConn
+	buf  []byte
+}
+
+// Open opens a netlink socket of the given protocol subscribed to the multicast groups. The
+// receive buffer is increased to rcvbuf bytes if it is not 0.
+func Open(protocol int, groups uint32, rcvbuf int) (Socket, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, protocol)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	if rcvbuf > 0 {
+		// SO_RCVBUFFORCE ignores rmem_max but requires CAP_NET_ADMIN
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, rcvbuf); err != nil {
+			if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, rcvbuf); err != nil {
+				log.WithError(err).Warn("Failed to increase receive buffer size")
+			}
+		}
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: groups,
+	}); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	f := os.NewFile(uintptr(fd), "netlink")
+	conn, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &socket{f: f, conn: conn, buf: make([]byte, os.Getpagesize()*8)}, nil
+}
+
+// Receive blocks until the next datagram has been received or the socket is closed
+func (s *socket) Receive() ([]byte, error) {
+	var n int
+	var rerr error
+	err := s.conn.Read(func(fd uintptr) bool {
+		for {
+			n, _, rerr = syscall.Recvfrom(int(fd), s.buf, 0)
+			if rerr != syscall.EINTR {
+				return rerr != syscall.EAGAIN
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch rerr {
+	case nil:
+		return s.buf[:n], nil
+	case syscall.ENOBUFS:
+		return nil, ErrLostEvents
+	default:
+		return nil, os.NewSyscallError("recvfrom", rerr)
+	}
+}
+
+func (s *socket) Close() error {
+	return s.f.Close()
+}
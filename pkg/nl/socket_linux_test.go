@@ -0,0 +1,60 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package nl
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSocketClose(t *testing.T) {
+	sock, err := Open(syscall.NETLINK_ROUTE, 0, 0)
+	if err != nil {
+		t.Skip("netlink not available: ", err)
+	}
+
+	received := make(chan error)
+	go func() {
+		_, err := sock.Receive()
+		received <- err
+	}()
+
+	// Wait for the receiver to block
+	time.Sleep(10 * time.Millisecond)
+	if err := sock.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-received:
+		if err == nil {
+			t.Error("Expected an error receiving on a closed socket")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Receive did not return after the socket was closed")
+	}
+}
@@ -0,0 +1,96 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package procfs
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// containerIDPattern matches the container ID in the last element of a cgroup path, e.g.
+	// 0123...cdef, docker-0123...cdef.scope or cri-containerd-0123...cdef.scope
+	containerIDPattern = regexp.MustCompile(`(?:^|[-:])([0-9a-f]{64})(?:\.scope)?$`)
+	// podUIDPattern matches the pod UID in a kubepods cgroup path, e.g. pod1234-... with the
+	// cgroupfs or kubepods-besteffort-pod1234_....slice with the systemd cgroup driver
+	podUIDPattern = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+)
+
+// Cgroup identifies the container and pod a process belongs to, both are empty for processes
+// of the host. Path is the cgroup in the unified hierarchy, or the first hierarchy listed with
+// cgroup v1.
+type Cgroup struct {
+	Path        string
+	ContainerID string
+	PodUID      string
+}
+
+// ReadCgroup reads the cgroup of the process pid from the proc filesystem mounted at root
+func ReadCgroup(root string, pid int) (Cgroup, error) {
+	f, err := os.Open(filepath.Join(root, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return Cgroup{}, err
+	}
+	defer f.Close()
+	return parseCgroup(f)
+}
+
+// parseCgroup extracts the container and pod from the cgroup paths of a process, e.g.
+//
+//	12:pids:/kubepods/burstable/pod8d6d7a4c-.../0123...cdef
+//	0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod8d6d7a4c_....slice/cri-containerd-0123...cdef.scope
+func parseCgroup(r io.Reader) (Cgroup, error) {
+	var c Cgroup
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		path := parts[2]
+		if c.Path == "" || parts[0] == "0" {
+			c.Path = path
+		}
+
+		if c.ContainerID == "" {
+			if m := containerIDPattern.FindStringSubmatch(filepath.Base(path)); m != nil {
+				c.ContainerID = m[1]
+			}
+		}
+		if c.PodUID == "" {
+			if m := podUIDPattern.FindStringSubmatch(path); m != nil {
+				c.PodUID = strings.Replace(m[1], "_", "-", -1)
+			}
+		}
+	}
+	return c, scanner.Err()
+}
@@ -0,0 +1,70 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package procfs
+
+import (
+	"strings"
+	"testing"
+)
+
+const testContainerID = "3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e3f4a"
+
+func TestParseCgroup(t *testing.T) {
+	for name, c := range map[string]struct {
+		cgroup   string
+		expected Cgroup
+	}{
+		"cgroupfs": {
+			"12:pids:/kubepods/burstable/pod8d6d7a4c-1b2e-4f3a-9c8d-7e6f5a4b3c2d/" + testContainerID + "\n" +
+				"1:name=systemd:/kubepods/burstable/pod8d6d7a4c-1b2e-4f3a-9c8d-7e6f5a4b3c2d/" + testContainerID + "\n",
+			Cgroup{"/kubepods/burstable/pod8d6d7a4c-1b2e-4f3a-9c8d-7e6f5a4b3c2d/" + testContainerID, testContainerID, "8d6d7a4c-1b2e-4f3a-9c8d-7e6f5a4b3c2d"},
+		},
+		"systemd containerd": {
+			"0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod8d6d7a4c_1b2e_4f3a_9c8d_7e6f5a4b3c2d.slice/cri-containerd-" + testContainerID + ".scope\n",
+			Cgroup{"/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod8d6d7a4c_1b2e_4f3a_9c8d_7e6f5a4b3c2d.slice/cri-containerd-" + testContainerID + ".scope", testContainerID, "8d6d7a4c-1b2e-4f3a-9c8d-7e6f5a4b3c2d"},
+		},
+		"systemd cri-o": {
+			"0::/kubepods.slice/kubepods-pod8d6d7a4c_1b2e_4f3a_9c8d_7e6f5a4b3c2d.slice/crio-" + testContainerID + ".scope\n",
+			Cgroup{"/kubepods.slice/kubepods-pod8d6d7a4c_1b2e_4f3a_9c8d_7e6f5a4b3c2d.slice/crio-" + testContainerID + ".scope", testContainerID, "8d6d7a4c-1b2e-4f3a-9c8d-7e6f5a4b3c2d"},
+		},
+		"docker": {
+			"4:memory:/docker/" + testContainerID + "\n",
+			Cgroup{Path: "/docker/" + testContainerID, ContainerID: testContainerID},
+		},
+		"host": {
+			"12:pids:/system.slice/kubelet.service\n1:name=systemd:/system.slice/kubelet.service\n0::/system.slice/kubelet.service/init\n",
+			Cgroup{Path: "/system.slice/kubelet.service/init"},
+		},
+	} {
+		got, err := parseCgroup(strings.NewReader(c.cgroup))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.expected {
+			t.Errorf("%s: expected %+v, got %+v", name, c.expected, got)
+		}
+	}
+}
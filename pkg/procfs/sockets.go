@@ -0,0 +1,172 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package procfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+func init() {
+	// Addresses in the socket tables are printed as words in host byte order
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		nativeEndian = binary.LittleEndian
+	} else {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+var nativeEndian binary.ByteOrder
+
+// tcpListen is the state of listening TCP sockets in /proc/net/tcp
+const tcpListen = 0x0a
+
+// Socket is an entry of the socket tables in /proc/net
+type Socket struct {
+	Transport  protos.ProtocolType
+	IP         net.IP
+	Port       uint16
+	RemoteIP   net.IP
+	RemotePort uint16
+	Listening  bool
+	Inode      uint64
+}
+
+// socketTables are the socket tables of the network namespace in /proc/net
+var socketTables = []struct {
+	name      string
+	transport protos.ProtocolType
+}{
+	{"tcp", protos.TCP},
+	{"tcp6", protos.TCP},
+	{"udp", protos.UDP},
+	{"udp6", protos.UDP},
+}
+
+// ReadSockets reads the TCP and UDP sockets of the network namespace of the process from the
+// proc filesystem mounted at root. Missing tables, e.g. without IPv6 support, are skipped.
+func ReadSockets(root string) ([]Socket, error) {
+	var sockets []Socket
+	for _, table := range socketTables {
+		f, err := os.Open(filepath.Join(root, "net", table.name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s, err := parseSockets(f, table.transport)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", table.name, err)
+		}
+		sockets = append(sockets, s...)
+	}
+	return sockets, nil
+}
+
+// parseSockets parses a socket table, e.g.
+//
+//	sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+//	 0: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 23456 ...
+func parseSockets(r io.Reader, transport protos.ProtocolType) ([]Socket, error) {
+	var sockets []Socket
+
+	scanner := bufio.NewScanner(r)
+	// Skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("malformed socket entry %q", scanner.Text())
+		}
+
+		ip, port, err := parseAddr(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		remoteIP, remotePort, err := parseAddr(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		state, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		sockets = append(sockets, Socket{
+			Transport:  transport,
+			IP:         ip,
+			Port:       port,
+			RemoteIP:   remoteIP,
+			RemotePort: remotePort,
+			// Unconnected UDP sockets have no state of their own and are treated as listening
+			Listening: (transport == protos.TCP && state == tcpListen) || (transport == protos.UDP && remotePort == 0),
+			Inode:     inode,
+		})
+	}
+	return sockets, scanner.Err()
+}
+
+// parseAddr parses an address of a socket table, e.g. 0100007F:1F90 for 127.0.0.1:8080
+func parseAddr(s string) (net.IP, uint16, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return nil, 0, fmt.Errorf("malformed socket address %q", s)
+	}
+
+	raw, err := hex.DecodeString(parts[0])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("malformed socket address %q", s)
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed socket port %q", s)
+	}
+
+	// The address is printed as 32 bit words in host byte order
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], nativeEndian.Uint32(raw[i:]))
+	}
+	return ip, uint16(port), nil
+}
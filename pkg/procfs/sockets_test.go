@@ -0,0 +1,100 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package procfs
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+// Socket tables as printed on a little endian host
+const (
+	testTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:3A98 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0A00A8C0:0016 0B00A8C0:D431 01 00000000:00000000 02:000A7214 00000000     0        0 1002 4 0000000000000000 20 4 30 10 -1
+`
+	testTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:238C 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1003 1 0000000000000000 100 0 0 10 0
+   1: 0000000000000000FFFF00000100007F:3A98 0000000000000000FFFF00000100007F:9C40 01 00000000:00000000 00:00000000 00000000  1000        0 1004 1 0000000000000000 20 4 31 10 -1
+`
+	testUDP = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  100: 00000000:14E9 00000000:0000 07 00000000:00000000 00:00000000 00000000   104        0 1005 2 0000000000000000 0
+`
+)
+
+func TestParseSockets(t *testing.T) {
+	expected := []Socket{
+		{protos.TCP, net.ParseIP("127.0.0.1").To4(), 15000, net.IPv4zero.To4(), 0, true, 1001},
+		{protos.TCP, net.ParseIP("192.168.0.10").To4(), 22, net.ParseIP("192.168.0.11").To4(), 54321, false, 1002},
+	}
+	got, err := parseSockets(strings.NewReader(testTCP), protos.TCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(got, expected) {
+		t.Error(cmp.Diff(expected, got))
+	}
+
+	expected = []Socket{
+		{protos.TCP, net.IPv6unspecified, 9100, net.IPv6unspecified, 0, true, 1003},
+		{protos.TCP, net.ParseIP("::ffff:127.0.0.1"), 15000, net.ParseIP("::ffff:127.0.0.1"), 40000, false, 1004},
+	}
+	got, err = parseSockets(strings.NewReader(testTCP6), protos.TCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(got, expected) {
+		t.Error(cmp.Diff(expected, got))
+	}
+
+	expected = []Socket{
+		{protos.UDP, net.IPv4zero.To4(), 5353, net.IPv4zero.To4(), 0, true, 1005},
+	}
+	got, err = parseSockets(strings.NewReader(testUDP), protos.UDP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(got, expected) {
+		t.Error(cmp.Diff(expected, got))
+	}
+}
+
+func TestParseSocketsMalformed(t *testing.T) {
+	for _, entry := range []string{
+		"   0: 0100007F:3A98 00000000:0000 0A",
+		"   0: 0100007F 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001",
+		"   0: 0100007F:3A98 000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001",
+		"   0: 0100007F:3A98 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 inode",
+	} {
+		if _, err := parseSockets(strings.NewReader("header\n"+entry), protos.TCP); err == nil {
+			t.Errorf("Expected error for %q", entry)
+		}
+	}
+}
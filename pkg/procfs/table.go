@@ -0,0 +1,355 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package procfs
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+// Logger
+var log *logrus.Entry
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "procfs",
+	})
+}
+
+// DefaultRoot is the mount point of the proc filesystem
+const DefaultRoot = "/proc"
+
+// Owner is the process owning a socket
+type Owner struct {
+	PID        int
+	Comm       string // Command name
+	Executable string // Empty if not accessible
+	Cgroup
+}
+
+// Table maps local socket addresses to the processes owning them. Only the sockets of the
+// network namespace of the calling process are known, and only processes visible in its PID
+// namespace are attributed, e.g. a probe in the host network and PID namespace sees the
+// hostNetwork pods of its node.
+type Table interface {
+	// Lookup returns the owner of the socket bound to ip and port, nil if unknown
+	Lookup(transport protos.ProtocolType, ip net.IP, port uint16) *Owner
+	// LookupFlow returns the owner of a local socket of the flow, nil if unknown. source
+	// reports if the owner is the source of the flow.
+	LookupFlow(m flow.Meta) (o *Owner, source bool)
+}
+
+type socketKey struct {
+	transport protos.ProtocolType
+	ip        string // Empty for wildcard addresses
+	port      uint16
+}
+
+type connKey struct {
+	transport  protos.ProtocolType
+	ip         string
+	port       uint16
+	remoteIP   string
+	remotePort uint16
+}
+
+// socketOwner is the owner of a socket, removed is set once the socket has been closed
+type socketOwner struct {
+	*Owner
+	removed time.Time
+}
+
+type table struct {
+	sync.Mutex
+	root      string
+	maxAge    time.Duration
+	retention time.Duration
+	updated   time.Time
+	owners    map[socketKey]socketOwner
+	conns     map[connKey]socketOwner
+	local     map[string]bool
+	now       func() time.Time
+	addrs     func() ([]net.Addr, error)
+}
+
+// NewTable creates a new Table reading the proc filesystem mounted at root. Sockets are
+// reread on a lookup miss if the table is older than maxAge, as scanning the file descriptors
+// of all processes is expensive. Owners of closed sockets are kept for retention, so flows
+// exported after the connection has been closed are still attributed.
+func NewTable(root string, maxAge, retention time.Duration) Table {
+	return &table{
+		root:      root,
+		maxAge:    maxAge,
+		retention: retention,
+		owners:    make(map[socketKey]socketOwner),
+		conns:     make(map[connKey]socketOwner),
+		local:     make(map[string]bool),
+		now:       time.Now,
+		addrs:     net.InterfaceAddrs,
+	}
+}
+
+// Lookup returns the owner of the socket bound to ip and port. Sockets bound to the exact
+// address take precedence over sockets listening on the wildcard address. Remote addresses
+// are never attributed, even if a local socket listens on the port.
+func (t *table) Lookup(transport protos.ProtocolType, ip net.IP, port uint16) *Owner {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.cached(func() *Owner {
+		return t.lookup(transport, ip, port)
+	}, ip)
+}
+
+// LookupFlow returns the owner of a local socket of the flow. The connected socket of the
+// source, i.e. the process opening the connection, takes precedence over the destination.
+// Sockets without a matching connection, e.g. unconnected UDP sockets, are looked up by
+// their local address.
+func (t *table) LookupFlow(m flow.Meta) (*Owner, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	var source bool
+	o := t.cached(func() *Owner {
+		var o *Owner
+		o, source = t.lookupFlow(m)
+		return o
+	}, m.Src, m.Dst)
+	return o, source
+}
+
+// cached runs lookup, rereading the sockets on a miss if the table is older than maxAge and
+// one of the addresses is local
+func (t *table) cached(lookup func() *Owner, ips ...net.IP) *Owner {
+	if o := lookup(); o != nil {
+		lookups.WithLabelValues("hit").Inc()
+		return o
+	}
+
+	local := t.updated.IsZero()
+	for _, ip := range ips {
+		local = local || t.isLocal(ip)
+	}
+	if !local || t.now().Sub(t.updated) < t.maxAge {
+		lookups.WithLabelValues("miss").Inc()
+		return nil
+	}
+
+	if err := t.refresh(); err != nil {
+		log.WithError(err).Warn("Failed to read the socket tables")
+	}
+	if o := lookup(); o != nil {
+		lookups.WithLabelValues("hit").Inc()
+		return o
+	}
+	lookups.WithLabelValues("miss").Inc()
+	return nil
+}
+
+func (t *table) lookupFlow(m flow.Meta) (*Owner, bool) {
+	src, dst := m.Src.String(), m.Dst.String()
+	if o, ok := t.conns[connKey{m.Transport, src, m.SrcPort, dst, m.DstPort}]; ok {
+		return o.Owner, true
+	}
+	if o, ok := t.conns[connKey{m.Transport, dst, m.DstPort, src, m.SrcPort}]; ok {
+		return o.Owner, false
+	}
+	if o := t.lookup(m.Transport, m.Src, m.SrcPort); o != nil {
+		return o, true
+	}
+	return t.lookup(m.Transport, m.Dst, m.DstPort), false
+}
+
+func (t *table) lookup(transport protos.ProtocolType, ip net.IP, port uint16) *Owner {
+	if o, ok := t.owners[socketKey{transport, ip.String(), port}]; ok {
+		return o.Owner
+	}
+	if !t.isLocal(ip) {
+		return nil
+	}
+	return t.owners[socketKey{transport, "", port}].Owner
+}
+
+// isLocal checks if ip is an address of the network namespace
+func (t *table) isLocal(ip net.IP) bool {
+	return ip.IsLoopback() || t.local[ip.String()]
+}
+
+// refresh rereads the socket tables and resolves the owners of the sockets
+func (t *table) refresh() error {
+	t.updated = t.now()
+	refreshes.Inc()
+
+	addrs, err := t.addrs()
+	if err != nil {
+		return err
+	}
+	local := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			local[ipnet.IP.String()] = true
+		}
+	}
+	t.local = local
+
+	sockets, err := ReadSockets(t.root)
+	if err != nil {
+		return err
+	}
+	pids, err := t.socketOwners()
+	if err != nil {
+		return err
+	}
+
+	owners := make(map[socketKey]socketOwner)
+	conns := make(map[connKey]socketOwner)
+	procs := make(map[int]*Owner)
+	for _, s := range sockets {
+		pid, ok := pids[s.Inode]
+		if !ok {
+			continue
+		}
+
+		o, ok := procs[pid]
+		if !ok {
+			o, err = ReadOwner(t.root, pid)
+			if err != nil {
+				// The process exited in the meantime
+				continue
+			}
+			procs[pid] = o
+		}
+
+		if s.RemotePort != 0 {
+			conns[connKey{s.Transport, s.IP.String(), s.Port, s.RemoteIP.String(), s.RemotePort}] = socketOwner{Owner: o}
+		}
+
+		key := socketKey{transport: s.Transport, port: s.Port}
+		if !s.IP.IsUnspecified() {
+			key.ip = s.IP.String()
+		}
+		// Listening sockets are preferred over connections sharing the local address
+		if _, ok := owners[key]; !ok || s.Listening {
+			owners[key] = socketOwner{Owner: o}
+		}
+	}
+
+	// Keep closed sockets for the retention time unless their address has been reused
+	for k, o := range t.owners {
+		if _, ok := owners[k]; !ok && t.retain(&o) {
+			owners[k] = o
+		}
+	}
+	for k, o := range t.conns {
+		if _, ok := conns[k]; !ok && t.retain(&o) {
+			conns[k] = o
+		}
+	}
+
+	t.owners = owners
+	t.conns = conns
+	knownSockets.Set(float64(len(owners)))
+	return nil
+}
+
+// retain marks the owner of a socket which is gone as removed and checks if it is still kept
+func (t *table) retain(o *socketOwner) bool {
+	if o.removed.IsZero() {
+		o.removed = t.updated
+	}
+	return t.updated.Sub(o.removed) < t.retention
+}
+
+// ReadOwner reads the command, executable and cgroup of the process pid from the proc
+// filesystem mounted at root
+func ReadOwner(root string, pid int) (*Owner, error) {
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	comm, err := ioutil.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return nil, err
+	}
+	c, err := ReadCgroup(root, pid)
+	if err != nil {
+		return nil, err
+	}
+	// Resolving the executable requires ptrace access to the process
+	exe, _ := os.Readlink(filepath.Join(dir, "exe"))
+
+	return &Owner{
+		PID:        pid,
+		Comm:       strings.TrimSuffix(string(comm), "\n"),
+		Executable: exe,
+		Cgroup:     c,
+	}, nil
+}
+
+// socketOwners maps socket inodes to the processes holding a file descriptor of them
+func (t *table) socketOwners() (map[uint64]int, error) {
+	procs, err := ioutil.ReadDir(t.root)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[uint64]int)
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil || !proc.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(t.root, proc.Name(), "fd")
+		fds, err := ioutil.ReadDir(dir)
+		if err != nil {
+			// The process exited or is not accessible
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(dir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			// Sockets shared between processes, e.g. after a fork, are attributed to one of them
+			if _, ok := owners[inode]; !ok {
+				owners[inode] = pid
+			}
+		}
+	}
+	return owners, nil
+}
@@ -0,0 +1,256 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package procfs
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/protos"
+	"github.com/xvzf/insight/pkg/tracking/trackingtest"
+)
+
+// newTestRoot creates a proc filesystem with the test socket tables. Process 100 is a
+// container owning the sockets 1001 and 1004, process 200 a host process owning 1002, 1003
+// and 1005.
+func newTestRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "procfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(name, content string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("net/tcp", testTCP)
+	write("net/tcp6", testTCP6)
+	write("net/udp", testUDP)
+	write("100/cgroup", "0::/kubepods/pod8d6d7a4c-1b2e-4f3a-9c8d-7e6f5a4b3c2d/"+testContainerID+"\n")
+	write("200/cgroup", "0::/system.slice/node-exporter.service\n")
+	write("100/comm", "envoy\n")
+	write("200/comm", "node_exporter\n")
+	if err := os.Symlink("/usr/bin/node_exporter", filepath.Join(root, "200", "exe")); err != nil {
+		t.Fatal(err)
+	}
+
+	for pid, inodes := range map[int][]int{100: {1001, 1004}, 200: {1002, 1003, 1005}} {
+		dir := filepath.Join(root, strconv.Itoa(pid), "fd")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("/dev/null", filepath.Join(dir, "0")); err != nil {
+			t.Fatal(err)
+		}
+		for i, inode := range inodes {
+			link := "socket:[" + strconv.Itoa(inode) + "]"
+			if err := os.Symlink(link, filepath.Join(dir, strconv.Itoa(i+3))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+// testAddrs returns the local addresses 192.168.0.10 and fd00::10
+func testAddrs() ([]net.Addr, error) {
+	return []net.Addr{
+		&net.IPNet{IP: net.ParseIP("192.168.0.10"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("fd00::10"), Mask: net.CIDRMask(64, 128)},
+	}, nil
+}
+
+func TestTableLookup(t *testing.T) {
+	root := newTestRoot(t)
+	defer os.RemoveAll(root)
+
+	tbl := NewTable(root, time.Minute, 30*time.Second).(*table)
+	tbl.addrs = testAddrs
+	container := &Owner{100, "envoy", "", Cgroup{
+		"/kubepods/pod8d6d7a4c-1b2e-4f3a-9c8d-7e6f5a4b3c2d/" + testContainerID,
+		testContainerID,
+		"8d6d7a4c-1b2e-4f3a-9c8d-7e6f5a4b3c2d",
+	}}
+	host := &Owner{200, "node_exporter", "/usr/bin/node_exporter", Cgroup{Path: "/system.slice/node-exporter.service"}}
+
+	for _, c := range []struct {
+		transport protos.ProtocolType
+		ip        string
+		port      uint16
+		expected  *Owner
+	}{
+		{protos.TCP, "127.0.0.1", 15000, container},
+		{protos.TCP, "192.168.0.10", 22, host},
+		// Wildcard listeners
+		{protos.TCP, "192.168.0.10", 9100, host},
+		{protos.TCP, "fd00::10", 9100, host},
+		{protos.UDP, "127.0.0.53", 5353, host},
+		{protos.UDP, "127.0.0.1", 15000, nil},
+		{protos.TCP, "192.168.0.10", 15000, nil},
+		// Remote address
+		{protos.TCP, "192.168.0.11", 9100, nil},
+	} {
+		got := tbl.Lookup(c.transport, net.ParseIP(c.ip), c.port)
+		if (got == nil) != (c.expected == nil) || (got != nil && *got != *c.expected) {
+			t.Errorf("%s %s:%d: expected %+v, got %+v", c.transport, c.ip, c.port, c.expected, got)
+		}
+	}
+}
+
+func TestTableLookupFlow(t *testing.T) {
+	root := newTestRoot(t)
+	defer os.RemoveAll(root)
+
+	tbl := NewTable(root, time.Minute, 30*time.Second).(*table)
+	tbl.addrs = testAddrs
+	meta := func(src string, srcPort uint16, dst string, dstPort uint16) flow.Meta {
+		return flow.Meta{
+			Transport: protos.TCP,
+			Src:       net.ParseIP(src),
+			SrcPort:   srcPort,
+			Dst:       net.ParseIP(dst),
+			DstPort:   dstPort,
+		}
+	}
+
+	for _, c := range []struct {
+		flow   flow.Meta
+		pid    int
+		source bool
+	}{
+		// Connected sockets
+		{meta("127.0.0.1", 15000, "127.0.0.1", 40000), 100, true},
+		{meta("192.168.0.11", 54321, "192.168.0.10", 22), 200, false},
+		// Listening socket
+		{meta("192.168.0.11", 50000, "192.168.0.10", 9100), 200, false},
+		// Remote flow
+		{meta("192.168.0.11", 50000, "192.168.0.12", 9100), 0, false},
+	} {
+		o, source := tbl.LookupFlow(c.flow)
+		if c.pid == 0 {
+			if o != nil {
+				t.Errorf("%+v: unexpected owner %+v", c.flow, o)
+			}
+			continue
+		}
+		if o == nil || o.PID != c.pid || source != c.source {
+			t.Errorf("%+v: expected pid %d (source %v), got %+v (source %v)", c.flow, c.pid, c.source, o, source)
+		}
+	}
+}
+
+func TestTableRefresh(t *testing.T) {
+	root := newTestRoot(t)
+	defer os.RemoveAll(root)
+
+	clock := trackingtest.NewClock(time.Unix(1000, 0))
+	tbl := NewTable(root, time.Minute, 30*time.Second).(*table)
+	tbl.addrs = testAddrs
+	tbl.now = clock.Now
+
+	if tbl.Lookup(protos.TCP, net.ParseIP("127.0.0.1"), 15000) == nil {
+		t.Fatal("Expected owner after the first refresh")
+	}
+
+	// Socket closed
+	if err := os.Remove(filepath.Join(root, "100", "fd", "3")); err != nil {
+		t.Fatal(err)
+	}
+	if tbl.Lookup(protos.TCP, net.ParseIP("127.0.0.1"), 15000) == nil {
+		t.Error("Known socket must be served from the cache")
+	}
+
+	// Misses within maxAge do not reread the tables
+	ip := net.ParseIP("::ffff:127.0.0.1")
+	tbl.owners = map[socketKey]socketOwner{}
+	if tbl.Lookup(protos.TCP, ip, 15000) != nil {
+		t.Error("Expected miss without refresh")
+	}
+
+	clock.Add(time.Minute)
+	if got := tbl.Lookup(protos.TCP, ip, 15000); got == nil || got.PID != 100 {
+		t.Errorf("Expected owner of the remaining socket after refresh, got %+v", got)
+	}
+}
+
+func TestTableRetention(t *testing.T) {
+	root := newTestRoot(t)
+	defer os.RemoveAll(root)
+
+	clock := trackingtest.NewClock(time.Unix(1000, 0))
+	tbl := NewTable(root, time.Minute, 30*time.Second).(*table)
+	tbl.addrs = testAddrs
+	tbl.now = clock.Now
+
+	ssh := flow.Meta{
+		Transport: protos.TCP,
+		Src:       net.ParseIP("192.168.0.11"),
+		SrcPort:   54321,
+		Dst:       net.ParseIP("192.168.0.10"),
+		DstPort:   22,
+	}
+	if o, _ := tbl.LookupFlow(ssh); o == nil || o.PID != 200 {
+		t.Fatalf("Expected the owner of the open connection, got %+v", o)
+	}
+
+	// The connection is closed before its flow is exported
+	if err := ioutil.WriteFile(filepath.Join(root, "net", "tcp"), []byte(testTCP[:strings.Index(testTCP, "   1:")]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "200", "fd", "3")); err != nil {
+		t.Fatal(err)
+	}
+	refresh := func() {
+		clock.Add(time.Minute)
+		tbl.Lookup(protos.TCP, net.ParseIP("192.168.0.10"), 1)
+	}
+
+	refresh()
+	if o, source := tbl.LookupFlow(ssh); o == nil || o.PID != 200 || source {
+		t.Errorf("Expected the owner of the closed connection, got %+v (source %v)", o, source)
+	}
+	if got := tbl.Lookup(protos.TCP, net.ParseIP("127.0.0.1"), 15000); got == nil || got.PID != 100 {
+		t.Errorf("Expected owner of the open socket, got %+v", got)
+	}
+
+	// Removed after the retention time
+	refresh()
+	if o, _ := tbl.LookupFlow(ssh); o != nil {
+		t.Errorf("Expected the closed connection to be removed, got %+v", o)
+	}
+}
@@ -38,6 +38,8 @@ const (
 	ICMP4 ProtocolType = 1
 	// ICMP6 protocol
 	ICMP6 ProtocolType = 58
+	// SCTP protocol
+	SCTP ProtocolType = 132
 )
 
 // Stringr converts the protocol type to a string
@@ -51,6 +53,8 @@ func (pt ProtocolType) String() string {
 		return "icmp"
 	case ICMP6:
 		return "ipv6-icmp"
+	case SCTP:
+		return "sctp"
 	default:
 		return "UNDEFINED"
 	}
@@ -36,6 +36,7 @@ func TestString(t *testing.T) {
 		UDP:   "udp",
 		ICMP4: "icmp",
 		ICMP6: "ipv6-icmp",
+		SCTP:  "sctp",
 		244:   "UNDEFINED",
 	} {
 		if fmt.Sprint(k) != v {
@@ -0,0 +1,462 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package queue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "queue",
+	})
+}
+
+// Logger
+var log *logrus.Entry
+
+// ErrEmpty is returned when there is no record to read
+var ErrEmpty = errors.New("queue is empty")
+
+// Queue is a durable FIFO queue of records stored in segment files. Records stay in the queue
+// until they have been removed with Pop, unacknowledged records are replayed after a restart.
+type Queue interface {
+	Push(record []byte) error
+	Peek() ([]byte, error)
+	Pop() error
+	Stats() Stats
+	Close() error
+}
+
+// Options limit the disk usage of a queue
+type Options struct {
+	MaxSize     int64         // Maximum size of all segments, the oldest segments are dropped when exceeded
+	MaxAge      time.Duration // Records older than this are dropped (0 disables)
+	SegmentSize int64         // Size after which a new segment is started
+}
+
+// Stats contains the current state of the queue
+type Stats struct {
+	Records int    // Records waiting to be read
+	Bytes   int64  // Size of all segments
+	Dropped uint64 // Records dropped due to the size or age limit
+}
+
+const (
+	segmentSuffix = ".seg"
+	cursorFile    = "cursor"
+	// recordHeaderLength covers the length, CRC32 and write timestamp of a record
+	recordHeaderLength = 16
+	// maxRecordSize protects against allocating huge buffers for corrupted headers
+	maxRecordSize = 64 << 20
+	// defaultSegmentSize is used if no segment size is configured
+	defaultSegmentSize = 4 << 20
+)
+
+// segment is a file containing a sequence of records
+type segment struct {
+	id       uint64
+	size     int64     // Bytes written
+	records  int       // Records written
+	modified time.Time // Time of the last write
+}
+
+type queue struct {
+	sync.Mutex
+	dir      string
+	opts     Options
+	segments []*segment // Oldest first, the last one is written to
+	w        *os.File   // Write segment
+	r        *os.File   // Read segment (segments[0])
+	rID      uint64     // ID of the opened read segment
+	rOffset  int64      // Read offset in segments[0]
+	rRecords int        // Records consumed from segments[0]
+	nextID   uint64     // ID of the next segment
+	dropped  uint64
+}
+
+// Open opens (or creates) the queue stored in dir. Records which have not been popped before
+// the queue was closed are read again.
+func Open(dir string, opts Options) (Queue, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	if opts.MaxSize > 0 && opts.SegmentSize > opts.MaxSize/2 {
+		// Make sure dropping the oldest segment does not wipe out the whole queue
+		opts.SegmentSize = opts.MaxSize / 2
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	q := &queue{dir: dir, opts: opts}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	if err := q.rotate(); err != nil {
+		return nil, err
+	}
+
+	if n := q.stats().Records; n > 0 {
+		log.WithField("dir", dir).Infof("Replaying %d queued records", n)
+	}
+	return q, nil
+}
+
+func (q *queue) segmentPath(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", id, segmentSuffix))
+}
+
+// load scans existing segments and restores the read position
+func (q *queue) load() error {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range files {
+		name := fi.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		s, err := q.scan(id)
+		if err != nil {
+			return err
+		}
+		s.modified = fi.ModTime()
+		q.segments = append(q.segments, s)
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i].id < q.segments[j].id })
+
+	id, offset, records, err := q.readCursor()
+	if err != nil {
+		return err
+	}
+	for len(q.segments) > 0 && q.segments[0].id < id {
+		q.remove(q.segments[0])
+	}
+	if len(q.segments) > 0 && q.segments[0].id == id && offset <= q.segments[0].size && records <= q.segments[0].records {
+		q.rOffset, q.rRecords = offset, records
+	}
+
+	// Never reuse IDs, the cursor may still point to a removed segment
+	q.nextID = id + 1
+	if n := len(q.segments); n > 0 && q.segments[n-1].id >= q.nextID {
+		q.nextID = q.segments[n-1].id + 1
+	}
+	return nil
+}
+
+// scan counts the records of a segment and truncates incomplete or corrupted records at the
+// end, e.g. after a crash
+func (q *queue) scan(id uint64) (*segment, error) {
+	f, err := os.OpenFile(q.segmentPath(id), os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &segment{id: id}
+	for {
+		record, _, err := readRecord(f, s.size)
+		if err != nil {
+			if err != io.EOF {
+				log.WithError(err).Warnf("Truncating segment %d at offset %d", id, s.size)
+				if err := f.Truncate(s.size); err != nil {
+					return nil, err
+				}
+			}
+			return s, nil
+		}
+		s.size += recordHeaderLength + int64(len(record))
+		s.records++
+	}
+}
+
+func (q *queue) readCursor() (id uint64, offset int64, records int, err error) {
+	b, err := ioutil.ReadFile(filepath.Join(q.dir, cursorFile))
+	if os.IsNotExist(err) {
+		return 0, 0, 0, nil
+	}
+	if err != nil || len(b) != 20 {
+		// Start from the beginning, delivering records twice is better than losing them
+		return 0, 0, 0, nil
+	}
+	return binary.BigEndian.Uint64(b[0:]), int64(binary.BigEndian.Uint64(b[8:])), int(binary.BigEndian.Uint32(b[16:])), nil
+}
+
+func (q *queue) writeCursor() error {
+	var b [20]byte
+	if len(q.segments) > 0 {
+		binary.BigEndian.PutUint64(b[0:], q.segments[0].id)
+	}
+	binary.BigEndian.PutUint64(b[8:], uint64(q.rOffset))
+	binary.BigEndian.PutUint32(b[16:], uint32(q.rRecords))
+
+	tmp := filepath.Join(q.dir, cursorFile+".tmp")
+	if err := ioutil.WriteFile(tmp, b[:], 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(q.dir, cursorFile))
+}
+
+// rotate starts a new write segment
+func (q *queue) rotate() error {
+	id := q.nextID
+	f, err := os.OpenFile(q.segmentPath(id), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if q.w != nil {
+		q.w.Close()
+	}
+	q.w = f
+	q.nextID++
+	q.segments = append(q.segments, &segment{id: id, modified: time.Now()})
+	return nil
+}
+
+// remove deletes the oldest segment
+func (q *queue) remove(s *segment) {
+	if q.r != nil && q.rID == s.id {
+		q.r.Close()
+		q.r = nil
+	}
+	if err := os.Remove(q.segmentPath(s.id)); err != nil {
+		log.WithError(err).Warnf("Failed to remove segment %d", s.id)
+	}
+	q.segments = q.segments[1:]
+	q.rOffset, q.rRecords = 0, 0
+}
+
+// drop removes the oldest segment including records which have not been read yet
+func (q *queue) drop(reason string) {
+	s := q.segments[0]
+	lost := s.records - q.rRecords
+	q.remove(s)
+	if lost > 0 {
+		q.dropped += uint64(lost)
+		log.WithField("dir", q.dir).Warnf("Dropped %d records, %s", lost, reason)
+	}
+	q.writeCursor()
+}
+
+// enforceLimits drops the oldest segments exceeding the size or age limit. Expired records of
+// the write segment are skipped when reading.
+func (q *queue) enforceLimits(now time.Time) error {
+	for q.opts.MaxAge > 0 && len(q.segments) > 1 && now.Sub(q.segments[0].modified) > q.opts.MaxAge {
+		q.drop("maximum age exceeded")
+	}
+
+	for q.opts.MaxSize > 0 && q.stats().Bytes > q.opts.MaxSize && len(q.segments) > 1 {
+		q.drop("maximum size exceeded")
+	}
+	return nil
+}
+
+func (q *queue) Push(record []byte) error {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(record) > maxRecordSize {
+		return fmt.Errorf("record exceeds maximum size of %d bytes", maxRecordSize)
+	}
+
+	w := q.segments[len(q.segments)-1]
+	if w.size >= q.opts.SegmentSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+		w = q.segments[len(q.segments)-1]
+	}
+
+	now := time.Now()
+	buf := make([]byte, recordHeaderLength+len(record))
+	binary.BigEndian.PutUint32(buf[0:], uint32(len(record)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(record))
+	binary.BigEndian.PutUint64(buf[8:], uint64(now.UnixNano()))
+	copy(buf[recordHeaderLength:], record)
+	if _, err := q.w.Write(buf); err != nil {
+		// Do not append to a segment with a partial record
+		q.rotate()
+		return err
+	}
+
+	w.size += int64(len(buf))
+	w.records++
+	w.modified = now
+
+	return q.enforceLimits(now)
+}
+
+// next returns the next unread record, skipping records exceeding the age limit
+func (q *queue) next() ([]byte, error) {
+	now := time.Now()
+	if err := q.enforceLimits(now); err != nil {
+		return nil, err
+	}
+
+	expired := 0
+	defer func() {
+		if expired > 0 {
+			q.dropped += uint64(expired)
+			log.WithField("dir", q.dir).Warnf("Dropped %d records, maximum age exceeded", expired)
+			q.writeCursor()
+		}
+	}()
+
+	for {
+		record, ts, err := q.read()
+		if err != nil || q.opts.MaxAge == 0 || now.Sub(ts) <= q.opts.MaxAge {
+			return record, err
+		}
+		q.rOffset += recordHeaderLength + int64(len(record))
+		q.rRecords++
+		expired++
+	}
+}
+
+// read returns the record at the read position
+func (q *queue) read() ([]byte, time.Time, error) {
+	for {
+		s := q.segments[0]
+		if q.rRecords < s.records {
+			break
+		}
+		if len(q.segments) == 1 {
+			return nil, time.Time{}, ErrEmpty
+		}
+		// Segment completely consumed
+		q.remove(s)
+		q.writeCursor()
+	}
+
+	s := q.segments[0]
+	if q.r == nil || q.rID != s.id {
+		if q.r != nil {
+			q.r.Close()
+		}
+		f, err := os.Open(q.segmentPath(s.id))
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		q.r, q.rID = f, s.id
+	}
+	return readRecord(q.r, q.rOffset)
+}
+
+func (q *queue) Peek() ([]byte, error) {
+	q.Lock()
+	defer q.Unlock()
+	return q.next()
+}
+
+func (q *queue) Pop() error {
+	q.Lock()
+	defer q.Unlock()
+
+	record, err := q.next()
+	if err != nil {
+		return err
+	}
+	q.rOffset += recordHeaderLength + int64(len(record))
+	q.rRecords++
+	return q.writeCursor()
+}
+
+func (q *queue) stats() Stats {
+	st := Stats{Dropped: q.dropped}
+	for _, s := range q.segments {
+		st.Records += s.records
+		st.Bytes += s.size
+	}
+	st.Records -= q.rRecords
+	return st
+}
+
+func (q *queue) Stats() Stats {
+	q.Lock()
+	defer q.Unlock()
+	return q.stats()
+}
+
+func (q *queue) Close() error {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.r != nil {
+		q.r.Close()
+	}
+	if err := q.writeCursor(); err != nil {
+		return err
+	}
+	return q.w.Close()
+}
+
+// readRecord reads the record at offset and verifies its checksum
+func readRecord(r io.ReaderAt, offset int64) ([]byte, time.Time, error) {
+	var header [recordHeaderLength]byte
+	if n, err := r.ReadAt(header[:], offset); err != nil {
+		if err == io.EOF && n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, time.Time{}, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:])
+	if length > maxRecordSize {
+		return nil, time.Time{}, errors.New("invalid record length")
+	}
+	record := make([]byte, length)
+	if _, err := r.ReadAt(record, offset+recordHeaderLength); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, time.Time{}, err
+	}
+	if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, time.Time{}, errors.New("record checksum mismatch")
+	}
+	return record, time.Unix(0, int64(binary.BigEndian.Uint64(header[8:]))), nil
+}
@@ -0,0 +1,224 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package queue
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func record(i int) []byte {
+	return []byte(fmt.Sprintf("record-%04d", i))
+}
+
+// expectRecords pops records and compares them with the expected sequence
+func expectRecords(t *testing.T, q Queue, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		r, err := q.Peek()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if string(r) != string(record(i)) {
+			t.Fatalf("expected %s, got %s", record(i), r)
+		}
+		if err := q.Pop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := q.Peek(); err != ErrEmpty {
+		t.Fatalf("expected empty queue, got %v", err)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insight-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := Open(dir, Options{SegmentSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if _, err := q.Peek(); err != ErrEmpty {
+		t.Errorf("expected empty queue, got %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := q.Push(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := q.Stats(); st.Records != 20 || st.Bytes != 20*(recordHeaderLength+11) {
+		t.Errorf("unexpected stats %+v", st)
+	}
+
+	expectRecords(t, q, 0, 20)
+
+	// Consumed segments are removed
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(segments) != 1 {
+		t.Errorf("expected only the write segment to be left, got %v", segments)
+	}
+}
+
+func TestQueueReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insight-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := Open(dir, Options{SegmentSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		q.Push(record(i))
+	}
+	for i := 0; i < 4; i++ {
+		q.Pop()
+	}
+	// Read without acknowledgement
+	q.Peek()
+	q.Close()
+
+	q, err = Open(dir, Options{SegmentSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := q.Stats().Records; n != 6 {
+		t.Errorf("expected 6 records to be replayed, got %d", n)
+	}
+	q.Push(record(10))
+	expectRecords(t, q, 4, 11)
+	q.Close()
+
+	// Reopening an empty queue must not replay anything
+	q, err = Open(dir, Options{SegmentSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.Push(record(0))
+	expectRecords(t, q, 0, 1)
+}
+
+func TestQueueTruncatesCorruptedRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insight-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		q.Push(record(i))
+	}
+	q.Close()
+
+	// Simulate a crash while writing
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 42, 1, 2})
+	f.Close()
+
+	q, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	expectRecords(t, q, 0, 3)
+}
+
+func TestQueueMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insight-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	size := int64(recordHeaderLength + 11)
+	q, err := Open(dir, Options{MaxSize: 8 * size, SegmentSize: 2 * size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	for i := 0; i < 20; i++ {
+		q.Push(record(i))
+	}
+
+	st := q.Stats()
+	if st.Bytes > 8*size {
+		t.Errorf("size limit exceeded: %+v", st)
+	}
+	if st.Dropped != uint64(20-st.Records) {
+		t.Errorf("unexpected drop count: %+v", st)
+	}
+
+	// The newest records are kept
+	expectRecords(t, q, 20-st.Records, 20)
+}
+
+func TestQueueMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insight-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := Open(dir, Options{MaxAge: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	for i := 0; i < 5; i++ {
+		q.Push(record(i))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	q.Push(record(5))
+	expectRecords(t, q, 5, 6)
+	if st := q.Stats(); st.Dropped != 5 {
+		t.Errorf("expected 5 dropped records, got %+v", st)
+	}
+}
@@ -0,0 +1,243 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/queue"
+)
+
+// Retry backoff of buffered sinks
+const (
+	minBackoff = time.Second
+	maxBackoff = 2 * time.Minute
+)
+
+// BufferConfig configures the on-disk buffers of sinks
+type BufferConfig struct {
+	Dir string // Base directory, every sink gets its own queue in a subdirectory
+	queue.Options
+}
+
+// Counters contain the batch statistics of a buffered sink
+type Counters struct {
+	Queued    uint64 // Batches written to the queue
+	Delivered uint64 // Batches written to the sink
+	Retried   uint64 // Failed delivery attempts
+	Dropped   uint64 // Batches dropped due to the queue limits or being undecodable
+	Rejected  uint64 // Events permanently rejected by the sink
+	Pending   int    // Batches waiting for delivery
+}
+
+// Buffered is a sink queueing batches on disk until they have been delivered
+type Buffered interface {
+	Sink
+	Counters() Counters
+}
+
+type buffered struct {
+	sink      Sink
+	queue     queue.Queue
+	queued    uint64
+	delivered uint64
+	retried   uint64
+	invalid   uint64
+	rejected  uint64
+	head      []byte           // Queued batch partially written to the sink
+	retry     []*insight.Event // Events of head which have to be written again
+	notify    chan struct{}    // Signals new batches
+	exitChan  chan struct{}    // Exit channel
+	wg        sync.WaitGroup
+}
+
+// NewBuffered creates a sink writing batches to q and delivering them asynchronously to s.
+// Failed deliveries are retried with exponential backoff, batches which have not been delivered
+// when the sink is closed stay in the queue and are delivered once it is reopened.
+func NewBuffered(s Sink, q queue.Queue) Buffered {
+	b := &buffered{
+		sink:     s,
+		queue:    q,
+		notify:   make(chan struct{}, 1),
+		exitChan: make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.deliveryRunner()
+	return b
+}
+
+// Buffer wraps the sink created from spec with a disk queue below cfg.Dir
+func Buffer(s Sink, spec string, cfg *BufferConfig) (Buffered, error) {
+	// The subdirectory is derived from the spec, so queued batches are replayed to the same sink
+	h := sha1.Sum([]byte(spec))
+	q, err := queue.Open(filepath.Join(cfg.Dir, hex.EncodeToString(h[:8])), cfg.Options)
+	if err != nil {
+		return nil, err
+	}
+	b := NewBuffered(s, q)
+	bufferCollector.add(b, spec)
+	return b, nil
+}
+
+func (b *buffered) Write(events []*insight.Event) error {
+	js, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	if err := b.queue.Push(js); err != nil {
+		return err
+	}
+	atomic.AddUint64(&b.queued, 1)
+
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// deliver tries to write the oldest batch to the sink. It returns false if the queue is empty
+// or the delivery failed. Events rejected by the sink are dropped, only events which failed
+// temporarily are retried.
+func (b *buffered) deliver() (bool, error) {
+	js, err := b.queue.Peek()
+	if err == queue.ErrEmpty {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// The partially written batch might have been dropped by the queue limits in the meantime
+	events := b.retry
+	if events == nil || !bytes.Equal(js, b.head) {
+		if err := json.Unmarshal(js, &events); err != nil {
+			// Retrying will not help
+			log.WithError(err).Error("Dropping undecodable batch")
+			atomic.AddUint64(&b.invalid, 1)
+			return true, b.queue.Pop()
+		}
+	}
+	b.head, b.retry = nil, nil
+
+	err = b.sink.Write(events)
+	var werr *WriteError
+	if errors.As(err, &werr) {
+		if werr.Rejected > 0 {
+			atomic.AddUint64(&b.rejected, uint64(werr.Rejected))
+			log.WithError(err).Warnf("Dropping %d events rejected by the sink", werr.Rejected)
+		}
+		if len(werr.Retry) > 0 {
+			b.head, b.retry = js, werr.Retry
+			return false, err
+		}
+		if werr.Rejected >= len(events) {
+			return true, b.queue.Pop()
+		}
+		err = nil
+	}
+	if err != nil {
+		return false, err
+	}
+	atomic.AddUint64(&b.delivered, 1)
+	return true, b.queue.Pop()
+}
+
+func (b *buffered) deliveryRunner() {
+	defer b.wg.Done()
+
+	backoff := time.Duration(0)
+	for {
+		ok, err := b.deliver()
+		if ok {
+			backoff = 0
+			continue
+		}
+
+		var wait <-chan time.Time
+		if err != nil {
+			atomic.AddUint64(&b.retried, 1)
+			switch {
+			case backoff == 0:
+				backoff = minBackoff
+			case backoff < maxBackoff:
+				backoff *= 2
+				if backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+			}
+			log.WithError(err).WithField("pending", b.queue.Stats().Records).Warnf("Failed to deliver batch, retrying in %s", backoff)
+			wait = time.After(backoff)
+		}
+
+		select {
+		case <-b.exitChan:
+			return
+		case <-wait:
+		case <-b.notify:
+			if err != nil {
+				// Keep backing off, new batches do not make the sink healthy
+				select {
+				case <-b.exitChan:
+					return
+				case <-wait:
+				}
+			}
+		}
+	}
+}
+
+func (b *buffered) Counters() Counters {
+	st := b.queue.Stats()
+	return Counters{
+		Queued:    atomic.LoadUint64(&b.queued),
+		Delivered: atomic.LoadUint64(&b.delivered),
+		Retried:   atomic.LoadUint64(&b.retried),
+		Dropped:   st.Dropped + atomic.LoadUint64(&b.invalid),
+		Rejected:  atomic.LoadUint64(&b.rejected),
+		Pending:   st.Records,
+	}
+}
+
+func (b *buffered) Close() error {
+	bufferCollector.remove(b)
+	close(b.exitChan)
+	b.wg.Wait()
+
+	if err := b.queue.Close(); err != nil {
+		return err
+	}
+	return b.sink.Close()
+}
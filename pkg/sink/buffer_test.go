@@ -0,0 +1,229 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/queue"
+)
+
+// flakySink fails until it is enabled
+type flakySink struct {
+	sync.Mutex
+	enabled bool
+	batches [][]*insight.Event
+	closed  bool
+}
+
+func (f *flakySink) Write(events []*insight.Event) error {
+	f.Lock()
+	defer f.Unlock()
+	if !f.enabled {
+		return errors.New("unavailable")
+	}
+	f.batches = append(f.batches, events)
+	return nil
+}
+
+func (f *flakySink) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (f *flakySink) enable() {
+	f.Lock()
+	defer f.Unlock()
+	f.enabled = true
+}
+
+func (f *flakySink) delivered() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.batches)
+}
+
+// partialSink rejects the first event and fails the second one temporarily on the first write
+type partialSink struct {
+	flakySink
+	writes int
+}
+
+func (p *partialSink) Write(events []*insight.Event) error {
+	p.Lock()
+	p.writes++
+	first := p.writes == 1
+	p.Unlock()
+	if first {
+		return &WriteError{Err: errors.New("partial failure"), Rejected: 1, Retry: events[1:]}
+	}
+	return p.flakySink.Write(events)
+}
+
+// waitFor polls cond until it is true or the timeout is reached
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBuffered(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	q, err := queue.Open(dir, queue.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := &flakySink{}
+	s := NewBuffered(inner, q)
+
+	for i := 0; i < 3; i++ {
+		if err := s.Write(testEvents[i%2 : i%2+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// First attempt fails, batches stay queued
+	waitFor(t, time.Second, func() bool { return s.Counters().Retried > 0 })
+	if c := s.Counters(); c.Queued != 3 || c.Pending != 3 || c.Delivered != 0 {
+		t.Errorf("unexpected counters %+v", c)
+	}
+
+	// Delivered in order after the backoff
+	inner.enable()
+	waitFor(t, 3*minBackoff, func() bool { return inner.delivered() == 3 })
+	for i, b := range inner.batches {
+		if b[0].Network.CommunityID != testEvents[i%2].Network.CommunityID {
+			t.Errorf("batch %d delivered out of order", i)
+		}
+	}
+	if c := s.Counters(); c.Delivered != 3 || c.Pending != 0 || c.Dropped != 0 {
+		t.Errorf("unexpected counters %+v", c)
+	}
+
+	s.Close()
+	if !inner.closed {
+		t.Error("inner sink not closed")
+	}
+}
+
+func TestBufferedReplay(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	cfg := &BufferConfig{Dir: dir}
+
+	inner := &flakySink{}
+	s, err := Buffer(inner, "http://logstash:8080", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Write(testEvents)
+	waitFor(t, time.Second, func() bool { return s.Counters().Retried > 0 })
+	s.Close()
+
+	// Reopened with a healthy sink, the batch is delivered
+	inner = &flakySink{enabled: true}
+	s, err = Buffer(inner, "http://logstash:8080", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	waitFor(t, time.Second, func() bool { return inner.delivered() == 1 })
+	if len(inner.batches[0]) != len(testEvents) {
+		t.Errorf("expected %d events, got %d", len(testEvents), len(inner.batches[0]))
+	}
+}
+
+func TestBufferedPartial(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	q, err := queue.Open(dir, queue.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := &partialSink{flakySink: flakySink{enabled: true}}
+	s := NewBuffered(inner, q)
+	defer s.Close()
+
+	if err := s.Write(testEvents); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the event failing temporarily is written again
+	waitFor(t, 2*minBackoff, func() bool { return inner.delivered() == 1 })
+	if b := inner.batches[0]; len(b) != 1 || b[0].Network.CommunityID != testEvents[1].Network.CommunityID {
+		t.Errorf("unexpected retried batch %v", b)
+	}
+	if c := s.Counters(); c.Delivered != 1 || c.Pending != 0 || c.Rejected != 1 || c.Retried != 1 {
+		t.Errorf("unexpected counters %+v", c)
+	}
+}
+
+func TestBufferedRejected(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	q, err := queue.Open(dir, queue.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewBuffered(&rejectingSink{}, q)
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := s.Write(testEvents); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Rejected batches are dropped without backing off
+	waitFor(t, time.Second, func() bool { return s.Counters().Pending == 0 })
+	if c := s.Counters(); c.Rejected != 6 || c.Retried != 0 || c.Delivered != 0 {
+		t.Errorf("unexpected counters %+v", c)
+	}
+}
+
+// rejectingSink rejects every event
+type rejectingSink struct{}
+
+func (rejectingSink) Write(events []*insight.Event) error {
+	return &WriteError{Err: errors.New("mapping conflict"), Rejected: len(events)}
+}
+
+func (rejectingSink) Close() error {
+	return nil
+}
@@ -0,0 +1,115 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/xvzf/insight/pkg/insight"
+)
+
+// elasticsearch indexes events using the bulk API
+type elasticsearch struct {
+	url    string
+	action []byte // Bulk action line, identical for every event
+	client *http.Client
+}
+
+// bulkResponse contains the relevant parts of a bulk API response
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// NewElasticsearch creates a sink indexing events into index on the Elasticsearch cluster at url
+func NewElasticsearch(url, index string) Sink {
+	action, _ := json.Marshal(map[string]interface{}{
+		"index": map[string]string{"_index": index},
+	})
+	return &elasticsearch{
+		url:    url + "/_bulk",
+		action: action,
+		client: &http.Client{Timeout: httpTimeout},
+	}
+}
+
+func (es *elasticsearch) Write(events []*insight.Event) error {
+	var buf bytes.Buffer
+	for _, e := range events {
+		js, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(es.action)
+		buf.WriteByte('\n')
+		buf.Write(js)
+		buf.WriteByte('\n')
+	}
+
+	var resp bulkResponse
+	if err := post(es.client, es.url, "application/x-ndjson", buf.Bytes(), &resp); err != nil {
+		return rejected(err, events)
+	}
+	if !resp.Errors {
+		return nil
+	}
+
+	// The bulk request is not atomic, only events failing temporarily are written again. Items
+	// are in the order of the events.
+	werr := &WriteError{}
+	var reason string
+	for i, item := range resp.Items {
+		for _, res := range item {
+			if res.Error == nil || i >= len(events) {
+				continue
+			}
+			if reason == "" {
+				reason = fmt.Sprintf("%s: %s", res.Error.Type, res.Error.Reason)
+			}
+			if retryable(res.Status) {
+				werr.Retry = append(werr.Retry, events[i])
+			} else {
+				werr.Rejected++
+			}
+		}
+	}
+	werr.Err = fmt.Errorf("failed to index %d/%d events (%d rejected), %s",
+		werr.Rejected+len(werr.Retry), len(events), werr.Rejected, reason)
+	return werr
+}
+
+func (es *elasticsearch) Close() error {
+	return nil
+}
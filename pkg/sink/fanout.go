@@ -0,0 +1,93 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/xvzf/insight/pkg/insight"
+)
+
+// fanout writes every batch to multiple sinks
+type fanout struct {
+	sinks []Sink
+}
+
+// FanoutError lists the sinks a batch could not be written to
+type FanoutError struct {
+	Errors map[int]error // Index of the failed sink -> error
+}
+
+func (e *FanoutError) Error() string {
+	var msgs []string
+	for i, err := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("sink %d: %s", i, err))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// NewFanout creates a sink writing every batch to all given sinks concurrently.
+// A failing sink does not prevent the batch from being written to the others.
+func NewFanout(sinks ...Sink) Sink {
+	return &fanout{sinks: sinks}
+}
+
+func (f *fanout) Write(events []*insight.Event) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(f.sinks))
+
+	for i, s := range f.sinks {
+		wg.Add(1)
+		go func(i int, s Sink) {
+			defer wg.Done()
+			errs[i] = s.Write(events)
+		}(i, s)
+	}
+	wg.Wait()
+
+	fe := &FanoutError{Errors: make(map[int]error)}
+	for i, err := range errs {
+		if err != nil {
+			fe.Errors[i] = err
+		}
+	}
+	if len(fe.Errors) > 0 {
+		return fe
+	}
+	return nil
+}
+
+func (f *fanout) Close() error {
+	var err error
+	for _, s := range f.sinks {
+		if cerr := s.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
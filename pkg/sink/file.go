@@ -0,0 +1,94 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/xvzf/insight/pkg/insight"
+)
+
+// file writes newline-delimited JSON
+type file struct {
+	sync.Mutex
+	w   io.Writer
+	buf *bufio.Writer
+	out *json.Encoder
+}
+
+// NewFile creates a sink appending newline-delimited JSON events to the file at path
+func NewFile(path string) (Sink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(f), nil
+}
+
+// NewStdout creates a sink writing newline-delimited JSON events to stdout
+func NewStdout() Sink {
+	return NewWriter(os.Stdout)
+}
+
+// NewWriter creates a sink writing newline-delimited JSON events to w. w is closed together
+// with the sink if it implements io.Closer (stdout is left open).
+func NewWriter(w io.Writer) Sink {
+	buf := bufio.NewWriter(w)
+	return &file{
+		w:   w,
+		buf: buf,
+		out: json.NewEncoder(buf),
+	}
+}
+
+func (f *file) Write(events []*insight.Event) error {
+	f.Lock()
+	defer f.Unlock()
+
+	for _, e := range events {
+		if err := f.out.Encode(e); err != nil {
+			return err
+		}
+	}
+	return f.buf.Flush()
+}
+
+func (f *file) Close() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	if c, ok := f.w.(io.Closer); ok && f.w != os.Stdout {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/xvzf/insight/pkg/insight"
+)
+
+// httpTimeout limits the time a single request may take
+const httpTimeout = 30 * time.Second
+
+// httpSink posts a JSON array of events, e.g. to a logstash http input
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTP creates a sink posting every batch as JSON array to the given URL
+func NewHTTP(url string) Sink {
+	return &httpSink{
+		url:    url,
+		client: &http.Client{Timeout: httpTimeout},
+	}
+}
+
+func (h *httpSink) Write(events []*insight.Event) error {
+	js, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	return rejected(post(h.client, h.url, "application/json", js, nil), events)
+}
+
+func (h *httpSink) Close() error {
+	return nil
+}
+
+// statusError is a non 2xx response
+type statusError struct {
+	url    string
+	status string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s responded with %s", e.url, e.status)
+}
+
+// retryable reports whether a request failing with the status code may succeed later
+func retryable(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// rejected marks all events as rejected if the endpoint refused the request permanently
+func rejected(err error, events []*insight.Event) error {
+	if se, ok := err.(*statusError); ok && !retryable(se.code) {
+		return &WriteError{Err: err, Rejected: len(events)}
+	}
+	return err
+}
+
+// post sends body to url and decodes a JSON response into v (if not nil)
+func post(client *http.Client, url, contentType string, body []byte, v interface{}) error {
+	resp, err := client.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &statusError{url: url, status: resp.Status, code: resp.StatusCode}
+	}
+	if v != nil {
+		return json.NewDecoder(resp.Body).Decode(v)
+	}
+	return nil
+}
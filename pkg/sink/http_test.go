@@ -0,0 +1,131 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xvzf/insight/pkg/insight"
+)
+
+func TestHTTP(t *testing.T) {
+	var received []*insight.Event
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	s := NewHTTP(srv.URL)
+	if err := s.Write(testEvents); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != len(testEvents) {
+		t.Errorf("expected %d events, got %d", len(testEvents), len(received))
+	}
+
+	status = http.StatusServiceUnavailable
+	err := s.Write(testEvents)
+	if _, ok := err.(*WriteError); err == nil || ok {
+		t.Errorf("expected temporary error on 503 response, got %v", err)
+	}
+
+	status = http.StatusBadRequest
+	err = s.Write(testEvents)
+	if werr, ok := err.(*WriteError); !ok || werr.Rejected != len(testEvents) || len(werr.Retry) != 0 {
+		t.Errorf("expected rejected batch on 400 response, got %v", err)
+	}
+}
+
+func TestElasticsearch(t *testing.T) {
+	failing, failStatus := 0, http.StatusBadRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+
+		var items []string
+		sc := bufio.NewScanner(r.Body)
+		for i := 0; sc.Scan(); i++ {
+			if i%2 == 0 && sc.Text() != `{"index":{"_index":"insight"}}` {
+				t.Errorf("unexpected action %s", sc.Text())
+			}
+			if i%2 == 1 {
+				status, errField := 201, ""
+				if len(items) < failing {
+					status, errField = failStatus, `,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}`
+				}
+				items = append(items, fmt.Sprintf(`{"index":{"status":%d%s}}`, status, errField))
+			}
+		}
+		fmt.Fprintf(w, `{"took":1,"errors":%t,"items":[%s]}`, failing > 0, strings.Join(items, ","))
+	}))
+	defer srv.Close()
+
+	s := NewElasticsearch(srv.URL, "insight")
+	if err := s.Write(testEvents); err != nil {
+		t.Fatal(err)
+	}
+
+	failing = 1
+	err := s.Write(testEvents)
+	if err == nil || !strings.Contains(err.Error(), "1/2") || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Errorf("expected partial failure, got %v", err)
+	}
+	if werr, ok := err.(*WriteError); !ok || werr.Rejected != 1 || len(werr.Retry) != 0 {
+		t.Errorf("expected rejected event, got %#v", err)
+	}
+
+	// Only the events failing temporarily are written again
+	failStatus = http.StatusTooManyRequests
+	err = s.Write(testEvents)
+	if werr, ok := err.(*WriteError); !ok || werr.Rejected != 0 || len(werr.Retry) != 1 || werr.Retry[0] != testEvents[0] {
+		t.Errorf("expected event to retry, got %#v", err)
+	}
+}
+
+func TestPostError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Write([]byte("not json"))
+	}))
+	defer srv.Close()
+
+	var v struct{}
+	if err := post(srv.Client(), srv.URL, "application/json", nil, &v); err == nil {
+		t.Error("expected error on invalid response")
+	}
+}
@@ -0,0 +1,426 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xvzf/insight/pkg/insight"
+)
+
+// Kafka API keys and versions (https://kafka.apache.org/protocol)
+const (
+	kafkaProduceKey      int16 = 0
+	kafkaProduceVersion  int16 = 3 // First version supporting record batches (magic 2)
+	kafkaMetadataKey     int16 = 3
+	kafkaMetadataVersion int16 = 1
+)
+
+const (
+	kafkaClientID = "insight"
+	kafkaTimeout  = 10 * time.Second
+	kafkaAcks     = 1 // Wait for the partition leader
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// kafka produces one record per event, distributing batches round-robin over the partitions
+// of the topic. It speaks the Kafka wire protocol directly and only implements what is needed
+// to produce uncompressed records.
+type kafka struct {
+	sync.Mutex
+	brokers       []string            // Bootstrap brokers
+	topic         string              // Target topic
+	partitions    []int32             // Partitions of the topic
+	leaders       map[int32]string    // Partition -> address of the leader
+	conns         map[string]net.Conn // Open broker connections
+	next          int                 // Next partition (index into partitions)
+	correlationID int32
+}
+
+// NewKafka creates a sink producing to topic. The cluster metadata is fetched from one of the
+// bootstrap brokers on the first write and refreshed after errors.
+func NewKafka(brokers []string, topic string) Sink {
+	return &kafka{
+		brokers: brokers,
+		topic:   topic,
+		conns:   make(map[string]net.Conn),
+	}
+}
+
+func (k *kafka) Write(events []*insight.Event) error {
+	values := make([][]byte, len(events))
+	for i, e := range events {
+		js, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		values[i] = js
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	if err := k.produce(values); err != nil {
+		// Force a metadata refresh, leaders may have changed
+		k.reset()
+		return err
+	}
+	return nil
+}
+
+func (k *kafka) Close() error {
+	k.Lock()
+	defer k.Unlock()
+	k.reset()
+	return nil
+}
+
+// reset closes all connections and drops the cluster metadata
+func (k *kafka) reset() {
+	for _, c := range k.conns {
+		c.Close()
+	}
+	k.conns = make(map[string]net.Conn)
+	k.partitions = nil
+	k.leaders = nil
+}
+
+func (k *kafka) produce(values [][]byte) error {
+	if k.partitions == nil {
+		if err := k.refreshMetadata(); err != nil {
+			return err
+		}
+	}
+
+	partition := k.partitions[k.next%len(k.partitions)]
+	k.next++
+
+	e := &kafkaEncoder{}
+	e.string(nil) // transactional_id
+	e.int16(kafkaAcks)
+	e.int32(int32(kafkaTimeout / time.Millisecond))
+	e.int32(1) // topic_data
+	e.string(&k.topic)
+	e.int32(1) // partition_data
+	e.int32(partition)
+	e.bytes(encodeRecordBatch(values, time.Now()))
+
+	resp, err := k.request(k.leaders[partition], kafkaProduceKey, kafkaProduceVersion, e.Bytes())
+	if err != nil {
+		return err
+	}
+
+	d := &kafkaDecoder{b: resp}
+	for topics := d.int32(); topics > 0; topics-- {
+		d.string()
+		for partitions := d.int32(); partitions > 0; partitions-- {
+			d.int32()
+			if code := d.int16(); code != 0 && d.err == nil {
+				return fmt.Errorf("kafka produce to %s/%d failed with error code %d", k.topic, partition, code)
+			}
+			d.int64() // base_offset
+			d.int64() // log_append_time
+		}
+	}
+	return d.err
+}
+
+// refreshMetadata fetches the partition leaders of the topic from the first responding broker
+func (k *kafka) refreshMetadata() error {
+	e := &kafkaEncoder{}
+	e.int32(1)
+	e.string(&k.topic)
+
+	var err error
+	for _, broker := range k.brokers {
+		var resp []byte
+		resp, err = k.request(broker, kafkaMetadataKey, kafkaMetadataVersion, e.Bytes())
+		if err != nil {
+			continue
+		}
+		if err = k.parseMetadata(resp); err == nil {
+			return nil
+		}
+	}
+	if err == nil {
+		err = errors.New("no kafka broker configured")
+	}
+	return err
+}
+
+func (k *kafka) parseMetadata(resp []byte) error {
+	d := &kafkaDecoder{b: resp}
+
+	brokers := make(map[int32]string)
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller_id
+
+	var partitions []int32
+	leaders := make(map[int32]string)
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		code := d.int16()
+		name := d.string()
+		d.int8() // is_internal
+		if name == k.topic && code != 0 {
+			return fmt.Errorf("kafka metadata for %s failed with error code %d", k.topic, code)
+		}
+		for p := d.int32(); p > 0 && d.err == nil; p-- {
+			d.int16() // error_code
+			partition := d.int32()
+			leader := d.int32()
+			d.int32Array() // replicas
+			d.int32Array() // isr
+			if addr, ok := brokers[leader]; ok && name == k.topic {
+				partitions = append(partitions, partition)
+				leaders[partition] = addr
+			}
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("no partition with leader for topic %s", k.topic)
+	}
+
+	k.partitions = partitions
+	k.leaders = leaders
+	return nil
+}
+
+// request sends a request to the broker at addr and returns the response body
+func (k *kafka) request(addr string, key, version int16, body []byte) ([]byte, error) {
+	conn, ok := k.conns[addr]
+	if !ok {
+		var err error
+		conn, err = net.DialTimeout("tcp", addr, kafkaTimeout)
+		if err != nil {
+			return nil, err
+		}
+		k.conns[addr] = conn
+	}
+
+	k.correlationID++
+	clientID := kafkaClientID
+
+	e := &kafkaEncoder{}
+	e.int32(0) // size, set below
+	e.int16(key)
+	e.int16(version)
+	e.int32(k.correlationID)
+	e.string(&clientID)
+	e.Write(body)
+	req := e.Bytes()
+	binary.BigEndian.PutUint32(req, uint32(len(req)-4))
+
+	conn.SetDeadline(time.Now().Add(2 * kafkaTimeout))
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[0:])
+	if size < 4 {
+		return nil, errors.New("invalid kafka response size")
+	}
+	if id := int32(binary.BigEndian.Uint32(header[4:])); id != k.correlationID {
+		return nil, fmt.Errorf("kafka correlation ID mismatch, expected %d, got %d", k.correlationID, id)
+	}
+
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// encodeRecordBatch creates an uncompressed record batch (magic 2) without keys and headers
+func encodeRecordBatch(values [][]byte, ts time.Time) []byte {
+	millis := ts.UnixNano() / int64(time.Millisecond)
+
+	// Everything after the CRC field, covered by the checksum
+	e := &kafkaEncoder{}
+	e.int16(0) // attributes
+	e.int32(int32(len(values) - 1))
+	e.int64(millis) // first_timestamp
+	e.int64(millis) // max_timestamp
+	e.int64(-1)     // producer_id
+	e.int16(-1)     // producer_epoch
+	e.int32(-1)     // base_sequence
+	e.int32(int32(len(values)))
+	for i, v := range values {
+		r := &kafkaEncoder{}
+		r.int8(0)   // attributes
+		r.varint(0) // timestamp_delta
+		r.varint(int64(i))
+		r.varint(-1) // key
+		r.varint(int64(len(v)))
+		r.Write(v)
+		r.varint(0) // headers
+
+		e.varint(int64(r.Len()))
+		e.Write(r.Bytes())
+	}
+	payload := e.Bytes()
+
+	b := &kafkaEncoder{}
+	b.int64(0)                               // base_offset
+	b.int32(int32(4 + 1 + 4 + len(payload))) // batch_length
+	b.int32(0)                               // partition_leader_epoch
+	b.int8(2)                                // magic
+	b.int32(int32(crc32.Checksum(payload, crc32c)))
+	b.Write(payload)
+	return b.Bytes()
+}
+
+// kafkaEncoder writes Kafka protocol primitives
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) int8(v int8) {
+	e.WriteByte(byte(v))
+}
+
+func (e *kafkaEncoder) int16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+
+// varint writes a zigzag encoded variable length integer
+func (e *kafkaEncoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+// string writes a nullable string
+func (e *kafkaEncoder) string(s *string) {
+	if s == nil {
+		e.int16(-1)
+		return
+	}
+	e.int16(int16(len(*s)))
+	e.WriteString(*s)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.Write(b)
+}
+
+// kafkaDecoder reads Kafka protocol primitives; the first error is sticky
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errors.New("kafka response too short")
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a nullable string, null is returned as empty string
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *kafkaDecoder) int32Array() {
+	n := d.int32()
+	d.next(int(n) * 4)
+}
@@ -0,0 +1,234 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/xvzf/insight/pkg/insight"
+)
+
+// tempDir creates a temporary directory removed by the returned function
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "insight-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// fakeBroker is a single Kafka broker leading partitions 0 and 1 of every topic
+type fakeBroker struct {
+	t       *testing.T
+	l       net.Listener
+	records map[int32][][]byte // Partition -> record values
+	errCode int16              // Error code returned on produce
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{t: t, l: l, records: make(map[int32][][]byte)}
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.l.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+
+		d := &kafkaDecoder{b: req}
+		key, version, correlationID := d.int16(), d.int16(), d.int32()
+		d.string() // client_id
+
+		e := &kafkaEncoder{}
+		e.int32(0)
+		e.int32(correlationID)
+		switch {
+		case key == kafkaMetadataKey && version == kafkaMetadataVersion:
+			b.metadata(d, e)
+		case key == kafkaProduceKey && version == kafkaProduceVersion:
+			b.produce(d, e)
+		default:
+			b.t.Errorf("unexpected request %d/%d", key, version)
+			return
+		}
+		if d.err != nil {
+			b.t.Error(d.err)
+		}
+
+		resp := e.Bytes()
+		binary.BigEndian.PutUint32(resp, uint32(len(resp)-4))
+		conn.Write(resp)
+	}
+}
+
+func (b *fakeBroker) metadata(d *kafkaDecoder, e *kafkaEncoder) {
+	d.int32()
+	topic := d.string()
+
+	host, port, _ := net.SplitHostPort(b.l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	e.int32(1)
+	e.int32(1)
+	e.string(&host)
+	e.int32(int32(p))
+	e.string(nil)
+	e.int32(1) // controller_id
+	e.int32(1)
+	e.int16(0)
+	e.string(&topic)
+	e.int8(0)
+	e.int32(2)
+	for partition := int32(0); partition < 2; partition++ {
+		e.int16(0)
+		e.int32(partition)
+		e.int32(1) // leader
+		e.int32(1)
+		e.int32(1)
+		e.int32(1)
+		e.int32(1)
+	}
+}
+
+func (b *fakeBroker) produce(d *kafkaDecoder, e *kafkaEncoder) {
+	d.string() // transactional_id
+	d.int16()  // acks
+	d.int32()  // timeout
+	d.int32()
+	topic := d.string()
+	d.int32()
+	partition := d.int32()
+	batch := d.next(int(d.int32()))
+	if d.err != nil {
+		return
+	}
+
+	// Record batch header
+	bd := &kafkaDecoder{b: batch}
+	bd.int64()
+	if length := bd.int32(); int(length) != len(batch)-12 {
+		b.t.Errorf("invalid batch length %d", length)
+	}
+	bd.int32()
+	if magic := bd.int8(); magic != 2 {
+		b.t.Errorf("invalid magic %d", magic)
+	}
+	if crc := uint32(bd.int32()); crc != crc32.Checksum(bd.b, crc32c) {
+		b.t.Error("invalid CRC")
+	}
+	bd.next(2 + 4 + 8 + 8 + 8 + 2 + 4)
+	for n := bd.int32(); n > 0; n-- {
+		length, l := binary.Varint(bd.b)
+		bd.next(l)
+		record := bd.next(int(length))
+
+		// attributes, timestamp_delta, offset_delta, key (null)
+		record = record[1:]
+		for i := 0; i < 3; i++ {
+			_, l := binary.Varint(record)
+			record = record[l:]
+		}
+		vlen, l := binary.Varint(record)
+		b.records[partition] = append(b.records[partition], record[l:l+int(vlen)])
+	}
+
+	e.int32(1)
+	e.string(&topic)
+	e.int32(1)
+	e.int32(partition)
+	e.int16(b.errCode)
+	e.int64(0)
+	e.int64(-1)
+	e.int32(0) // throttle_time_ms
+}
+
+func TestKafka(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.l.Close()
+
+	s := NewKafka([]string{"127.0.0.1:1", b.l.Addr().String()}, "flows")
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := s.Write(testEvents); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Batches are distributed round-robin
+	for partition := int32(0); partition < 2; partition++ {
+		records := b.records[partition]
+		if len(records) != len(testEvents) {
+			t.Fatalf("expected %d records on partition %d, got %d", len(testEvents), partition, len(records))
+		}
+		for i, r := range records {
+			var e insight.Event
+			if err := json.Unmarshal(r, &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Network.CommunityID != testEvents[i].Network.CommunityID {
+				t.Errorf("unexpected record %s", r)
+			}
+		}
+	}
+
+	b.errCode = 6 // NOT_LEADER_FOR_PARTITION
+	if err := s.Write(testEvents); err == nil {
+		t.Error("expected produce error")
+	}
+	if s.(*kafka).partitions != nil {
+		t.Error("expected metadata to be dropped after error")
+	}
+}
@@ -0,0 +1,101 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"net/url"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/xvzf/insight/pkg/metrics"
+)
+
+func init() {
+	prometheus.MustRegister(bufferCollector)
+}
+
+func bufferDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "sink", name), help, []string{"sink"}, nil)
+}
+
+var (
+	batchesQueuedDesc    = bufferDesc("batches_queued_total", "Event batches written to the disk buffer.")
+	batchesDeliveredDesc = bufferDesc("batches_delivered_total", "Event batches delivered to the sink.")
+	batchesRetriedDesc   = bufferDesc("batches_retried_total", "Failed delivery attempts.")
+	batchesDroppedDesc   = bufferDesc("batches_dropped_total", "Event batches dropped due to the buffer limits.")
+	batchesPendingDesc   = bufferDesc("batches_pending", "Event batches waiting for delivery.")
+	eventsRejectedDesc   = bufferDesc("events_rejected_total", "Events permanently rejected by the sink.")
+)
+
+// bufferCollector exposes the counters of all buffered sinks created with Buffer
+var bufferCollector = &buffers{sinks: make(map[Buffered]string)}
+
+type buffers struct {
+	sync.Mutex
+	sinks map[Buffered]string // Sink -> label
+}
+
+func (b *buffers) add(s Buffered, spec string) {
+	// Do not leak credentials into the label
+	if u, err := url.Parse(spec); err == nil && u.User != nil {
+		u.User = nil
+		spec = u.String()
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	b.sinks[s] = spec
+}
+
+func (b *buffers) remove(s Buffered) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.sinks, s)
+}
+
+func (b *buffers) Describe(ch chan<- *prometheus.Desc) {
+	ch <- batchesQueuedDesc
+	ch <- batchesDeliveredDesc
+	ch <- batchesRetriedDesc
+	ch <- batchesDroppedDesc
+	ch <- batchesPendingDesc
+	ch <- eventsRejectedDesc
+}
+
+func (b *buffers) Collect(ch chan<- prometheus.Metric) {
+	b.Lock()
+	defer b.Unlock()
+
+	for s, label := range b.sinks {
+		c := s.Counters()
+		ch <- prometheus.MustNewConstMetric(batchesQueuedDesc, prometheus.CounterValue, float64(c.Queued), label)
+		ch <- prometheus.MustNewConstMetric(batchesDeliveredDesc, prometheus.CounterValue, float64(c.Delivered), label)
+		ch <- prometheus.MustNewConstMetric(batchesRetriedDesc, prometheus.CounterValue, float64(c.Retried), label)
+		ch <- prometheus.MustNewConstMetric(batchesDroppedDesc, prometheus.CounterValue, float64(c.Dropped), label)
+		ch <- prometheus.MustNewConstMetric(batchesPendingDesc, prometheus.GaugeValue, float64(c.Pending), label)
+		ch <- prometheus.MustNewConstMetric(eventsRejectedDesc, prometheus.CounterValue, float64(c.Rejected), label)
+	}
+}
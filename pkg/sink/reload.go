@@ -0,0 +1,102 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"sync"
+
+	"github.com/xvzf/insight/pkg/insight"
+)
+
+// Reloadable is a sink whose underlying sinks can be replaced at runtime
+type Reloadable interface {
+	Sink
+	Reload(open func() (Sink, error)) error
+}
+
+// reloadable forwards batches to the current sink
+type reloadable struct {
+	sync.RWMutex
+	current Sink
+	open    func() (Sink, error) // Opens the current sink, used to restore it
+}
+
+// NewReloadable creates a sink forwarding to the sink created by open
+func NewReloadable(open func() (Sink, error)) (Reloadable, error) {
+	s, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return &reloadable{current: s, open: open}, nil
+}
+
+// Reload replaces the current sink. The current sink is closed first, so buffered sinks can
+// reopen their queue. If the new sink cannot be opened, the previous one is restored.
+func (r *reloadable) Reload(open func() (Sink, error)) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.current.Close()
+	s, err := open()
+	if err != nil {
+		previous, rerr := r.open()
+		if rerr != nil {
+			log.WithError(rerr).Error("Failed to restore the previous sinks")
+			previous = &failedSink{err: rerr}
+		}
+		r.current = previous
+		return err
+	}
+
+	r.current, r.open = s, open
+	log.Info("Reloaded sinks")
+	return nil
+}
+
+func (r *reloadable) Write(events []*insight.Event) error {
+	r.RLock()
+	defer r.RUnlock()
+	return r.current.Write(events)
+}
+
+func (r *reloadable) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.current.Close()
+}
+
+// failedSink rejects all batches, it replaces sinks which could not be opened
+type failedSink struct {
+	err error
+}
+
+func (f *failedSink) Write(events []*insight.Event) error {
+	return f.err
+}
+
+func (f *failedSink) Close() error {
+	return nil
+}
@@ -0,0 +1,70 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReloadable(t *testing.T) {
+	first, second := &memorySink{}, &memorySink{}
+	opened := 0
+	openFirst := func() (Sink, error) {
+		opened++
+		return first, nil
+	}
+
+	r, err := NewReloadable(openFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write(testEvents)
+
+	if err := r.Reload(func() (Sink, error) { return second, nil }); err != nil {
+		t.Fatal(err)
+	}
+	r.Write(testEvents)
+
+	if !first.closed || len(first.batches) != 1 || len(second.batches) != 1 {
+		t.Error("Batches have to be written to the current sink only")
+	}
+
+	// A failed reload restores the previous sink
+	first.closed = false
+	if err := r.Reload(func() (Sink, error) { return nil, errors.New("invalid") }); err == nil {
+		t.Error("Expected reload error")
+	}
+	r.Write(testEvents)
+	if !second.closed || len(second.batches) != 2 {
+		t.Error("Previous sink has not been restored")
+	}
+	if opened != 1 {
+		t.Errorf("Expected first sink to be opened once, got %d", opened)
+	}
+
+	r.Close()
+}
@@ -0,0 +1,156 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xvzf/insight/pkg/insight"
+)
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "sink",
+	})
+}
+
+// Logger
+var log *logrus.Entry
+
+// Sink delivers batches of events to an output
+type Sink interface {
+	Write(events []*insight.Event) error
+	Close() error
+}
+
+// WriteError is returned by sinks which did not accept a batch, or only a part of it. Rejected
+// events will never be accepted, Retry contains the events worth writing again. Other errors
+// are temporary, the whole batch has to be written again.
+type WriteError struct {
+	Err      error
+	Rejected int
+	Retry    []*insight.Event
+}
+
+func (e *WriteError) Error() string {
+	return e.Err.Error()
+}
+
+// New creates a sink based on a URL-like specification:
+//
+//	http://logstash:8080, https://...          JSON array posted to a HTTP endpoint (logstash http input)
+//	elasticsearch://es:9200/index              Elasticsearch bulk API (elasticsearch+https:// for TLS)
+//	file:///var/log/insight.json, stdout, -    Newline-delimited JSON
+//	syslog://host:514, syslog+tcp://host:514   One JSON document per syslog message (syslog:// without host logs locally)
+//	kafka://broker1:9092,broker2:9092/topic    Kafka produce requests, one record per event
+func New(spec string) (Sink, error) {
+	if spec == "stdout" || spec == "-" {
+		return NewStdout(), nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := strings.Split(u.Scheme, "+")
+	switch scheme[0] {
+	case "http", "https":
+		return NewHTTP(spec), nil
+	case "elasticsearch":
+		base := "http"
+		if len(scheme) > 1 {
+			base = scheme[1]
+		}
+		index := strings.Trim(u.Path, "/")
+		if index == "" {
+			return nil, errors.New("elasticsearch sink requires an index")
+		}
+		return NewElasticsearch(fmt.Sprintf("%s://%s", base, u.Host), index), nil
+	case "file":
+		return NewFile(u.Path)
+	case "syslog":
+		network := "udp"
+		if len(scheme) > 1 {
+			network = scheme[1]
+		}
+		if u.Host == "" {
+			network = ""
+		}
+		return NewSyslog(network, u.Host)
+	case "kafka":
+		topic := strings.Trim(u.Path, "/")
+		if topic == "" {
+			return nil, errors.New("kafka sink requires a topic")
+		}
+		return NewKafka(strings.Split(u.Host, ","), topic), nil
+	default:
+		return nil, fmt.Errorf("unknown sink %s", spec)
+	}
+}
+
+// FromSpecs creates a sink writing to all given specifications. If buffer is not nil, every
+// sink gets its own disk queue, so an unavailable sink does not hold back the others.
+func FromSpecs(specs []string, buffer *BufferConfig) (Sink, error) {
+	var sinks []Sink
+	closeAll := func() {
+		for _, s := range sinks {
+			s.Close()
+		}
+	}
+
+	for _, spec := range specs {
+		s, err := New(spec)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if buffer != nil {
+			b, err := Buffer(s, spec, buffer)
+			if err != nil {
+				s.Close()
+				closeAll()
+				return nil, err
+			}
+			s = b
+		}
+		log.Infof("Writing events to %s", spec)
+		sinks = append(sinks, s)
+	}
+
+	switch len(sinks) {
+	case 0:
+		return nil, errors.New("no sink configured")
+	case 1:
+		return sinks[0], nil
+	default:
+		return NewFanout(sinks...), nil
+	}
+}
@@ -0,0 +1,200 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xvzf/insight/pkg/flow"
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/protos"
+)
+
+var testEvents = insight.NewFromFlows([]*flow.Flow{
+	{
+		Meta: flow.Meta{
+			Src:       net.ParseIP("10.0.0.1"),
+			Dst:       net.ParseIP("10.0.0.2"),
+			SrcPort:   34567,
+			DstPort:   80,
+			Transport: protos.TCP,
+		},
+		Incoming:    flow.Counters{Bytes: 100, Packets: 2},
+		Outgoing:    flow.Counters{Bytes: 1500, Packets: 1},
+		Start:       time.Unix(1577880000, 0),
+		End:         time.Unix(1577880001, 0),
+		CommunityID: "1:LQU9qZlK+B5F3KDmev6m5PMibrg=",
+	},
+	{
+		Meta: flow.Meta{
+			Src:       net.ParseIP("10.0.0.1"),
+			Dst:       net.ParseIP("10.0.0.3"),
+			SrcPort:   54321,
+			DstPort:   53,
+			Transport: protos.UDP,
+		},
+		Incoming:    flow.Counters{Bytes: 60, Packets: 1},
+		Outgoing:    flow.Counters{Bytes: 120, Packets: 1},
+		Start:       time.Unix(1577880000, 0),
+		End:         time.Unix(1577880000, 0),
+		CommunityID: "1:d/FP5EW3wiY1vCndhwleRRKHowQ=",
+	},
+})
+
+// memorySink records written batches
+type memorySink struct {
+	batches [][]*insight.Event
+	err     error
+	closed  bool
+}
+
+func (m *memorySink) Write(events []*insight.Event) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.batches = append(m.batches, events)
+	return nil
+}
+
+func (m *memorySink) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestNew(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	for spec, valid := range map[string]bool{
+		"stdout":                                true,
+		"-":                                     true,
+		"http://logstash:8080":                  true,
+		"https://logstash:8443/events":          true,
+		"elasticsearch://es:9200/insight":       true,
+		"elasticsearch+https://es:9200/insight": true,
+		"elasticsearch://es:9200":               false,
+		"file://" + filepath.Join(dir, "out"):   true,
+		"kafka://k1:9092,k2:9092/flows":         true,
+		"kafka://k1:9092":                       false,
+		"ftp://example.com":                     false,
+		"::":                                    false,
+	} {
+		s, err := New(spec)
+		if valid && err != nil {
+			t.Errorf("%s: unexpected error %v", spec, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("%s: expected error", spec)
+		}
+		if s != nil && spec != "stdout" && spec != "-" {
+			s.Close()
+		}
+	}
+
+	if _, err := FromSpecs(nil, nil); err == nil {
+		t.Error("expected error without sinks")
+	}
+}
+
+func TestFanout(t *testing.T) {
+	a, b, c := &memorySink{}, &memorySink{err: errors.New("unavailable")}, &memorySink{}
+	f := NewFanout(a, b, c)
+
+	err := f.Write(testEvents)
+	fe, ok := err.(*FanoutError)
+	if !ok {
+		t.Fatalf("expected FanoutError, got %v", err)
+	}
+	if len(fe.Errors) != 1 || fe.Errors[1] == nil {
+		t.Errorf("expected sink 1 to fail, got %v", fe.Errors)
+	}
+	if len(a.batches) != 1 || len(c.batches) != 1 {
+		t.Error("batch not written to healthy sinks")
+	}
+
+	f.Close()
+	if !a.closed || !b.closed || !c.closed {
+		t.Error("not all sinks closed")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewWriter(&buf)
+	if err := s.Write(testEvents); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(testEvents) {
+		t.Fatalf("expected %d lines, got %d", len(testEvents), len(lines))
+	}
+	for i, l := range lines {
+		var e insight.Event
+		if err := json.Unmarshal([]byte(l), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Network.CommunityID != testEvents[i].Network.CommunityID {
+			t.Errorf("unexpected event %s", l)
+		}
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := New("syslog://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Write(testEvents[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.Contains(msg, syslogTag) || !strings.Contains(msg, testEvents[0].Network.CommunityID) {
+		t.Errorf("unexpected syslog message %s", msg)
+	}
+}
@@ -0,0 +1,103 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package tracking
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xvzf/insight/pkg/insight"
+)
+
+// Logger
+var log *logrus.Entry
+
+func init() {
+	log = logrus.WithFields(logrus.Fields{
+		"context": "tracking",
+	})
+}
+
+// FlushInterval is the interval completed events are written and state is expired in
+const FlushInterval = time.Second
+
+// Writer writes events
+type Writer interface {
+	Write(events []*insight.Event) error
+}
+
+// Base contains the writer, clock and flush loop shared by the trackers decoding events from
+// packet payloads. Trackers embed it and pass a collect function returning the events to write.
+type Base struct {
+	Writer   Writer
+	Now      func() time.Time
+	name     string
+	collect  func(now time.Time) []*insight.Event
+	exitChan chan struct{}
+}
+
+// NewBase creates the base of a tracker writing the events returned by collect to w; name
+// identifies the events in logs
+func NewBase(name string, w Writer, collect func(now time.Time) []*insight.Event) Base {
+	return Base{
+		Writer:   w,
+		Now:      time.Now,
+		name:     name,
+		collect:  collect,
+		exitChan: make(chan struct{}),
+	}
+}
+
+// Run flushes the tracker every FlushInterval until it is stopped
+func (b *Base) Run() error {
+	ticker := time.NewTicker(FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.exitChan:
+			return nil
+		case <-ticker.C:
+			b.Flush()
+		}
+	}
+}
+
+// Stop stops the tracker
+func (b *Base) Stop() {
+	close(b.exitChan)
+}
+
+// Flush writes the events collected from the tracker
+func (b *Base) Flush() {
+	events := b.collect(b.Now())
+	if len(events) == 0 {
+		return
+	}
+	if err := b.Writer.Write(events); err != nil {
+		log.WithError(err).Errorf("Failed to write %s events, %d events lost", b.name, len(events))
+	}
+}
@@ -0,0 +1,76 @@
+/* 
+ *  MIT License
+ *  
+ *  Copyright (c) 2020 Matthias Riegler <me@xvzf.tech>
+ *  
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *  
+ *  The above copyright notice and this permission notice shall be included in all
+ *  copies or substantial portions of the Software.
+ *  
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *  SOFTWARE.
+ *  
+ */
+
+package trackingtest
+
+import (
+	"time"
+
+	"github.com/xvzf/insight/pkg/insight"
+	"github.com/xvzf/insight/pkg/tracking"
+)
+
+// Writer collects the events written by a tracker under test
+type Writer struct {
+	Events []*insight.Event
+}
+
+func (w *Writer) Write(events []*insight.Event) error {
+	w.Events = append(w.Events, events...)
+	return nil
+}
+
+// Clock is a clock advanced by the test, for the components under test taking a time source
+type Clock struct {
+	now time.Time
+}
+
+// NewClock creates a clock starting at now
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current time of the clock
+func (c *Clock) Now() time.Time {
+	return c.now
+}
+
+// Set sets the clock to now
+func (c *Clock) Set(now time.Time) {
+	c.now = now
+}
+
+// Add advances the clock by d
+func (c *Clock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// Attach replaces the writer of a tracker by a collecting Writer and its clock by c
+func Attach(b *tracking.Base, c *Clock) *Writer {
+	w := &Writer{}
+	b.Writer = w
+	b.Now = c.Now
+	return w
+}